@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/config"
+	"github.com/avvvet/cdnbuddy-intent/internal/diagnostics"
+	"github.com/avvvet/cdnbuddy-intent/internal/llm"
+	"github.com/avvvet/cdnbuddy-intent/internal/memory"
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "doctor":
+		os.Exit(runDoctor(os.Args[2:]))
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: cdnbuddy-intent <command> [flags]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Commands:")
+	fmt.Fprintln(os.Stderr, "  doctor    Check connectivity to the session store, NATS and (optionally) the LLM")
+}
+
+// runDoctor checks every dependency using the real config and returns the
+// process exit code
+func runDoctor(args []string) int {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	checkLLM := fs.Bool("llm", false, "also run a live LLM round-trip (spends tokens)")
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout for each check")
+	fs.Parse(args)
+
+	// Load .env file if it exists (for development)
+	_ = godotenv.Load()
+
+	// Check the same config the server would run with
+	cfg, err := config.LoadDefault()
+	if err != nil {
+		log.Printf("❌ Failed to load config: %v", err)
+		return 1
+	}
+
+	// Connect to the store the server would use; a failure is reported by
+	// the store check below
+	store, storeErr := memory.NewStore(cfg, 5*time.Minute)
+	if closer, ok := store.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	storeCheck := diagnostics.StoreCheck(cfg.StoreBackend, store)
+	if storeErr != nil {
+		storeCheck.Run = func(ctx context.Context) error { return storeErr }
+	}
+
+	checks := []diagnostics.Check{
+		storeCheck,
+		diagnostics.NATSCheck(cfg),
+	}
+
+	sessionID := fmt.Sprintf("doctor-%d", time.Now().UnixNano())
+	var memoryManager *memory.Manager
+	if *checkLLM {
+		if storeErr != nil {
+			checks = append(checks, diagnostics.Check{
+				Name: "llm",
+				Run: func(ctx context.Context) error {
+					return fmt.Errorf("skipped: requires the session store")
+				},
+			})
+		} else {
+			memoryManager = memory.NewManager(store)
+			provider, err := llm.NewProvider(cfg, memoryManager)
+			if err != nil {
+				log.Printf("❌ Failed to initialize LLM provider: %v", err)
+				return 1
+			}
+			checks = append(checks, diagnostics.LLMCheck(provider, sessionID))
+		}
+	}
+
+	results := diagnostics.Run(context.Background(), checks, *timeout)
+
+	// Don't leave the diagnostic conversation behind
+	if memoryManager != nil {
+		if err := memoryManager.ClearSession(context.Background(), sessionID); err != nil {
+			log.Printf("⚠️ Failed to clear doctor session: %v", err)
+		}
+	}
+
+	for _, r := range results {
+		if r.Passed() {
+			fmt.Printf("✅ %-6s ok (%s)\n", r.Name, r.Duration.Round(time.Millisecond))
+		} else {
+			fmt.Printf("❌ %-6s %v (%s)\n", r.Name, r.Err, r.Duration.Round(time.Millisecond))
+		}
+	}
+
+	if !diagnostics.AllPassed(results) {
+		return 1
+	}
+	return 0
+}
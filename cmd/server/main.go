@@ -1,17 +1,32 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"io"
 	"log"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/avvvet/cdnbuddy-intent/internal/catalog"
 	"github.com/avvvet/cdnbuddy-intent/internal/config"
 	"github.com/avvvet/cdnbuddy-intent/internal/handlers"
+	"github.com/avvvet/cdnbuddy-intent/internal/idempotency"
 	"github.com/avvvet/cdnbuddy-intent/internal/llm"
+	"github.com/avvvet/cdnbuddy-intent/internal/logging"
 	"github.com/avvvet/cdnbuddy-intent/internal/memory"
+	"github.com/avvvet/cdnbuddy-intent/internal/metrics"
+	"github.com/avvvet/cdnbuddy-intent/internal/prompts"
+	"github.com/avvvet/cdnbuddy-intent/internal/ratelimit"
+	"github.com/avvvet/cdnbuddy-intent/internal/respcache"
+	"github.com/avvvet/cdnbuddy-intent/internal/tracing"
 	"github.com/avvvet/cdnbuddy-intent/internal/transport"
+	"github.com/avvvet/cdnbuddy-intent/internal/usage"
 	"github.com/joho/godotenv"
 )
 
@@ -23,56 +38,196 @@ func main() {
 
 	log.Println("🚀 Starting CDNbuddy Intent Service...")
 
-	// Load configuration
-	cfg, err := config.Load()
+	// Load configuration, from CONFIG_FILE when set
+	if path := os.Getenv(config.FileEnv); path != "" {
+		log.Printf("📄 Config file: %s", path)
+	}
+	cfg, err := config.LoadDefault()
 	if err != nil {
 		log.Fatalf("❌ Failed to load config: %v", err)
 	}
+
+	// Route all logging, including the log package, through slog
+	logger, err := logging.New(os.Stderr, cfg.LogLevel, cfg.LogFormat)
+	if err != nil {
+		log.Fatalf("❌ Failed to configure logging: %v", err)
+	}
+	slog.SetDefault(logger)
+
 	log.Printf("📋 Service: %s", cfg.ServiceName)
 	log.Printf("📡 NATS URL: %s", cfg.NatsURL)
-	log.Printf("🤖 Anthropic Model: %s", cfg.AnthropicModel)
+	log.Printf("🤖 LLM Provider: %s", cfg.LLMProvider)
 
-	// Validate required configuration
-	if cfg.AnthropicAPIKey == "" {
-		log.Fatal("❌ ANTHROPIC_API_KEY environment variable is required")
+	// Export traces when a collector is configured
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.TracingEndpoint, cfg.ServiceName)
+	if err != nil {
+		log.Fatalf("❌ Failed to set up tracing: %v", err)
+	}
+	if cfg.TracingEndpoint != "" {
+		log.Printf("🔭 Exporting traces to %s", cfg.TracingEndpoint)
 	}
 
-	// Get Redis URL from environment (with default)
-	redisURL := getEnv("REDIS_URL", "redis://localhost:6379/0")
-	log.Printf("💾 Redis URL: %s", redisURL)
+	// Load localized messages
+	if cfg.MessageCatalogFile != "" {
+		if err := prompts.LoadCatalogFile(cfg.MessageCatalogFile); err != nil {
+			log.Fatalf("❌ Failed to load message catalog: %v", err)
+		}
+		log.Printf("🌍 Message catalog loaded: %s", cfg.MessageCatalogFile)
+	}
+
+	// Replace the built-in system prompt for this deployment
+	if cfg.PromptTemplateFile != "" {
+		if err := prompts.LoadSystemTemplateFile(cfg.PromptTemplateFile); err != nil {
+			log.Fatalf("❌ Failed to load prompt template: %v", err)
+		}
+		log.Printf("📝 Prompt template loaded: %s", cfg.PromptTemplateFile)
+	}
 
-	// Initialize Redis store
-	log.Println("🔌 Connecting to Redis...")
-	redisStore, err := memory.NewRedisStore(redisURL, 30*time.Minute) // 30 min TTL
+	// Initialize session store
+	switch cfg.StoreBackend {
+	case config.StoreBackendPostgres:
+		log.Println("🔌 Connecting to Postgres...")
+	case config.StoreBackendMemory:
+		log.Println("⚠️ Using in-memory session store, sessions are lost on restart")
+	default:
+		log.Printf("💾 Redis URL: %s", cfg.RedisURL)
+		switch cfg.RedisMode {
+		case config.RedisModeSentinel:
+			log.Printf("🛡️ Redis Sentinel master %s via %s", cfg.RedisSentinelMaster, strings.Join(cfg.RedisAddrs, ", "))
+		case config.RedisModeCluster:
+			log.Printf("🕸️ Redis Cluster via %s", strings.Join(cfg.RedisAddrs, ", "))
+		}
+		log.Println("🔌 Connecting to Redis...")
+	}
+
+	// With REDIS_OPTIONAL an unreachable Redis leaves sessions in memory
+	store, err := memory.NewStoreWithFallback(cfg, 30*time.Minute, logger) // 30 min TTL
 	if err != nil {
-		log.Fatalf("❌ Failed to connect to Redis: %v", err)
+		log.Fatalf("❌ Failed to connect to session store: %v", err)
+	}
+	if closer, ok := store.(io.Closer); ok {
+		defer closer.Close()
 	}
-	defer redisStore.Close()
-	log.Println("✅ Redis connected")
+	log.Printf("✅ Session store ready (%T)", store)
+	redisStore, _ := store.(*memory.RedisStore) // Shared with usage reporting, nil without Redis
 
 	// Initialize Memory Manager
 	log.Println("🧠 Initializing memory manager...")
-	memoryManager := memory.NewManager(redisStore)
+	memoryOpts := []memory.Option{
+		memory.WithLogger(logger),
+		memory.WithMaxHistoryBytes(cfg.MaxHistoryBytes),
+		memory.WithHistoryTimestamps(cfg.HistoryTimestamps),
+		memory.WithMaxCheckpoints(cfg.MaxCheckpoints),
+		memory.WithMaxSessionsPerUser(cfg.MaxUserSessions),
+		memory.WithSessionCacheSize(cfg.SessionCacheSize),
+		memory.WithCacheJanitor(cfg.CacheJanitor),
+	}
+	if cfg.PIIClassification {
+		classifier, err := memory.NewPIIClassifier(cfg.PIIPatterns)
+		if err != nil {
+			log.Fatalf("❌ Failed to initialize PII classifier: %v", err)
+		}
+		memoryOpts = append(memoryOpts, memory.WithPIIClassifier(classifier))
+		log.Println("🔒 PII classification enabled")
+	}
+	if cfg.SummarizeAfter > 0 {
+		summarizer, err := llm.NewSummarizer(cfg)
+		if err != nil {
+			log.Fatalf("❌ Failed to initialize summarizer: %v", err)
+		}
+		defer summarizer.Close()
+		memoryOpts = append(memoryOpts, memory.WithSummarizer(summarizer, cfg.SummarizeAfter, cfg.SummaryKeepRecent))
+		log.Printf("📝 Summarizing sessions longer than %d messages", cfg.SummarizeAfter)
+	}
+	memoryManager := memory.NewManager(store, memoryOpts...)
 	defer memoryManager.Close()
 	log.Println("✅ Memory manager initialized")
 
+	if !prompts.IsKnownPersona(cfg.AssistantPersona) {
+		log.Fatalf("❌ Unknown ASSISTANT_PERSONA %q", cfg.AssistantPersona)
+	}
+
+	// Initialize usage aggregator and idempotency cache (share the Redis connection)
+	providerOpts := []llm.Option{llm.WithLogger(logger)}
+	transportOpts := []transport.Option{transport.WithLogger(logger), transport.WithSessionControl(memoryManager)}
+	handlerOpts := []handlers.Option{
+		handlers.WithMaxActions(cfg.MaxAvailableActions, cfg.ActionOverflowMode),
+		handlers.WithMaxMessageChars(cfg.MaxUserMessageChars),
+		handlers.WithMinConfidence(cfg.MinConfidence),
+		handlers.WithActionGraph(cfg.ActionGraph),
+		handlers.WithMemoryManager(memoryManager),
+		handlers.WithLogger(logger),
+	}
+	if redisStore != nil {
+		usageAggregator := usage.NewAggregator(redisStore.Client(), cfg.UsageRetention)
+		transportOpts = append(transportOpts, transport.WithRedisHealth(redisStore))
+		providerOpts = append(providerOpts, llm.WithUsageRecorder(usageAggregator))
+		transportOpts = append(transportOpts, transport.WithUsageReporter(usageAggregator))
+		handlerOpts = append(handlerOpts, handlers.WithIdempotency(idempotency.NewCache(redisStore.Client(), cfg.IdempotencyTTL)))
+		actionCatalogs := catalog.NewStore(redisStore.Client())
+		handlerOpts = append(handlerOpts, handlers.WithActionCatalog(actionCatalogs))
+		transportOpts = append(transportOpts, transport.WithActionRegistry(actionCatalogs))
+		if cfg.ResponseCacheTTL > 0 {
+			providerOpts = append(providerOpts, llm.WithResponseCache(respcache.NewCache(redisStore.Client(), cfg.ResponseCacheTTL)))
+			log.Printf("🗃️ Caching first-turn replies for %s", cfg.ResponseCacheTTL)
+		}
+	} else {
+		log.Println("⚠️ Usage reporting, request deduplication, response caching and action catalogs disabled: they require Redis")
+	}
+
+	// Cap spend per session
+	if cfg.SessionBudgetCents > 0 {
+		price, _ := cfg.BudgetPrice() // Checked by config validation
+		handlerOpts = append(handlerOpts, handlers.WithSessionBudget(cfg.SessionBudgetCents, price.Input, price.Output))
+		log.Printf("💰 Session budget: %.2f cents", cfg.SessionBudgetCents)
+	}
+
+	// Rate limits are shared across replicas through Redis when available
+	if cfg.RateLimitPerMinute > 0 {
+		var limiter handlers.RateLimiter
+		if redisStore != nil {
+			limiter = ratelimit.NewRedisLimiter(redisStore.Client(), cfg.RateLimitPerMinute, cfg.RateLimitBurst)
+		} else {
+			limiter = ratelimit.NewLocalLimiter(cfg.RateLimitPerMinute, cfg.RateLimitBurst)
+		}
+		handlerOpts = append(handlerOpts, handlers.WithRateLimiter(limiter, cfg.RateLimitByUser))
+		log.Printf("🚦 Rate limit: %d requests per minute per session", cfg.RateLimitPerMinute)
+	}
+
 	// Initialize LLM provider with memory manager
-	log.Println("🤖 Initializing Anthropic provider...")
-	anthropicProvider := llm.NewAnthropicProvider(
-		cfg.AnthropicAPIKey,
-		cfg.AnthropicModel,
-		cfg.AnthropicTimeout,
-		memoryManager,
-	)
-	log.Println("✅ Anthropic provider initialized")
+	log.Println("🤖 Initializing LLM provider...")
+	if cfg.DebugSampleRate > 0 && cfg.DebugSinkFile != "" {
+		debugSink, err := llm.NewFileDebugSink(cfg.DebugSinkFile)
+		if err != nil {
+			log.Fatalf("❌ Failed to open debug sink: %v", err)
+		}
+		defer debugSink.Close()
+		providerOpts = append(providerOpts, llm.WithDebugSampling(cfg.DebugSampleRate, debugSink))
+		log.Printf("🐞 Debug capture enabled: %.2f%% of requests to %s", cfg.DebugSampleRate*100, cfg.DebugSinkFile)
+	}
+	if cfg.LLMAuditLog {
+		auditLogger := logger.With("audit", true)
+		providerOpts = append(providerOpts, llm.WithAuditSink(func(sessionID, rawResponse string) {
+			auditLogger.Info("raw model reply", "session_id", sessionID, "raw_response", rawResponse)
+		}))
+		log.Println("🧾 Audit logging of raw model replies enabled")
+	}
+	provider, err := llm.NewProvider(cfg, memoryManager, providerOpts...)
+	if err != nil {
+		log.Fatalf("❌ Failed to initialize LLM provider: %v", err)
+	}
+	if closer, ok := provider.(io.Closer); ok {
+		defer closer.Close()
+	}
+	log.Printf("✅ %s provider initialized", cfg.LLMProvider)
 
 	// Initialize intent handler
-	intentHandler := handlers.NewIntentHandler(anthropicProvider)
+	intentHandler := handlers.NewIntentHandler(provider, handlerOpts...)
 	log.Println("✅ Intent handler initialized")
 
 	// Initialize NATS transport
 	log.Println("📡 Connecting to NATS...")
-	natsTransport, err := transport.NewNATSTransport(cfg, intentHandler)
+	natsTransport, err := transport.NewNATSTransport(cfg, intentHandler, transportOpts...)
 	if err != nil {
 		log.Fatalf("❌ Failed to initialize NATS transport: %v", err)
 	}
@@ -83,6 +238,15 @@ func main() {
 		log.Fatalf("❌ Failed to start NATS transport: %v", err)
 	}
 
+	// Serve the expvar counters on /debug/vars
+	metricsServer := &http.Server{Addr: ":" + cfg.Port, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("⚠️ Metrics server stopped: %v", err)
+		}
+	}()
+	log.Printf("📈 Metrics on :%s/debug/vars", cfg.Port)
+
 	log.Println("✅ CDNbuddy Intent Service is running!")
 	log.Printf("👂 Listening on subject: %s", cfg.NatsRequestSubject)
 	log.Printf("📊 Active sessions: %d", memoryManager.GetActiveSessionCount())
@@ -99,6 +263,12 @@ func main() {
 	// Cleanup
 	log.Printf("📊 Final session count: %d", memoryManager.GetActiveSessionCount())
 
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+		log.Printf("⚠️ Error stopping metrics server: %v", err)
+	}
+
 	if err := memoryManager.Close(); err != nil {
 		log.Printf("⚠️ Error closing memory manager: %v", err)
 	}
@@ -106,14 +276,10 @@ func main() {
 	if err := natsTransport.Close(); err != nil {
 		log.Printf("⚠️ Error closing NATS transport: %v", err)
 	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		log.Printf("⚠️ Error flushing traces: %v", err)
+	}
+	log.Printf("📊 Responses dropped on shutdown: %d", metrics.ResponsesDroppedOnShutdown.Value())
 
 	log.Println("👋 CDNbuddy Intent Service stopped")
 }
-
-// getEnv gets environment variable with default value
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
-	}
-	return defaultValue
-}
@@ -0,0 +1,61 @@
+// Package catalog keeps named action catalogs registered at runtime, so
+// requests can reference a catalog instead of embedding their actions.
+package catalog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrNotFound is returned for a catalog ID that was never registered
+var ErrNotFound = errors.New("action catalog not found")
+
+// Store keeps catalogs in Redis. They don't expire: a catalog stays until
+// it is registered again under the same ID.
+type Store struct {
+	client redis.Cmdable
+}
+
+// NewStore creates a Redis-backed catalog store
+func NewStore(client redis.Cmdable) *Store {
+	return &Store{client: client}
+}
+
+// catalogKey generates the Redis key for a catalog
+func (s *Store) catalogKey(id string) string {
+	return fmt.Sprintf("action_catalog:%s", id)
+}
+
+// Save registers a catalog, replacing any catalog with the same ID
+func (s *Store) Save(ctx context.Context, id string, actions []models.ActionSchema) error {
+	data, err := json.Marshal(actions)
+	if err != nil {
+		return fmt.Errorf("failed to marshal catalog: %w", err)
+	}
+	if err := s.client.Set(ctx, s.catalogKey(id), data, 0).Err(); err != nil {
+		return fmt.Errorf("failed to save catalog: %w", err)
+	}
+	return nil
+}
+
+// Get returns a catalog's actions, or ErrNotFound
+func (s *Store) Get(ctx context.Context, id string) ([]models.ActionSchema, error) {
+	data, err := s.client.Get(ctx, s.catalogKey(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load catalog: %w", err)
+	}
+
+	var actions []models.ActionSchema
+	if err := json.Unmarshal(data, &actions); err != nil {
+		return nil, fmt.Errorf("failed to parse catalog: %w", err)
+	}
+	return actions, nil
+}
@@ -0,0 +1,80 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestStore(t *testing.T) {
+	purge := models.ActionSchema{Action: "PURGE_CACHE", Parameters: []models.ParameterSpec{{Name: "service_id", Required: true}}}
+	create := models.ActionSchema{Action: "CREATE_SERVICE", Parameters: []models.ParameterSpec{{Name: "domain", Required: true}}}
+
+	tests := []struct {
+		name    string
+		saves   [][]models.ActionSchema // Registered under "cdn" in order
+		raw     string                  // Stored as is under "cdn" instead, if set
+		want    []string                // Actions returned for "cdn"
+		wantErr error
+	}{
+		{name: "registered", saves: [][]models.ActionSchema{{purge, create}}, want: []string{"PURGE_CACHE", "CREATE_SERVICE"}},
+		{name: "registered again", saves: [][]models.ActionSchema{{purge, create}, {create}}, want: []string{"CREATE_SERVICE"}},
+		{name: "never registered", wantErr: ErrNotFound},
+		{name: "corrupt entry", raw: "not json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr := miniredis.RunT(t)
+			client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+			t.Cleanup(func() { client.Close() })
+			store := NewStore(client)
+			ctx := context.Background()
+
+			for _, actions := range tt.saves {
+				if err := store.Save(ctx, "cdn", actions); err != nil {
+					t.Fatalf("Save() error = %v", err)
+				}
+			}
+			if tt.raw != "" {
+				mr.Set("action_catalog:cdn", tt.raw)
+			}
+			// Catalogs don't expire
+			if len(tt.saves) > 0 && mr.TTL("action_catalog:cdn") != 0 {
+				t.Errorf("catalog TTL = %v, want none", mr.TTL("action_catalog:cdn"))
+			}
+
+			actions, err := store.Get(ctx, "cdn")
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Get() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			case tt.raw != "":
+				if err == nil || errors.Is(err, ErrNotFound) {
+					t.Fatalf("Get() error = %v, want a parse error", err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("Get() error = %v", err)
+			}
+
+			var got []string
+			for _, action := range actions {
+				got = append(got, action.Action)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Get() actions = %v, want %v", got, tt.want)
+			}
+			if actions[0].Parameters[0].Name == "" || !actions[0].Parameters[0].Required {
+				t.Errorf("parameters not kept: %+v", actions[0].Parameters)
+			}
+		})
+	}
+}
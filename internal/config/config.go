@@ -3,61 +3,509 @@ package config
 import (
 	"fmt"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// Supported values for Config.StoreBackend
+const (
+	StoreBackendRedis    = "redis"
+	StoreBackendPostgres = "postgres"
+	StoreBackendMemory   = "memory" // Local development only, nothing persists
+)
+
+// Supported values for Config.RedisMode
+const (
+	RedisModeStandalone = "standalone"
+	RedisModeSentinel   = "sentinel"
+	RedisModeCluster    = "cluster"
+)
+
 type Config struct {
 	// Service
 	ServiceName string
-	Port        string
+	Port        string // Serves the expvar metrics on /debug/vars
+
+	// Logging
+	LogLevel  string // debug, info, warn or error
+	LogFormat string // json or text
+
+	// Tracing
+	TracingEndpoint string // OTLP/HTTP collector URL, e.g. http://localhost:4318; tracing is off when empty
 
 	// NATS
 	NatsURL            string
-	NatsRequestSubject string
+	NatsRequestSubject string // Comma-separated, may use wildcards; the token after the shared prefix names the tenant
 	NatsTimeout        time.Duration
+	NatsUsageSubject   string
+	NatsHealthSubject  string
+	NatsControlSubject string // Session commands such as undo
+	NatsActionsSubject string // Registers action catalogs
+	NatsQueueGroup     string // Replicas in the same group share requests
+	NatsEventSubject   string // READY responses are also published here, empty disables it
+	NatsDLQSubject     string // Failed requests are published here, empty disables it
+	MaxConcurrency     int    // Intent requests processed at once
+
+	// A streaming request is cancelled once its reply inbox has had no
+	// listener for this long
+	NatsStreamGrace time.Duration
+
+	// NATS authentication: set at most one of a credentials file, a token
+	// or a user and password
+	NatsCredsFile string
+	NatsToken     string
+	NatsUser      string
+	NatsPassword  string
+
+	// NATS TLS: a CA bundle for the server certificate and a client
+	// certificate and key for mutual TLS. A tls:// URL without a CA uses the
+	// system roots.
+	NatsTLSCA   string
+	NatsTLSCert string
+	NatsTLSKey  string
+
+	// Consume requests through a durable JetStream consumer so requests
+	// survive restarts. Replies go to the inbox in the request's
+	// Intent-Reply-To header.
+	NatsJetStream  bool
+	NatsStreamName string // Created over the request subjects if missing
 
 	// Anthropic
-	AnthropicAPIKey  string
-	AnthropicModel   string
-	AnthropicTimeout time.Duration
+	AnthropicAPIKey     string
+	AnthropicModel      string
+	AnthropicTimeout    time.Duration
+	AnthropicMaxRetries int           // Retries on 429/5xx/529 responses
+	AnthropicKeepAlive  time.Duration // Connection warming ping interval, 0 disables it
+	AnthropicBaseURL    string        // API host, e.g. an internal gateway
+
+	// OpenAI
+	OpenAIAPIKey  string
+	OpenAIModel   string
+	OpenAIBaseURL string
+	OpenAITimeout time.Duration
+
+	// Ollama
+	OllamaURL     string
+	OllamaModel   string
+	OllamaTimeout time.Duration
+
+	// LLM
+	LLMProvider              string         // anthropic, openai, ollama or weighted
+	LLMWeights               map[string]int // Traffic split for the weighted provider, e.g. LLM_WEIGHTS="anthropic=70,openai=30"
+	HistoryDeadlineThreshold time.Duration  // Trim history when less than this remains
+	HistoryTokenBudget       int            // Approximate history cap in tokens, 0 disables it
+	AssistantPersona         string         // Default tone: friendly, formal or terse
+	LenientJSON              bool           // Repair trailing commas and smart quotes in model JSON
+	LLMMaxTokens             int            // Reply length cap
+	LLMTemperature           float64        // Sampling temperature
+	LLMBreakerThreshold      int            // Consecutive failures that open the circuit, 0 disables it
+	LLMBreakerCooldown       time.Duration  // How long the circuit stays open before a probe
+	LLMFallbackProviders     []string       // Tried in order when LLM_PROVIDER fails, e.g. LLM_FALLBACK_PROVIDERS="openai"
+
+	// Debug capture
+	DebugSampleRate float64 // Fraction of requests (0-1) captured in full
+	DebugSinkFile   string  // JSON lines file receiving captures
+	LLMAuditLog     bool    // Log every raw model reply before parsing, for compliance
+
+	// Spend cap per session, priced from the session's token totals.
+	// MODEL_PRICES lists input/output prices in cents per million tokens,
+	// e.g. MODEL_PRICES="claude-sonnet-4-20250514=300/1500,claude-3-5-haiku-latest=80/400"
+	SessionBudgetCents float64 // 0 disables the cap
+	ModelPrices        map[string]ModelPrice
+
+	// ModelRouting maps action complexity to a model,
+	// e.g. MODEL_ROUTING="simple=claude-3-5-haiku-latest,complex=claude-sonnet-4-20250514"
+	ModelRouting          map[string]string
+	ModelRoutingMaxSimple int // Actions with at most this many parameters are simple
 
 	// Redis
-	RedisURL string
+	RedisURL           string
+	RedisTLSCAFile     string // CA bundle for verifying the Redis server certificate
+	RedisTLSSkipVerify bool   // Skip certificate verification, for local testing only
+	RedisOptional      bool   // Fall back to the in-memory store if Redis is down at startup
+
+	// Redis topology: standalone uses the REDIS_URL host; sentinel and
+	// cluster connect to REDIS_ADDRS and keep the URL's credentials, DB and
+	// TLS settings
+	RedisMode           string
+	RedisSentinelMaster string   // Master name monitored by the sentinels
+	RedisAddrs          []string // Sentinel or cluster seed addresses, comma-separated host:port
+
+	// Session storage
+	StoreBackend string // "redis", "postgres" or "memory"
+	PostgresDSN  string
+
+	// Usage
+	UsageRetention time.Duration // How long daily usage counters are kept
+
+	// Memory
+	MaxHistoryBytes   int           // 0 disables the cap
+	HistoryTimestamps bool          // Show when each message was sent in the prompt history
+	PIIClassification bool          // Flag stored messages containing PII
+	PIIPatterns       []string      // Empty uses the built-in patterns
+	MaxCheckpoints    int           // Checkpoints kept per session
+	MaxUserSessions   int           // Active sessions allowed per user, 0 disables the cap
+	SessionCacheSize  int           // Conversation buffers cached with STORE_BACKEND=memory, 0 means unbounded
+	CacheJanitor      time.Duration // Interval for dropping expired sessions from the cache, 0 disables it
+	SummarizeAfter    int           // Summarize once a session has more messages than this, 0 disables it
+	SummaryKeepRecent int           // Messages kept verbatim when summarizing
+
+	// Localization and prompt customization
+	MessageCatalogFile string // Optional JSON catalog of localized messages
+	PromptTemplateFile string // Optional text/template replacing the built-in system prompt
+
+	// Handler
+	MaxAvailableActions int           // 0 disables the cap
+	MaxUserMessageChars int           // Longer user messages are rejected, 0 disables the cap
+	IdempotencyTTL      time.Duration // How long responses are kept for retried request IDs
+	ResponseCacheTTL    time.Duration // How long first-turn replies are reused, 0 disables the cache
+	RateLimitPerMinute  int           // Requests per session per minute, 0 disables rate limiting
+	RateLimitBurst      int           // Requests allowed back to back, defaults to the per-minute rate
+	RateLimitByUser     bool          // Also limit per user_id
+	MinConfidence       float64       // READY responses less confident than this (0-1) ask for confirmation, 0 disables it
+	ActionOverflowMode  string        // "error" or "rank"
+
+	// ActionGraph maps a completed action to suggested follow-up actions,
+	// e.g. ACTION_GRAPH="CREATE_SERVICE:ADD_CUSTOM_DOMAIN|SETUP_SSL;SETUP_CDN:PURGE_CACHE"
+	ActionGraph map[string][]string
+}
+
+// FileEnv names the environment variable holding the config file path
+const FileEnv = "CONFIG_FILE"
+
+// LoadDefault reads the YAML file named by CONFIG_FILE, with environment
+// variables taking precedence, or the environment alone when it is unset.
+// Every binary loads its configuration this way.
+func LoadDefault() (*Config, error) {
+	if path := os.Getenv(FileEnv); path != "" {
+		return LoadFromFile(path)
+	}
+	return Load()
 }
 
+// Load reads the configuration from environment variables
 func Load() (*Config, error) {
+	return load(nil)
+}
+
+// LoadFromFile reads the configuration from a YAML file of settings keyed
+// by their environment variable names, in either case:
+//
+//	nats_url: nats://nats:4222
+//	anthropic_timeout: 45s
+//
+// Environment variables override file values, which override the defaults.
+func LoadFromFile(path string) (*Config, error) {
+	values, err := readYAMLFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return load(values)
+}
+
+// load builds the configuration, looking each setting up in the
+// environment first and then in file
+func load(file source) (*Config, error) {
 	cfg := &Config{
-		ServiceName:        getEnv("SERVICE_NAME", "cdnbuddy-intent"),
-		Port:               getEnv("PORT", "8083"),
-		NatsURL:            getEnv("NATS_URL", "nats://localhost:4222"),
-		NatsRequestSubject: getEnv("NATS_REQUEST_SUBJECT", "intent.analyze"),
-		NatsTimeout:        getDurationEnv("NATS_TIMEOUT", 10*time.Second),
-		AnthropicAPIKey:    getEnv("ANTHROPIC_API_KEY", ""),
-		AnthropicModel:     getEnv("ANTHROPIC_MODEL", "claude-sonnet-4-20250514"),
-		AnthropicTimeout:   getDurationEnv("ANTHROPIC_TIMEOUT", 30*time.Second),
-		RedisURL:           getEnv("REDIS_URL", "redis://localhost:6379/0"),
+		ServiceName:         file.getEnv("SERVICE_NAME", "cdnbuddy-intent"),
+		Port:                file.getEnv("PORT", "8083"),
+		LogLevel:            file.getEnv("LOG_LEVEL", "info"),
+		LogFormat:           file.getEnv("LOG_FORMAT", "json"),
+		TracingEndpoint:     file.getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", ""),
+		NatsURL:             file.getEnv("NATS_URL", "nats://localhost:4222"),
+		NatsRequestSubject:  file.getEnv("NATS_REQUEST_SUBJECT", "intent.analyze"),
+		NatsTimeout:         file.getDurationEnv("NATS_TIMEOUT", 10*time.Second),
+		NatsUsageSubject:    file.getEnv("NATS_USAGE_SUBJECT", "intent.admin.usage"),
+		NatsHealthSubject:   file.getEnv("NATS_HEALTH_SUBJECT", "intent.health"),
+		NatsControlSubject:  file.getEnv("NATS_CONTROL_SUBJECT", "intent.admin.control"),
+		NatsActionsSubject:  file.getEnv("NATS_ACTIONS_SUBJECT", "intent.actions.register"),
+		NatsQueueGroup:      file.getEnv("NATS_QUEUE_GROUP", "cdnbuddy-intent"),
+		NatsEventSubject:    file.getEnv("NATS_EVENT_SUBJECT", "intent.completed"),
+		NatsDLQSubject:      file.getEnv("NATS_DLQ_SUBJECT", ""),
+		NatsStreamGrace:     file.getDurationEnv("NATS_STREAM_GRACE", 2*time.Second),
+		NatsCredsFile:       file.getEnv("NATS_CREDS", ""),
+		NatsToken:           file.getEnv("NATS_TOKEN", ""),
+		NatsUser:            file.getEnv("NATS_USER", ""),
+		NatsPassword:        file.getEnv("NATS_PASSWORD", ""),
+		NatsTLSCA:           file.getEnv("NATS_TLS_CA", ""),
+		NatsTLSCert:         file.getEnv("NATS_TLS_CERT", ""),
+		NatsTLSKey:          file.getEnv("NATS_TLS_KEY", ""),
+		NatsJetStream:       file.getBoolEnv("NATS_JETSTREAM", false),
+		NatsStreamName:      file.getEnv("NATS_STREAM", "INTENT_REQUESTS"),
+		MaxConcurrency:      file.getIntEnv("MAX_CONCURRENCY", 16),
+		AnthropicAPIKey:     file.getEnv("ANTHROPIC_API_KEY", ""),
+		AnthropicModel:      file.getEnv("ANTHROPIC_MODEL", "claude-sonnet-4-20250514"),
+		AnthropicTimeout:    file.getDurationEnv("ANTHROPIC_TIMEOUT", 30*time.Second),
+		AnthropicMaxRetries: file.getIntEnv("ANTHROPIC_MAX_RETRIES", 3),
+		AnthropicKeepAlive:  file.getDurationEnv("ANTHROPIC_KEEPALIVE", 0),
+		AnthropicBaseURL:    file.getEnv("ANTHROPIC_BASE_URL", "https://api.anthropic.com"),
+
+		OpenAIAPIKey:  file.getEnv("OPENAI_API_KEY", ""),
+		OpenAIModel:   file.getEnv("OPENAI_MODEL", "gpt-4o"),
+		OpenAIBaseURL: file.getEnv("OPENAI_BASE_URL", "https://api.openai.com"),
+		OpenAITimeout: file.getDurationEnv("OPENAI_TIMEOUT", 30*time.Second),
+
+		OllamaURL:     file.getEnv("OLLAMA_URL", "http://localhost:11434"),
+		OllamaModel:   file.getEnv("OLLAMA_MODEL", "llama3"),
+		OllamaTimeout: file.getDurationEnv("OLLAMA_TIMEOUT", 120*time.Second),
+
+		LLMProvider:              file.getEnv("LLM_PROVIDER", "anthropic"),
+		HistoryDeadlineThreshold: file.getDurationEnv("HISTORY_DEADLINE_THRESHOLD", 5*time.Second),
+		HistoryTokenBudget:       file.getIntEnv("HISTORY_MAX_TOKENS", 8000),
+		AssistantPersona:         file.getEnv("ASSISTANT_PERSONA", "friendly"),
+		LenientJSON:              file.getBoolEnv("LLM_LENIENT_JSON", false),
+		LLMMaxTokens:             file.getIntEnv("LLM_MAX_TOKENS", 1000),
+		LLMTemperature:           file.getFloatEnv("LLM_TEMPERATURE", 0.1),
+		LLMBreakerThreshold:      file.getIntEnv("LLM_BREAKER_THRESHOLD", 5),
+		LLMBreakerCooldown:       file.getDurationEnv("LLM_BREAKER_COOLDOWN", 30*time.Second),
+		LLMFallbackProviders:     file.getListEnv("LLM_FALLBACK_PROVIDERS", ","),
+		DebugSampleRate:          file.getFloatEnv("DEBUG_SAMPLE_RATE", 0),
+		DebugSinkFile:            file.getEnv("DEBUG_SINK_FILE", ""),
+		LLMAuditLog:              file.getBoolEnv("LLM_AUDIT_LOG", false),
+		SessionBudgetCents:       file.getFloatEnv("SESSION_BUDGET_CENTS", 0),
+		ModelRouting:             file.getMapEnv("MODEL_ROUTING"),
+		ModelRoutingMaxSimple:    file.getIntEnv("MODEL_ROUTING_SIMPLE_MAX_PARAMS", 1),
+
+		RedisURL:           file.getEnv("REDIS_URL", "redis://localhost:6379/0"),
+		RedisTLSCAFile:     file.getEnv("REDIS_TLS_CA_FILE", ""),
+		RedisTLSSkipVerify: file.getBoolEnv("REDIS_TLS_SKIP_VERIFY", false),
+		RedisOptional:      file.getBoolEnv("REDIS_OPTIONAL", false),
+
+		RedisMode:           file.getEnv("REDIS_MODE", RedisModeStandalone),
+		RedisSentinelMaster: file.getEnv("REDIS_SENTINEL_MASTER", ""),
+		RedisAddrs:          file.getListEnv("REDIS_ADDRS", ","),
+
+		StoreBackend:      file.getEnv("STORE_BACKEND", StoreBackendRedis),
+		PostgresDSN:       file.getEnv("POSTGRES_DSN", ""),
+		UsageRetention:    file.getDurationEnv("USAGE_RETENTION", 90*24*time.Hour),
+		MaxHistoryBytes:   file.getIntEnv("MAX_HISTORY_BYTES", 0),
+		HistoryTimestamps: file.getBoolEnv("HISTORY_TIMESTAMPS", false),
+		PIIClassification: file.getBoolEnv("PII_CLASSIFICATION", false),
+		PIIPatterns:       file.getListEnv("PII_PATTERNS", ";"),
+		MaxCheckpoints:    file.getIntEnv("MAX_CHECKPOINTS", 10),
+		MaxUserSessions:   file.getIntEnv("MAX_SESSIONS_PER_USER", 0),
+		SessionCacheSize:  file.getIntEnv("SESSION_CACHE_SIZE", 1000),
+		CacheJanitor:      file.getDurationEnv("SESSION_CACHE_JANITOR_INTERVAL", 5*time.Minute),
+		SummarizeAfter:    file.getIntEnv("SUMMARIZE_AFTER_MESSAGES", 0),
+		SummaryKeepRecent: file.getIntEnv("SUMMARY_KEEP_RECENT", 6),
+
+		MessageCatalogFile: file.getEnv("MESSAGE_CATALOG_FILE", ""),
+		PromptTemplateFile: file.getEnv("PROMPT_TEMPLATE_FILE", ""),
+
+		MaxAvailableActions: file.getIntEnv("MAX_AVAILABLE_ACTIONS", 0),
+		MaxUserMessageChars: file.getIntEnv("MAX_USER_MESSAGE_CHARS", 8000),
+		IdempotencyTTL:      file.getDurationEnv("IDEMPOTENCY_TTL", 10*time.Minute),
+		ResponseCacheTTL:    file.getDurationEnv("RESPONSE_CACHE_TTL", 0),
+		RateLimitPerMinute:  file.getIntEnv("RATE_LIMIT_PER_MINUTE", 0),
+		RateLimitBurst:      file.getIntEnv("RATE_LIMIT_BURST", 0),
+		RateLimitByUser:     file.getBoolEnv("RATE_LIMIT_BY_USER", false),
+		MinConfidence:       file.getFloatEnv("MIN_CONFIDENCE", 0),
+		ActionOverflowMode:  file.getEnv("ACTION_OVERFLOW_MODE", "error"),
 	}
 
-	// Validate
-	if cfg.AnthropicAPIKey == "" {
-		return nil, fmt.Errorf("ANTHROPIC_API_KEY is required")
+	actionGraph, err := parseActionGraph(file.lookup("ACTION_GRAPH"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ACTION_GRAPH: %w", err)
+	}
+	cfg.ActionGraph = actionGraph
+
+	prices, err := parseModelPrices(file.getMapEnv("MODEL_PRICES"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid MODEL_PRICES: %w", err)
+	}
+	cfg.ModelPrices = prices
+
+	weights, err := parseWeights(cfg.LLMProvider, file.getMapEnv("LLM_WEIGHTS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid LLM_WEIGHTS: %w", err)
+	}
+	cfg.LLMWeights = weights
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
 	}
 
 	return cfg, nil
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
+func (s source) getEnv(key, defaultValue string) string {
+	if value := s.lookup(key); value != "" {
 		return value
 	}
 	return defaultValue
 }
 
-func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
-	if value := os.Getenv(key); value != "" {
+func (s source) getDurationEnv(key string, defaultValue time.Duration) time.Duration {
+	if value := s.lookup(key); value != "" {
 		if duration, err := time.ParseDuration(value); err == nil {
 			return duration
 		}
 	}
 	return defaultValue
 }
+
+func (s source) getIntEnv(key string, defaultValue int) int {
+	if value := s.lookup(key); value != "" {
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+	}
+	return defaultValue
+}
+
+func (s source) getBoolEnv(key string, defaultValue bool) bool {
+	if value := s.lookup(key); value != "" {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return defaultValue
+}
+
+// getListEnv splits an environment variable on sep, dropping empty entries
+func (s source) getListEnv(key, sep string) []string {
+	var values []string
+	for _, v := range strings.Split(s.lookup(key), sep) {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// parseActionGraph parses "A:B|C;D:E" into {A: [B, C], D: [E]}
+func parseActionGraph(value string) (map[string][]string, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+
+	graph := make(map[string][]string)
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		action, next, found := strings.Cut(entry, ":")
+		action = strings.TrimSpace(action)
+		if !found || action == "" {
+			return nil, fmt.Errorf("entry %q must be ACTION:NEXT_ACTION|NEXT_ACTION", entry)
+		}
+
+		for _, n := range strings.Split(next, "|") {
+			if n = strings.TrimSpace(n); n != "" {
+				graph[action] = append(graph[action], n)
+			}
+		}
+	}
+	return graph, nil
+}
+
+// getMapEnv parses "key=value,key=value" pairs, skipping malformed entries
+func (s source) getMapEnv(key string) map[string]string {
+	pairs := s.getListEnv(key, ",")
+	if len(pairs) == 0 {
+		return nil
+	}
+
+	values := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		k, v, found := strings.Cut(pair, "=")
+		if !found {
+			continue
+		}
+		values[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return values
+}
+
+func (s source) getFloatEnv(key string, defaultValue float64) float64 {
+	if value := s.lookup(key); value != "" {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	return defaultValue
+}
+
+// usesProvider reports whether the named provider may serve requests
+func (c *Config) usesProvider(name string) bool {
+	if slices.Contains(c.LLMFallbackProviders, name) {
+		return true
+	}
+	if c.LLMProvider == "weighted" {
+		return c.LLMWeights[name] > 0
+	}
+	return c.LLMProvider == name
+}
+
+// ModelPrice is a model's price in cents per million tokens
+type ModelPrice struct {
+	Input  float64
+	Output float64
+}
+
+// parseModelPrices parses "input/output" price pairs keyed by model
+func parseModelPrices(raw map[string]string) (map[string]ModelPrice, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	prices := make(map[string]ModelPrice, len(raw))
+	for model, value := range raw {
+		input, output, found := strings.Cut(value, "/")
+		inputPrice, inputErr := strconv.ParseFloat(strings.TrimSpace(input), 64)
+		outputPrice, outputErr := strconv.ParseFloat(strings.TrimSpace(output), 64)
+		if !found || inputErr != nil || outputErr != nil || inputPrice < 0 || outputPrice < 0 {
+			return nil, fmt.Errorf("price for %q must be input/output cents per million tokens, got %q", model, value)
+		}
+		prices[model] = ModelPrice{Input: inputPrice, Output: outputPrice}
+	}
+	return prices, nil
+}
+
+// BudgetPrice returns the price used to enforce SESSION_BUDGET_CENTS.
+// Session totals aren't split by model, so they are priced at the
+// configured provider's model, or for the weighted provider at the most
+// expensive priced model.
+func (c *Config) BudgetPrice() (ModelPrice, bool) {
+	switch c.LLMProvider {
+	case "anthropic":
+		price, ok := c.ModelPrices[c.AnthropicModel]
+		return price, ok
+	case "openai":
+		price, ok := c.ModelPrices[c.OpenAIModel]
+		return price, ok
+	case "ollama":
+		price, ok := c.ModelPrices[c.OllamaModel]
+		return price, ok
+	}
+
+	var highest ModelPrice
+	for _, price := range c.ModelPrices {
+		if price.Input+price.Output > highest.Input+highest.Output {
+			highest = price
+		}
+	}
+	return highest, len(c.ModelPrices) > 0
+}
+
+// parseWeights converts LLM_WEIGHTS values to integers. Weights are only
+// read for the weighted provider.
+func parseWeights(provider string, raw map[string]string) (map[string]int, error) {
+	if provider != "weighted" {
+		return nil, nil
+	}
+
+	weights := make(map[string]int, len(raw))
+	for name, value := range raw {
+		weight, err := strconv.Atoi(value)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("weight for %q must be a non-negative integer, got %q", name, value)
+		}
+		weights[name] = weight
+	}
+	return weights, nil
+}
@@ -0,0 +1,202 @@
+package config
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRedisURLPrecedence(t *testing.T) {
+	tests := []struct {
+		name string
+		env  string // REDIS_URL in the environment, empty for unset
+		file string // redis_url in the config file, empty to omit
+		want string
+	}{
+		{name: "default", want: "redis://localhost:6379/0"},
+		{name: "file", file: "redis://file:6379/1", want: "redis://file:6379/1"},
+		{name: "environment", env: "redis://env:6379/2", want: "redis://env:6379/2"},
+		{name: "environment over file", env: "redis://env:6379/2", file: "redis://file:6379/1", want: "redis://env:6379/2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ANTHROPIC_API_KEY", "test-key")
+			t.Setenv("REDIS_URL", tt.env)
+
+			contents := "service_name: cdnbuddy-intent\n"
+			if tt.file != "" {
+				contents += "redis_url: " + tt.file + "\n"
+			}
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg, err := LoadFromFile(path)
+			if err != nil {
+				t.Fatalf("LoadFromFile() error = %v", err)
+			}
+			if cfg.RedisURL != tt.want {
+				t.Errorf("RedisURL = %q, want %q", cfg.RedisURL, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadDefault(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string // Contents of the CONFIG_FILE, empty to leave it unset
+		missing bool   // Point CONFIG_FILE at a file that doesn't exist
+		wantErr bool
+		want    string // ServiceName
+	}{
+		{name: "environment only", want: "cdnbuddy-intent"},
+		{name: "config file", file: "service_name: from-file\n", want: "from-file"},
+		{name: "missing config file", missing: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ANTHROPIC_API_KEY", "test-key")
+			t.Setenv("SERVICE_NAME", "")
+			t.Setenv(FileEnv, "")
+
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if tt.file != "" {
+				if err := os.WriteFile(path, []byte(tt.file), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.file != "" || tt.missing {
+				t.Setenv(FileEnv, path)
+			}
+
+			cfg, err := LoadDefault()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadDefault() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && cfg.ServiceName != tt.want {
+				t.Errorf("ServiceName = %q, want %q", cfg.ServiceName, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseActionGraph(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    map[string][]string
+		wantErr bool
+	}{
+		{name: "empty"},
+		{name: "single edge", value: "CREATE_SERVICE:ADD_DOMAIN", want: map[string][]string{"CREATE_SERVICE": {"ADD_DOMAIN"}}},
+		{
+			name:  "several actions with spaces",
+			value: " CREATE_SERVICE : ADD_DOMAIN | ENABLE_WAF ; PURGE_CACHE:CHECK_STATUS; ",
+			want:  map[string][]string{"CREATE_SERVICE": {"ADD_DOMAIN", "ENABLE_WAF"}, "PURGE_CACHE": {"CHECK_STATUS"}},
+		},
+		{name: "missing colon", value: "CREATE_SERVICE", wantErr: true},
+		{name: "missing action", value: ":ADD_DOMAIN", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseActionGraph(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseActionGraph() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !maps.EqualFunc(got, tt.want, slices.Equal) {
+				t.Errorf("parseActionGraph() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnthropicBaseURL(t *testing.T) {
+	tests := []struct {
+		name string
+		env  string // ANTHROPIC_BASE_URL, empty for unset
+		want string
+	}{
+		{name: "default", want: "https://api.anthropic.com"},
+		{name: "gateway", env: "https://llm-gateway.internal/anthropic", want: "https://llm-gateway.internal/anthropic"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ANTHROPIC_API_KEY", "test-key")
+			t.Setenv("ANTHROPIC_BASE_URL", tt.env)
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte("service_name: cdnbuddy-intent\n"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg, err := LoadFromFile(path)
+			if err != nil {
+				t.Fatalf("LoadFromFile() error = %v", err)
+			}
+			if cfg.AnthropicBaseURL != tt.want {
+				t.Errorf("AnthropicBaseURL = %q, want %q", cfg.AnthropicBaseURL, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		wantErrs []string // Every problem the error must list, none for a valid config
+	}{
+		{name: "defaults"},
+		{name: "missing API key", env: map[string]string{"ANTHROPIC_API_KEY": ""}, wantErrs: []string{"ANTHROPIC_API_KEY is required"}},
+		{name: "NATS URL without scheme", env: map[string]string{"NATS_URL": "localhost:4222"}, wantErrs: []string{"NATS_URL must be a URL"}},
+		{name: "one bad NATS server", env: map[string]string{"NATS_URL": "nats://a:4222, b:4222"}, wantErrs: []string{"NATS_URL must be a URL"}},
+		{name: "Redis URL without scheme", env: map[string]string{"REDIS_URL": "localhost:6379"}, wantErrs: []string{"REDIS_URL must be a URL"}},
+		{name: "Redis URL unused by the memory store", env: map[string]string{"REDIS_URL": "localhost:6379", "STORE_BACKEND": StoreBackendMemory}},
+		{name: "zero NATS timeout", env: map[string]string{"NATS_TIMEOUT": "0s"}, wantErrs: []string{"NATS_TIMEOUT must be positive"}},
+		{name: "negative Anthropic timeout", env: map[string]string{"ANTHROPIC_TIMEOUT": "-1s"}, wantErrs: []string{"ANTHROPIC_TIMEOUT must be positive"}},
+		{name: "port not a number", env: map[string]string{"PORT": "http"}, wantErrs: []string{"PORT must be a number"}},
+		{name: "port out of range", env: map[string]string{"PORT": "70000"}, wantErrs: []string{"PORT must be a number"}},
+		{
+			name:     "several problems",
+			env:      map[string]string{"REDIS_URL": "localhost:6379", "NATS_TIMEOUT": "0s", "PORT": "0"},
+			wantErrs: []string{"REDIS_URL must be a URL", "NATS_TIMEOUT must be positive", "PORT must be a number"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ANTHROPIC_API_KEY", "test-key")
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte("service_name: cdnbuddy-intent\n"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := LoadFromFile(path)
+			if len(tt.wantErrs) == 0 {
+				if err != nil {
+					t.Fatalf("LoadFromFile() error = %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("LoadFromFile() error = nil, want %q", tt.wantErrs)
+			}
+			for _, want := range tt.wantErrs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("LoadFromFile() error = %v, want it to mention %q", err, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// source holds settings read from a config file, keyed by environment
+// variable name. A nil source reads the environment only.
+type source map[string]string
+
+// lookup returns the environment variable if set, otherwise the file value
+func (s source) lookup(key string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return s[key]
+}
+
+// readYAMLFile reads a flat YAML mapping of setting names to scalar values.
+// Values are kept as strings and parsed like the environment variables
+// they stand in for, so list and map settings use the same formats.
+func readYAMLFile(path string) (source, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var nodes map[string]yaml.Node
+	if err := yaml.Unmarshal(data, &nodes); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	values := make(source, len(nodes))
+	for key, node := range nodes {
+		if node.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("config file %s: %s must be a single value", path, key)
+		}
+		values[strings.ToUpper(key)] = node.Value
+	}
+	return values, nil
+}
@@ -0,0 +1,146 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// validate checks the loaded settings and reports every problem at once
+func (c *Config) validate() error {
+	var errs []error
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// Credentials for every provider that can receive traffic
+	if c.usesProvider("anthropic") && c.AnthropicAPIKey == "" {
+		errs = append(errs, fmt.Errorf("ANTHROPIC_API_KEY is required"))
+	}
+	if c.usesProvider("openai") && c.OpenAIAPIKey == "" {
+		errs = append(errs, fmt.Errorf("OPENAI_API_KEY is required"))
+	}
+
+	// NATS_URL may list several servers separated by commas
+	for _, server := range strings.Split(c.NatsURL, ",") {
+		check(validateURL("NATS_URL", strings.TrimSpace(server)))
+	}
+	if strings.Trim(c.NatsRequestSubject, ", ") == "" {
+		errs = append(errs, fmt.Errorf("NATS_REQUEST_SUBJECT is required"))
+	}
+	check(validatePositive("NATS_TIMEOUT", c.NatsTimeout))
+	if c.NatsJetStream && (c.NatsStreamName == "" || c.NatsQueueGroup == "") {
+		errs = append(errs, fmt.Errorf("NATS_JETSTREAM requires NATS_STREAM and NATS_QUEUE_GROUP"))
+	}
+	check(c.validateNatsAuth())
+	if (c.NatsTLSCert == "") != (c.NatsTLSKey == "") {
+		errs = append(errs, fmt.Errorf("NATS_TLS_CERT and NATS_TLS_KEY must be set together"))
+	}
+	check(validatePositive("NATS_STREAM_GRACE", c.NatsStreamGrace))
+	check(validatePositive("ANTHROPIC_TIMEOUT", c.AnthropicTimeout))
+	check(validatePort(c.Port))
+
+	switch c.StoreBackend {
+	case StoreBackendRedis:
+		check(validateURL("REDIS_URL", c.RedisURL))
+		check(c.validateRedisMode())
+	case StoreBackendMemory:
+	case StoreBackendPostgres:
+		if c.PostgresDSN == "" {
+			errs = append(errs, fmt.Errorf("POSTGRES_DSN is required when STORE_BACKEND=%s", StoreBackendPostgres))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("STORE_BACKEND must be %q, %q or %q, got %q",
+			StoreBackendRedis, StoreBackendPostgres, StoreBackendMemory, c.StoreBackend))
+	}
+	if c.SessionBudgetCents > 0 {
+		if _, ok := c.BudgetPrice(); !ok {
+			errs = append(errs, fmt.Errorf("SESSION_BUDGET_CENTS requires a MODEL_PRICES entry for the configured model"))
+		}
+	}
+	if c.MinConfidence < 0 || c.MinConfidence > 1 {
+		errs = append(errs, fmt.Errorf("MIN_CONFIDENCE must be between 0 and 1, got %g", c.MinConfidence))
+	}
+	if c.ActionOverflowMode != "error" && c.ActionOverflowMode != "rank" {
+		errs = append(errs, fmt.Errorf("ACTION_OVERFLOW_MODE must be \"error\" or \"rank\", got %q", c.ActionOverflowMode))
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return nil
+}
+
+// validateNatsAuth allows at most one NATS authentication method
+func (c *Config) validateNatsAuth() error {
+	var methods []string
+	if c.NatsCredsFile != "" {
+		methods = append(methods, "NATS_CREDS")
+	}
+	if c.NatsToken != "" {
+		methods = append(methods, "NATS_TOKEN")
+	}
+	if c.NatsUser != "" {
+		methods = append(methods, "NATS_USER")
+	} else if c.NatsPassword != "" {
+		return fmt.Errorf("NATS_PASSWORD requires NATS_USER")
+	}
+	if len(methods) > 1 {
+		return fmt.Errorf("only one NATS authentication method may be set, got %s", strings.Join(methods, ", "))
+	}
+	return nil
+}
+
+// validateRedisMode checks that sentinel and cluster mode have the
+// addresses, and sentinel the master name, they need
+func (c *Config) validateRedisMode() error {
+	switch c.RedisMode {
+	case RedisModeStandalone:
+		return nil
+	case RedisModeSentinel:
+		if c.RedisSentinelMaster == "" {
+			return fmt.Errorf("REDIS_SENTINEL_MASTER is required when REDIS_MODE=%s", RedisModeSentinel)
+		}
+	case RedisModeCluster:
+	default:
+		return fmt.Errorf("REDIS_MODE must be %q, %q or %q, got %q",
+			RedisModeStandalone, RedisModeSentinel, RedisModeCluster, c.RedisMode)
+	}
+	if len(c.RedisAddrs) == 0 {
+		return fmt.Errorf("REDIS_ADDRS is required when REDIS_MODE=%s", c.RedisMode)
+	}
+	return nil
+}
+
+// validateURL requires a scheme and a host, catching values such as
+// "localhost:6379" that only fail later with a confusing client error
+func validateURL(key, value string) error {
+	u, err := url.Parse(value)
+	if err != nil {
+		return fmt.Errorf("%s is not a valid URL: %w", key, err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("%s must be a URL like scheme://host:port, got %q", key, value)
+	}
+	return nil
+}
+
+func validatePositive(key string, d time.Duration) error {
+	if d <= 0 {
+		return fmt.Errorf("%s must be positive, got %s", key, d)
+	}
+	return nil
+}
+
+func validatePort(port string) error {
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("PORT must be a number between 1 and 65535, got %q", port)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package diagnostics
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/config"
+	"github.com/avvvet/cdnbuddy-intent/internal/llm"
+	"github.com/avvvet/cdnbuddy-intent/internal/memory"
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+	"github.com/avvvet/cdnbuddy-intent/internal/transport"
+	"github.com/nats-io/nats.go"
+)
+
+// Check is a single named dependency check
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// Result holds the outcome of a single check
+type Result struct {
+	Name     string
+	Err      error
+	Duration time.Duration
+}
+
+// Passed reports whether the check succeeded
+func (r Result) Passed() bool {
+	return r.Err == nil
+}
+
+// Run executes the checks in order, giving each one its own timeout
+func Run(ctx context.Context, checks []Check, timeout time.Duration) []Result {
+	results := make([]Result, 0, len(checks))
+	for _, check := range checks {
+		checkCtx, cancel := context.WithTimeout(ctx, timeout)
+		start := time.Now()
+		err := check.Run(checkCtx)
+		cancel()
+
+		results = append(results, Result{
+			Name:     check.Name,
+			Err:      err,
+			Duration: time.Since(start),
+		})
+	}
+	return results
+}
+
+// AllPassed reports whether every result succeeded
+func AllPassed(results []Result) bool {
+	for _, r := range results {
+		if !r.Passed() {
+			return false
+		}
+	}
+	return true
+}
+
+// Pinger is implemented by stores that can verify their connection
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// StoreCheck verifies the session store connection is alive. The check is
+// named after the backend; stores that can't be pinged, like the in-memory
+// one, always pass.
+func StoreCheck(backend string, store memory.Store) Check {
+	return Check{
+		Name: backend,
+		Run: func(ctx context.Context) error {
+			if pinger, ok := store.(Pinger); ok {
+				return pinger.Ping(ctx)
+			}
+			return nil
+		},
+	}
+}
+
+// NATSCheck connects to NATS with the same authentication and TLS settings
+// as the server and flushes a round-trip to it
+func NATSCheck(cfg *config.Config) Check {
+	return Check{
+		Name: "nats",
+		Run: func(ctx context.Context) error {
+			opts := append(transport.ConnectOptions(cfg), nats.Name(cfg.ServiceName+"-doctor"))
+			conn, err := nats.Connect(cfg.NatsURL, opts...)
+			if err != nil {
+				return fmt.Errorf("failed to connect: %w", err)
+			}
+			defer conn.Close()
+
+			if err := conn.FlushWithContext(ctx); err != nil {
+				return fmt.Errorf("failed to flush: %w", err)
+			}
+			return nil
+		},
+	}
+}
+
+// LLMCheck sends a trivial prompt through the provider and expects a
+// parseable intent response back. It spends a small number of tokens.
+func LLMCheck(provider llm.LLMProvider, sessionID string) Check {
+	return Check{
+		Name: "llm",
+		Run: func(ctx context.Context) error {
+			if provider == nil {
+				return fmt.Errorf("provider not initialized")
+			}
+			response, err := provider.AnalyzeIntent(ctx, &models.IntentRequest{
+				SessionID:   sessionID,
+				UserMessage: "Hello",
+			})
+			if err != nil {
+				return err
+			}
+			if response.Status == models.StatusError {
+				return fmt.Errorf("provider returned status %s", response.Status)
+			}
+			return nil
+		},
+	}
+}
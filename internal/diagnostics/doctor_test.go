@@ -0,0 +1,192 @@
+package diagnostics
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/config"
+	"github.com/avvvet/cdnbuddy-intent/internal/llm"
+	"github.com/avvvet/cdnbuddy-intent/internal/memory"
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+	"github.com/nats-io/nats-server/v2/server"
+)
+
+// stubStore is a session store whose ping fails with err
+type stubStore struct {
+	memory.Store
+	err error
+}
+
+func (s stubStore) Ping(ctx context.Context) error {
+	return s.err
+}
+
+// runNATSServer starts an embedded NATS server for the test
+func runNATSServer(t *testing.T, opts *server.Options) *server.Server {
+	t.Helper()
+	opts.Host = "127.0.0.1"
+	opts.Port = -1
+	opts.NoLog = true
+	opts.NoSigs = true
+
+	ns, err := server.NewServer(opts)
+	if err != nil {
+		t.Fatalf("failed to create NATS server: %v", err)
+	}
+	ns.Start()
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server not ready")
+	}
+	t.Cleanup(ns.Shutdown)
+	return ns
+}
+
+func TestStoreCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		store   memory.Store
+		wantErr bool
+	}{
+		{name: "ping succeeds", store: stubStore{}},
+		{name: "ping fails", store: stubStore{err: errors.New("connection refused")}, wantErr: true},
+		{name: "store without ping", store: memory.NewInMemoryStore(time.Minute)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := StoreCheck("redis", tt.store)
+			if check.Name != "redis" {
+				t.Errorf("Name = %q, want the backend", check.Name)
+			}
+			err := check.Run(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLLMCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		response *models.IntentResponse
+		err      error
+		wantErr  bool
+	}{
+		{
+			name:     "ready response",
+			response: &models.IntentResponse{Status: models.StatusReady},
+		},
+		{
+			name:     "needs info response",
+			response: &models.IntentResponse{Status: models.StatusNeedsInfo},
+		},
+		{
+			name:     "error status",
+			response: &models.IntentResponse{Status: models.StatusError},
+			wantErr:  true,
+		},
+		{
+			name:    "provider error",
+			err:     errors.New("rate limited"),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := llm.NewMockProvider()
+			provider.Enqueue(tt.response, tt.err)
+
+			err := LLMCheck(provider, "doctor").Run(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLLMCheckNilProvider(t *testing.T) {
+	if err := LLMCheck(nil, "doctor").Run(context.Background()); err == nil {
+		t.Fatal("expected an error for a nil provider")
+	}
+}
+
+func TestNATSCheck(t *testing.T) {
+	open := runNATSServer(t, &server.Options{})
+	secured := runNATSServer(t, &server.Options{Username: "intent", Password: "secret"})
+
+	tests := []struct {
+		name    string
+		url     string
+		user    string
+		pass    string
+		wantErr bool
+	}{
+		{name: "reachable server", url: open.ClientURL()},
+		{name: "credentials from config", url: secured.ClientURL(), user: "intent", pass: "secret"},
+		{name: "wrong credentials", url: secured.ClientURL(), user: "intent", pass: "wrong", wantErr: true},
+		{name: "missing credentials", url: secured.ClientURL(), wantErr: true},
+		{name: "unreachable server", url: "nats://127.0.0.1:1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				ServiceName:  "cdnbuddy-intent",
+				NatsURL:      tt.url,
+				NatsTimeout:  time.Second,
+				NatsUser:     tt.user,
+				NatsPassword: tt.pass,
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			err := NATSCheck(cfg).Run(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunAndAllPassed(t *testing.T) {
+	pass := Check{Name: "pass", Run: func(ctx context.Context) error { return nil }}
+	fail := Check{Name: "fail", Run: func(ctx context.Context) error { return errors.New("down") }}
+	slow := Check{Name: "slow", Run: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+
+	tests := []struct {
+		name       string
+		checks     []Check
+		wantNames  []string
+		wantPassed bool
+	}{
+		{name: "no checks", wantPassed: true},
+		{name: "all pass", checks: []Check{pass, pass}, wantNames: []string{"pass", "pass"}, wantPassed: true},
+		{name: "one fails", checks: []Check{pass, fail}, wantNames: []string{"pass", "fail"}},
+		{name: "timeout fails", checks: []Check{slow, pass}, wantNames: []string{"slow", "pass"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := Run(context.Background(), tt.checks, 50*time.Millisecond)
+			if len(results) != len(tt.wantNames) {
+				t.Fatalf("got %d results, want %d", len(results), len(tt.wantNames))
+			}
+			for i, result := range results {
+				if result.Name != tt.wantNames[i] {
+					t.Errorf("result %d name = %q, want %q", i, result.Name, tt.wantNames[i])
+				}
+			}
+			if got := AllPassed(results); got != tt.wantPassed {
+				t.Errorf("AllPassed() = %v, want %v", got, tt.wantPassed)
+			}
+		})
+	}
+}
@@ -2,52 +2,482 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/avvvet/cdnbuddy-intent/internal/llm"
+	"github.com/avvvet/cdnbuddy-intent/internal/memory"
+	"github.com/avvvet/cdnbuddy-intent/internal/metrics"
 	"github.com/avvvet/cdnbuddy-intent/internal/models"
 	"github.com/avvvet/cdnbuddy-intent/internal/prompts"
 )
 
+// Overflow modes for requests carrying more actions than the configured cap
+const (
+	ActionOverflowError = "error"
+	ActionOverflowRank  = "rank"
+)
+
 type IntentHandler struct {
-	provider llm.LLMProvider
+	provider        llm.LLMProvider
+	maxActions      int // 0 means unlimited
+	actionOverflow  string
+	actionGraph     map[string][]string // READY action -> suggested next actions
+	memoryManager   *memory.Manager     // Optional, enables memory slots
+	idempotency     IdempotencyStore    // Optional, replays responses to retried requests
+	catalogs        ActionCatalog       // Optional, resolves catalog_id
+	rateLimiter     RateLimiter         // Optional, throttles requests per session
+	maxMessageChars int                 // 0 means unlimited
+	minConfidence   float64             // READY responses below this ask for confirmation, 0 disables it
+	limitByUser     bool                // Also throttle per user when the request names one
+	logger          *slog.Logger
+
+	// Spend cap per session in cents, with prices in cents per million tokens
+	sessionBudget float64 // 0 disables the cap
+	inputPrice    float64
+	outputPrice   float64
+}
+
+// RateLimiter decides whether a request for key may proceed
+type RateLimiter interface {
+	Allow(ctx context.Context, key string) (bool, error)
+}
+
+// IdempotencyStore remembers responses by request ID
+type IdempotencyStore interface {
+	Get(ctx context.Context, sessionID, requestID string) (*models.IntentResponse, error)
+	Save(ctx context.Context, sessionID, requestID string, response *models.IntentResponse) error
+}
+
+// ActionCatalog looks up actions registered under a catalog ID
+type ActionCatalog interface {
+	Get(ctx context.Context, id string) ([]models.ActionSchema, error)
+}
+
+// Option configures optional IntentHandler behaviour
+type Option func(*IntentHandler)
+
+// WithMaxActions caps how many available actions are sent to the model.
+// In ActionOverflowError mode oversized requests are rejected; in
+// ActionOverflowRank mode only the actions most relevant to the user
+// message are kept.
+func WithMaxActions(max int, overflowMode string) Option {
+	return func(h *IntentHandler) {
+		h.maxActions = max
+		h.actionOverflow = overflowMode
+	}
+}
+
+// WithActionGraph enables proactive suggestions: when a response is READY
+// for an action, the graph's follow-up actions are attached to it
+func WithActionGraph(graph map[string][]string) Option {
+	return func(h *IntentHandler) {
+		h.actionGraph = graph
+	}
+}
+
+// WithLogger sets the structured logger, slog.Default() otherwise
+func WithLogger(l *slog.Logger) Option {
+	return func(h *IntentHandler) {
+		h.logger = l
+	}
+}
+
+// WithMemoryManager lets the handler remember extracted parameters as
+// session memory slots so later turns see them as known facts
+func WithMemoryManager(m *memory.Manager) Option {
+	return func(h *IntentHandler) {
+		h.memoryManager = m
+	}
 }
 
-func NewIntentHandler(provider llm.LLMProvider) *IntentHandler {
-	return &IntentHandler{
-		provider: provider,
+// WithIdempotency answers requests carrying an already seen request_id with
+// the stored response instead of calling the model again
+func WithIdempotency(s IdempotencyStore) Option {
+	return func(h *IntentHandler) {
+		h.idempotency = s
 	}
 }
 
+// WithActionCatalog lets requests reference a registered action catalog
+func WithActionCatalog(c ActionCatalog) Option {
+	return func(h *IntentHandler) {
+		h.catalogs = c
+	}
+}
+
+// WithRateLimiter throttles requests per session before they reach the
+// model, and per user as well when byUser is set and the request carries a
+// user_id
+func WithRateLimiter(l RateLimiter, byUser bool) Option {
+	return func(h *IntentHandler) {
+		h.rateLimiter = l
+		h.limitByUser = byUser
+	}
+}
+
+// WithMaxMessageChars rejects user messages longer than n characters.
+// Zero disables the cap.
+func WithMaxMessageChars(n int) Option {
+	return func(h *IntentHandler) {
+		h.maxMessageChars = n
+	}
+}
+
+// WithMinConfidence turns READY responses whose confidence is below min
+// into NEEDS_INFO, asking the user to confirm before the backend acts.
+// Zero disables the check.
+func WithMinConfidence(min float64) Option {
+	return func(h *IntentHandler) {
+		h.minConfidence = min
+	}
+}
+
+// WithSessionBudget refuses requests once a session's token totals, priced
+// at inputPrice and outputPrice cents per million tokens, reach budget
+// cents. It needs WithMemoryManager for the totals.
+func WithSessionBudget(budget, inputPrice, outputPrice float64) Option {
+	return func(h *IntentHandler) {
+		h.sessionBudget = budget
+		h.inputPrice = inputPrice
+		h.outputPrice = outputPrice
+	}
+}
+
+func NewIntentHandler(provider llm.LLMProvider, opts ...Option) *IntentHandler {
+	h := &IntentHandler{
+		provider:       provider,
+		actionOverflow: ActionOverflowError,
+		logger:         slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// ProcessIntent is the entry point for every intent request. The provider
+// owns the conversation: it saves the user message, builds the prompt from
+// the stored history, calls the model and saves the reply. The handler
+// validates the request beforehand and cleans up the response afterwards.
 func (h *IntentHandler) ProcessIntent(ctx context.Context, request *models.IntentRequest) (*models.IntentResponse, error) {
+	return h.process(ctx, request, nil)
+}
+
+// ProcessIntentStream is ProcessIntent with the model's user_message passed
+// to onChunk as it is generated. Responses that don't come from the model,
+// such as validation errors and replays, produce no chunks.
+func (h *IntentHandler) ProcessIntentStream(ctx context.Context, request *models.IntentRequest, onChunk llm.ChunkFunc) (*models.IntentResponse, error) {
+	return h.process(ctx, request, onChunk)
+}
+
+// process handles a request, streaming the model's reply when onChunk is set
+func (h *IntentHandler) process(ctx context.Context, request *models.IntentRequest, onChunk llm.ChunkFunc) (*models.IntentResponse, error) {
+	// Keep each tenant's sessions apart
+	ctx = memory.WithTenant(ctx, request.Tenant)
+	if request.SessionTTLSeconds > 0 {
+		ctx = memory.WithSessionTTL(ctx, time.Duration(request.SessionTTLSeconds)*time.Second)
+	}
+
 	// Validate request
 	if err := h.validateRequest(request); err != nil {
 		return h.createErrorResponse(request, models.ErrorParseError, err.Error()), nil
 	}
 
-	// Call LLM provider - this will now use AnthropicProvider.AnalyzeIntent
-	response, err := h.provider.AnalyzeIntent(ctx, request)
+	// Replay the response to a retried request
+	if replayed := h.replay(ctx, request); replayed != nil {
+		return replayed, nil
+	}
+
+	// Start over without asking the model when the caller says so
+	if request.Reset {
+		return h.resetSession(ctx, request), nil
+	}
+
+	// Throttle clients sending too many requests
+	if !h.allowRequest(ctx, request) {
+		response := h.createErrorResponse(request, models.ErrorRateLimited, "rate limit exceeded")
+		response.UserMessage = prompts.Localize(request.MessageLocale(), prompts.MsgRateLimited)
+		return response, nil
+	}
+
+	// Add the actions of the referenced catalog
+	if err := h.resolveCatalog(ctx, request); err != nil {
+		return h.createErrorResponse(request, models.ErrorParseError, err.Error()), nil
+	}
+
+	// Keep the action list within the prompt budget
+	if err := h.limitActions(ctx, request); err != nil {
+		return h.createErrorResponse(request, models.ErrorParseError, err.Error()), nil
+	}
+
+	// Refuse sessions that have used up their budget
+	if !h.withinBudget(ctx, request) {
+		response := h.createErrorResponse(request, models.ErrorBudgetExceeded, "session budget exceeded")
+		response.UserMessage = prompts.Localize(request.MessageLocale(), prompts.MsgBudget)
+		return response, nil
+	}
+
+	// Call the LLM provider
+	var response *models.IntentResponse
+	var err error
+	if onChunk != nil {
+		response, err = llm.AnalyzeIntentStream(ctx, h.provider, request, onChunk)
+	} else {
+		response, err = h.provider.AnalyzeIntent(ctx, request)
+	}
+	if errors.Is(err, memory.ErrSessionLimitExceeded) {
+		return h.createErrorResponse(request, models.ErrorSessionLimit, err.Error()), nil
+	}
+	if errors.Is(err, llm.ErrCircuitOpen) {
+		response := h.createErrorResponse(request, models.ErrorLLMFailed, err.Error())
+		response.UserMessage = prompts.Localize(request.MessageLocale(), prompts.MsgUnavailable)
+		return response, nil
+	}
+	if errors.Is(err, llm.ErrUnparsableReply) {
+		return h.createErrorResponse(request, models.ErrorParseError, err.Error()), nil
+	}
+	if isTimeout(err) {
+		return h.createErrorResponse(request, models.ErrorLLMTimeout, err.Error()), nil
+	}
 	if err != nil {
 		return h.createErrorResponse(request, models.ErrorLLMFailed, err.Error()), nil
 	}
 
+	// The user asked to start over
+	if response.Action != nil && *response.Action == models.ActionReset {
+		return h.resetSession(ctx, request), nil
+	}
+
 	// Validate and clean response
-	h.validateAndCleanResponse(response)
+	h.validateAndCleanResponse(request, response)
+
+	// Flag requests the model couldn't map to any action
+	h.markUnknownIntent(ctx, request, response)
+
+	// Ask for confirmation instead of acting on a guess
+	h.requireConfidence(ctx, request, response)
 
-	log.Printf("Intent processed for session %s: action=%v, status=%s",
-		request.SessionID, response.Action, response.Status)
+	// Move parameters collected for a different action out of the response
+	// and keep them for that action
+	h.keepRoutedParameters(ctx, request, h.routeParameters(ctx, request, response))
+
+	// Keep parameters collected in earlier turns for the same action
+	h.carryForwardParameters(ctx, request, response)
+
+	// Offer related next steps once an action is ready
+	h.addSuggestions(response)
+
+	// Remember extracted parameters for later turns
+	h.rememberParameters(ctx, request, response)
+
+	h.logger.InfoContext(ctx, "intent processed", "session_id", request.SessionID,
+		"action", response.Action, "status", response.Status)
+	recordIntent(response)
+
+	// Measure how long users take to get to an action
+	h.recordTurnsToReady(ctx, request, response)
+
+	// Remember the response so a retry doesn't save the message twice
+	if h.idempotency != nil && request.RequestID != "" {
+		if err := h.idempotency.Save(ctx, request.SessionID, request.RequestID, response); err != nil {
+			h.logger.WarnContext(ctx, "failed to store response for replay", "session_id", request.SessionID,
+				"request_id", request.RequestID, "error", err)
+		}
+	}
 
 	return response, nil
 }
 
+// recordIntent counts a model response by action and final status
+func recordIntent(response *models.IntentResponse) {
+	action := "null"
+	if response.Action != nil {
+		action = *response.Action
+	}
+	metrics.IntentActions.Add(action, 1)
+	metrics.IntentStatuses.Add(response.Status, 1)
+}
+
+// recordTurnsToReady records the turns a READY response took in the
+// session and the metrics histogram
+func (h *IntentHandler) recordTurnsToReady(ctx context.Context, request *models.IntentRequest, response *models.IntentResponse) {
+	if response.Status != models.StatusReady || h.memoryManager == nil {
+		return
+	}
+
+	turns, err := h.memoryManager.RecordReady(ctx, request.SessionID)
+	if err != nil {
+		h.logger.WarnContext(ctx, "failed to record turns to READY", "session_id", request.SessionID, "error", err)
+		return
+	}
+
+	bucket := strconv.Itoa(turns)
+	if turns >= 10 {
+		bucket = "10+"
+	}
+	metrics.TurnsToReady.Add(bucket, 1)
+	h.logger.DebugContext(ctx, "READY reached", "session_id", request.SessionID, "turns", turns)
+}
+
+// resetSession clears the conversation from the store and the cache and
+// returns a fresh greeting
+func (h *IntentHandler) resetSession(ctx context.Context, request *models.IntentRequest) *models.IntentResponse {
+	if h.memoryManager == nil {
+		h.logger.WarnContext(ctx, "reset requested but no memory manager is configured", "session_id", request.SessionID)
+	} else if err := h.memoryManager.ClearSession(ctx, request.SessionID); err != nil {
+		h.logger.ErrorContext(ctx, "failed to reset session", "session_id", request.SessionID, "error", err)
+		return h.createErrorResponse(request, models.ErrorLLMFailed, err.Error())
+	}
+
+	action := models.ActionReset
+	return &models.IntentResponse{
+		SessionID:   request.SessionID,
+		Action:      &action,
+		Status:      models.StatusNeedsInfo, // Waiting for what to do next
+		Parameters:  make(map[string]*string),
+		UserMessage: prompts.Localize(request.MessageLocale(), prompts.MsgReset),
+	}
+}
+
+// allowRequest checks the session's, and optionally the user's, rate limit.
+// Limits are kept per tenant. If the limiter fails the request is let
+// through.
+func (h *IntentHandler) allowRequest(ctx context.Context, request *models.IntentRequest) bool {
+	if h.rateLimiter == nil {
+		return true
+	}
+
+	var prefix string
+	if tenant := memory.TenantFromContext(ctx); tenant != "" {
+		prefix = "tenant:" + tenant + ":"
+	}
+	keys := []string{prefix + "session:" + request.SessionID}
+	if h.limitByUser && request.UserID != "" {
+		keys = append(keys, prefix+"user:"+request.UserID)
+	}
+	for _, key := range keys {
+		allowed, err := h.rateLimiter.Allow(ctx, key)
+		if err != nil {
+			h.logger.WarnContext(ctx, "rate limit check failed", "session_id", request.SessionID, "key", key, "error", err)
+			continue
+		}
+		if !allowed {
+			h.logger.WarnContext(ctx, "rate limit exceeded", "session_id", request.SessionID, "key", key)
+			return false
+		}
+	}
+	return true
+}
+
+// resolveCatalog merges the request's catalog into its available actions.
+// Inline actions win over catalog actions with the same name.
+func (h *IntentHandler) resolveCatalog(ctx context.Context, request *models.IntentRequest) error {
+	if request.CatalogID == "" {
+		return nil
+	}
+	if h.catalogs == nil {
+		return fmt.Errorf("catalog_id is not supported: no action catalog is configured")
+	}
+
+	actions, err := h.catalogs.Get(ctx, request.CatalogID)
+	if err != nil {
+		return fmt.Errorf("failed to resolve catalog %q: %w", request.CatalogID, err)
+	}
+
+	inline := make(map[string]bool, len(request.AvailableActions))
+	for _, action := range request.AvailableActions {
+		inline[action.Action] = true
+	}
+	for _, action := range actions {
+		if !inline[action.Action] {
+			request.AvailableActions = append(request.AvailableActions, action)
+		}
+	}
+	return nil
+}
+
+// withinBudget reports whether the session's spend so far is below the
+// budget. Sessions whose totals can't be loaded are let through.
+func (h *IntentHandler) withinBudget(ctx context.Context, request *models.IntentRequest) bool {
+	if h.sessionBudget <= 0 || h.memoryManager == nil {
+		return true
+	}
+
+	inputTokens, outputTokens, err := h.memoryManager.GetUsage(ctx, request.SessionID)
+	if err != nil {
+		h.logger.WarnContext(ctx, "failed to load session usage, skipping budget check", "session_id", request.SessionID, "error", err)
+		return true
+	}
+
+	spent := (float64(inputTokens)*h.inputPrice + float64(outputTokens)*h.outputPrice) / 1e6
+	if spent < h.sessionBudget {
+		return true
+	}
+	h.logger.WarnContext(ctx, "session budget exceeded", "session_id", request.SessionID,
+		"spent_cents", spent, "budget_cents", h.sessionBudget)
+	return false
+}
+
+// replay returns the stored response for a request ID seen before, or nil.
+// Lookup failures are logged and the request is processed normally.
+func (h *IntentHandler) replay(ctx context.Context, request *models.IntentRequest) *models.IntentResponse {
+	if h.idempotency == nil || request.RequestID == "" {
+		return nil
+	}
+
+	response, err := h.idempotency.Get(ctx, request.SessionID, request.RequestID)
+	if err != nil {
+		h.logger.WarnContext(ctx, "failed to look up stored response", "session_id", request.SessionID,
+			"request_id", request.RequestID, "error", err)
+		return nil
+	}
+	if response != nil {
+		h.logger.InfoContext(ctx, "replaying response to retried request", "session_id", request.SessionID,
+			"request_id", request.RequestID)
+		response.Replayed = true
+	}
+	return response
+}
+
+// isTimeout reports whether an LLM call failed by running out of time,
+// either on the request deadline or on the HTTP client timeout
+func isTimeout(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var timeout interface{ Timeout() bool }
+	return errors.As(err, &timeout) && timeout.Timeout()
+}
+
 func (h *IntentHandler) validateRequest(request *models.IntentRequest) error {
 	if request.SessionID == "" {
 		return fmt.Errorf("session_id is required")
 	}
-	if request.UserMessage == "" {
+
+	// Drop control characters before the message is stored or prompted
+	request.UserMessage = sanitizeMessage(request.UserMessage)
+	for i := range request.ConversationHistory {
+		request.ConversationHistory[i].Message = sanitizeMessage(request.ConversationHistory[i].Message)
+	}
+
+	if strings.TrimSpace(request.UserMessage) == "" && !request.Reset {
 		return fmt.Errorf("user_message is required")
 	}
+	if h.maxMessageChars > 0 {
+		if n := utf8.RuneCountInString(request.UserMessage); n > h.maxMessageChars {
+			return fmt.Errorf("user_message is %d characters, the limit is %d", n, h.maxMessageChars)
+		}
+	}
 	/* on request we don't need action for now
 	if len(request.AvailableActions) == 0 {
 		return fmt.Errorf("available_actions is required")
@@ -56,19 +486,37 @@ func (h *IntentHandler) validateRequest(request *models.IntentRequest) error {
 	return nil
 }
 
-func (h *IntentHandler) callLLM(ctx context.Context, request *llm.LLMRequest) (*llm.LLMResponse, error) {
-	// For now, we'll implement this in the Anthropic provider
-	// This is a placeholder that will be replaced when we implement the actual LLM call
-	return &llm.LLMResponse{
-		Content: `{"action": "CREATE_SERVICE", "status": "NEEDS_INFO", "parameters": {"domain_name": null}, "user_message": "I can help you setup a CDN! What would you like to name your service?"}`,
-		Usage: &llm.Usage{
-			InputTokens:  100,
-			OutputTokens: 50,
-		},
-	}, nil
+// sanitizeMessage removes null bytes and other control characters, keeping
+// tabs and line breaks, and replaces invalid UTF-8
+func sanitizeMessage(message string) string {
+	message = strings.ToValidUTF8(message, "\uFFFD")
+	return strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' || r == '\r' || !unicode.IsControl(r) {
+			return r
+		}
+		return -1
+	}, message)
+}
+
+// limitActions enforces the available actions cap, either rejecting the
+// request or trimming it to the most relevant actions
+func (h *IntentHandler) limitActions(ctx context.Context, request *models.IntentRequest) error {
+	if h.maxActions <= 0 || len(request.AvailableActions) <= h.maxActions {
+		return nil
+	}
+
+	if h.actionOverflow != ActionOverflowRank {
+		return fmt.Errorf("too many available_actions: got %d, maximum is %d",
+			len(request.AvailableActions), h.maxActions)
+	}
+
+	h.logger.InfoContext(ctx, "trimming available actions", "session_id", request.SessionID,
+		"from", len(request.AvailableActions), "to", h.maxActions)
+	request.AvailableActions = prompts.RankActions(request.AvailableActions, request.UserMessage, h.maxActions)
+	return nil
 }
 
-func (h *IntentHandler) validateAndCleanResponse(response *models.IntentResponse) {
+func (h *IntentHandler) validateAndCleanResponse(request *models.IntentRequest, response *models.IntentResponse) {
 	// Ensure status is valid
 	validStatuses := map[string]bool{
 		models.StatusNeedsInfo: true,
@@ -78,7 +526,7 @@ func (h *IntentHandler) validateAndCleanResponse(response *models.IntentResponse
 
 	if !validStatuses[response.Status] {
 		response.Status = models.StatusError
-		response.UserMessage = prompts.FallbackMessage
+		response.UserMessage = prompts.Localize(request.MessageLocale(), prompts.MsgFallback)
 	}
 
 	// Ensure parameters is not nil
@@ -88,7 +536,171 @@ func (h *IntentHandler) validateAndCleanResponse(response *models.IntentResponse
 
 	// Ensure user_message is not empty
 	if response.UserMessage == "" {
-		response.UserMessage = "How can I help you with your CDN setup?"
+		response.UserMessage = prompts.Localize(request.MessageLocale(), prompts.MsgGreeting)
+	}
+}
+
+// markUnknownIntent sets ErrorUnknownIntent on error responses that don't
+// resolve to one of the request's available actions, so the backend can
+// offer help or escalate. A null action with NEEDS_INFO is a clarifying
+// question and is left alone.
+func (h *IntentHandler) markUnknownIntent(ctx context.Context, request *models.IntentRequest, response *models.IntentResponse) {
+	if response.Status != models.StatusError || response.ErrorCode != nil {
+		return
+	}
+	if response.Action != nil {
+		for _, action := range request.AvailableActions {
+			if action.Action == *response.Action {
+				return
+			}
+		}
+	}
+
+	code := models.ErrorUnknownIntent
+	message := "request does not match any available action"
+	response.ErrorCode = &code
+	response.ErrorMessage = &message
+	h.logger.InfoContext(ctx, "unknown intent", "session_id", request.SessionID, "action", response.Action)
+}
+
+// requireConfidence downgrades a READY response the model isn't confident
+// about to NEEDS_INFO and asks the user to confirm
+func (h *IntentHandler) requireConfidence(ctx context.Context, request *models.IntentRequest, response *models.IntentResponse) {
+	if h.minConfidence <= 0 || response.Status != models.StatusReady || response.Confidence >= h.minConfidence {
+		return
+	}
+
+	h.logger.InfoContext(ctx, "low confidence, asking for confirmation", "session_id", request.SessionID,
+		"action", response.Action, "confidence", response.Confidence)
+	response.Status = models.StatusNeedsInfo
+	response.UserMessage = strings.TrimSpace(response.UserMessage + " " + prompts.Localize(request.MessageLocale(), prompts.MsgConfirm))
+}
+
+// addSuggestions attaches follow-up actions from the action graph to READY
+// responses
+func (h *IntentHandler) addSuggestions(response *models.IntentResponse) {
+	if h.actionGraph == nil || response.Status != models.StatusReady || response.Action == nil {
+		return
+	}
+	if next := h.actionGraph[*response.Action]; len(next) > 0 {
+		response.SuggestedNextActions = next
+	}
+}
+
+// routeParameters removes parameters that are not part of the returned
+// action's schema. Values belonging to another available action are
+// returned by owning action so they can be kept for it; the rest are
+// dropped.
+func (h *IntentHandler) routeParameters(ctx context.Context, request *models.IntentRequest, response *models.IntentResponse) map[string]map[string]string {
+	if response.Action == nil {
+		return nil
+	}
+
+	// Index which action owns each parameter
+	var schema []models.ParameterSpec
+	found := false
+	owners := make(map[string]string)
+	for _, action := range request.AvailableActions {
+		if action.Action == *response.Action {
+			schema = action.Parameters
+			found = true
+			continue
+		}
+		for _, param := range action.Parameters {
+			if _, ok := owners[param.Name]; !ok {
+				owners[param.Name] = action.Action
+			}
+		}
+	}
+	if !found {
+		return nil // Unknown action, nothing to validate against
+	}
+
+	allowed := make(map[string]bool, len(schema))
+	for _, param := range schema {
+		allowed[param.Name] = true
+	}
+
+	routed := make(map[string]map[string]string)
+	for name, value := range response.Parameters {
+		if allowed[name] {
+			continue
+		}
+		delete(response.Parameters, name)
+
+		owner, ok := owners[name]
+		if !ok || value == nil || *value == "" {
+			h.logger.WarnContext(ctx, "dropped parameter not in action schema", "session_id", request.SessionID,
+				"parameter", name, "action", *response.Action)
+			continue
+		}
+		h.logger.InfoContext(ctx, "routed parameter to its action", "session_id", request.SessionID,
+			"parameter", name, "from", *response.Action, "to", owner)
+		if routed[owner] == nil {
+			routed[owner] = make(map[string]string)
+		}
+		routed[owner][name] = *value
+	}
+	return routed
+}
+
+// keepRoutedParameters stores parameters routed to other actions with the
+// session, so they are filled in once the conversation reaches that action
+func (h *IntentHandler) keepRoutedParameters(ctx context.Context, request *models.IntentRequest, routed map[string]map[string]string) {
+	if h.memoryManager == nil || len(routed) == 0 {
+		return
+	}
+	if err := h.memoryManager.RouteParameters(ctx, request.SessionID, routed); err != nil {
+		h.logger.WarnContext(ctx, "failed to save routed parameters", "session_id", request.SessionID, "error", err)
+	}
+}
+
+// rememberParameters stores every non-null extracted parameter as a memory
+// slot
+func (h *IntentHandler) rememberParameters(ctx context.Context, request *models.IntentRequest, response *models.IntentResponse) {
+	if h.memoryManager == nil {
+		return
+	}
+
+	slots := make(map[string]string, len(response.Parameters))
+	for name, value := range response.Parameters {
+		if value != nil && *value != "" {
+			slots[name] = *value
+		}
+	}
+
+	if err := h.memoryManager.SetSlots(ctx, request.SessionID, slots); err != nil {
+		h.logger.WarnContext(ctx, "failed to save memory slots", "session_id", request.SessionID, "error", err)
+	}
+}
+
+// carryForwardParameters fills parameters the model left empty with values
+// extracted for the same action in earlier turns, and stores the combined
+// set for the next turn
+func (h *IntentHandler) carryForwardParameters(ctx context.Context, request *models.IntentRequest, response *models.IntentResponse) {
+	if h.memoryManager == nil || response.Action == nil || response.Status == models.StatusError {
+		return
+	}
+
+	extracted := make(map[string]string, len(response.Parameters))
+	for name, value := range response.Parameters {
+		if value != nil {
+			extracted[name] = *value
+		}
+	}
+
+	done := response.Status == models.StatusReady
+	merged, err := h.memoryManager.MergePendingParameters(ctx, request.SessionID, *response.Action, extracted, done)
+	if err != nil {
+		h.logger.WarnContext(ctx, "failed to merge pending parameters", "session_id", request.SessionID, "error", err)
+		return
+	}
+
+	for name, value := range merged {
+		if current := response.Parameters[name]; current == nil || *current == "" {
+			v := value
+			response.Parameters[name] = &v
+		}
 	}
 }
 
@@ -97,7 +709,7 @@ func (h *IntentHandler) createErrorResponse(request *models.IntentRequest, error
 		SessionID:    request.SessionID,
 		Status:       models.StatusError,
 		Parameters:   make(map[string]*string),
-		UserMessage:  prompts.FallbackMessage,
+		UserMessage:  prompts.Localize(request.MessageLocale(), prompts.MsgFallback),
 		ErrorCode:    &errorCode,
 		ErrorMessage: &errorMessage,
 	}
@@ -0,0 +1,979 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/llm"
+	"github.com/avvvet/cdnbuddy-intent/internal/memory"
+	"github.com/avvvet/cdnbuddy-intent/internal/metrics"
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+	"github.com/avvvet/cdnbuddy-intent/internal/prompts"
+)
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// newTestHandler returns a handler over provider with an in-memory session
+// store
+func newTestHandler(t *testing.T, provider llm.LLMProvider, opts ...Option) (*IntentHandler, *memory.Manager) {
+	t.Helper()
+	manager := memory.NewManager(memory.NewInMemoryStore(time.Hour), memory.WithLogger(discardLogger))
+	t.Cleanup(func() { manager.Close() })
+
+	opts = append([]Option{WithLogger(discardLogger), WithMemoryManager(manager)}, opts...)
+	return NewIntentHandler(provider, opts...), manager
+}
+
+// modelReply builds a response as a provider would return it
+func modelReply(action, status string, params map[string]string) *models.IntentResponse {
+	response := &models.IntentResponse{
+		Action:      &action,
+		Status:      status,
+		Parameters:  make(map[string]*string, len(params)),
+		UserMessage: "ok",
+		Confidence:  1,
+	}
+	for name, value := range params {
+		v := value
+		response.Parameters[name] = &v
+	}
+	return response
+}
+
+// responseParameters flattens a response's non-null parameters
+func responseParameters(response *models.IntentResponse) map[string]string {
+	params := make(map[string]string, len(response.Parameters))
+	for name, value := range response.Parameters {
+		if value != nil {
+			params[name] = *value
+		}
+	}
+	return params
+}
+
+var cdnActions = []models.ActionSchema{
+	{Action: "create_distribution", Parameters: []models.ParameterSpec{{Name: "origin", Required: true}}},
+	{Action: "purge_cache", Parameters: []models.ParameterSpec{{Name: "service_id", Required: true}, {Name: "path"}}},
+}
+
+func TestRouteParametersAcrossActions(t *testing.T) {
+	type turn struct {
+		action string
+		status string
+		params map[string]string // What the model extracted
+		want   map[string]string // Parameters in the final response
+	}
+	tests := []struct {
+		name  string
+		turns []turn
+	}{
+		{
+			name: "routed to the owning action",
+			turns: []turn{
+				{action: "create_distribution", status: models.StatusNeedsInfo,
+					params: map[string]string{"origin": "example.com", "service_id": "svc-1"},
+					want:   map[string]string{"origin": "example.com"}},
+				{action: "purge_cache", status: models.StatusNeedsInfo,
+					want: map[string]string{"service_id": "svc-1"}},
+			},
+		},
+		{
+			name: "routed value survives the current action completing",
+			turns: []turn{
+				{action: "create_distribution", status: models.StatusReady,
+					params: map[string]string{"origin": "example.com", "path": "/img/*"},
+					want:   map[string]string{"origin": "example.com"}},
+				{action: "purge_cache", status: models.StatusNeedsInfo,
+					params: map[string]string{"service_id": "svc-1"},
+					want:   map[string]string{"service_id": "svc-1", "path": "/img/*"}},
+			},
+		},
+		{
+			name: "model value wins over a routed one",
+			turns: []turn{
+				{action: "create_distribution", status: models.StatusNeedsInfo,
+					params: map[string]string{"origin": "example.com", "service_id": "svc-1"},
+					want:   map[string]string{"origin": "example.com"}},
+				{action: "purge_cache", status: models.StatusNeedsInfo,
+					params: map[string]string{"service_id": "svc-2"},
+					want:   map[string]string{"service_id": "svc-2"}},
+			},
+		},
+		{
+			name: "parameter in no schema is dropped",
+			turns: []turn{
+				{action: "create_distribution", status: models.StatusNeedsInfo,
+					params: map[string]string{"origin": "example.com", "colour": "blue"},
+					want:   map[string]string{"origin": "example.com"}},
+				{action: "purge_cache", status: models.StatusNeedsInfo,
+					want: map[string]string{}},
+			},
+		},
+		{
+			name: "empty routed value is dropped",
+			turns: []turn{
+				{action: "create_distribution", status: models.StatusNeedsInfo,
+					params: map[string]string{"origin": "example.com", "service_id": ""},
+					want:   map[string]string{"origin": "example.com"}},
+				{action: "purge_cache", status: models.StatusNeedsInfo,
+					want: map[string]string{}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := llm.NewMockProvider()
+			for _, turn := range tt.turns {
+				provider.Enqueue(modelReply(turn.action, turn.status, turn.params), nil)
+			}
+			h, manager := newTestHandler(t, provider)
+			ctx := context.Background()
+
+			for i, turn := range tt.turns {
+				response, err := h.ProcessIntent(ctx, &models.IntentRequest{
+					SessionID:        "s1",
+					UserMessage:      "next",
+					AvailableActions: cdnActions,
+				})
+				if err != nil {
+					t.Fatalf("turn %d: ProcessIntent() error = %v", i, err)
+				}
+				if got := responseParameters(response); !maps.Equal(got, turn.want) {
+					t.Errorf("turn %d: parameters = %v, want %v", i, got, turn.want)
+				}
+
+				// Routed and dropped values don't leak into the session slots
+				slots, err := manager.GetSlots(ctx, "s1")
+				if err != nil {
+					t.Fatal(err)
+				}
+				for name := range turn.params {
+					if _, kept := turn.want[name]; !kept && slots[name] != "" {
+						t.Errorf("turn %d: %s stored as a slot", i, name)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestProcessIntentCircuitOpen(t *testing.T) {
+	provider := llm.NewMockProvider()
+	provider.Enqueue(nil, errors.New("upstream overloaded"))
+	breaker := llm.NewCircuitBreakerProvider(provider, 1, time.Minute, discardLogger)
+	h, _ := newTestHandler(t, breaker)
+
+	tests := []struct {
+		name        string
+		wantMessage string // Empty to skip the check
+		wantCalls   int
+	}{
+		{name: "failure opens the circuit", wantCalls: 1},
+		{name: "open circuit fails fast", wantMessage: prompts.Localize("en", prompts.MsgUnavailable), wantCalls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := h.ProcessIntent(context.Background(), &models.IntentRequest{SessionID: "s1", UserMessage: "purge the cache"})
+			if err != nil {
+				t.Fatalf("ProcessIntent() error = %v", err)
+			}
+			if response.ErrorCode == nil || *response.ErrorCode != models.ErrorLLMFailed {
+				t.Errorf("error_code = %v, want %s", response.ErrorCode, models.ErrorLLMFailed)
+			}
+			if tt.wantMessage != "" && response.UserMessage != tt.wantMessage {
+				t.Errorf("user_message = %q, want %q", response.UserMessage, tt.wantMessage)
+			}
+			if got := len(provider.Requests()); got != tt.wantCalls {
+				t.Errorf("provider called %d times, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestProcessIntentErrorCodes(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "session limit", err: memory.ErrSessionLimitExceeded, want: models.ErrorSessionLimit},
+		{name: "circuit open", err: fmt.Errorf("anthropic: %w", llm.ErrCircuitOpen), want: models.ErrorLLMFailed},
+		{name: "timeout", err: fmt.Errorf("request failed: %w", context.DeadlineExceeded), want: models.ErrorLLMTimeout},
+		{name: "parse error", err: fmt.Errorf("%w: %w", llm.ErrUnparsableReply, errors.New("invalid character 'H'")), want: models.ErrorParseError},
+		{name: "generic failure", err: errors.New("upstream overloaded"), want: models.ErrorLLMFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := llm.NewMockProvider()
+			provider.Enqueue(nil, tt.err)
+			h, _ := newTestHandler(t, provider)
+
+			response, err := h.ProcessIntent(context.Background(), &models.IntentRequest{SessionID: "s1", UserMessage: "purge the cache"})
+			if err != nil {
+				t.Fatalf("ProcessIntent() error = %v", err)
+			}
+			if response.Status != models.StatusError {
+				t.Errorf("status = %s, want %s", response.Status, models.StatusError)
+			}
+			if response.ErrorCode == nil || *response.ErrorCode != tt.want {
+				t.Errorf("error_code = %v, want %s", response.ErrorCode, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessIntentStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		status string
+		want   string
+	}{
+		{name: "ready", status: models.StatusReady, want: models.StatusReady},
+		{name: "needs info", status: models.StatusNeedsInfo, want: models.StatusNeedsInfo},
+		{name: "unknown status", status: "PENDING", want: models.StatusError},
+		{name: "unnormalized synonym", status: "done", want: models.StatusError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := llm.NewMockProvider()
+			provider.Enqueue(modelReply("purge_cache", tt.status, map[string]string{"service_id": "svc-1"}), nil)
+			h, _ := newTestHandler(t, provider)
+
+			response, err := h.ProcessIntent(context.Background(), &models.IntentRequest{
+				SessionID:        "s1",
+				UserMessage:      "purge svc-1",
+				AvailableActions: cdnActions,
+			})
+			if err != nil {
+				t.Fatalf("ProcessIntent() error = %v", err)
+			}
+			if response.Status != tt.want {
+				t.Errorf("status = %s, want %s", response.Status, tt.want)
+			}
+		})
+	}
+}
+
+func TestMinConfidence(t *testing.T) {
+	confirm := prompts.Localize("en", prompts.MsgConfirm)
+	tests := []struct {
+		name        string
+		min         float64
+		status      string
+		confidence  float64
+		wantStatus  string
+		wantConfirm bool
+	}{
+		{name: "below the minimum", min: 0.7, status: models.StatusReady, confidence: 0.5, wantStatus: models.StatusNeedsInfo, wantConfirm: true},
+		{name: "at the minimum", min: 0.7, status: models.StatusReady, confidence: 0.7, wantStatus: models.StatusReady},
+		{name: "above the minimum", min: 0.7, status: models.StatusReady, confidence: 0.9, wantStatus: models.StatusReady},
+		{name: "disabled", status: models.StatusReady, confidence: 0.1, wantStatus: models.StatusReady},
+		{name: "already asking", min: 0.7, status: models.StatusNeedsInfo, confidence: 0.2, wantStatus: models.StatusNeedsInfo},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply := modelReply("purge_cache", tt.status, map[string]string{"service_id": "svc-1"})
+			reply.Confidence = tt.confidence
+			provider := llm.NewMockProvider()
+			provider.Enqueue(reply, nil)
+			h, _ := newTestHandler(t, provider, WithMinConfidence(tt.min))
+
+			response, err := h.ProcessIntent(context.Background(), &models.IntentRequest{
+				SessionID:        "s1",
+				UserMessage:      "purge svc-1",
+				AvailableActions: cdnActions,
+			})
+			if err != nil {
+				t.Fatalf("ProcessIntent() error = %v", err)
+			}
+			if response.Status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", response.Status, tt.wantStatus)
+			}
+			if asked := strings.HasSuffix(response.UserMessage, confirm); asked != tt.wantConfirm {
+				t.Errorf("user_message = %q, confirmation asked = %v, want %v", response.UserMessage, asked, tt.wantConfirm)
+			}
+		})
+	}
+}
+
+func TestConfidenceOmitted(t *testing.T) {
+	tests := []struct {
+		name    string
+		request models.IntentRequest
+		err     error // Returned by the provider
+		want    bool  // confidence present in the JSON
+	}{
+		{name: "model reply", request: models.IntentRequest{SessionID: "s1", UserMessage: "purge svc-1"}, want: true},
+		{name: "error", request: models.IntentRequest{SessionID: "s1", UserMessage: "purge svc-1"}, err: errors.New("upstream overloaded")},
+		{name: "reset", request: models.IntentRequest{SessionID: "s1", Reset: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := llm.NewMockProvider()
+			if tt.err != nil {
+				provider.Enqueue(nil, tt.err)
+			} else {
+				provider.Enqueue(modelReply("purge_cache", models.StatusReady, map[string]string{"service_id": "svc-1"}), nil)
+			}
+			h, _ := newTestHandler(t, provider)
+
+			response, err := h.ProcessIntent(context.Background(), &tt.request)
+			if err != nil {
+				t.Fatalf("ProcessIntent() error = %v", err)
+			}
+			data, err := json.Marshal(response)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var fields map[string]any
+			if err := json.Unmarshal(data, &fields); err != nil {
+				t.Fatal(err)
+			}
+			if _, got := fields["confidence"]; got != tt.want {
+				t.Errorf("confidence present = %v, want %v in %s", got, tt.want, data)
+			}
+		})
+	}
+}
+
+func TestProcessIntentHangingAPI(t *testing.T) {
+	tests := []struct {
+		name          string
+		deadline      time.Duration // Request deadline, 0 for none
+		clientTimeout time.Duration // HTTP client timeout of the provider
+	}{
+		{name: "request deadline", deadline: time.Second, clientTimeout: time.Minute},
+		{name: "client timeout", clientTimeout: time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-r.Context().Done():
+				case <-release:
+				}
+			}))
+			t.Cleanup(api.Close)
+			t.Cleanup(func() { close(release) })
+
+			manager := memory.NewManager(memory.NewInMemoryStore(time.Hour), memory.WithLogger(discardLogger))
+			t.Cleanup(func() { manager.Close() })
+			provider := llm.NewAnthropicProvider("test-key", "claude-test", tt.clientTimeout, manager,
+				llm.WithBaseURL(api.URL), llm.WithLogger(discardLogger), llm.WithMaxRetries(0))
+			t.Cleanup(func() { provider.Close() })
+			h := NewIntentHandler(provider, WithLogger(discardLogger), WithMemoryManager(manager))
+
+			ctx := context.Background()
+			if tt.deadline > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.deadline)
+				defer cancel()
+			}
+
+			start := time.Now()
+			response, err := h.ProcessIntent(ctx, &models.IntentRequest{SessionID: "s1", UserMessage: "purge the cache"})
+			if err != nil {
+				t.Fatalf("ProcessIntent() error = %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("ProcessIntent() took %v", elapsed)
+			}
+			if response.ErrorCode == nil || *response.ErrorCode != models.ErrorLLMTimeout {
+				t.Errorf("error_code = %v, want %s", response.ErrorCode, models.ErrorLLMTimeout)
+			}
+		})
+	}
+}
+
+func TestSessionBudget(t *testing.T) {
+	// $3 and $15 per million input and output tokens
+	const inputPrice, outputPrice = 300, 1500
+	tests := []struct {
+		name         string
+		budget       float64 // Cents, 0 disables the cap
+		inputTokens  int
+		outputTokens int
+		wantExceeded bool
+	}{
+		{name: "under budget", budget: 1, inputTokens: 1000, outputTokens: 100},
+		{name: "budget reached", budget: 0.6, inputTokens: 2000, wantExceeded: true},
+		{name: "over budget", budget: 1, inputTokens: 100_000, outputTokens: 10_000, wantExceeded: true},
+		{name: "no budget", inputTokens: 100_000_000, outputTokens: 10_000_000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := llm.NewMockProvider()
+			provider.Enqueue(modelReply("purge_cache", models.StatusNeedsInfo, nil), nil)
+			h, manager := newTestHandler(t, provider, WithSessionBudget(tt.budget, inputPrice, outputPrice))
+			ctx := context.Background()
+			if err := manager.SaveUserMessage(ctx, "s1", "user1", "purge the cache"); err != nil {
+				t.Fatal(err)
+			}
+			if err := manager.AddUsage(ctx, "s1", tt.inputTokens, tt.outputTokens); err != nil {
+				t.Fatal(err)
+			}
+
+			response, err := h.ProcessIntent(ctx, &models.IntentRequest{SessionID: "s1", UserMessage: "and the images"})
+			if err != nil {
+				t.Fatalf("ProcessIntent() error = %v", err)
+			}
+			exceeded := response.ErrorCode != nil && *response.ErrorCode == models.ErrorBudgetExceeded
+			if exceeded != tt.wantExceeded {
+				t.Errorf("error_code = %v, budget exceeded = %v, want %v", response.ErrorCode, exceeded, tt.wantExceeded)
+			}
+			if exceeded && response.UserMessage != prompts.Localize("en", prompts.MsgBudget) {
+				t.Errorf("user_message = %q, want the budget message", response.UserMessage)
+			}
+			wantCalls := 1
+			if tt.wantExceeded {
+				wantCalls = 0
+			}
+			if got := len(provider.Requests()); got != wantCalls {
+				t.Errorf("provider called %d times, want %d", got, wantCalls)
+			}
+		})
+	}
+}
+
+func TestMaxActions(t *testing.T) {
+	actions := []models.ActionSchema{
+		{Action: "create_distribution", Parameters: []models.ParameterSpec{{Name: "origin", Required: true}}},
+		{Action: "purge_cache", Parameters: []models.ParameterSpec{{Name: "service_id", Required: true}}},
+		{Action: "enable_waf"},
+	}
+	tests := []struct {
+		name        string
+		max         int
+		mode        string
+		wantCode    string   // Error code, empty when the provider is called
+		wantActions []string // Actions the provider received
+	}{
+		{name: "within the cap", max: 3, mode: ActionOverflowError, wantActions: []string{"create_distribution", "purge_cache", "enable_waf"}},
+		{name: "no cap", mode: ActionOverflowError, wantActions: []string{"create_distribution", "purge_cache", "enable_waf"}},
+		{name: "over the cap rejected", max: 2, mode: ActionOverflowError, wantCode: models.ErrorParseError},
+		{name: "over the cap ranked", max: 1, mode: ActionOverflowRank, wantActions: []string{"purge_cache"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := llm.NewMockProvider()
+			provider.Enqueue(modelReply("purge_cache", models.StatusNeedsInfo, nil), nil)
+			h, _ := newTestHandler(t, provider, WithMaxActions(tt.max, tt.mode))
+
+			response, err := h.ProcessIntent(context.Background(), &models.IntentRequest{
+				SessionID:        "s1",
+				UserMessage:      "purge the cache",
+				AvailableActions: actions,
+			})
+			if err != nil {
+				t.Fatalf("ProcessIntent() error = %v", err)
+			}
+			var code string
+			if response.ErrorCode != nil {
+				code = *response.ErrorCode
+			}
+			if code != tt.wantCode {
+				t.Fatalf("error_code = %q, want %q", code, tt.wantCode)
+			}
+
+			requests := provider.Requests()
+			if tt.wantCode != "" {
+				if len(requests) != 0 {
+					t.Errorf("provider called %d times, want 0", len(requests))
+				}
+				if !strings.Contains(*response.ErrorMessage, "too many available_actions") {
+					t.Errorf("error_message = %q, want it to explain the cap", *response.ErrorMessage)
+				}
+				return
+			}
+			if len(requests) != 1 {
+				t.Fatalf("provider called %d times, want 1", len(requests))
+			}
+			var got []string
+			for _, action := range requests[0].AvailableActions {
+				got = append(got, action.Action)
+			}
+			if !slices.Equal(got, tt.wantActions) {
+				t.Errorf("provider got actions %v, want %v", got, tt.wantActions)
+			}
+		})
+	}
+}
+
+func TestLocalizedFallback(t *testing.T) {
+	tests := []struct {
+		name     string
+		locale   string
+		language string
+		want     string
+	}{
+		{name: "no locale", want: prompts.FallbackMessage},
+		{name: "spanish", locale: "es", want: prompts.Localize("es", prompts.MsgFallback)},
+		{name: "regional french", locale: "fr-CA", want: prompts.Localize("fr", prompts.MsgFallback)},
+		{name: "locale without a catalog", locale: "ja", want: prompts.FallbackMessage},
+		{name: "language", language: "fr", want: prompts.Localize("fr", prompts.MsgFallback)},
+		{name: "language beats locale", locale: "es-MX", language: "fr", want: prompts.Localize("fr", prompts.MsgFallback)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A status the handler doesn't know is replaced by the fallback
+			provider := llm.NewMockProvider()
+			provider.Enqueue(modelReply("purge_cache", "MAYBE", nil), nil)
+			h, _ := newTestHandler(t, provider)
+
+			response, err := h.ProcessIntent(context.Background(), &models.IntentRequest{SessionID: "s1", UserMessage: "purge the cache", Locale: tt.locale, Language: tt.language})
+			if err != nil {
+				t.Fatalf("ProcessIntent() error = %v", err)
+			}
+			if response.Status != models.StatusError || response.UserMessage != tt.want {
+				t.Errorf("response = %s %q, want %s %q", response.Status, response.UserMessage, models.StatusError, tt.want)
+			}
+		})
+	}
+}
+
+func TestSuggestedNextActions(t *testing.T) {
+	graph := map[string][]string{"create_distribution": {"add_custom_domain", "enable_waf"}}
+	tests := []struct {
+		name  string
+		graph map[string][]string
+		reply *models.IntentResponse
+		want  []string
+	}{
+		{name: "READY action in the graph", graph: graph,
+			reply: modelReply("create_distribution", models.StatusReady, map[string]string{"origin": "example.com"}),
+			want:  []string{"add_custom_domain", "enable_waf"}},
+		{name: "NEEDS_INFO", graph: graph, reply: modelReply("create_distribution", models.StatusNeedsInfo, nil)},
+		{name: "READY action not in the graph", graph: graph,
+			reply: modelReply("purge_cache", models.StatusReady, map[string]string{"service_id": "svc-1"})},
+		{name: "no graph", reply: modelReply("create_distribution", models.StatusReady, map[string]string{"origin": "example.com"})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := llm.NewMockProvider()
+			provider.Enqueue(tt.reply, nil)
+			h, _ := newTestHandler(t, provider, WithActionGraph(tt.graph))
+
+			response, err := h.ProcessIntent(context.Background(), &models.IntentRequest{
+				SessionID:        "s1",
+				UserMessage:      "set up a distribution for example.com",
+				AvailableActions: cdnActions,
+			})
+			if err != nil {
+				t.Fatalf("ProcessIntent() error = %v", err)
+			}
+			if response.Status != tt.reply.Status {
+				t.Fatalf("status = %s, want %s", response.Status, tt.reply.Status)
+			}
+			if !slices.Equal(response.SuggestedNextActions, tt.want) {
+				t.Errorf("suggested_next_actions = %v, want %v", response.SuggestedNextActions, tt.want)
+			}
+
+			// Omitted from the JSON when there's nothing to suggest
+			data, err := json.Marshal(response)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if present := strings.Contains(string(data), "suggested_next_actions"); present != (tt.want != nil) {
+				t.Errorf("suggested_next_actions in JSON = %v, want %v: %s", present, tt.want != nil, data)
+			}
+		})
+	}
+}
+
+func TestSlotsInjectedOnLaterTurns(t *testing.T) {
+	// The model extracts the origin on the first turn and the service on the second
+	replies := []string{
+		`{"action": "create_distribution", "status": "NEEDS_INFO", "parameters": {"origin": "origin.example.com"}, "user_message": "Anything else?"}`,
+		`{"action": "purge_cache", "status": "NEEDS_INFO", "parameters": {"service_id": "svc-1"}, "user_message": "Which path?"}`,
+		`{"action": "purge_cache", "status": "NEEDS_INFO", "parameters": {}, "user_message": "Which path?"}`,
+	}
+	var mu sync.Mutex
+	var systems []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request llm.AnthropicRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		systems = append(systems, request.System)
+		reply := replies[min(len(systems), len(replies))-1]
+		mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]any{
+			"type":    "message",
+			"role":    "assistant",
+			"content": []map[string]string{{"type": "text", "text": reply}},
+			"usage":   map[string]int{"input_tokens": 10, "output_tokens": 5},
+		})
+	}))
+	t.Cleanup(api.Close)
+
+	manager := memory.NewManager(memory.NewInMemoryStore(time.Hour), memory.WithLogger(discardLogger))
+	t.Cleanup(func() { manager.Close() })
+	provider := llm.NewAnthropicProvider("test-key", "claude-test", 5*time.Second, manager,
+		llm.WithBaseURL(api.URL), llm.WithLogger(discardLogger), llm.WithMaxRetries(0))
+	t.Cleanup(func() { provider.Close() })
+	h := NewIntentHandler(provider, WithLogger(discardLogger), WithMemoryManager(manager))
+
+	turns := []struct {
+		message   string
+		wantFacts []string // Facts in the system prompt for this turn
+	}{
+		{message: "set up a distribution for origin.example.com"},
+		{message: "actually, purge svc-1 first", wantFacts: []string{"- origin: origin.example.com"}},
+		{message: "all of it", wantFacts: []string{"- origin: origin.example.com", "- service_id: svc-1"}},
+	}
+	for i, turn := range turns {
+		if _, err := h.ProcessIntent(context.Background(), &models.IntentRequest{SessionID: "s1", UserMessage: turn.message, AvailableActions: cdnActions}); err != nil {
+			t.Fatalf("turn %d: ProcessIntent() error = %v", i, err)
+		}
+		mu.Lock()
+		system := systems[i]
+		mu.Unlock()
+		_, facts, _ := strings.Cut(system, "Known Facts")
+		if len(turn.wantFacts) == 0 && !strings.Contains(facts, "None") {
+			t.Errorf("turn %d: facts = %q, want none", i, facts)
+		}
+		for _, fact := range turn.wantFacts {
+			if !strings.Contains(facts, fact) {
+				t.Errorf("turn %d: facts = %q, want %q", i, facts, fact)
+			}
+		}
+	}
+}
+
+func TestValidateRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		request     models.IntentRequest
+		wantErr     bool   // Rejected with PARSE_ERROR before the provider is called
+		wantMessage string // Message the provider sees
+	}{
+		{name: "valid", request: models.IntentRequest{SessionID: "s1", UserMessage: "purge the cache"}, wantMessage: "purge the cache"},
+		{name: "missing session", request: models.IntentRequest{UserMessage: "purge the cache"}, wantErr: true},
+		{name: "empty message", request: models.IntentRequest{SessionID: "s1"}, wantErr: true},
+		{name: "only whitespace", request: models.IntentRequest{SessionID: "s1", UserMessage: " \n\t"}, wantErr: true},
+		{name: "only control characters", request: models.IntentRequest{SessionID: "s1", UserMessage: "\x00\x07"}, wantErr: true},
+		{name: "at the limit", request: models.IntentRequest{SessionID: "s1", UserMessage: strings.Repeat("é", 20)}, wantMessage: strings.Repeat("é", 20)},
+		{name: "over the limit", request: models.IntentRequest{SessionID: "s1", UserMessage: strings.Repeat("a", 21)}, wantErr: true},
+		{
+			name:        "control characters stripped",
+			request:     models.IntentRequest{SessionID: "s1", UserMessage: "purge\x00 all\x1b\r\n\tnow\x7f"},
+			wantMessage: "purge all\r\n\tnow",
+		},
+		{name: "invalid UTF-8 replaced", request: models.IntentRequest{SessionID: "s1", UserMessage: "purge \xff"}, wantMessage: "purge �"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := llm.NewMockProvider()
+			provider.Enqueue(&models.IntentResponse{Status: models.StatusNeedsInfo, UserMessage: "Which service?"}, nil)
+			h, _ := newTestHandler(t, provider, WithMaxMessageChars(20))
+
+			request := tt.request
+			response, err := h.ProcessIntent(context.Background(), &request)
+			if err != nil {
+				t.Fatalf("ProcessIntent() error = %v", err)
+			}
+
+			requests := provider.Requests()
+			if tt.wantErr {
+				if response.ErrorCode == nil || *response.ErrorCode != models.ErrorParseError {
+					t.Errorf("error_code = %v, want %s", response.ErrorCode, models.ErrorParseError)
+				}
+				if len(requests) != 0 {
+					t.Errorf("provider called %d times, want 0", len(requests))
+				}
+				return
+			}
+			if response.Status != models.StatusNeedsInfo {
+				t.Fatalf("status = %s, want %s", response.Status, models.StatusNeedsInfo)
+			}
+			if len(requests) != 1 {
+				t.Fatalf("provider called %d times, want 1", len(requests))
+			}
+			if got := requests[0].UserMessage; got != tt.wantMessage {
+				t.Errorf("provider saw %q, want %q", got, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestCarryForwardParameters(t *testing.T) {
+	type turn struct {
+		action string
+		status string
+		params map[string]string // What the model extracted
+		want   map[string]string // Parameters in the response
+	}
+	actions := []models.ActionSchema{
+		{Action: "create_service", Parameters: []models.ParameterSpec{{Name: "domain", Required: true}, {Name: "region", Required: true}}},
+		{Action: "purge_cache", Parameters: []models.ParameterSpec{{Name: "domain", Required: true}}},
+	}
+
+	tests := []struct {
+		name  string
+		turns []turn
+	}{
+		{
+			name: "domain kept until the region arrives",
+			turns: []turn{
+				{action: "create_service", status: models.StatusNeedsInfo,
+					params: map[string]string{"domain": "example.com"},
+					want:   map[string]string{"domain": "example.com"}},
+				{action: "create_service", status: models.StatusNeedsInfo,
+					want: map[string]string{"domain": "example.com"}},
+				{action: "create_service", status: models.StatusReady,
+					params: map[string]string{"region": "eu-west"},
+					want:   map[string]string{"domain": "example.com", "region": "eu-west"}},
+			},
+		},
+		{
+			name: "new value replaces the stored one",
+			turns: []turn{
+				{action: "create_service", status: models.StatusNeedsInfo,
+					params: map[string]string{"domain": "example.com"},
+					want:   map[string]string{"domain": "example.com"}},
+				{action: "create_service", status: models.StatusNeedsInfo,
+					params: map[string]string{"domain": "example.org"},
+					want:   map[string]string{"domain": "example.org"}},
+				{action: "create_service", status: models.StatusReady,
+					params: map[string]string{"region": "eu-west"},
+					want:   map[string]string{"domain": "example.org", "region": "eu-west"}},
+			},
+		},
+		{
+			name: "switching action starts over",
+			turns: []turn{
+				{action: "create_service", status: models.StatusNeedsInfo,
+					params: map[string]string{"region": "eu-west"},
+					want:   map[string]string{"region": "eu-west"}},
+				{action: "purge_cache", status: models.StatusNeedsInfo,
+					want: map[string]string{}},
+				{action: "create_service", status: models.StatusNeedsInfo,
+					params: map[string]string{"domain": "example.com"},
+					want:   map[string]string{"domain": "example.com"}},
+			},
+		},
+		{
+			name: "nothing pending once READY",
+			turns: []turn{
+				{action: "create_service", status: models.StatusReady,
+					params: map[string]string{"domain": "example.com", "region": "eu-west"},
+					want:   map[string]string{"domain": "example.com", "region": "eu-west"}},
+				{action: "create_service", status: models.StatusNeedsInfo,
+					want: map[string]string{}},
+				{action: "create_service", status: models.StatusNeedsInfo,
+					params: map[string]string{"region": "us-east"},
+					want:   map[string]string{"region": "us-east"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := llm.NewMockProvider()
+			for _, turn := range tt.turns {
+				provider.Enqueue(modelReply(turn.action, turn.status, turn.params), nil)
+			}
+			h, _ := newTestHandler(t, provider)
+
+			for i, turn := range tt.turns {
+				response, err := h.ProcessIntent(context.Background(), &models.IntentRequest{
+					SessionID:        "s1",
+					UserMessage:      "next",
+					AvailableActions: actions,
+				})
+				if err != nil {
+					t.Fatalf("turn %d: ProcessIntent() error = %v", i, err)
+				}
+				if response.Status != turn.status {
+					t.Errorf("turn %d: status = %s, want %s", i, response.Status, turn.status)
+				}
+				if got := responseParameters(response); !maps.Equal(got, turn.want) {
+					t.Errorf("turn %d: parameters = %v, want %v", i, got, turn.want)
+				}
+			}
+		})
+	}
+}
+
+func TestUnknownIntent(t *testing.T) {
+	action := func(name string) *string { return &name }
+	tests := []struct {
+		name     string
+		response models.IntentResponse // What the model returned
+		wantCode string                // Empty for no error code
+	}{
+		{
+			name:     "joke with no action",
+			response: models.IntentResponse{Status: models.StatusError, UserMessage: "I can only help with your CDN."},
+			wantCode: models.ErrorUnknownIntent,
+		},
+		{
+			name:     "action not available",
+			response: models.IntentResponse{Status: models.StatusError, Action: action("tell_joke"), UserMessage: "I can only help with your CDN."},
+			wantCode: models.ErrorUnknownIntent,
+		},
+		{
+			name:     "error on an available action",
+			response: models.IntentResponse{Status: models.StatusError, Action: action("purge_cache"), UserMessage: "That path isn't valid."},
+		},
+		{
+			name:     "clarifying question",
+			response: models.IntentResponse{Status: models.StatusNeedsInfo, UserMessage: "What would you like to do with your CDN?"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := llm.NewMockProvider()
+			reply := tt.response
+			reply.Parameters = map[string]*string{}
+			provider.Enqueue(&reply, nil)
+			h, _ := newTestHandler(t, provider)
+
+			response, err := h.ProcessIntent(context.Background(), &models.IntentRequest{
+				SessionID:        "s1",
+				UserMessage:      "tell me a joke",
+				AvailableActions: cdnActions,
+			})
+			if err != nil {
+				t.Fatalf("ProcessIntent() error = %v", err)
+			}
+			if response.Status != tt.response.Status {
+				t.Errorf("status = %s, want %s", response.Status, tt.response.Status)
+			}
+			var code string
+			if response.ErrorCode != nil {
+				code = *response.ErrorCode
+			}
+			if code != tt.wantCode {
+				t.Errorf("error_code = %q, want %q", code, tt.wantCode)
+			}
+			if response.UserMessage != tt.response.UserMessage {
+				t.Errorf("user_message = %q, want the model's %q", response.UserMessage, tt.response.UserMessage)
+			}
+		})
+	}
+}
+
+// newScriptedHandler returns a handler over an Anthropic provider whose API
+// answers with replies in turn, storing the session in store
+func newScriptedHandler(t *testing.T, store memory.Store, replies []string) *IntentHandler {
+	t.Helper()
+	var mu sync.Mutex
+	calls := 0
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		reply := replies[min(calls, len(replies)-1)]
+		calls++
+		mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]any{
+			"type":    "message",
+			"role":    "assistant",
+			"content": []map[string]string{{"type": "text", "text": reply}},
+			"usage":   map[string]int{"input_tokens": 10, "output_tokens": 5},
+		})
+	}))
+	t.Cleanup(api.Close)
+
+	manager := memory.NewManager(store, memory.WithLogger(discardLogger))
+	t.Cleanup(func() { manager.Close() })
+	provider := llm.NewAnthropicProvider("test-key", "claude-test", 5*time.Second, manager,
+		llm.WithBaseURL(api.URL), llm.WithLogger(discardLogger), llm.WithMaxRetries(0))
+	t.Cleanup(func() { provider.Close() })
+	return NewIntentHandler(provider, WithLogger(discardLogger), WithMemoryManager(manager))
+}
+
+func TestTurnsToReady(t *testing.T) {
+	const (
+		needsInfo = `{"action": "create_distribution", "status": "NEEDS_INFO", "parameters": {}, "user_message": "Which origin?"}`
+		ready     = `{"action": "create_distribution", "status": "READY", "parameters": {"origin": "origin.example.com"}, "user_message": "Creating it"}`
+	)
+	tests := []struct {
+		name    string
+		replies []string
+		want    []int // Turns recorded for each READY
+	}{
+		{name: "ready on the third turn", replies: []string{needsInfo, needsInfo, ready}, want: []int{3}},
+		{name: "ready on the first turn", replies: []string{ready}, want: []int{1}},
+		{name: "two actions", replies: []string{needsInfo, needsInfo, ready, needsInfo, ready}, want: []int{3, 2}},
+		{name: "never ready", replies: []string{needsInfo, needsInfo, needsInfo}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := memory.NewInMemoryStore(time.Hour)
+			h := newScriptedHandler(t, store, tt.replies)
+			ctx := context.Background()
+
+			before := make(map[int]int64)
+			for _, turns := range tt.want {
+				before[turns] = metricCount(metrics.TurnsToReady, strconv.Itoa(turns))
+			}
+
+			for i, reply := range tt.replies {
+				response, err := h.ProcessIntent(ctx, &models.IntentRequest{SessionID: "s1", UserMessage: fmt.Sprintf("turn %d", i+1), AvailableActions: cdnActions})
+				if err != nil {
+					t.Fatalf("turn %d: ProcessIntent() error = %v", i+1, err)
+				}
+				if wantReady := reply == ready; (response.Status == models.StatusReady) != wantReady {
+					t.Fatalf("turn %d: status = %s", i+1, response.Status)
+				}
+			}
+
+			session, err := store.LoadSession(ctx, "s1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(session.Metadata.ReadyTurns, tt.want) {
+				t.Errorf("ReadyTurns = %v, want %v", session.Metadata.ReadyTurns, tt.want)
+			}
+
+			wantIncrease := make(map[int]int64)
+			for _, turns := range tt.want {
+				wantIncrease[turns]++
+			}
+			for turns, n := range wantIncrease {
+				if got := metricCount(metrics.TurnsToReady, strconv.Itoa(turns)) - before[turns]; got != n {
+					t.Errorf("turns_to_ready[%d] went up by %d, want %d", turns, got, n)
+				}
+			}
+		})
+	}
+}
+
+// metricCount reads one key of a counter map, 0 if it was never incremented
+func metricCount(m *expvar.Map, key string) int64 {
+	if v, ok := m.Get(key).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
@@ -0,0 +1,68 @@
+// Package idempotency remembers the response to each request ID so a
+// retried request can be answered without calling the model again.
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/memory"
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+	"github.com/redis/go-redis/v9"
+)
+
+// Cache stores responses in Redis keyed by session and request ID
+type Cache struct {
+	client redis.Cmdable
+	ttl    time.Duration // How long a response can be replayed
+}
+
+// NewCache creates a Redis-backed idempotency cache
+func NewCache(client redis.Cmdable, ttl time.Duration) *Cache {
+	return &Cache{
+		client: client,
+		ttl:    ttl,
+	}
+}
+
+// responseKey generates the Redis key for a request's response. Request IDs
+// are scoped to their session, and sessions to the tenant in ctx, so
+// clients can't replay each other's replies.
+func (c *Cache) responseKey(ctx context.Context, sessionID, requestID string) string {
+	if tenant := memory.TenantFromContext(ctx); tenant != "" {
+		return fmt.Sprintf("idempotency:tenant:%s:%s:%s", tenant, sessionID, requestID)
+	}
+	return fmt.Sprintf("idempotency:%s:%s", sessionID, requestID)
+}
+
+// Get returns the stored response for a request, or nil if there is none
+func (c *Cache) Get(ctx context.Context, sessionID, requestID string) (*models.IntentResponse, error) {
+	data, err := c.client.Get(ctx, c.responseKey(ctx, sessionID, requestID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stored response: %w", err)
+	}
+
+	var response models.IntentResponse
+	if err := json.Unmarshal(data, &response); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal stored response: %w", err)
+	}
+	return &response, nil
+}
+
+// Save stores the response for a request
+func (c *Cache) Save(ctx context.Context, sessionID, requestID string, response *models.IntentResponse) error {
+	data, err := json.Marshal(response)
+	if err != nil {
+		return fmt.Errorf("failed to marshal response: %w", err)
+	}
+	if err := c.client.Set(ctx, c.responseKey(ctx, sessionID, requestID), data, c.ttl).Err(); err != nil {
+		return fmt.Errorf("failed to store response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package idempotency
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/avvvet/cdnbuddy-intent/internal/memory"
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestCacheTenantScope(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	cache := NewCache(rdb, time.Hour)
+
+	saved := memory.WithTenant(context.Background(), "acme")
+	if err := cache.Save(saved, "s1", "r1", &models.IntentResponse{SessionID: "s1", UserMessage: "acme reply"}); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		tenant string
+		want   string // Replayed user_message, empty for no replay
+	}{
+		{name: "same tenant", tenant: "acme", want: "acme reply"},
+		{name: "other tenant", tenant: "globex"},
+		{name: "no tenant", tenant: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := cache.Get(memory.WithTenant(context.Background(), tt.tenant), "s1", "r1")
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			var got string
+			if response != nil {
+				got = response.UserMessage
+			}
+			if got != tt.want {
+				t.Errorf("replayed %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -11,23 +12,33 @@ import (
 	"time"
 
 	"github.com/avvvet/cdnbuddy-intent/internal/memory"
+	"github.com/avvvet/cdnbuddy-intent/internal/metrics"
 	"github.com/avvvet/cdnbuddy-intent/internal/models"
+	"github.com/avvvet/cdnbuddy-intent/internal/prompts"
+	"github.com/avvvet/cdnbuddy-intent/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+// DefaultAnthropicBaseURL is used when no base URL is configured
+const DefaultAnthropicBaseURL = "https://api.anthropic.com"
+
 type AnthropicProvider struct {
-	apiKey        string
-	model         string
-	timeout       time.Duration
-	client        *http.Client
-	memoryManager *memory.Manager
+	apiKey  string
+	model   string
+	timeout time.Duration
+	client  *http.Client
+	stop    chan struct{} // Closed by Close to stop keep-alive pings
+	conversation
 }
 
 // AnthropicRequest represents the request structure for Anthropic's API
 type AnthropicRequest struct {
 	Model       string             `json:"model"`
 	MaxTokens   int                `json:"max_tokens"`
-	Temperature float64            `json:"temperature,omitempty"`
+	Temperature float64            `json:"temperature"`
+	System      string             `json:"system,omitempty"` // Top-level instructions, kept out of the user turn
 	Messages    []AnthropicMessage `json:"messages"`
+	Stream      bool               `json:"stream,omitempty"` // Reply with server-sent events
 }
 
 // AnthropicMessage represents a message in the conversation
@@ -53,239 +64,384 @@ type AnthropicResponse struct {
 	StopReason string `json:"stop_reason"`
 }
 
+// Text concatenates all text content blocks in order. Non-text blocks such
+// as thinking are skipped, so JSON in a later block is still found.
+func (r *AnthropicResponse) Text() string {
+	var builder strings.Builder
+	for _, block := range r.Content {
+		if block.Type == "text" {
+			builder.WriteString(block.Text)
+		}
+	}
+	return builder.String()
+}
+
 // AnthropicError represents an error response from Anthropic
 type AnthropicError struct {
 	Type    string `json:"type"`
 	Message string `json:"message"`
 }
 
-func NewAnthropicProvider(apiKey, model string, timeout time.Duration, memoryManager *memory.Manager) *AnthropicProvider {
-	return &AnthropicProvider{
-		apiKey:        apiKey,
-		model:         model,
-		timeout:       timeout,
-		memoryManager: memoryManager,
-		client: &http.Client{
-			Timeout: timeout,
-		},
+func NewAnthropicProvider(apiKey, model string, timeout time.Duration, memoryManager *memory.Manager, opts ...Option) *AnthropicProvider {
+	a := &AnthropicProvider{
+		apiKey:       apiKey,
+		model:        model,
+		timeout:      timeout,
+		conversation: newConversation(ProviderAnthropic, memoryManager),
+		stop:         make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(&a.settings)
 	}
+	if a.baseURL == "" {
+		a.baseURL = DefaultAnthropicBaseURL
+	}
+	a.baseURL = strings.TrimRight(a.baseURL, "/")
+
+	a.client = &http.Client{
+		Timeout:   timeout,
+		Transport: newPooledTransport(a.keepAlive),
+	}
+	if a.keepAlive > 0 {
+		go keepWarm(a.client, a.baseURL, a.keepAlive, a.stop, a.logger)
+	}
+	return a
+}
+
+// Close stops keep-alive pings and releases idle connections
+func (a *AnthropicProvider) Close() error {
+	select {
+	case <-a.stop:
+	default:
+		close(a.stop)
+	}
+	a.client.CloseIdleConnections()
+	return nil
 }
 
 // AnalyzeIntent implements the LLMProvider interface
 func (a *AnthropicProvider) AnalyzeIntent(ctx context.Context, request *models.IntentRequest) (*models.IntentResponse, error) {
-	// Step 1: Save user message to Redis
-	userID := "user_" + request.SessionID // Default user ID (can be improved later)
-	if err := a.memoryManager.SaveUserMessage(ctx, request.SessionID, userID, request.UserMessage); err != nil {
-		fmt.Printf("⚠️ Warning: Failed to save user message to Redis: %v\n", err)
-		// Continue anyway - we can still process without saving
+	return a.analyze(ctx, request, nil)
+}
+
+// AnalyzeIntentStream implements the StreamingProvider interface. The reply
+// is requested as server-sent events and its user_message is passed to
+// onChunk as it arrives.
+func (a *AnthropicProvider) AnalyzeIntentStream(ctx context.Context, request *models.IntentRequest, onChunk ChunkFunc) (*models.IntentResponse, error) {
+	return a.analyze(ctx, request, onChunk)
+}
+
+// analyze runs a conversation turn, streaming the reply when onChunk is set
+func (a *AnthropicProvider) analyze(ctx context.Context, request *models.IntentRequest, onChunk ChunkFunc) (*models.IntentResponse, error) {
+	// Save the user message and load history
+	t, err := a.beginTurn(ctx, request)
+	if err != nil {
+		return nil, err
 	}
 
-	// Step 2: Load conversation history from Redis
-	formattedHistory, err := a.memoryManager.GetFormattedHistory(ctx, request.SessionID)
+	// Send the stored history as real turns
+	firstTurn := t.historyLoaded && isFirstTurn(t.messages)
+
+	// Instructions go in the system field, the conversation in messages
+	system, messages := buildAnthropicMessages(prompts.BuildSystemPrompt(request, t.facts, a.persona)+buildTimingHint(t.idle), t.messages, request.UserMessage)
+	t.prompt = system + "\n\nConversation History:\n" + t.history
+
+	// Route to a cheaper model for simple actions
+	model := a.resolveModel(a.model, request)
+
+	// Opening messages don't depend on earlier turns, so identical ones can
+	// share a reply
+	var cacheKey string
+	if a.responseCache != nil && firstTurn {
+		cacheKey = responseCacheKey(model, system, request.AvailableActions, request.UserMessage)
+		if content, ok := a.cachedReply(ctx, request.SessionID, cacheKey); ok {
+			response, err := a.finishTurn(ctx, request, t, model, content, Usage{})
+			if err == nil && onChunk != nil {
+				onChunk(response.UserMessage)
+			}
+			return response, err
+		}
+	}
+
+	// Call Claude
+	var content string
+	var usage Usage
+	if onChunk != nil {
+		content, usage, err = a.completeStream(ctx, request.SessionID, model, system, messages, onChunk)
+	} else {
+		content, usage, err = a.completeMessages(ctx, request.SessionID, model, system, messages)
+	}
 	if err != nil {
-		fmt.Printf("⚠️ Warning: Failed to load history from Redis: %v\n", err)
-		formattedHistory = "No previous conversation."
+		return nil, err
+	}
+
+	// Give the model one chance to fix output that doesn't parse
+	if _, _, parseErr := decodeIntentJSON(content, a.lenientJSON); parseErr != nil {
+		content, usage = a.correctJSON(ctx, request.SessionID, model, system, messages, content, usage, parseErr)
+	}
+	if cacheKey != "" {
+		a.cacheReply(ctx, request.SessionID, cacheKey, content)
 	}
 
-	fmt.Printf("📚 Loaded conversation history for session %s:\n%s\n", request.SessionID, formattedHistory)
+	// Parse the response and save the assistant reply
+	return a.finishTurn(ctx, request, t, model, content, usage)
+}
+
+// jsonCorrectionPrompt asks the model to restate its previous reply as JSON
+const jsonCorrectionPrompt = `Your previous reply could not be parsed (%v). Reply again with ONLY a valid JSON object in the required format: no prose, no markdown, nothing before or after the JSON.`
+
+// correctJSON makes a single follow-up request echoing the model's invalid
+// output and asking for valid JSON. The original content is kept if the
+// follow-up request fails; otherwise the corrected reply is returned, valid
+// or not. Usage from both requests is combined.
+func (a *AnthropicProvider) correctJSON(ctx context.Context, sessionID, model, system string, messages []AnthropicMessage, content string, usage Usage, parseErr error) (string, Usage) {
+	a.logger.WarnContext(ctx, "model returned invalid JSON, requesting a correction", "session_id", sessionID, "error", parseErr)
+	metrics.JSONCorrectionAttempts.Add(1)
+
+	// The API rejects empty assistant turns
+	echo := content
+	if strings.TrimSpace(echo) == "" {
+		echo = "(empty reply)"
+	}
+	followUp := append(append([]AnthropicMessage{}, messages...),
+		AnthropicMessage{Role: "assistant", Content: echo},
+		AnthropicMessage{Role: "user", Content: fmt.Sprintf(jsonCorrectionPrompt, parseErr)},
+	)
+	corrected, correctionUsage, err := a.completeMessages(ctx, sessionID, model, system, followUp)
+	if err != nil {
+		a.logger.WarnContext(ctx, "JSON correction request failed", "session_id", sessionID, "error", err)
+		return content, usage
+	}
 
-	// Step 3: Build the prompt using history from Redis
-	prompt := a.buildPromptWithHistory(request, formattedHistory)
+	usage.InputTokens += correctionUsage.InputTokens
+	usage.OutputTokens += correctionUsage.OutputTokens
+	// finishTurn audits the corrected reply, so audit the original first to
+	// keep the audit trail in the order the model replied
+	a.audit(sessionID, content)
+	if _, _, err := decodeIntentJSON(corrected, a.lenientJSON); err != nil {
+		a.logger.WarnContext(ctx, "corrected reply is still not valid JSON", "session_id", sessionID, "error", err)
+		return corrected, usage
+	}
+	metrics.JSONCorrectionSuccesses.Add(1)
+	return corrected, usage
+}
 
-	// Step 4: Create a single message with the full prompt
+// complete sends a single-message prompt to the Messages API and returns
+// the text content
+func (a *AnthropicProvider) complete(ctx context.Context, sessionID, model, prompt string) (string, Usage, error) {
+	// Create a single message with the full prompt
 	messages := []AnthropicMessage{
 		{
 			Role:    "user",
 			Content: prompt,
 		},
 	}
+	return a.completeMessages(ctx, sessionID, model, "", messages)
+}
 
-	// Step 5: Prepare the request body
+// completeMessages sends a conversation with optional top-level system
+// instructions and returns the text content
+func (a *AnthropicProvider) completeMessages(ctx context.Context, sessionID, model, system string, messages []AnthropicMessage) (string, Usage, error) {
+	// Prepare the request body
 	anthropicReq := AnthropicRequest{
-		Model:       a.model,
-		MaxTokens:   1000,
-		Temperature: 0.1, // Low temperature for consistent responses
+		Model:       model,
+		MaxTokens:   a.maxTokens,
+		Temperature: a.temperature,
+		System:      system,
 		Messages:    messages,
 	}
 
 	// Marshal the request
 	reqBody, err := json.Marshal(anthropicReq)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
+		return "", Usage{}, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	fmt.Printf("🤖 Calling Claude API for session: %s\n", request.SessionID)
+	a.logger.InfoContext(ctx, "calling Claude API", "session_id", sessionID, "model", model)
 
-	// Step 6: Create HTTP request
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", "https://api.anthropic.com/v1/messages", bytes.NewBuffer(reqBody))
+	// Send, retrying transient failures with backoff
+	anthropicResp, err := a.sendWithRetry(ctx, sessionID, reqBody)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+		return "", Usage{}, err
 	}
 
-	// Set headers
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("x-api-key", a.apiKey)
-	httpReq.Header.Set("anthropic-version", "2023-06-01")
+	// Extract content
+	content := anthropicResp.Text()
 
-	// Step 7: Make the request
-	resp, err := a.client.Do(httpReq)
-	if err != nil {
-		return nil, fmt.Errorf("failed to make HTTP request: %w", err)
-	}
-	defer resp.Body.Close()
+	a.logger.InfoContext(ctx, "Claude response received", "session_id", sessionID, "characters", len(content))
 
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+	usage := Usage{
+		InputTokens:  anthropicResp.Usage.InputTokens,
+		OutputTokens: anthropicResp.Usage.OutputTokens,
+		Model:        anthropicResp.Model,
 	}
+	return content, usage, nil
+}
 
-	// Handle non-200 responses
-	if resp.StatusCode != http.StatusOK {
-		fmt.Printf("❌ Error response body: %s\n", string(body))
-
-		var anthropicErr AnthropicError
-		if err := json.Unmarshal(body, &anthropicErr); err != nil {
-			return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+// anthropicTurnReminder closes the system prompt when the conversation is
+// sent as separate messages
+const anthropicTurnReminder = `Respond to the latest user message with the JSON format above. Review the ENTIRE conversation before responding and don't ask for information that was already provided.`
+
+// buildAnthropicMessages turns stored history into alternating user and
+// assistant turns ending with the current message. Consecutive messages
+// from the same role are merged, leading assistant turns are dropped since
+// the API requires the first message to be from the user, and system
+// messages such as summaries are moved into the system prompt.
+func buildAnthropicMessages(system string, history []memory.Message, currentMessage string) (string, []AnthropicMessage) {
+	var earlier []string
+	var messages []AnthropicMessage
+	for _, msg := range history {
+		switch msg.Role {
+		case "system":
+			earlier = append(earlier, msg.Content)
+			continue
+		case "user", "assistant":
+		default:
+			continue
 		}
-		return nil, fmt.Errorf("anthropic API error: %s", anthropicErr.Message)
-	}
-
-	// Step 8: Parse response
-	var anthropicResp AnthropicResponse
-	if err := json.Unmarshal(body, &anthropicResp); err != nil {
-		return nil, fmt.Errorf("failed to parse response: %w", err)
-	}
-
-	// Extract content
-	var content string
-	if len(anthropicResp.Content) > 0 {
-		content = anthropicResp.Content[0].Text
-	}
-
-	fmt.Printf("✅ Claude response received: %d characters\n", len(content))
 
-	// Step 9: Parse the LLM response
-	intentResponse, err := a.parseIntentResponse(content)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse intent response: %w", err)
+		if len(messages) == 0 && msg.Role == "assistant" {
+			continue
+		}
+		if n := len(messages); n > 0 && messages[n-1].Role == msg.Role {
+			messages[n-1].Content += "\n\n" + msg.Content
+			continue
+		}
+		messages = append(messages, AnthropicMessage{Role: msg.Role, Content: msg.Content})
 	}
 
-	// Set session ID
-	intentResponse.SessionID = request.SessionID
-
-	// Step 10: Save assistant response to Redis
-	if intentResponse.UserMessage != "" {
-		if err := a.memoryManager.SaveAssistantMessage(ctx, request.SessionID, userID, intentResponse.UserMessage); err != nil {
-			fmt.Printf("⚠️ Warning: Failed to save assistant message to Redis: %v\n", err)
-			// Continue anyway
+	// The current message is normally the last stored one; add it if saving
+	// it failed
+	if n := len(messages); n == 0 || messages[n-1].Role != "user" || !strings.HasSuffix(messages[n-1].Content, currentMessage) {
+		if n > 0 && messages[n-1].Role == "user" {
+			messages[n-1].Content += "\n\n" + currentMessage
+		} else {
+			messages = append(messages, AnthropicMessage{Role: "user", Content: currentMessage})
 		}
 	}
 
-	return intentResponse, nil
+	if len(earlier) > 0 {
+		system += "\nEarlier Context:\n" + strings.Join(earlier, "\n") + "\n"
+	}
+	return system + "\n" + anthropicTurnReminder, messages
 }
 
-// buildPromptWithHistory creates the full prompt using conversation history from Redis
-func (a *AnthropicProvider) buildPromptWithHistory(request *models.IntentRequest, formattedHistory string) string {
-	// Build available actions section
-	actionsSection := a.buildActionsSection(request.AvailableActions)
-
-	const SystemPrompt = `You are an AI assistant for CDNbuddy, a CDN management platform. Your job is to analyze user conversations and determine what CDN-related actions they want to perform.
-
-IMPORTANT RULES:
-1. Work on ONE action at a time, even if multiple actions are mentioned
-2. If multiple actions are mentioned, pick the first one mentioned
-3. Extract parameters from the conversation for the selected action
-4. If you need more information, ask specific questions
-5. When an action is complete, you can ask "Do you have any other requirements?"
-6. IMPORTANT: Review the ENTIRE conversation history before responding - don't ask for information already provided
-
-CDN SETUP REQUIREMENTS:
-When user wants to setup CDN (SETUP_CDN action), you MUST collect these TWO pieces of information:
-1. Domain name - The website domain (e.g., "example.com")
-2. Origin hostname - Where content is currently hosted (e.g., "yellowgreen.com", "backend.example.com")
-
-For the origin hostname:
-- Ask: "Where is your website currently hosted? This can be a domain name or subdomain."
-- If user doesn't provide it explicitly, ask: "What's the hostname where your content is currently served from?"
-- Examples of valid origins: "origin.example.com", "example.com", "server.company.com", "backend.example.com"
-
-ONLY return status="READY" and action="SETUP_CDN" when you have BOTH:
-- parameter "domain" with the website domain
-- parameter "origin_hostname" with the origin server hostname
-
-If you only have the domain but not the origin, ask for the origin hostname specifically.
-
-RESPONSE FORMAT:
-You must respond with a valid JSON object in this exact format:
-{
- "action": "ACTION_NAME or null",
- "status": "NEEDS_INFO or READY",
- "parameters": {
- "param_name": "extracted_value or null"
- },
- "user_message": "Your response to the user"
+// sendWithRetry posts the request, retrying rate limits and transient
+// server errors with exponential backoff until maxRetries is used up or
+// the context is done. A 429 carrying Retry-After waits for that long
+// instead.
+func (a *AnthropicProvider) sendWithRetry(ctx context.Context, sessionID string, reqBody []byte) (*AnthropicResponse, error) {
+	var anthropicResp *AnthropicResponse
+	err := a.retry(ctx, sessionID, func() error {
+		var err error
+		anthropicResp, err = a.send(ctx, reqBody)
+		return err
+	})
+	return anthropicResp, err
 }
 
-Available Actions:
-%s
-
-Conversation History:
-%s
+// retry calls attempt until it succeeds, fails with an error that isn't
+// worth retrying or maxRetries is used up
+func (a *AnthropicProvider) retry(ctx context.Context, sessionID string, attempt func() error) error {
+	for n := 0; ; n++ {
+		err := attempt()
+		if err == nil {
+			return nil
+		}
 
-Current User Message: %s
+		var statusErr *StatusError
+		if !errors.As(err, &statusErr) || !isRetryableStatus(statusErr.StatusCode) || n >= a.maxRetries {
+			return err
+		}
 
-Analyze the FULL conversation history above and respond with the JSON format. Remember to check what information was already provided in previous messages.`
+		// Honour the server's cooldown on rate limits, else back off
+		delay := backoffDelay(n)
+		if statusErr.StatusCode == http.StatusTooManyRequests {
+			if wait, ok := retryAfter(statusErr.Header, time.Now()); ok {
+				delay = wait
+			}
+		}
 
-	return fmt.Sprintf(SystemPrompt, actionsSection, formattedHistory, request.UserMessage)
-}
+		a.logger.WarnContext(ctx, "retrying Claude API call", "session_id", sessionID, "status", statusErr.StatusCode,
+			"delay", delay.Round(time.Millisecond), "attempt", n+1, "max_retries", a.maxRetries)
 
-func (a *AnthropicProvider) buildActionsSection(actions []models.ActionSchema) string {
-	var builder strings.Builder
-	for _, action := range actions {
-		builder.WriteString(fmt.Sprintf("- %s: requires [%s]\n",
-			action.Action,
-			strings.Join(action.Parameters, ", ")))
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("gave up retrying: %w", ctx.Err())
+		case <-timer.C:
+		}
 	}
-	return builder.String()
 }
 
-// parseIntentResponse parses the JSON response from the LLM into an IntentResponse
-func (a *AnthropicProvider) parseIntentResponse(content string) (*models.IntentResponse, error) {
-
-	jsonContent := a.extractJSON(content)
-	if jsonContent == "" {
-		return nil, fmt.Errorf("no valid JSON found in response")
+// send makes a single Messages API call
+func (a *AnthropicProvider) send(ctx context.Context, reqBody []byte) (*AnthropicResponse, error) {
+	resp, err := a.post(ctx, reqBody)
+	if err != nil {
+		return nil, err
 	}
+	defer resp.Body.Close()
 
-	var response models.IntentResponse
-	if err := json.Unmarshal([]byte(jsonContent), &response); err != nil {
-		return nil, fmt.Errorf("failed to parse JSON: %w", err)
+	// Read response body
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
 
-	if response.Status == "" {
-		response.Status = models.StatusError
-		response.UserMessage = "I didn't understand your request clearly. Could you please rephrase what you'd like me to help you with regarding CDN setup or management?"
+	// Parse response
+	var anthropicResp AnthropicResponse
+	if err := json.Unmarshal(body, &anthropicResp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
 	}
+	return &anthropicResp, nil
+}
+
+// post sends a Messages API request. A non-200 answer is returned as a
+// *StatusError; otherwise the caller reads and closes the body. Each
+// attempt gets its own span, which ends once the response headers arrive.
+func (a *AnthropicProvider) post(ctx context.Context, reqBody []byte) (resp *http.Response, err error) {
+	ctx, span := tracing.Start(ctx, "anthropic.messages")
+	defer func() { tracing.End(span, err) }()
 
-	if response.Parameters == nil {
-		response.Parameters = make(map[string]*string)
+	// Create HTTP request
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", a.baseURL+"/v1/messages", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
 	}
 
-	return &response, nil
-}
+	// Set headers
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("x-api-key", a.apiKey)
+	httpReq.Header.Set("anthropic-version", "2023-06-01")
 
-func (a *AnthropicProvider) extractJSON(content string) string {
-	// Look for JSON object in the content
-	start := strings.Index(content, "{")
-	if start == -1 {
-		return ""
+	// Make the request
+	resp, err = a.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make HTTP request: %w", err)
+	}
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
 	}
+	defer resp.Body.Close()
 
-	end := strings.LastIndex(content, "}")
-	if end == -1 || end <= start {
-		return ""
+	// Handle non-200 responses
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
+	a.logger.ErrorContext(ctx, "Claude API error response", "status", resp.StatusCode, "body", string(body))
 
-	return content[start : end+1]
+	statusErr := &StatusError{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+	}
+	var anthropicErr AnthropicError
+	if err := json.Unmarshal(body, &anthropicErr); err != nil {
+		statusErr.Message = fmt.Sprintf("API request failed with status %d: %s", resp.StatusCode, string(body))
+	} else {
+		statusErr.Message = fmt.Sprintf("anthropic API error: %s", anthropicErr.Message)
+	}
+	return nil, statusErr
 }
@@ -0,0 +1,715 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/avvvet/cdnbuddy-intent/internal/memory"
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+	"github.com/avvvet/cdnbuddy-intent/internal/prompts"
+)
+
+// fakeAnthropic is a Messages API stand-in that records each request and
+// answers with a fixed reply
+type fakeAnthropic struct {
+	*httptest.Server
+	model  string // Reported as the model that answered, empty to omit
+	reply  string
+	blocks []map[string]string // Content blocks to send instead of reply
+	chunk  int                 // Bytes of reply per streamed text delta
+
+	mu       sync.Mutex
+	requests []AnthropicRequest
+	failures []fakeFailure // Answered in turn before the reply
+	replies  []string      // Sent in turn before reply, after any failures
+}
+
+// fakeFailure is an error answer from fakeAnthropic
+type fakeFailure struct {
+	status     int
+	retryAfter string // Retry-After header, empty to omit
+}
+
+func newFakeAnthropic(t *testing.T, reply string) *fakeAnthropic {
+	t.Helper()
+	f := &fakeAnthropic{reply: reply}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request AnthropicRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		f.requests = append(f.requests, request)
+		var failure *fakeFailure
+		reply := f.reply
+		if len(f.failures) > 0 {
+			failure, f.failures = &f.failures[0], f.failures[1:]
+		} else if len(f.replies) > 0 {
+			reply, f.replies = f.replies[0], f.replies[1:]
+		}
+		f.mu.Unlock()
+
+		if failure != nil {
+			if failure.retryAfter != "" {
+				w.Header().Set("Retry-After", failure.retryAfter)
+			}
+			w.WriteHeader(failure.status)
+			json.NewEncoder(w).Encode(map[string]any{
+				"type":  "error",
+				"error": map[string]string{"type": "overloaded_error", "message": "Overloaded"},
+			})
+			return
+		}
+
+		if request.Stream {
+			f.stream(w, reply)
+			return
+		}
+
+		blocks := f.blocks
+		if blocks == nil {
+			blocks = []map[string]string{{"type": "text", "text": reply}}
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"id":      "msg_test",
+			"type":    "message",
+			"role":    "assistant",
+			"content": blocks,
+			"model":   f.model,
+			"usage":   map[string]int{"input_tokens": 10, "output_tokens": 5},
+		})
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// stream answers with reply as server-sent events, split into text deltas
+// of about f.chunk bytes. Like the real API it never splits a rune.
+func (f *fakeAnthropic) stream(w http.ResponseWriter, reply string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	send := func(event map[string]any) {
+		data, _ := json.Marshal(event)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event["type"], data)
+	}
+
+	send(map[string]any{"type": "message_start", "message": map[string]any{
+		"model": f.model,
+		"usage": map[string]int{"input_tokens": 10, "output_tokens": 1},
+	}})
+	send(map[string]any{"type": "content_block_start", "index": 0, "content_block": map[string]string{"type": "text", "text": ""}})
+	fmt.Fprint(w, "event: ping\ndata: {\"type\": \"ping\"}\n\n")
+	chunk := max(f.chunk, 1)
+	for reply != "" {
+		n := min(chunk, len(reply))
+		for n < len(reply) && !utf8.RuneStart(reply[n]) {
+			n++
+		}
+		send(map[string]any{"type": "content_block_delta", "index": 0, "delta": map[string]string{"type": "text_delta", "text": reply[:n]}})
+		reply = reply[n:]
+	}
+	send(map[string]any{"type": "content_block_stop", "index": 0})
+	send(map[string]any{"type": "message_delta", "usage": map[string]int{"output_tokens": 5}})
+	send(map[string]any{"type": "message_stop"})
+}
+
+func (f *fakeAnthropic) Requests() []AnthropicRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]AnthropicRequest{}, f.requests...)
+}
+
+// recordingSink keeps debug captures in memory
+type recordingSink struct {
+	mu       sync.Mutex
+	captures []DebugCapture
+}
+
+func (s *recordingSink) Capture(ctx context.Context, capture DebugCapture) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.captures = append(s.captures, capture)
+	return nil
+}
+
+// newTestAnthropic returns a provider pointed at server with an in-memory
+// conversation store
+func newTestAnthropic(t *testing.T, server *fakeAnthropic, opts ...Option) (*AnthropicProvider, *memory.Manager) {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	manager := memory.NewManager(memory.NewInMemoryStore(time.Hour), memory.WithLogger(logger))
+	t.Cleanup(func() { manager.Close() })
+
+	opts = append([]Option{WithBaseURL(server.URL), WithLogger(logger), WithMaxRetries(0)}, opts...)
+	provider := NewAnthropicProvider("test-key", "claude-test", 5*time.Second, manager, opts...)
+	t.Cleanup(func() { provider.Close() })
+	return provider, manager
+}
+
+// sentText joins the content of every message in a request
+func sentText(request AnthropicRequest) string {
+	var parts []string
+	for _, msg := range request.Messages {
+		parts = append(parts, msg.Content)
+	}
+	return strings.Join(parts, "\n")
+}
+
+const readyReply = `{"status": "READY", "action": "purge_cache", "parameters": {}, "user_message": "Done", "confidence": 0.9}`
+
+func TestAnthropicHistoryDeadline(t *testing.T) {
+	old := strings.Repeat("an early message about origins ", 200)
+	tests := []struct {
+		name      string
+		deadline  time.Duration // Zero for no deadline
+		threshold time.Duration
+		wantOld   bool
+	}{
+		{name: "no deadline", threshold: time.Minute, wantOld: true},
+		{name: "plenty of time", deadline: time.Hour, threshold: time.Minute, wantOld: true},
+		{name: "near expired", deadline: 5 * time.Second, threshold: time.Minute},
+		{name: "threshold disabled", deadline: 5 * time.Second, wantOld: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeAnthropic(t, readyReply)
+			sink := &recordingSink{}
+			provider, manager := newTestAnthropic(t, server,
+				WithHistoryDeadlineThreshold(tt.threshold),
+				WithDebugSampling(1, sink),
+			)
+
+			ctx := context.Background()
+			if err := manager.SaveUserMessage(ctx, "s1", "u1", old); err != nil {
+				t.Fatal(err)
+			}
+			if err := manager.SaveAssistantMessage(ctx, "s1", "u1", "noted"); err != nil {
+				t.Fatal(err)
+			}
+			if tt.deadline > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.deadline)
+				defer cancel()
+			}
+
+			response, err := provider.AnalyzeIntent(ctx, &models.IntentRequest{SessionID: "s1", UserID: "u1", UserMessage: "purge everything"})
+			if err != nil {
+				t.Fatalf("AnalyzeIntent() error = %v", err)
+			}
+			if response.Status != models.StatusReady {
+				t.Errorf("status = %s, want READY", response.Status)
+			}
+
+			requests := server.Requests()
+			if len(requests) != 1 {
+				t.Fatalf("LLM called %d times, want 1", len(requests))
+			}
+			sent := sentText(requests[0])
+			if !strings.Contains(sent, "purge everything") {
+				t.Errorf("current message missing from request")
+			}
+			if got := strings.Contains(sent, old); got != tt.wantOld {
+				t.Errorf("old history sent = %v, want %v", got, tt.wantOld)
+			}
+
+			// The debug transcript comes from the same load as the request
+			if len(sink.captures) != 1 {
+				t.Fatalf("got %d debug captures, want 1", len(sink.captures))
+			}
+			prompt := sink.captures[0].Prompt
+			if got := strings.Contains(prompt, old); got != tt.wantOld {
+				t.Errorf("old history in debug prompt = %v, want %v", got, tt.wantOld)
+			}
+			if !strings.Contains(prompt, "User: purge everything") {
+				t.Errorf("debug prompt missing the current message:\n%s", prompt)
+			}
+		})
+	}
+}
+
+func TestAnthropicResponseText(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "single text block",
+			content: `[{"type": "text", "text": "hello"}]`,
+			want:    "hello",
+		},
+		{
+			name:    "text blocks are concatenated",
+			content: `[{"type": "text", "text": "{\"status\": "}, {"type": "text", "text": "\"READY\"}"}]`,
+			want:    `{"status": "READY"}`,
+		},
+		{
+			name:    "non-text blocks are skipped",
+			content: `[{"type": "thinking", "text": "hmm"}, {"type": "text", "text": "one "}, {"type": "tool_use"}, {"type": "text", "text": "two"}]`,
+			want:    "one two",
+		},
+		{
+			name:    "no text blocks",
+			content: `[{"type": "tool_use"}]`,
+			want:    "",
+		},
+		{
+			name:    "no content",
+			content: `[]`,
+			want:    "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var response AnthropicResponse
+			if err := json.Unmarshal([]byte(`{"content": `+tt.content+`}`), &response); err != nil {
+				t.Fatal(err)
+			}
+			if got := response.Text(); got != tt.want {
+				t.Errorf("Text() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnthropicMultiBlockReply(t *testing.T) {
+	server := newFakeAnthropic(t, "")
+	server.blocks = []map[string]string{
+		{"type": "thinking", "text": "The user wants a purge."},
+		{"type": "text", "text": `Here you go: {"status": "READY", "action": "purge_cache",`},
+		{"type": "text", "text": ` "parameters": {}, "user_message": "Purging now", "confidence": 0.9}`},
+	}
+	provider, _ := newTestAnthropic(t, server)
+
+	response, err := provider.AnalyzeIntent(context.Background(), &models.IntentRequest{SessionID: "s1", UserMessage: "purge everything"})
+	if err != nil {
+		t.Fatalf("AnalyzeIntent() error = %v", err)
+	}
+	if response.Status != models.StatusReady || response.UserMessage != "Purging now" {
+		t.Errorf("got status %s and user_message %q, want READY and %q", response.Status, response.UserMessage, "Purging now")
+	}
+}
+
+func TestAnthropicResponseModel(t *testing.T) {
+	tests := []struct {
+		name     string
+		reported string // Model in the API response, empty to omit
+		want     string
+	}{
+		{name: "reported by the provider", reported: "claude-test-20250101", want: "claude-test-20250101"},
+		{name: "not reported", want: "claude-test"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeAnthropic(t, readyReply)
+			server.model = tt.reported
+			provider, _ := newTestAnthropic(t, server)
+
+			response, err := provider.AnalyzeIntent(context.Background(), &models.IntentRequest{SessionID: "s1", UserMessage: "purge everything"})
+			if err != nil {
+				t.Fatalf("AnalyzeIntent() error = %v", err)
+			}
+			if response.Model != tt.want {
+				t.Errorf("model = %q, want %q", response.Model, tt.want)
+			}
+			if response.Meta == nil || response.Meta.Model != tt.want {
+				t.Errorf("meta = %+v, want model %q", response.Meta, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnthropicPersona(t *testing.T) {
+	tests := []struct {
+		name       string
+		deployment string // WithPersona, empty to leave unset
+		requested  string
+		want       string
+	}{
+		{name: "default", want: prompts.PersonaInstruction(prompts.DefaultPersona)},
+		{name: "deployment persona", deployment: "formal", want: prompts.PersonaInstruction("formal")},
+		{name: "request override", deployment: "formal", requested: "terse", want: prompts.PersonaInstruction("terse")},
+		{name: "unknown request persona", deployment: "formal", requested: "pirate", want: prompts.PersonaInstruction("formal")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeAnthropic(t, readyReply)
+			provider, _ := newTestAnthropic(t, server, WithPersona(tt.deployment))
+
+			request := &models.IntentRequest{SessionID: "s1", UserMessage: "purge the cache", Persona: tt.requested}
+			if _, err := provider.AnalyzeIntent(context.Background(), request); err != nil {
+				t.Fatalf("AnalyzeIntent() error = %v", err)
+			}
+			if system := server.Requests()[0].System; !strings.Contains(system, tt.want) {
+				t.Errorf("system prompt is missing %q:\n%s", tt.want, system)
+			}
+		})
+	}
+}
+
+func TestAnthropicJSONCorrection(t *testing.T) {
+	const garbage = "Sure! I'll purge the cache for you."
+	tests := []struct {
+		name      string
+		replies   []string // Sent before readyReply
+		wantErr   bool
+		wantCalls int
+		wantUsage int // Input tokens reported on the response
+	}{
+		{name: "valid first time", wantCalls: 1, wantUsage: 10},
+		{name: "garbage then valid", replies: []string{garbage}, wantCalls: 2, wantUsage: 20},
+		{name: "garbage twice", replies: []string{garbage, "Still not JSON"}, wantErr: true, wantCalls: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeAnthropic(t, readyReply)
+			server.replies = tt.replies
+			provider, manager := newTestAnthropic(t, server)
+
+			ctx := context.Background()
+			response, err := provider.AnalyzeIntent(ctx, &models.IntentRequest{SessionID: "s1", UserMessage: "purge the cache"})
+			if tt.wantErr {
+				if !errors.Is(err, ErrUnparsableReply) {
+					t.Fatalf("AnalyzeIntent() error = %v, want %v", err, ErrUnparsableReply)
+				}
+			} else if err != nil {
+				t.Fatalf("AnalyzeIntent() error = %v", err)
+			} else if response.Status != models.StatusReady || response.InputTokens != tt.wantUsage {
+				t.Errorf("response = %s with %d input tokens, want %s with %d", response.Status, response.InputTokens, models.StatusReady, tt.wantUsage)
+			}
+
+			requests := server.Requests()
+			if len(requests) != tt.wantCalls {
+				t.Fatalf("API called %d times, want %d", len(requests), tt.wantCalls)
+			}
+			if tt.wantCalls > 1 {
+				// The follow-up echoes the bad reply and asks for JSON only
+				followUp := requests[1].Messages
+				n := len(followUp)
+				if n < 2 || followUp[n-2].Role != "assistant" || followUp[n-2].Content != garbage ||
+					followUp[n-1].Role != "user" || !strings.Contains(followUp[n-1].Content, "ONLY a valid JSON object") {
+					t.Errorf("correction request ends with %+v, want the bad reply and the correction prompt", followUp[max(n-2, 0):])
+				}
+			}
+
+			// Neither the bad reply nor the correction prompt is stored
+			messages, err := manager.GetMessages(ctx, "s1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, msg := range messages {
+				if msg.Content == garbage || strings.Contains(msg.Content, "ONLY a valid JSON object") {
+					t.Errorf("stored %s message %q", msg.Role, msg.Content)
+				}
+			}
+		})
+	}
+}
+
+func TestAnthropicBaseURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		prefix   string // Appended to the mock server's URL
+		wantPath string
+	}{
+		{name: "host only", wantPath: "/v1/messages"},
+		{name: "trailing slash", prefix: "/", wantPath: "/v1/messages"},
+		{name: "gateway path", prefix: "/llm/anthropic/", wantPath: "/llm/anthropic/v1/messages"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			type call struct {
+				path, apiKey, version string
+			}
+			calls := make(chan call, 1)
+			fake := newFakeAnthropic(t, readyReply)
+			mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls <- call{r.URL.Path, r.Header.Get("x-api-key"), r.Header.Get("anthropic-version")}
+				fake.Config.Handler.ServeHTTP(w, r)
+			}))
+			t.Cleanup(mock.Close)
+
+			provider, _ := newTestAnthropic(t, fake, WithBaseURL(mock.URL+tt.prefix))
+			response, err := provider.AnalyzeIntent(context.Background(), &models.IntentRequest{SessionID: "s1", UserMessage: "purge the cache"})
+			if err != nil {
+				t.Fatalf("AnalyzeIntent() error = %v", err)
+			}
+			if response.Status != models.StatusReady {
+				t.Errorf("status = %s, want %s", response.Status, models.StatusReady)
+			}
+
+			got := <-calls
+			if got.path != tt.wantPath || got.apiKey != "test-key" || got.version == "" {
+				t.Errorf("request to %s with key %q and version %q, want %s with the API key and version", got.path, got.apiKey, got.version, tt.wantPath)
+			}
+		})
+	}
+}
+
+func TestAnthropicTimingHint(t *testing.T) {
+	tests := []struct {
+		name       string
+		timestamps bool
+		idle       time.Duration // Age of the previous message, 0 for a new session
+		want       string        // Hint in the system prompt, empty for none
+	}{
+		{name: "disabled", idle: 72 * time.Hour},
+		{name: "new session", timestamps: true},
+		{name: "moments ago", timestamps: true, idle: 10 * time.Second, want: "was sent moments ago."},
+		{name: "one hour", timestamps: true, idle: 90 * time.Minute, want: "was sent 1 hour ago."},
+		{name: "days", timestamps: true, idle: 73 * time.Hour, want: "was sent 3 days ago."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeAnthropic(t, readyReply)
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			store := memory.NewInMemoryStore(time.Hour)
+			manager := memory.NewManager(store, memory.WithLogger(logger), memory.WithHistoryTimestamps(tt.timestamps))
+			t.Cleanup(func() { manager.Close() })
+			provider := NewAnthropicProvider("test-key", "claude-test", 5*time.Second, manager,
+				WithBaseURL(server.URL), WithLogger(logger), WithMaxRetries(0))
+			t.Cleanup(func() { provider.Close() })
+
+			ctx := context.Background()
+			if tt.idle > 0 {
+				msg := memory.Message{Role: "assistant", Content: "Which service?", Timestamp: time.Now().Add(-tt.idle)}
+				if err := store.SaveMessage(ctx, "s1", "user1", msg); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if _, err := provider.AnalyzeIntent(ctx, &models.IntentRequest{SessionID: "s1", UserMessage: "the blog"}); err != nil {
+				t.Fatalf("AnalyzeIntent() error = %v", err)
+			}
+			system := server.Requests()[0].System
+			if tt.want == "" {
+				if strings.Contains(system, "Timing:") {
+					t.Errorf("system prompt has a timing hint, want none:\n%s", system)
+				}
+			} else if !strings.Contains(system, tt.want) {
+				t.Errorf("system prompt doesn't say the previous message %s:\n%s", tt.want, system)
+			}
+		})
+	}
+}
+
+func TestAnthropicAuditSink(t *testing.T) {
+	const wrapped = "Here's the intent:\n```json\n" + readyReply + "\n```"
+	const garbage = "Sure! I'll purge the cache for you."
+	tests := []struct {
+		name    string
+		reply   string
+		replies []string // Sent before reply
+		want    []string // Raw replies the sink receives, in order
+	}{
+		{name: "JSON reply", reply: readyReply, want: []string{readyReply}},
+		{name: "wrapped in prose", reply: wrapped, want: []string{wrapped}},
+		{name: "corrected reply", reply: readyReply, replies: []string{garbage}, want: []string{garbage, readyReply}},
+		{name: "never valid", reply: "Still not JSON", replies: []string{garbage}, want: []string{garbage, "Still not JSON"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeAnthropic(t, tt.reply)
+			server.replies = tt.replies
+			var got []string
+			provider, _ := newTestAnthropic(t, server, WithAuditSink(func(sessionID, rawResponse string) {
+				if sessionID != "s1" {
+					t.Errorf("audited session %q, want s1", sessionID)
+				}
+				got = append(got, rawResponse)
+			}))
+
+			// Unparsable replies are audited too, so the error doesn't matter
+			provider.AnalyzeIntent(context.Background(), &models.IntentRequest{SessionID: "s1", UserMessage: "purge the cache"})
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("audited %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnthropicSystemHistory(t *testing.T) {
+	const policy = "Tenant policy: only purge paths under /static"
+	tests := []struct {
+		name      string
+		preambles []string // System message sent with each turn's history, empty for none
+		wantRoles []string // Stored conversation after every turn
+	}{
+		{name: "no preamble", preambles: []string{"", ""}, wantRoles: []string{"user", "assistant", "user", "assistant"}},
+		{name: "preamble stored once", preambles: []string{policy, policy}, wantRoles: []string{"system", "user", "assistant", "user", "assistant"}},
+		{
+			name:      "new preamble stored",
+			preambles: []string{policy, "Tenant policy: never purge /"},
+			wantRoles: []string{"system", "user", "assistant", "system", "user", "assistant"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeAnthropic(t, readyReply)
+			provider, manager := newTestAnthropic(t, server)
+			ctx := context.Background()
+
+			for _, preamble := range tt.preambles {
+				request := &models.IntentRequest{SessionID: "s1", UserMessage: "purge /static/app.js"}
+				if preamble != "" {
+					request.ConversationHistory = []models.ConversationMessage{{Role: "system", Message: preamble}}
+				}
+				if _, err := provider.AnalyzeIntent(ctx, request); err != nil {
+					t.Fatalf("AnalyzeIntent() error = %v", err)
+				}
+			}
+
+			messages, err := manager.GetMessages(ctx, "s1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var roles []string
+			for _, msg := range messages {
+				roles = append(roles, msg.Role)
+			}
+			if !slices.Equal(roles, tt.wantRoles) {
+				t.Errorf("stored roles = %v, want %v", roles, tt.wantRoles)
+			}
+
+			// Each preamble reaches the system prompt, never a chat turn
+			requests := server.Requests()
+			for i, preamble := range tt.preambles {
+				if preamble == "" {
+					continue
+				}
+				if !strings.Contains(requests[i].System, preamble) {
+					t.Errorf("turn %d: system prompt is missing %q", i, preamble)
+				}
+				if strings.Contains(sentText(requests[i]), preamble) {
+					t.Errorf("turn %d: %q sent as a chat message", i, preamble)
+				}
+			}
+
+			history, err := manager.GetFormattedHistory(ctx, "s1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.preambles[0] != "" && !strings.Contains(history, "System: "+tt.preambles[0]) {
+				t.Errorf("formatted history is missing the preamble:\n%s", history)
+			}
+		})
+	}
+}
+
+func TestAnthropicExamples(t *testing.T) {
+	examples := []models.IntentExample{{
+		UserMessage: "clear everything under /img for svc-9",
+		Response:    json.RawMessage(`{"action": "purge_cache", "status": "READY", "parameters": {"service_id": "svc-9", "path": "/img/*"}}`),
+	}}
+	const rendered = `User: clear everything under /img for svc-9
+Response: {"action":"purge_cache","status":"READY","parameters":{"service_id":"svc-9","path":"/img/*"}}`
+
+	// redisContents joins every value miniredis holds
+	redisContents := func(mr *miniredis.Miniredis) string {
+		var values []string
+		for _, key := range mr.Keys() {
+			switch mr.Type(key) {
+			case "string":
+				value, _ := mr.Get(key)
+				values = append(values, value)
+			case "list":
+				list, _ := mr.List(key)
+				values = append(values, list...)
+			case "hash":
+				fields, _ := mr.HKeys(key)
+				for _, field := range fields {
+					values = append(values, mr.HGet(key, field))
+				}
+			}
+		}
+		return strings.Join(values, "\n")
+	}
+
+	tests := []struct {
+		name  string
+		redis bool // Store the session in Redis instead of memory
+	}{
+		{name: "memory"},
+		{name: "redis", redis: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeAnthropic(t, readyReply)
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			var store memory.Store = memory.NewInMemoryStore(time.Hour)
+			var mr *miniredis.Miniredis
+			if tt.redis {
+				mr = miniredis.RunT(t)
+				redisStore, err := memory.NewRedisStore("redis://"+mr.Addr(), time.Hour)
+				if err != nil {
+					t.Fatal(err)
+				}
+				store = redisStore
+			}
+			manager := memory.NewManager(store, memory.WithLogger(logger))
+			t.Cleanup(func() { manager.Close() })
+			provider := NewAnthropicProvider("test-key", "claude-test", 5*time.Second, manager,
+				WithBaseURL(server.URL), WithLogger(logger), WithMaxRetries(0))
+			t.Cleanup(func() { provider.Close() })
+
+			// Examples come with the first turn only
+			ctx := context.Background()
+			for _, request := range []*models.IntentRequest{
+				{SessionID: "s1", UserMessage: "purge the cache", Examples: examples},
+				{SessionID: "s1", UserMessage: "svc-1"},
+			} {
+				if _, err := provider.AnalyzeIntent(ctx, request); err != nil {
+					t.Fatalf("AnalyzeIntent() error = %v", err)
+				}
+			}
+
+			requests := server.Requests()
+			if !strings.Contains(requests[0].System, "Examples:\n\n"+rendered) {
+				t.Errorf("first prompt is missing the examples:\n%s", requests[0].System)
+			}
+			if strings.Contains(requests[1].System, "svc-9") || strings.Contains(sentText(requests[1]), "svc-9") {
+				t.Errorf("examples carried into the next turn:\n%s\n%s", requests[1].System, sentText(requests[1]))
+			}
+
+			history, err := manager.GetFormattedHistory(ctx, "s1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(history, "svc-9") {
+				t.Errorf("examples stored in the history:\n%s", history)
+			}
+			if mr != nil {
+				contents := redisContents(mr)
+				if !strings.Contains(contents, "purge the cache") {
+					t.Fatalf("conversation not found in Redis:\n%s", contents)
+				}
+				if strings.Contains(contents, "svc-9") {
+					t.Errorf("examples persisted to Redis:\n%s", contents)
+				}
+			}
+		})
+	}
+}
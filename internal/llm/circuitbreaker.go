@@ -0,0 +1,153 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/memory"
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+)
+
+// ErrCircuitOpen is returned without calling the provider while the
+// circuit breaker is open
+var ErrCircuitOpen = errors.New("LLM provider unavailable: circuit breaker is open")
+
+// Circuit breaker states
+const (
+	circuitClosed   = "closed"
+	circuitOpen     = "open"
+	circuitHalfOpen = "half-open"
+)
+
+// CircuitBreakerProvider stops calling a failing provider. After threshold
+// consecutive failures the circuit opens and requests fail immediately with
+// ErrCircuitOpen. Once the cooldown has passed a single probe request is let
+// through: success closes the circuit, failure opens it for another cooldown.
+type CircuitBreakerProvider struct {
+	provider  LLMProvider
+	threshold int
+	cooldown  time.Duration
+	logger    *slog.Logger
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    string
+	failures int // Consecutive failures while closed
+	openedAt time.Time
+}
+
+// NewCircuitBreakerProvider wraps provider in a circuit breaker
+func NewCircuitBreakerProvider(provider LLMProvider, threshold int, cooldown time.Duration, logger *slog.Logger) *CircuitBreakerProvider {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &CircuitBreakerProvider{
+		provider:  provider,
+		threshold: threshold,
+		cooldown:  cooldown,
+		logger:    logger,
+		now:       time.Now,
+		state:     circuitClosed,
+	}
+}
+
+// AnalyzeIntent implements the LLMProvider interface
+func (cb *CircuitBreakerProvider) AnalyzeIntent(ctx context.Context, request *models.IntentRequest) (*models.IntentResponse, error) {
+	if !cb.allow() {
+		return nil, ErrCircuitOpen
+	}
+
+	response, err := cb.provider.AnalyzeIntent(ctx, request)
+	cb.record(err)
+	return response, err
+}
+
+// AnalyzeIntentStream implements the StreamingProvider interface, falling
+// back to a single chunk when the wrapped provider can't stream
+func (cb *CircuitBreakerProvider) AnalyzeIntentStream(ctx context.Context, request *models.IntentRequest, onChunk ChunkFunc) (*models.IntentResponse, error) {
+	if !cb.allow() {
+		return nil, ErrCircuitOpen
+	}
+
+	response, err := AnalyzeIntentStream(ctx, cb.provider, request, onChunk)
+	cb.record(err)
+	return response, err
+}
+
+// State returns the current circuit state
+func (cb *CircuitBreakerProvider) State() string {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
+
+// allow reports whether a request may reach the provider, moving an open
+// circuit to half-open once the cooldown has passed
+func (cb *CircuitBreakerProvider) allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case circuitOpen:
+		if cb.now().Sub(cb.openedAt) < cb.cooldown {
+			return false
+		}
+		cb.state = circuitHalfOpen
+		cb.logger.Info("LLM circuit half-open, sending probe request")
+		return true
+	case circuitHalfOpen:
+		return false // Only the probe gets through
+	default:
+		return true
+	}
+}
+
+// record updates the circuit with the outcome of a request
+func (cb *CircuitBreakerProvider) record(err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if err != nil && !isProviderFailure(err) {
+		// Says nothing about the provider: let the next request probe again
+		if cb.state == circuitHalfOpen {
+			cb.state = circuitOpen
+		}
+		return
+	}
+
+	if err == nil {
+		if cb.state != circuitClosed {
+			cb.logger.Info("LLM circuit closed")
+		}
+		cb.state = circuitClosed
+		cb.failures = 0
+		return
+	}
+
+	cb.failures++
+	if cb.state == circuitHalfOpen || cb.failures >= cb.threshold {
+		cb.state = circuitOpen
+		cb.openedAt = cb.now()
+		cb.failures = 0
+		cb.logger.Warn("LLM circuit opened", "cooldown", cb.cooldown, "error", err)
+	}
+}
+
+// isProviderFailure reports whether err says something about the provider's
+// health. Rejections by the session store and requests the caller gave up
+// on don't count.
+func isProviderFailure(err error) bool {
+	return !errors.Is(err, memory.ErrSessionLimitExceeded) && !errors.Is(err, context.Canceled)
+}
+
+// Close closes the wrapped provider if it holds resources
+func (cb *CircuitBreakerProvider) Close() error {
+	if closer, ok := cb.provider.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
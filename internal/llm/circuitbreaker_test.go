@@ -0,0 +1,156 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/memory"
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+)
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	errUpstream := errors.New("upstream overloaded")
+	ok := &models.IntentResponse{Status: models.StatusReady}
+
+	type step struct {
+		advance   time.Duration // Clock movement before the call
+		result    error         // Provider outcome, ignored when the call is blocked
+		blocked   bool          // Expect ErrCircuitOpen without reaching the provider
+		wantState string
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "closed, open, half-open, closed",
+			steps: []step{
+				{result: errUpstream, wantState: circuitClosed},
+				{result: errUpstream, wantState: circuitClosed},
+				{result: errUpstream, wantState: circuitOpen},
+				{blocked: true, wantState: circuitOpen},
+				{advance: 30 * time.Second, blocked: true, wantState: circuitOpen},
+				{advance: 31 * time.Second, wantState: circuitClosed},
+				{wantState: circuitClosed},
+			},
+		},
+		{
+			name: "failed probe reopens",
+			steps: []step{
+				{result: errUpstream},
+				{result: errUpstream},
+				{result: errUpstream, wantState: circuitOpen},
+				{advance: time.Minute, result: errUpstream, wantState: circuitOpen},
+				{advance: 30 * time.Second, blocked: true, wantState: circuitOpen},
+				{advance: 31 * time.Second, wantState: circuitClosed},
+			},
+		},
+		{
+			name: "success resets the failure count",
+			steps: []step{
+				{result: errUpstream},
+				{result: errUpstream},
+				{wantState: circuitClosed},
+				{result: errUpstream},
+				{result: errUpstream, wantState: circuitClosed},
+			},
+		},
+		{
+			name: "session limit and cancellation don't count",
+			steps: []step{
+				{result: memory.ErrSessionLimitExceeded},
+				{result: context.Canceled},
+				{result: memory.ErrSessionLimitExceeded, wantState: circuitClosed},
+				{result: errUpstream, wantState: circuitClosed},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := NewMockProvider()
+			cb := NewCircuitBreakerProvider(provider, 3, time.Minute, slog.New(slog.NewTextHandler(io.Discard, nil)))
+			now := time.Now()
+			cb.now = func() time.Time { return now }
+
+			for i, s := range tt.steps {
+				now = now.Add(s.advance)
+				if !s.blocked {
+					if s.result != nil {
+						provider.Enqueue(nil, s.result)
+					} else {
+						provider.Enqueue(ok, nil)
+					}
+				}
+				calls := len(provider.Requests())
+
+				_, err := cb.AnalyzeIntent(context.Background(), &models.IntentRequest{SessionID: "s1"})
+				reached := len(provider.Requests()) > calls
+				switch {
+				case s.blocked:
+					if !errors.Is(err, ErrCircuitOpen) || reached {
+						t.Fatalf("step %d: error = %v, reached provider = %v, want ErrCircuitOpen without a call", i, err, reached)
+					}
+				case !errors.Is(err, s.result) || (s.result == nil && err != nil):
+					t.Fatalf("step %d: error = %v, want %v", i, err, s.result)
+				}
+				if s.wantState != "" && cb.State() != s.wantState {
+					t.Fatalf("step %d: state = %s, want %s", i, cb.State(), s.wantState)
+				}
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerHalfOpenAdmitsOneProbe(t *testing.T) {
+	release := make(chan struct{})
+	probing := make(chan struct{})
+	failing := true
+	provider := NewMockProvider()
+	provider.AnalyzeFunc = func(ctx context.Context, request *models.IntentRequest) (*models.IntentResponse, error) {
+		if failing {
+			return nil, errors.New("upstream overloaded")
+		}
+		close(probing)
+		<-release
+		return &models.IntentResponse{Status: models.StatusReady}, nil
+	}
+	cb := NewCircuitBreakerProvider(provider, 1, time.Minute, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	now := time.Now()
+	cb.now = func() time.Time { return now }
+
+	request := &models.IntentRequest{SessionID: "s1"}
+	cb.AnalyzeIntent(context.Background(), request)
+	if cb.State() != circuitOpen {
+		t.Fatalf("state = %s, want open", cb.State())
+	}
+
+	// Let the probe through and hold it in flight
+	failing = false
+	now = now.Add(time.Minute)
+	done := make(chan error)
+	go func() {
+		_, err := cb.AnalyzeIntent(context.Background(), request)
+		done <- err
+	}()
+	<-probing
+
+	if cb.State() != circuitHalfOpen {
+		t.Errorf("state during probe = %s, want half-open", cb.State())
+	}
+	if _, err := cb.AnalyzeIntent(context.Background(), request); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("second request during probe: error = %v, want ErrCircuitOpen", err)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("probe error = %v", err)
+	}
+	if cb.State() != circuitClosed {
+		t.Errorf("state after probe = %s, want closed", cb.State())
+	}
+}
@@ -0,0 +1,245 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/memory"
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+)
+
+// conversation holds the memory bookkeeping and tunables shared by every
+// provider, so each provider only has to implement its own API call
+type conversation struct {
+	provider      string // Provider name reported in response metadata
+	memoryManager *memory.Manager
+	settings
+}
+
+// newConversation returns a conversation with default generation settings
+func newConversation(provider string, memoryManager *memory.Manager) conversation {
+	return conversation{
+		provider:      provider,
+		memoryManager: memoryManager,
+		settings: settings{
+			maxTokens:   DefaultMaxTokens,
+			temperature: DefaultTemperature,
+			logger:      slog.Default(),
+		},
+	}
+}
+
+// turn carries per-request state from beginTurn to finishTurn
+type turn struct {
+	userID        string
+	messages      []memory.Message  // Conversation history, loaded once per turn
+	historyLoaded bool              // False when loading the history failed
+	history       string            // Formatted from messages
+	facts         map[string]string // Known facts from the session's memory slots
+	prompt        string            // Set by the provider once built
+	sampled       bool              // Capture this request to the debug sink
+	trimmed       bool              // History was cut short to meet the deadline
+	idle          time.Duration     // Time since the previous message, 0 if unknown or none
+}
+
+// beginTurn saves the user message and loads the history to prompt with
+func (c *conversation) beginTurn(ctx context.Context, request *models.IntentRequest) (*turn, error) {
+	userID := resolveUserID(request)
+
+	// Note how long the conversation was idle before this message
+	var idle time.Duration
+	if c.memoryManager.HistoryTimestamps() {
+		last, err := c.memoryManager.LastMessageTime(ctx, request.SessionID)
+		if err != nil {
+			c.logger.WarnContext(ctx, "failed to load last message time", "session_id", request.SessionID, "error", err)
+		} else if !last.IsZero() {
+			idle = time.Since(last)
+		}
+	}
+
+	// A provider falling back from another finds the messages already saved
+	if !userMessageSaved(ctx) {
+		// Store system preambles from the request history, such as tenant policy
+		if err := c.memoryManager.SaveSystemMessages(ctx, request.SessionID, userID, systemMessages(request.ConversationHistory)); err != nil {
+			if errors.Is(err, memory.ErrSessionLimitExceeded) {
+				return nil, err
+			}
+			c.logger.WarnContext(ctx, "failed to save system messages", "session_id", request.SessionID, "error", err)
+		}
+
+		// Step 1: Save user message to Redis
+		if err := c.memoryManager.SaveUserMessage(ctx, request.SessionID, userID, request.UserMessage); err != nil {
+			if errors.Is(err, memory.ErrSessionLimitExceeded) {
+				return nil, err
+			}
+			c.logger.WarnContext(ctx, "failed to save user message", "session_id", request.SessionID, "error", err)
+			// Continue anyway - we can still process without saving
+		}
+	}
+
+	// Step 2: Load conversation history from Redis, once, within a budget
+	// decided up front so a tight deadline leaves time for the LLM call
+	budget, trimmed := c.historyBudget(ctx, request.SessionID)
+	messages, err := c.memoryManager.GetTruncatedMessages(ctx, request.SessionID, budget)
+	historyLoaded := err == nil
+	if err != nil {
+		c.logger.WarnContext(ctx, "failed to load history", "session_id", request.SessionID, "error", err)
+	}
+	formattedHistory := c.memoryManager.FormatHistory(messages)
+
+	c.logger.DebugContext(ctx, "loaded conversation history", "session_id", request.SessionID, "history_bytes", len(formattedHistory))
+
+	// Step 3: Load facts remembered from earlier turns
+	facts, err := c.memoryManager.GetSlots(ctx, request.SessionID)
+	if err != nil {
+		c.logger.WarnContext(ctx, "failed to load memory slots", "session_id", request.SessionID, "error", err)
+	}
+
+	return &turn{
+		userID:        userID,
+		messages:      messages,
+		historyLoaded: historyLoaded,
+		history:       formattedHistory,
+		facts:         facts,
+		sampled:       c.debugSink != nil && rand.Float64() < c.debugSampleRate,
+		trimmed:       trimmed,
+		idle:          idle,
+	}, nil
+}
+
+// historyBudget returns the token budget for loading history. When less
+// than the deadline threshold remains it drops to deadlineHistoryTokens, so
+// a long history can't use up the time left for the LLM call.
+func (c *conversation) historyBudget(ctx context.Context, sessionID string) (int, bool) {
+	budget := c.historyTokenBudget
+	if c.historyDeadlineThreshold <= 0 {
+		return budget, false
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return budget, false
+	}
+	remaining := time.Until(deadline)
+	if remaining >= c.historyDeadlineThreshold {
+		return budget, false
+	}
+
+	if budget <= 0 || budget > deadlineHistoryTokens {
+		budget = deadlineHistoryTokens
+	}
+	c.logger.InfoContext(ctx, "trimming history to meet deadline", "session_id", sessionID,
+		"remaining", remaining.Round(time.Millisecond), "max_tokens", budget)
+	return budget, true
+}
+
+// systemMessages returns the system-role entries of a request's history
+func systemMessages(history []models.ConversationMessage) []string {
+	var messages []string
+	for _, msg := range history {
+		if msg.Role == "system" {
+			messages = append(messages, msg.Message)
+		}
+	}
+	return messages
+}
+
+// resolveUserID returns the caller's user ID, falling back to one derived
+// from the session ID for callers that don't send it
+func resolveUserID(request *models.IntentRequest) string {
+	if request.UserID != "" {
+		return request.UserID
+	}
+	return "user_" + request.SessionID
+}
+
+// resolveModel routes the request to a model, falling back to defaultModel
+func (c *conversation) resolveModel(defaultModel string, request *models.IntentRequest) string {
+	if c.modelRouter != nil {
+		if routed := c.modelRouter.Route(request.AvailableActions); routed != "" {
+			return routed
+		}
+	}
+	return defaultModel
+}
+
+// finishTurn records usage, parses the model output and saves the
+// assistant reply
+func (c *conversation) finishTurn(ctx context.Context, request *models.IntentRequest, t *turn, model, content string, usage Usage) (*models.IntentResponse, error) {
+	// Report usage for cost tracking
+	if c.usageRecorder != nil {
+		if err := c.usageRecorder.RecordUsage(ctx, request.Tenant, usage.InputTokens, usage.OutputTokens); err != nil {
+			c.logger.WarnContext(ctx, "failed to record usage", "session_id", request.SessionID, "error", err)
+		}
+	}
+	if err := c.memoryManager.AddUsage(ctx, request.SessionID, usage.InputTokens, usage.OutputTokens); err != nil {
+		c.logger.WarnContext(ctx, "failed to update session token totals", "session_id", request.SessionID, "error", err)
+	}
+
+	// Record exactly what the model returned before touching it
+	c.audit(request.SessionID, content)
+
+	// Parse the LLM response
+	intentResponse, err := parseIntentResponse(ctx, content, request.MessageLocale(), c.lenientJSON, c.logger.With("session_id", request.SessionID))
+	if t.sampled {
+		c.capture(ctx, request, t, model, content, intentResponse, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnparsableReply, err)
+	}
+
+	// Set session ID and record which model answered and what it cost. The
+	// provider may report a more specific model than the one requested.
+	if usage.Model != "" {
+		model = usage.Model
+	}
+	intentResponse.SessionID = request.SessionID
+	intentResponse.Model = model
+	intentResponse.InputTokens = usage.InputTokens
+	intentResponse.OutputTokens = usage.OutputTokens
+	intentResponse.Meta = &models.ResponseMeta{
+		PromptVersion: promptVersion(),
+		Provider:      c.provider,
+		Model:         model,
+		Temperature:   c.temperature,
+	}
+
+	// Save assistant response to Redis
+	if intentResponse.UserMessage != "" {
+		if err := c.memoryManager.SaveAssistantMessage(ctx, request.SessionID, t.userID, intentResponse.UserMessage); err != nil {
+			c.logger.WarnContext(ctx, "failed to save assistant message", "session_id", request.SessionID, "error", err)
+			// Continue anyway
+		}
+	}
+
+	return intentResponse, nil
+}
+
+// audit hands a raw model reply to the audit sink, if one is set
+func (c *conversation) audit(sessionID, content string) {
+	if c.auditSink != nil {
+		c.auditSink(sessionID, content)
+	}
+}
+
+// capture writes a sampled request to the debug sink
+func (c *conversation) capture(ctx context.Context, request *models.IntentRequest, t *turn, model, content string, response *models.IntentResponse, parseErr error) {
+	capture := DebugCapture{
+		Timestamp: time.Now(),
+		SessionID: request.SessionID,
+		Model:     model,
+		Prompt:    t.prompt,
+		RawOutput: content,
+		Response:  response,
+	}
+	if parseErr != nil {
+		capture.Error = parseErr.Error()
+	}
+
+	if err := c.debugSink.Capture(ctx, capture); err != nil {
+		c.logger.WarnContext(ctx, "failed to write debug capture", "session_id", request.SessionID, "error", err)
+	}
+}
@@ -0,0 +1,64 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+)
+
+// DebugCapture is the full record of a sampled request
+type DebugCapture struct {
+	Timestamp time.Time              `json:"timestamp"`
+	SessionID string                 `json:"session_id"`
+	Model     string                 `json:"model"`
+	Prompt    string                 `json:"prompt"`
+	RawOutput string                 `json:"raw_output"`
+	Response  *models.IntentResponse `json:"response,omitempty"`
+	Error     string                 `json:"error,omitempty"`
+}
+
+// DebugSink receives captures for sampled requests
+type DebugSink interface {
+	Capture(ctx context.Context, capture DebugCapture) error
+}
+
+// FileDebugSink appends captures to a file as JSON lines
+type FileDebugSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileDebugSink opens (or creates) the capture file for appending
+func NewFileDebugSink(path string) (*FileDebugSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open debug sink: %w", err)
+	}
+	return &FileDebugSink{file: file}, nil
+}
+
+// Capture writes one capture as a single JSON line
+func (s *FileDebugSink) Capture(ctx context.Context, capture DebugCapture) error {
+	data, err := json.Marshal(capture)
+	if err != nil {
+		return fmt.Errorf("failed to marshal capture: %w", err)
+	}
+	data = append(data, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.file.Write(data); err != nil {
+		return fmt.Errorf("failed to write capture: %w", err)
+	}
+	return nil
+}
+
+// Close closes the capture file
+func (s *FileDebugSink) Close() error {
+	return s.file.Close()
+}
@@ -0,0 +1,101 @@
+package llm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+)
+
+func TestDebugSampling(t *testing.T) {
+	const requests = 500
+	tests := []struct {
+		name string
+		rate float64
+	}{
+		{name: "disabled", rate: 0},
+		{name: "ten percent", rate: 0.1},
+		{name: "half", rate: 0.5},
+		{name: "everything", rate: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeAnthropic(t, readyReply)
+			sink := &recordingSink{}
+			provider, _ := newTestAnthropic(t, server, WithDebugSampling(tt.rate, sink))
+
+			for i := range requests {
+				request := &models.IntentRequest{SessionID: fmt.Sprintf("s%d", i), UserMessage: "purge the cache"}
+				if _, err := provider.AnalyzeIntent(context.Background(), request); err != nil {
+					t.Fatalf("AnalyzeIntent() error = %v", err)
+				}
+			}
+
+			// Allow five standard deviations of the binomial distribution
+			want := tt.rate * requests
+			slack := 5 * math.Sqrt(requests*tt.rate*(1-tt.rate))
+			if got := float64(len(sink.captures)); math.Abs(got-want) > slack {
+				t.Errorf("captured %v of %d requests, want %v ± %.0f", got, requests, want, slack)
+			}
+
+			for _, capture := range sink.captures {
+				if capture.Prompt == "" || capture.RawOutput != readyReply || capture.Response == nil || capture.Response.Status != models.StatusReady {
+					t.Fatalf("capture = %+v, want the prompt, raw output and parsed response", capture)
+				}
+			}
+		})
+	}
+}
+
+func TestFileDebugSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "captures.jsonl")
+	captures := []DebugCapture{
+		{Timestamp: time.Now().UTC(), SessionID: "s1", Model: "claude-test", Prompt: "p1", RawOutput: readyReply},
+		{Timestamp: time.Now().UTC(), SessionID: "s2", Model: "claude-test", Prompt: "p2", RawOutput: "prose", Error: "no valid JSON found in response"},
+	}
+
+	// Captures are appended across reopens
+	for _, capture := range captures {
+		sink, err := NewFileDebugSink(path)
+		if err != nil {
+			t.Fatalf("NewFileDebugSink() error = %v", err)
+		}
+		if err := sink.Capture(context.Background(), capture); err != nil {
+			t.Fatalf("Capture() error = %v", err)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	var got []DebugCapture
+	for scanner.Scan() {
+		var capture DebugCapture
+		if err := json.Unmarshal(scanner.Bytes(), &capture); err != nil {
+			t.Fatalf("line %q is not a capture: %v", scanner.Text(), err)
+		}
+		got = append(got, capture)
+	}
+	if len(got) != len(captures) {
+		t.Fatalf("file has %d captures, want %d", len(got), len(captures))
+	}
+	for i := range got {
+		if got[i].SessionID != captures[i].SessionID || got[i].RawOutput != captures[i].RawOutput || got[i].Error != captures[i].Error {
+			t.Errorf("capture %d = %+v, want %+v", i, got[i], captures[i])
+		}
+	}
+}
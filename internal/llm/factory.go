@@ -0,0 +1,133 @@
+package llm
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/config"
+	"github.com/avvvet/cdnbuddy-intent/internal/memory"
+)
+
+// Supported values for config.LLMProvider
+const (
+	ProviderAnthropic = "anthropic"
+	ProviderOpenAI    = "openai"
+	ProviderOllama    = "ollama"
+	ProviderWeighted  = "weighted" // Split traffic by LLM_WEIGHTS
+)
+
+// SupportedProviders lists the accepted LLM_PROVIDER values
+var SupportedProviders = []string{ProviderAnthropic, ProviderOpenAI, ProviderOllama, ProviderWeighted}
+
+// NewProvider builds the provider selected by cfg.LLMProvider. Options that
+// can't come from config, such as a usage recorder, are applied on top of
+// the config-derived ones. The provider is wrapped in a circuit breaker
+// unless LLM_BREAKER_THRESHOLD is 0. With LLM_FALLBACK_PROVIDERS each
+// fallback gets its own breaker and they are tried in order behind the
+// primary.
+func NewProvider(cfg *config.Config, mem *memory.Manager, opts ...Option) (LLMProvider, error) {
+	var s settings
+	for _, opt := range opts {
+		opt(&s)
+	}
+
+	var provider LLMProvider
+	var err error
+	if cfg.LLMProvider == ProviderWeighted {
+		provider, err = newWeightedProvider(cfg, mem, opts)
+	} else {
+		provider, err = newSingleProvider(cfg.LLMProvider, cfg, mem, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.LLMFallbackProviders) == 0 {
+		return withCircuitBreaker(provider, cfg, s.logger), nil
+	}
+
+	chain := []NamedProvider{{Name: cfg.LLMProvider, Provider: withCircuitBreaker(provider, cfg, s.logger)}}
+	for _, name := range cfg.LLMFallbackProviders {
+		if name == ProviderWeighted {
+			return nil, fmt.Errorf("LLM_FALLBACK_PROVIDERS cannot include %q", ProviderWeighted)
+		}
+		fallback, err := newSingleProvider(name, cfg, mem, opts)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, NamedProvider{Name: name, Provider: withCircuitBreaker(fallback, cfg, s.logger)})
+	}
+	return NewFallbackProvider(chain, s.logger)
+}
+
+// withCircuitBreaker wraps provider in a circuit breaker unless
+// LLM_BREAKER_THRESHOLD is 0
+func withCircuitBreaker(provider LLMProvider, cfg *config.Config, logger *slog.Logger) LLMProvider {
+	if cfg.LLMBreakerThreshold <= 0 {
+		return provider
+	}
+	return NewCircuitBreakerProvider(provider, cfg.LLMBreakerThreshold, cfg.LLMBreakerCooldown, logger)
+}
+
+func newSingleProvider(name string, cfg *config.Config, mem *memory.Manager, extra []Option) (LLMProvider, error) {
+	opts := []Option{
+		WithHistoryDeadlineThreshold(cfg.HistoryDeadlineThreshold),
+		WithPersona(cfg.AssistantPersona),
+		WithLenientJSON(cfg.LenientJSON),
+		WithMaxTokens(cfg.LLMMaxTokens),
+		WithTemperature(cfg.LLMTemperature),
+		WithHistoryTokenBudget(cfg.HistoryTokenBudget),
+	}
+
+	switch name {
+	case ProviderAnthropic:
+		// Routing maps to Claude model names, so it only applies here
+		if len(cfg.ModelRouting) > 0 {
+			opts = append(opts, WithModelRouter(NewModelRouter(cfg.ModelRouting, cfg.ModelRoutingMaxSimple)))
+		}
+		opts = append(opts, WithMaxRetries(cfg.AnthropicMaxRetries), WithKeepAlive(cfg.AnthropicKeepAlive), WithBaseURL(cfg.AnthropicBaseURL))
+		return NewAnthropicProvider(cfg.AnthropicAPIKey, cfg.AnthropicModel, cfg.AnthropicTimeout, mem, append(opts, extra...)...), nil
+	case ProviderOpenAI:
+		opts = append(opts, WithBaseURL(cfg.OpenAIBaseURL))
+		return NewOpenAIProvider(cfg.OpenAIAPIKey, cfg.OpenAIModel, cfg.OpenAITimeout, mem, append(opts, extra...)...), nil
+	case ProviderOllama:
+		return NewOllamaProvider(cfg.OllamaURL, cfg.OllamaModel, cfg.OllamaTimeout, mem, append(opts, extra...)...), nil
+	default:
+		return nil, fmt.Errorf("unknown LLM provider %q (supported: %s)", name, strings.Join(SupportedProviders, ", "))
+	}
+}
+
+// newWeightedProvider builds one provider per LLM_WEIGHTS entry behind a
+// LoadBalancingProvider
+func newWeightedProvider(cfg *config.Config, mem *memory.Manager, extra []Option) (LLMProvider, error) {
+	if len(cfg.LLMWeights) == 0 {
+		return nil, fmt.Errorf("LLM_PROVIDER=%s requires LLM_WEIGHTS", ProviderWeighted)
+	}
+
+	// Sort names so the weight ranges, and therefore session stickiness,
+	// are stable across restarts
+	names := make([]string, 0, len(cfg.LLMWeights))
+	for name := range cfg.LLMWeights {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	backends := make([]WeightedProvider, 0, len(names))
+	for _, name := range names {
+		if name == ProviderWeighted {
+			return nil, fmt.Errorf("LLM_WEIGHTS cannot include %q", ProviderWeighted)
+		}
+		provider, err := newSingleProvider(name, cfg, mem, extra)
+		if err != nil {
+			return nil, err
+		}
+		backends = append(backends, WeightedProvider{
+			Name:     name,
+			Provider: provider,
+			Weight:   cfg.LLMWeights[name],
+		})
+	}
+
+	return NewLoadBalancingProvider(backends)
+}
@@ -0,0 +1,119 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+)
+
+// NamedProvider pairs a provider with the name it is logged under
+type NamedProvider struct {
+	Name     string
+	Provider LLMProvider
+}
+
+// FallbackProvider tries providers in order until one returns a response.
+// Each provider saves the user message when it starts a turn, so later
+// attempts are told the message is already stored; the assistant reply is
+// only saved by the provider that succeeds.
+type FallbackProvider struct {
+	providers []NamedProvider
+	logger    *slog.Logger
+}
+
+// NewFallbackProvider creates a provider that falls back through providers
+// in order
+func NewFallbackProvider(providers []NamedProvider, logger *slog.Logger) (*FallbackProvider, error) {
+	if len(providers) == 0 {
+		return nil, fmt.Errorf("at least one provider is required")
+	}
+	for _, p := range providers {
+		if p.Provider == nil {
+			return nil, fmt.Errorf("provider %q is nil", p.Name)
+		}
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &FallbackProvider{
+		providers: providers,
+		logger:    logger,
+	}, nil
+}
+
+// AnalyzeIntent implements the LLMProvider interface
+func (f *FallbackProvider) AnalyzeIntent(ctx context.Context, request *models.IntentRequest) (*models.IntentResponse, error) {
+	return f.analyze(ctx, request, func(ctx context.Context, provider LLMProvider) (*models.IntentResponse, error) {
+		return provider.AnalyzeIntent(ctx, request)
+	})
+}
+
+// AnalyzeIntentStream implements the StreamingProvider interface. Chunks
+// from a provider that fails part way are not withdrawn; the final
+// response is authoritative.
+func (f *FallbackProvider) AnalyzeIntentStream(ctx context.Context, request *models.IntentRequest, onChunk ChunkFunc) (*models.IntentResponse, error) {
+	return f.analyze(ctx, request, func(ctx context.Context, provider LLMProvider) (*models.IntentResponse, error) {
+		return AnalyzeIntentStream(ctx, provider, request, onChunk)
+	})
+}
+
+// analyze calls each provider in turn. Errors that say nothing about the
+// provider, such as the session limit or a cancelled request, are returned
+// without trying the next one.
+func (f *FallbackProvider) analyze(ctx context.Context, request *models.IntentRequest, call func(context.Context, LLMProvider) (*models.IntentResponse, error)) (*models.IntentResponse, error) {
+	var errs []error
+	for i, p := range f.providers {
+		if i > 0 {
+			ctx = withUserMessageSaved(ctx)
+		}
+
+		response, err := call(ctx, p.Provider)
+		if err == nil {
+			if i > 0 {
+				f.logger.InfoContext(ctx, "request served by fallback provider", "session_id", request.SessionID, "provider", p.Name)
+			}
+			return response, nil
+		}
+		if !isProviderFailure(err) {
+			return nil, err
+		}
+
+		errs = append(errs, fmt.Errorf("%s: %w", p.Name, err))
+		if ctx.Err() != nil {
+			break // No time left for another provider
+		}
+		if i < len(f.providers)-1 {
+			f.logger.WarnContext(ctx, "LLM provider failed, trying the next one", "session_id", request.SessionID,
+				"provider", p.Name, "next", f.providers[i+1].Name, "error", err)
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Close closes every provider that holds resources
+func (f *FallbackProvider) Close() error {
+	var errs []error
+	for _, p := range f.providers {
+		if closer, ok := p.Provider.(io.Closer); ok {
+			errs = append(errs, closer.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// userMessageSavedKey marks a context whose user message an earlier
+// provider already stored
+type userMessageSavedKey struct{}
+
+func withUserMessageSaved(ctx context.Context) context.Context {
+	return context.WithValue(ctx, userMessageSavedKey{}, true)
+}
+
+func userMessageSaved(ctx context.Context) bool {
+	saved, _ := ctx.Value(userMessageSavedKey{}).(bool)
+	return saved
+}
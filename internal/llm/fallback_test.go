@@ -0,0 +1,90 @@
+package llm
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+)
+
+func TestFallbackProvider(t *testing.T) {
+	tests := []struct {
+		name             string
+		primaryFailures  []fakeFailure
+		fallbackFailures []fakeFailure
+		wantErr          bool
+		wantFallback     int      // Calls to the fallback API
+		wantRoles        []string // Stored conversation after the turn
+	}{
+		{name: "primary answers", wantRoles: []string{"user", "assistant"}},
+		{name: "primary fails", primaryFailures: []fakeFailure{{status: http.StatusInternalServerError}}, wantFallback: 1, wantRoles: []string{"user", "assistant"}},
+		{
+			name:             "both fail",
+			primaryFailures:  []fakeFailure{{status: http.StatusInternalServerError}},
+			fallbackFailures: []fakeFailure{{status: 529}},
+			wantErr:          true,
+			wantFallback:     1,
+			wantRoles:        []string{"user"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primaryServer := newFakeAnthropic(t, readyReply)
+			primaryServer.failures = tt.primaryFailures
+			fallbackServer := newFakeAnthropic(t, readyReply)
+			fallbackServer.failures = tt.fallbackFailures
+
+			// Both providers share the conversation store, as NewProvider sets up
+			primary, manager := newTestAnthropic(t, primaryServer)
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			fallback := NewAnthropicProvider("test-key", "claude-test", 5*time.Second, manager,
+				WithBaseURL(fallbackServer.URL), WithLogger(logger), WithMaxRetries(0))
+			t.Cleanup(func() { fallback.Close() })
+
+			provider, err := NewFallbackProvider([]NamedProvider{
+				{Name: "primary", Provider: primary},
+				{Name: "fallback", Provider: fallback},
+			}, logger)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx := context.Background()
+			response, err := provider.AnalyzeIntent(ctx, &models.IntentRequest{SessionID: "s1", UserMessage: "purge the cache"})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("AnalyzeIntent() error = nil, want an error")
+				}
+			} else if err != nil {
+				t.Fatalf("AnalyzeIntent() error = %v", err)
+			} else if response.Status != models.StatusReady {
+				t.Errorf("status = %s, want %s", response.Status, models.StatusReady)
+			}
+
+			if got := len(primaryServer.Requests()); got != 1 {
+				t.Errorf("primary called %d times, want 1", got)
+			}
+			if got := len(fallbackServer.Requests()); got != tt.wantFallback {
+				t.Errorf("fallback called %d times, want %d", got, tt.wantFallback)
+			}
+
+			messages, err := manager.GetMessages(ctx, "s1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var roles []string
+			for _, msg := range messages {
+				roles = append(roles, msg.Role)
+			}
+			if !slices.Equal(roles, tt.wantRoles) {
+				t.Errorf("stored roles = %v, want %v", roles, tt.wantRoles)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package llm
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// newPooledTransport returns a transport tuned to keep idle connections to
+// a single API host around. With keepAlive set, idle connections outlive
+// the ping interval so pings can keep them warm.
+func newPooledTransport(keepAlive time.Duration) *http.Transport {
+	idleTimeout := 90 * time.Second
+	if keepAlive > 0 && 2*keepAlive > idleTimeout {
+		idleTimeout = 2 * keepAlive
+	}
+
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   16,
+		IdleConnTimeout:       idleTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// keepWarm sends a lightweight HEAD request to url every interval so a
+// pooled connection stays open between bursts. It returns when stop is
+// closed.
+func keepWarm(client *http.Client, url string, interval time.Duration, stop <-chan struct{}, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := ping(client, url); err != nil {
+				logger.Warn("keep-alive ping failed", "url", url, "error", err)
+			}
+		}
+	}
+}
+
+// ping issues a HEAD request and discards the response. Any HTTP status
+// counts as success: only the connection matters.
+func ping(client *http.Client, url string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
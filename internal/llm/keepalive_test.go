@@ -0,0 +1,134 @@
+package llm
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/memory"
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+)
+
+// countingServer serves handler and counts the connections clients open
+// and the keep-alive pings they send
+type countingServer struct {
+	*httptest.Server
+	conns atomic.Int64
+	pings atomic.Int64
+}
+
+func newCountingServer(t *testing.T, handler http.Handler) *countingServer {
+	t.Helper()
+	s := &countingServer{}
+	s.Server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			s.pings.Add(1)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	s.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			s.conns.Add(1)
+		}
+	}
+	s.Start()
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestAnthropicConnectionReuse(t *testing.T) {
+	tests := []struct {
+		name      string
+		keepAlive time.Duration
+		idle      time.Duration // Pause between the two bursts of requests
+		wantConns int64         // Most connections opened for the ten requests
+		wantPings bool
+	}{
+		{name: "sequential requests", wantConns: 1},
+		// A ping that overlaps a request needs a second connection
+		{name: "keep-alive pings between bursts", keepAlive: 20 * time.Millisecond, idle: 150 * time.Millisecond, wantConns: 2, wantPings: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newCountingServer(t, newFakeAnthropic(t, readyReply).Config.Handler)
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			manager := memory.NewManager(memory.NewInMemoryStore(time.Hour), memory.WithLogger(logger))
+			t.Cleanup(func() { manager.Close() })
+			provider := NewAnthropicProvider("test-key", "claude-test", 5*time.Second, manager,
+				WithBaseURL(server.URL), WithLogger(logger), WithMaxRetries(0), WithKeepAlive(tt.keepAlive))
+
+			burst := func() {
+				for range 5 {
+					if _, err := provider.AnalyzeIntent(context.Background(), &models.IntentRequest{SessionID: "s1", UserMessage: "purge the cache"}); err != nil {
+						t.Fatalf("AnalyzeIntent() error = %v", err)
+					}
+				}
+			}
+			burst()
+			time.Sleep(tt.idle)
+			burst()
+
+			if got := server.conns.Load(); got > tt.wantConns {
+				t.Errorf("opened %d connections, want at most %d", got, tt.wantConns)
+			}
+			if pinged := server.pings.Load() > 0; pinged != tt.wantPings {
+				t.Errorf("pinged = %v, want %v", pinged, tt.wantPings)
+			}
+
+			// Close stops the pings
+			provider.Close()
+			time.Sleep(2 * tt.keepAlive)
+			pings := server.pings.Load()
+			time.Sleep(5 * tt.keepAlive)
+			if got := server.pings.Load(); got != pings {
+				t.Errorf("%d pings after Close, want none", got-pings)
+			}
+		})
+	}
+}
+
+// BenchmarkAnthropicTLSConnections compares requests over the pooled
+// transport with requests that each pay for a new TLS handshake
+func BenchmarkAnthropicTLSConnections(b *testing.B) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+	}))
+	defer server.Close()
+	tlsConfig := server.Client().Transport.(*http.Transport).TLSClientConfig
+
+	for _, bm := range []struct {
+		name   string
+		pooled bool
+	}{
+		{name: "pooled", pooled: true},
+		{name: "new connection each time"},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			transport := newPooledTransport(0)
+			transport.TLSClientConfig = tlsConfig.Clone()
+			transport.DisableKeepAlives = !bm.pooled
+			// Session resumption would hide the handshake cost
+			transport.TLSClientConfig.ClientSessionCache = nil
+			transport.TLSClientConfig.SessionTicketsDisabled = true
+			client := &http.Client{Transport: transport}
+			defer transport.CloseIdleConnections()
+
+			for b.Loop() {
+				resp, err := client.Post(server.URL, "application/json", nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+)
+
+// WeightedProvider pairs a provider with its share of the traffic
+type WeightedProvider struct {
+	Name     string
+	Provider LLMProvider
+	Weight   int
+}
+
+// LoadBalancingProvider splits traffic across providers by weight.
+// Routing is sticky per session: the same session always lands on the same
+// provider so its conversation history stays coherent.
+type LoadBalancingProvider struct {
+	backends    []WeightedProvider
+	totalWeight int
+}
+
+// NewLoadBalancingProvider creates a provider that routes between backends
+// in proportion to their weights. Backends with a zero weight never receive
+// traffic.
+func NewLoadBalancingProvider(backends []WeightedProvider) (*LoadBalancingProvider, error) {
+	total := 0
+	for _, b := range backends {
+		if b.Provider == nil {
+			return nil, fmt.Errorf("provider %q is nil", b.Name)
+		}
+		if b.Weight < 0 {
+			return nil, fmt.Errorf("provider %q has negative weight %d", b.Name, b.Weight)
+		}
+		total += b.Weight
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("at least one provider must have a positive weight")
+	}
+
+	return &LoadBalancingProvider{
+		backends:    backends,
+		totalWeight: total,
+	}, nil
+}
+
+// AnalyzeIntent implements the LLMProvider interface
+func (lb *LoadBalancingProvider) AnalyzeIntent(ctx context.Context, request *models.IntentRequest) (*models.IntentResponse, error) {
+	return lb.pick(request.SessionID).Provider.AnalyzeIntent(ctx, request)
+}
+
+// AnalyzeIntentStream implements the StreamingProvider interface, streaming
+// from the selected backend when it supports it
+func (lb *LoadBalancingProvider) AnalyzeIntentStream(ctx context.Context, request *models.IntentRequest, onChunk ChunkFunc) (*models.IntentResponse, error) {
+	return AnalyzeIntentStream(ctx, lb.pick(request.SessionID).Provider, request, onChunk)
+}
+
+// pick hashes the session ID onto the weight range so a session is always
+// routed to the same backend
+func (lb *LoadBalancingProvider) pick(sessionID string) WeightedProvider {
+	h := fnv.New32a()
+	h.Write([]byte(sessionID))
+	slot := int(h.Sum32() % uint32(lb.totalWeight))
+
+	for _, b := range lb.backends {
+		if slot < b.Weight {
+			return b
+		}
+		slot -= b.Weight
+	}
+	return lb.backends[len(lb.backends)-1]
+}
+
+// Close closes every backend that holds resources
+func (lb *LoadBalancingProvider) Close() error {
+	var errs []error
+	for _, b := range lb.backends {
+		if closer, ok := b.Provider.(io.Closer); ok {
+			errs = append(errs, closer.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
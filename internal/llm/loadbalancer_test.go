@@ -0,0 +1,165 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+)
+
+// namedProvider answers every request with its own name as the model
+func namedProvider(name string) *MockProvider {
+	m := NewMockProvider()
+	m.AnalyzeFunc = func(ctx context.Context, request *models.IntentRequest) (*models.IntentResponse, error) {
+		return &models.IntentResponse{
+			SessionID:   request.SessionID,
+			Status:      models.StatusReady,
+			UserMessage: "answered by " + name,
+			Model:       name,
+		}, nil
+	}
+	return m
+}
+
+// chunkingProvider streams its reply in fixed chunks
+type chunkingProvider struct {
+	*MockProvider
+	chunks []string
+}
+
+func (c *chunkingProvider) AnalyzeIntentStream(ctx context.Context, request *models.IntentRequest, onChunk ChunkFunc) (*models.IntentResponse, error) {
+	for _, chunk := range c.chunks {
+		onChunk(chunk)
+	}
+	return c.AnalyzeIntent(ctx, request)
+}
+
+func TestLoadBalancingProviderDistribution(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights map[string]int
+	}{
+		{name: "even split", weights: map[string]int{"a": 1, "b": 1}},
+		{name: "weighted split", weights: map[string]int{"a": 3, "b": 1}},
+		{name: "zero weight gets nothing", weights: map[string]int{"a": 1, "b": 0}},
+	}
+
+	const sessions = 4000
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var backends []WeightedProvider
+			total := 0
+			for _, name := range []string{"a", "b"} {
+				backends = append(backends, WeightedProvider{Name: name, Provider: namedProvider(name), Weight: tt.weights[name]})
+				total += tt.weights[name]
+			}
+			lb, err := NewLoadBalancingProvider(backends)
+			if err != nil {
+				t.Fatalf("NewLoadBalancingProvider() error = %v", err)
+			}
+
+			counts := map[string]int{}
+			for i := range sessions {
+				response, err := lb.AnalyzeIntent(context.Background(), &models.IntentRequest{SessionID: fmt.Sprintf("session-%d", i)})
+				if err != nil {
+					t.Fatalf("AnalyzeIntent() error = %v", err)
+				}
+				counts[response.Model]++
+			}
+
+			for name, weight := range tt.weights {
+				want := float64(sessions) * float64(weight) / float64(total)
+				got := float64(counts[name])
+				if weight == 0 && got != 0 {
+					t.Errorf("backend %s got %v requests with zero weight", name, got)
+				}
+				if got < want*0.9 || got > want*1.1 {
+					t.Errorf("backend %s got %v requests, want about %v", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadBalancingProviderSticky(t *testing.T) {
+	lb, err := NewLoadBalancingProvider([]WeightedProvider{
+		{Name: "a", Provider: namedProvider("a"), Weight: 1},
+		{Name: "b", Provider: namedProvider("b"), Weight: 1},
+		{Name: "c", Provider: namedProvider("c"), Weight: 1},
+	})
+	if err != nil {
+		t.Fatalf("NewLoadBalancingProvider() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		sessionID string
+	}{
+		{name: "short id", sessionID: "s1"},
+		{name: "uuid id", sessionID: "0b5c3f1e-9a4d-4c2b-8f1e-2d7a6c9b0e11"},
+		{name: "empty id", sessionID: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := &models.IntentRequest{SessionID: tt.sessionID}
+			first, err := lb.AnalyzeIntent(context.Background(), request)
+			if err != nil {
+				t.Fatalf("AnalyzeIntent() error = %v", err)
+			}
+			for range 20 {
+				response, err := lb.AnalyzeIntentStream(context.Background(), request, func(string) {})
+				if err != nil {
+					t.Fatalf("AnalyzeIntentStream() error = %v", err)
+				}
+				if response.Model != first.Model {
+					t.Fatalf("session moved from %s to %s", first.Model, response.Model)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadBalancingProviderStream(t *testing.T) {
+	tests := []struct {
+		name       string
+		provider   LLMProvider
+		wantChunks []string
+	}{
+		{
+			name:       "streaming backend",
+			provider:   &chunkingProvider{MockProvider: namedProvider("stream"), chunks: []string{"answered ", "by ", "stream"}},
+			wantChunks: []string{"answered ", "by ", "stream"},
+		},
+		{
+			name:       "non-streaming backend",
+			provider:   namedProvider("plain"),
+			wantChunks: []string{"answered by plain"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lb, err := NewLoadBalancingProvider([]WeightedProvider{{Name: tt.name, Provider: tt.provider, Weight: 1}})
+			if err != nil {
+				t.Fatalf("NewLoadBalancingProvider() error = %v", err)
+			}
+
+			var chunks []string
+			response, err := lb.AnalyzeIntentStream(context.Background(), &models.IntentRequest{SessionID: "s1"}, func(delta string) {
+				chunks = append(chunks, delta)
+			})
+			if err != nil {
+				t.Fatalf("AnalyzeIntentStream() error = %v", err)
+			}
+			if strings.Join(chunks, "|") != strings.Join(tt.wantChunks, "|") {
+				t.Errorf("chunks = %q, want %q", chunks, tt.wantChunks)
+			}
+			if response.UserMessage != strings.Join(chunks, "") {
+				t.Errorf("user_message = %q, want %q", response.UserMessage, strings.Join(chunks, ""))
+			}
+		})
+	}
+}
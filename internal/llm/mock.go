@@ -0,0 +1,86 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"sync"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+)
+
+// ErrNoMockResponses is returned by MockProvider when its queue is empty
+// and no AnalyzeFunc is set
+var ErrNoMockResponses = errors.New("mock provider has no responses left")
+
+// MockResult is a canned MockProvider outcome
+type MockResult struct {
+	Response *models.IntentResponse
+	Err      error
+}
+
+// MockProvider is a deterministic LLMProvider for tests. AnalyzeFunc, when
+// set, answers every request; otherwise queued results are returned in
+// order. Requests are recorded so tests can assert on what was sent.
+type MockProvider struct {
+	AnalyzeFunc func(ctx context.Context, request *models.IntentRequest) (*models.IntentResponse, error)
+
+	mu       sync.Mutex
+	results  []MockResult
+	requests []*models.IntentRequest
+}
+
+// NewMockProvider creates a mock that returns responses in order
+func NewMockProvider(responses ...*models.IntentResponse) *MockProvider {
+	m := &MockProvider{}
+	for _, response := range responses {
+		m.Enqueue(response, nil)
+	}
+	return m
+}
+
+// Enqueue adds a canned result to the end of the queue
+func (m *MockProvider) Enqueue(response *models.IntentResponse, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.results = append(m.results, MockResult{Response: response, Err: err})
+}
+
+// AnalyzeIntent implements the LLMProvider interface
+func (m *MockProvider) AnalyzeIntent(ctx context.Context, request *models.IntentRequest) (*models.IntentResponse, error) {
+	m.mu.Lock()
+	m.requests = append(m.requests, request)
+	analyze := m.AnalyzeFunc
+	var result MockResult
+	ok := false
+	if analyze == nil && len(m.results) > 0 {
+		result, m.results = m.results[0], m.results[1:]
+		ok = true
+	}
+	m.mu.Unlock()
+
+	if analyze != nil {
+		return analyze(ctx, request)
+	}
+	if !ok {
+		return nil, ErrNoMockResponses
+	}
+	if result.Response == nil {
+		return nil, result.Err
+	}
+
+	// Hand out a copy so callers can modify it freely
+	response := *result.Response
+	if response.SessionID == "" {
+		response.SessionID = request.SessionID
+	}
+	response.Parameters = maps.Clone(result.Response.Parameters)
+	return &response, result.Err
+}
+
+// Requests returns the requests received so far
+func (m *MockProvider) Requests() []*models.IntentRequest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*models.IntentRequest{}, m.requests...)
+}
@@ -0,0 +1,169 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/memory"
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+	"github.com/avvvet/cdnbuddy-intent/internal/prompts"
+)
+
+// OllamaProvider talks to a local Ollama server so conversations never
+// leave the deployment
+type OllamaProvider struct {
+	url     string
+	model   string
+	timeout time.Duration
+	client  *http.Client
+	conversation
+}
+
+// OllamaRequest represents the request structure for Ollama's /api/chat
+type OllamaRequest struct {
+	Model    string          `json:"model"`
+	Messages []OllamaMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+	Options  OllamaOptions   `json:"options"`
+}
+
+// OllamaMessage represents a message in the conversation
+type OllamaMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// OllamaOptions holds the sampling parameters Ollama accepts
+type OllamaOptions struct {
+	Temperature float64 `json:"temperature"`
+	NumPredict  int     `json:"num_predict"`
+}
+
+// OllamaResponse represents a non-streaming response from /api/chat
+type OllamaResponse struct {
+	Model           string        `json:"model"`
+	Message         OllamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+}
+
+// OllamaError represents an error response from Ollama
+type OllamaError struct {
+	Error string `json:"error"`
+}
+
+// NewOllamaProvider mirrors NewAnthropicProvider, taking the Ollama server
+// URL (e.g. http://localhost:11434) in place of an API key
+func NewOllamaProvider(url, model string, timeout time.Duration, memoryManager *memory.Manager, opts ...Option) *OllamaProvider {
+	o := &OllamaProvider{
+		url:          url,
+		model:        model,
+		timeout:      timeout,
+		conversation: newConversation(ProviderOllama, memoryManager),
+		client: &http.Client{
+			Timeout: timeout,
+		},
+	}
+	for _, opt := range opts {
+		opt(&o.settings)
+	}
+	return o
+}
+
+// AnalyzeIntent implements the LLMProvider interface
+func (o *OllamaProvider) AnalyzeIntent(ctx context.Context, request *models.IntentRequest) (*models.IntentResponse, error) {
+	// Save the user message and load history
+	t, err := o.beginTurn(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	// Build the same prompt the other providers use
+	t.prompt = prompts.BuildIntentPrompt(request, t.history, t.facts, o.persona)
+
+	model := o.resolveModel(o.model, request)
+	content, usage, err := o.complete(ctx, request.SessionID, model, t.prompt)
+	if err != nil {
+		return nil, err
+	}
+
+	// Local models often wrap the JSON in prose; finishTurn extracts it
+	return o.finishTurn(ctx, request, t, model, content, usage)
+}
+
+// complete sends the prompt to /api/chat and returns the reply content
+func (o *OllamaProvider) complete(ctx context.Context, sessionID, model, prompt string) (string, Usage, error) {
+	ollamaReq := OllamaRequest{
+		Model: model,
+		Messages: []OllamaMessage{
+			{
+				Role:    "user",
+				Content: prompt,
+			},
+		},
+		Stream: false,
+		Options: OllamaOptions{
+			Temperature: o.temperature,
+			NumPredict:  o.maxTokens,
+		},
+	}
+
+	reqBody, err := json.Marshal(ollamaReq)
+	if err != nil {
+		return "", Usage{}, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	o.logger.InfoContext(ctx, "calling Ollama", "session_id", sessionID, "model", model)
+
+	url := strings.TrimRight(o.url, "/") + "/api/chat"
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return "", Usage{}, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := o.client.Do(httpReq)
+	if err != nil {
+		return "", Usage{}, fmt.Errorf("failed to make HTTP request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", Usage{}, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	// Handle non-200 responses
+	if resp.StatusCode != http.StatusOK {
+		o.logger.ErrorContext(ctx, "Ollama error response", "session_id", sessionID, "status", resp.StatusCode, "body", string(body))
+
+		var ollamaErr OllamaError
+		if err := json.Unmarshal(body, &ollamaErr); err != nil || ollamaErr.Error == "" {
+			return "", Usage{}, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		}
+		return "", Usage{}, fmt.Errorf("ollama API error: %s", ollamaErr.Error)
+	}
+
+	var ollamaResp OllamaResponse
+	if err := json.Unmarshal(body, &ollamaResp); err != nil {
+		return "", Usage{}, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	content := ollamaResp.Message.Content
+
+	o.logger.InfoContext(ctx, "Ollama response received", "session_id", sessionID, "characters", len(content))
+
+	usage := Usage{
+		InputTokens:  ollamaResp.PromptEvalCount,
+		OutputTokens: ollamaResp.EvalCount,
+		Model:        ollamaResp.Model,
+	}
+	return content, usage, nil
+}
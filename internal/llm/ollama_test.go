@@ -0,0 +1,102 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/memory"
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+)
+
+// llama3Reply is how llama3 tends to answer: an explanation, the JSON and
+// a closing remark
+const llama3Reply = `Sure! Based on the conversation, the user wants to purge the cache for the images path. Here is the JSON response:
+
+{"action": "purge_cache", "status": "NEEDS_INFO", "parameters": {"service_id": null, "path": "/images"}, "user_message": "Which service should I purge?", "confidence": 0.8}
+
+I hope this helps! Let me know if you need anything else.`
+
+func TestOllamaProvider(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		reply       string
+		wantErr     string // Substring of the error, empty for success
+		wantMessage string
+	}{
+		{name: "llama3 prose", status: http.StatusOK, reply: llama3Reply, wantMessage: "Which service should I purge?"},
+		{name: "fenced reply", status: http.StatusOK, reply: "```json\n" + readyReply + "\n```", wantMessage: "Done"},
+		{name: "model not pulled", status: http.StatusNotFound, wantErr: `model "llama3" not found`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got OllamaRequest
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/api/chat" {
+					http.NotFound(w, r)
+					return
+				}
+				if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				w.WriteHeader(tt.status)
+				if tt.status != http.StatusOK {
+					json.NewEncoder(w).Encode(OllamaError{Error: `model "llama3" not found, try pulling it first`})
+					return
+				}
+				json.NewEncoder(w).Encode(OllamaResponse{
+					Model:           "llama3:8b",
+					Message:         OllamaMessage{Role: "assistant", Content: tt.reply},
+					Done:            true,
+					PromptEvalCount: 120,
+					EvalCount:       40,
+				})
+			}))
+			t.Cleanup(server.Close)
+
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			manager := memory.NewManager(memory.NewInMemoryStore(time.Hour), memory.WithLogger(logger))
+			t.Cleanup(func() { manager.Close() })
+			provider := NewOllamaProvider(server.URL+"/", "llama3", 5*time.Second, manager, WithLogger(logger))
+
+			ctx := context.Background()
+			response, err := provider.AnalyzeIntent(ctx, &models.IntentRequest{SessionID: "s1", UserMessage: "purge /images", AvailableActions: []models.ActionSchema{
+				{Action: "purge_cache", Parameters: []models.ParameterSpec{{Name: "service_id", Required: true}, {Name: "path"}}},
+			}})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("AnalyzeIntent() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("AnalyzeIntent() error = %v", err)
+			}
+
+			// Parsed exactly as the same text from Anthropic would be
+			want, err := parseIntentResponse(ctx, tt.reply, "en", false, logger)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.Status != want.Status || *response.Action != *want.Action || response.UserMessage != tt.wantMessage {
+				t.Errorf("response = %s %s %q, want %s %s %q", response.Status, *response.Action, response.UserMessage, want.Status, *want.Action, tt.wantMessage)
+			}
+			if response.Model != "llama3:8b" || response.InputTokens != 120 || response.OutputTokens != 40 {
+				t.Errorf("response model and usage = %s %d/%d, want llama3:8b 120/40", response.Model, response.InputTokens, response.OutputTokens)
+			}
+
+			if got.Model != "llama3" || got.Stream || len(got.Messages) != 1 || !strings.Contains(got.Messages[0].Content, "purge /images") {
+				t.Errorf("Ollama request = %+v, want one non-streaming llama3 message with the user's text", got)
+			}
+		})
+	}
+}
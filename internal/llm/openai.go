@@ -0,0 +1,178 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/memory"
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+	"github.com/avvvet/cdnbuddy-intent/internal/prompts"
+)
+
+// DefaultOpenAIBaseURL is used when no base URL is configured
+const DefaultOpenAIBaseURL = "https://api.openai.com"
+
+type OpenAIProvider struct {
+	apiKey  string
+	model   string
+	timeout time.Duration
+	client  *http.Client
+	conversation
+}
+
+// OpenAIRequest represents the request structure for the Chat Completions API
+type OpenAIRequest struct {
+	Model       string          `json:"model"`
+	MaxTokens   int             `json:"max_tokens"`
+	Temperature float64         `json:"temperature"`
+	Messages    []OpenAIMessage `json:"messages"`
+}
+
+// OpenAIMessage represents a message in the conversation
+type OpenAIMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// OpenAIResponse represents the response from the Chat Completions API
+type OpenAIResponse struct {
+	ID      string `json:"id"`
+	Model   string `json:"model"`
+	Choices []struct {
+		Index        int           `json:"index"`
+		Message      OpenAIMessage `json:"message"`
+		FinishReason string        `json:"finish_reason"`
+	} `json:"choices"`
+	Usage struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+}
+
+// OpenAIError represents an error response from OpenAI
+type OpenAIError struct {
+	Error struct {
+		Type    string `json:"type"`
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func NewOpenAIProvider(apiKey, model string, timeout time.Duration, memoryManager *memory.Manager, opts ...Option) *OpenAIProvider {
+	o := &OpenAIProvider{
+		apiKey:       apiKey,
+		model:        model,
+		timeout:      timeout,
+		conversation: newConversation(ProviderOpenAI, memoryManager),
+		client: &http.Client{
+			Timeout: timeout,
+		},
+	}
+	for _, opt := range opts {
+		opt(&o.settings)
+	}
+	if o.baseURL == "" {
+		o.baseURL = DefaultOpenAIBaseURL
+	}
+	return o
+}
+
+// AnalyzeIntent implements the LLMProvider interface
+func (o *OpenAIProvider) AnalyzeIntent(ctx context.Context, request *models.IntentRequest) (*models.IntentResponse, error) {
+	// Save the user message and load history
+	t, err := o.beginTurn(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	// Build the same prompt the other providers use
+	t.prompt = prompts.BuildIntentPrompt(request, t.history, t.facts, o.persona)
+
+	model := o.resolveModel(o.model, request)
+	content, usage, err := o.complete(ctx, request.SessionID, model, t.prompt)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse the response and save the assistant reply
+	return o.finishTurn(ctx, request, t, model, content, usage)
+}
+
+// complete sends the prompt to the Chat Completions API and returns the
+// content of the first choice
+func (o *OpenAIProvider) complete(ctx context.Context, sessionID, model, prompt string) (string, Usage, error) {
+	openaiReq := OpenAIRequest{
+		Model:       model,
+		MaxTokens:   o.maxTokens,
+		Temperature: o.temperature,
+		Messages: []OpenAIMessage{
+			{
+				Role:    "user",
+				Content: prompt,
+			},
+		},
+	}
+
+	reqBody, err := json.Marshal(openaiReq)
+	if err != nil {
+		return "", Usage{}, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	o.logger.InfoContext(ctx, "calling OpenAI API", "session_id", sessionID, "model", model)
+
+	url := strings.TrimRight(o.baseURL, "/") + "/v1/chat/completions"
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return "", Usage{}, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+o.apiKey)
+
+	resp, err := o.client.Do(httpReq)
+	if err != nil {
+		return "", Usage{}, fmt.Errorf("failed to make HTTP request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", Usage{}, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	// Handle non-200 responses
+	if resp.StatusCode != http.StatusOK {
+		o.logger.ErrorContext(ctx, "OpenAI error response", "session_id", sessionID, "status", resp.StatusCode, "body", string(body))
+
+		var openaiErr OpenAIError
+		if err := json.Unmarshal(body, &openaiErr); err != nil || openaiErr.Error.Message == "" {
+			return "", Usage{}, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		}
+		return "", Usage{}, fmt.Errorf("openai API error: %s", openaiErr.Error.Message)
+	}
+
+	var openaiResp OpenAIResponse
+	if err := json.Unmarshal(body, &openaiResp); err != nil {
+		return "", Usage{}, fmt.Errorf("failed to parse response: %w", err)
+	}
+	if len(openaiResp.Choices) == 0 {
+		return "", Usage{}, fmt.Errorf("openai response contained no choices")
+	}
+
+	content := openaiResp.Choices[0].Message.Content
+
+	o.logger.InfoContext(ctx, "OpenAI response received", "session_id", sessionID, "characters", len(content))
+
+	usage := Usage{
+		InputTokens:  openaiResp.Usage.PromptTokens,
+		OutputTokens: openaiResp.Usage.CompletionTokens,
+		Model:        openaiResp.Model,
+	}
+	return content, usage, nil
+}
@@ -0,0 +1,163 @@
+package llm
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// UsageRecorder receives token usage for every successful LLM call
+type UsageRecorder interface {
+	RecordUsage(ctx context.Context, tenant string, inputTokens, outputTokens int) error
+}
+
+// Generation defaults, low temperature for consistent responses
+const (
+	DefaultMaxTokens   = 1000
+	DefaultTemperature = 0.1
+)
+
+// settings holds optional tunables shared by providers
+type settings struct {
+	historyDeadlineThreshold time.Duration
+	usageRecorder            UsageRecorder
+	persona                  string // Default tone, overridable per request
+	modelRouter              *ModelRouter
+	baseURL                  string // API base URL, empty uses the provider default
+	lenientJSON              bool   // Retry failed JSON parses after normalization
+	debugSampleRate          float64
+	debugSink                DebugSink
+	auditSink                AuditFunc     // Receives every raw model reply, nil disables auditing
+	responseCache            ResponseCache // Reuses first-turn replies, nil disables caching
+	maxRetries               int           // Retries after the first attempt on transient API errors
+	keepAlive                time.Duration // Interval of connection warming pings, 0 disables them
+	maxTokens                int
+	historyTokenBudget       int // Approximate history size cap in tokens, 0 disables it
+	logger                   *slog.Logger
+	temperature              float64
+}
+
+// Option configures optional provider behaviour
+type Option func(*settings)
+
+// WithHistoryDeadlineThreshold makes the provider load only a short tail of
+// the conversation history when less than d remains on the request
+// deadline, leaving the remaining budget for the LLM call. Zero disables it.
+func WithHistoryDeadlineThreshold(d time.Duration) Option {
+	return func(s *settings) {
+		s.historyDeadlineThreshold = d
+	}
+}
+
+// WithUsageRecorder reports token usage for each successful call
+func WithUsageRecorder(r UsageRecorder) Option {
+	return func(s *settings) {
+		s.usageRecorder = r
+	}
+}
+
+// WithPersona sets the default assistant tone. Requests may override it with
+// another allowed persona.
+func WithPersona(persona string) Option {
+	return func(s *settings) {
+		s.persona = persona
+	}
+}
+
+// WithModelRouter picks the model per request by action complexity
+func WithModelRouter(r *ModelRouter) Option {
+	return func(s *settings) {
+		s.modelRouter = r
+	}
+}
+
+// WithBaseURL points the provider at a different API host, such as an
+// internal deployment or gateway
+func WithBaseURL(url string) Option {
+	return func(s *settings) {
+		s.baseURL = url
+	}
+}
+
+// WithLenientJSON repairs trailing commas and smart quotes when a model
+// response fails strict JSON parsing
+func WithLenientJSON(enabled bool) Option {
+	return func(s *settings) {
+		s.lenientJSON = enabled
+	}
+}
+
+// WithDebugSampling captures the prompt, raw output and parsed response of
+// roughly rate (0-1) of requests to sink
+func WithDebugSampling(rate float64, sink DebugSink) Option {
+	return func(s *settings) {
+		s.debugSampleRate = rate
+		s.debugSink = sink
+	}
+}
+
+// AuditFunc receives a model's raw reply before it is parsed
+type AuditFunc func(sessionID, rawResponse string)
+
+// WithAuditSink passes every raw model reply to fn before parsing,
+// including replies that are later rejected as invalid JSON. fn runs on the
+// request goroutine and should return quickly.
+func WithAuditSink(fn AuditFunc) Option {
+	return func(s *settings) {
+		s.auditSink = fn
+	}
+}
+
+// WithResponseCache answers first-turn requests from cache when the model,
+// prompt, actions and message match an earlier one. Later turns depend on
+// the conversation so far and always reach the model.
+func WithResponseCache(c ResponseCache) Option {
+	return func(s *settings) {
+		s.responseCache = c
+	}
+}
+
+// WithMaxRetries retries rate-limited and transiently failing API calls up
+// to n times with exponential backoff
+func WithMaxRetries(n int) Option {
+	return func(s *settings) {
+		s.maxRetries = n
+	}
+}
+
+// WithKeepAlive pings the API host every interval so pooled connections
+// stay warm and bursts skip the TLS handshake
+func WithKeepAlive(interval time.Duration) Option {
+	return func(s *settings) {
+		s.keepAlive = interval
+	}
+}
+
+// WithMaxTokens caps the length of the model's reply
+func WithMaxTokens(n int) Option {
+	return func(s *settings) {
+		s.maxTokens = n
+	}
+}
+
+// WithTemperature sets the sampling temperature
+func WithTemperature(t float64) Option {
+	return func(s *settings) {
+		s.temperature = t
+	}
+}
+
+// WithHistoryTokenBudget keeps only the most recent history that fits in
+// roughly n tokens, so long sessions stay inside the context window
+func WithHistoryTokenBudget(n int) Option {
+	return func(s *settings) {
+		s.historyTokenBudget = n
+	}
+}
+
+// WithLogger sets the structured logger, slog.Default() otherwise
+func WithLogger(l *slog.Logger) Option {
+	return func(s *settings) {
+		s.logger = l
+	}
+}
@@ -0,0 +1,286 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/metrics"
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+	"github.com/avvvet/cdnbuddy-intent/internal/prompts"
+)
+
+// ErrUnparsableReply is returned when the model's reply can't be parsed
+// into an intent response
+var ErrUnparsableReply = errors.New("failed to parse intent response")
+
+// parseIntentResponse parses the JSON response from the LLM into an IntentResponse.
+// Strict parsing always runs first; when lenient is set and it fails, common
+// model mistakes such as trailing commas and smart quotes are repaired and
+// parsing is retried.
+func parseIntentResponse(ctx context.Context, content, locale string, lenient bool, logger *slog.Logger) (*models.IntentResponse, error) {
+	response, strictErr, err := decodeIntentJSON(content, lenient)
+	if err != nil {
+		return nil, err
+	}
+	if strictErr != nil {
+		metrics.LenientJSONRecoveries.Add(1)
+		logger.InfoContext(ctx, "recovered malformed JSON with lenient parsing", "error", strictErr)
+	}
+
+	if status, ok := normalizeStatus(response.Status); ok && status != response.Status {
+		logger.DebugContext(ctx, "normalized response status", "from", response.Status, "to", status)
+		response.Status = status
+	}
+	if response.Status == "" {
+		response.Status = models.StatusError
+		response.UserMessage = prompts.Localize(locale, prompts.MsgFallback)
+	}
+
+	if response.Parameters == nil {
+		response.Parameters = make(map[string]*string)
+	}
+
+	// Keep the confidence within 0-1 whatever the model sent
+	response.Confidence = min(max(response.Confidence, 0), 1)
+
+	return response, nil
+}
+
+// statusSynonyms maps statuses models commonly return instead of the
+// documented ones
+var statusSynonyms = map[string]string{
+	models.StatusReady:     models.StatusReady,
+	models.StatusNeedsInfo: models.StatusNeedsInfo,
+	models.StatusError:     models.StatusError,
+	"COMPLETE":             models.StatusReady,
+	"DONE":                 models.StatusReady,
+	"FINISHED":             models.StatusReady,
+	"MORE_INFO":            models.StatusNeedsInfo,
+	"CLARIFY":              models.StatusNeedsInfo,
+}
+
+// normalizeStatus maps a status, in any case, to the documented status it
+// stands for. Unrecognized statuses are left for the handler to reject.
+func normalizeStatus(status string) (string, bool) {
+	normalized, ok := statusSynonyms[strings.ToUpper(strings.TrimSpace(status))]
+	return normalized, ok
+}
+
+// decodeIntentJSON extracts and unmarshals the JSON object in content
+// without logging or counting anything. Each candidate from
+// extractJSONCandidates is tried in order, preferring one that carries a
+// status. When only lenient parsing succeeds, the strict parse error is
+// returned as strictErr.
+func decodeIntentJSON(content string, lenient bool) (response *models.IntentResponse, strictErr, err error) {
+	candidates := extractJSONCandidates(content)
+	if len(candidates) == 0 {
+		return nil, nil, fmt.Errorf("no valid JSON found in response")
+	}
+
+	var fallback *models.IntentResponse
+	var firstErr error
+	for _, candidate := range candidates {
+		decoded := models.IntentResponse{Confidence: models.DefaultConfidence} // Kept when absent
+		if err := json.Unmarshal([]byte(candidate), &decoded); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if decoded.Status != "" {
+			return &decoded, nil, nil
+		}
+		if fallback == nil {
+			fallback = &decoded
+		}
+	}
+	if fallback != nil {
+		return fallback, nil, nil
+	}
+
+	if lenient {
+		for _, candidate := range candidates {
+			decoded := models.IntentResponse{Confidence: models.DefaultConfidence}
+			if err := json.Unmarshal([]byte(normalizeLenientJSON(candidate)), &decoded); err == nil {
+				return &decoded, firstErr, nil
+			}
+		}
+	}
+	return nil, nil, fmt.Errorf("failed to parse JSON: %w", firstErr)
+}
+
+// extractJSON returns the first JSON object candidate in content
+func extractJSON(content string) string {
+	candidates := extractJSONCandidates(content)
+	if len(candidates) == 0 {
+		return ""
+	}
+	return candidates[0]
+}
+
+// extractJSONCandidates returns the possible JSON objects in a model reply,
+// most likely first: objects inside ```json or ``` fences, then balanced
+// top-level objects in the unfenced text, then the span from the first
+// '{' to the last '}' as a last resort for replies too malformed to
+// balance.
+func extractJSONCandidates(content string) []string {
+	var candidates []string
+	seen := make(map[string]bool)
+	add := func(candidate string) {
+		candidate = strings.TrimSpace(candidate)
+		if candidate != "" && !seen[candidate] {
+			seen[candidate] = true
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	fenced, unfenced := splitCodeFences(content)
+	for _, block := range fenced {
+		for _, object := range balancedObjects(block) {
+			add(object)
+		}
+	}
+	for _, object := range balancedObjects(unfenced) {
+		add(object)
+	}
+
+	start := strings.Index(content, "{")
+	end := strings.LastIndex(content, "}")
+	if start != -1 && end > start {
+		add(content[start : end+1])
+	}
+	return candidates
+}
+
+// splitCodeFences separates the contents of markdown code fences from the
+// surrounding text. The language tag on an opening fence is skipped, and
+// an unterminated fence runs to the end of the content.
+func splitCodeFences(content string) (fenced []string, unfenced string) {
+	const fence = "```"
+
+	var outside strings.Builder
+	rest := content
+	for {
+		open := strings.Index(rest, fence)
+		if open == -1 {
+			outside.WriteString(rest)
+			break
+		}
+		outside.WriteString(rest[:open])
+		rest = rest[open+len(fence):]
+
+		// Skip the language tag, e.g. ```json
+		if newline := strings.Index(rest, "\n"); newline != -1 && !strings.Contains(rest[:newline], "{") {
+			rest = rest[newline+1:]
+		}
+
+		closing := strings.Index(rest, fence)
+		if closing == -1 {
+			fenced = append(fenced, rest)
+			break
+		}
+		fenced = append(fenced, rest[:closing])
+		outside.WriteString("\n")
+		rest = rest[closing+len(fence):]
+	}
+	return fenced, outside.String()
+}
+
+// balancedObjects returns each top-level {...} span in content whose braces
+// balance, ignoring braces inside JSON strings
+func balancedObjects(content string) []string {
+	var objects []string
+	depth := 0
+	start := -1
+	inString := false
+	escaped := false
+	for i := 0; i < len(content); i++ {
+		ch := content[i]
+
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case ch == '\\':
+				escaped = true
+			case ch == '"':
+				inString = false
+			}
+			continue
+		}
+
+		switch ch {
+		case '"':
+			if depth > 0 {
+				inString = true
+			}
+		case '{':
+			if depth == 0 {
+				start = i
+			}
+			depth++
+		case '}':
+			if depth == 0 {
+				continue
+			}
+			depth--
+			if depth == 0 {
+				objects = append(objects, content[start:i+1])
+			}
+		}
+	}
+	return objects
+}
+
+// smartQuotes maps typographic quotes to their ASCII equivalents
+var smartQuotes = strings.NewReplacer(
+	"\u201c", `"`, "\u201d", `"`, "\u201e", `"`,
+	"\u2018", "'", "\u2019", "'",
+)
+
+// normalizeLenientJSON replaces smart quotes and removes trailing commas
+// before a closing brace or bracket. Commas inside strings are left alone.
+func normalizeLenientJSON(content string) string {
+	content = smartQuotes.Replace(content)
+
+	var builder strings.Builder
+	inString := false
+	escaped := false
+	for i := 0; i < len(content); i++ {
+		ch := content[i]
+
+		if inString {
+			builder.WriteByte(ch)
+			switch {
+			case escaped:
+				escaped = false
+			case ch == '\\':
+				escaped = true
+			case ch == '"':
+				inString = false
+			}
+			continue
+		}
+
+		if ch == '"' {
+			inString = true
+		}
+
+		if ch == ',' {
+			// Skip the comma if the next non-space character closes a container
+			j := i + 1
+			for j < len(content) && strings.ContainsRune(" \t\r\n", rune(content[j])) {
+				j++
+			}
+			if j < len(content) && (content[j] == '}' || content[j] == ']') {
+				continue
+			}
+		}
+		builder.WriteByte(ch)
+	}
+	return builder.String()
+}
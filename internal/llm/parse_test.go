@@ -0,0 +1,153 @@
+package llm
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/metrics"
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+)
+
+func TestNormalizeStatus(t *testing.T) {
+	tests := []struct {
+		status string
+		want   string
+		wantOK bool
+	}{
+		{status: "READY", want: models.StatusReady, wantOK: true},
+		{status: "NEEDS_INFO", want: models.StatusNeedsInfo, wantOK: true},
+		{status: "ERROR", want: models.StatusError, wantOK: true},
+		{status: "COMPLETE", want: models.StatusReady, wantOK: true},
+		{status: "DONE", want: models.StatusReady, wantOK: true},
+		{status: "FINISHED", want: models.StatusReady, wantOK: true},
+		{status: "MORE_INFO", want: models.StatusNeedsInfo, wantOK: true},
+		{status: "CLARIFY", want: models.StatusNeedsInfo, wantOK: true},
+		{status: "done", want: models.StatusReady, wantOK: true},
+		{status: "Clarify", want: models.StatusNeedsInfo, wantOK: true},
+		{status: "  ready\n", want: models.StatusReady, wantOK: true},
+		{status: " more_info ", want: models.StatusNeedsInfo, wantOK: true},
+		{status: "PENDING"},
+		{status: "READY_NOW"},
+		{status: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			got, ok := normalizeStatus(tt.status)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("normalizeStatus(%q) = %q, %v, want %q, %v", tt.status, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestParseIntentResponseStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		status string
+		want   string
+	}{
+		{name: "documented", status: "NEEDS_INFO", want: models.StatusNeedsInfo},
+		{name: "synonym", status: "Finished", want: models.StatusReady},
+		{name: "padded synonym", status: " clarify ", want: models.StatusNeedsInfo},
+		{name: "unknown is left for the handler", status: "PENDING", want: "PENDING"},
+		{name: "missing", status: "", want: models.StatusError},
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := `{"status": "` + tt.status + `", "action": "purge_cache", "parameters": {}, "user_message": "ok"}`
+			response, err := parseIntentResponse(context.Background(), content, "en", false, logger)
+			if err != nil {
+				t.Fatalf("parseIntentResponse() error = %v", err)
+			}
+			if response.Status != tt.want {
+				t.Errorf("status = %q, want %q", response.Status, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseIntentResponseLenient(t *testing.T) {
+	tests := []struct {
+		name         string
+		content      string
+		lenient      bool
+		wantErr      bool
+		wantRecovery bool   // Counted as a lenient recovery
+		wantMessage  string // Expected user_message
+	}{
+		{name: "valid JSON", content: `{"status": "READY", "user_message": "Done"}`, lenient: true, wantMessage: "Done"},
+		{name: "trailing comma", content: `{"status": "READY", "user_message": "Done",}`, lenient: true, wantRecovery: true, wantMessage: "Done"},
+		{name: "trailing comma in parameters", content: "{\"status\": \"READY\", \"parameters\": {\"path\": \"/img\",\n}, \"user_message\": \"Done\"}", lenient: true, wantRecovery: true, wantMessage: "Done"},
+		{name: "smart quotes", content: "{“status”: “READY”, “user_message”: “Done”}", lenient: true, wantRecovery: true, wantMessage: "Done"},
+		{name: "comma inside a string kept", content: `{"status": "READY", "user_message": "a, }",}`, lenient: true, wantRecovery: true, wantMessage: "a, }"},
+		{name: "trailing comma when strict", content: `{"status": "READY", "user_message": "Done",}`, wantErr: true},
+		{name: "beyond repair", content: `{"status": READY}`, lenient: true, wantErr: true},
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := metrics.LenientJSONRecoveries.Value()
+			response, err := parseIntentResponse(context.Background(), tt.content, "en", tt.lenient, logger)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseIntentResponse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if recovered := metrics.LenientJSONRecoveries.Value() > before; recovered != tt.wantRecovery {
+				t.Errorf("recovery counted = %v, want %v", recovered, tt.wantRecovery)
+			}
+			if err != nil {
+				return
+			}
+			if response.Status != models.StatusReady || response.UserMessage != tt.wantMessage {
+				t.Errorf("response = %s %q, want %s %q", response.Status, response.UserMessage, models.StatusReady, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestDecodeIntentJSONExtraction(t *testing.T) {
+	const reply = `{"status": "READY", "action": "purge_cache", "parameters": {}, "user_message": "Done"}`
+	tests := []struct {
+		name    string
+		content string
+		want    string // user_message of the decoded reply
+		wantErr bool
+	}{
+		{name: "plain", content: reply, want: "Done"},
+		{name: "surrounded by prose", content: "Here you go:\n" + reply + "\nLet me know!", want: "Done"},
+		{name: "json fence", content: "```json\n" + reply + "\n```", want: "Done"},
+		{name: "bare fence", content: "```\n" + reply + "\n```", want: "Done"},
+		{name: "fence with braces in the explanation", content: "Use {service_id} to pick one {like this}.\n```json\n" + reply + "\n```\nThe {path} is optional.", want: "Done"},
+		{name: "unterminated fence", content: "```json\n" + reply, want: "Done"},
+		{
+			name:    "fake example in the prose",
+			content: `The format is {"example": true}. My answer: ` + reply,
+			want:    "Done",
+		},
+		{
+			name:    "example without a status comes second",
+			content: `{"user_message": "example"} and then ` + reply,
+			want:    "Done",
+		},
+		{name: "braces inside strings", content: `{"status": "READY", "user_message": "use } and { freely"}`, want: "use } and { freely"},
+		{name: "two replies keep the first", content: reply + "\n" + `{"status": "NEEDS_INFO", "user_message": "Second"}`, want: "Done"},
+		{name: "no JSON", content: "I can't help with that.", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, _, err := decodeIntentJSON(tt.content, false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeIntentJSON() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && response.UserMessage != tt.want {
+				t.Errorf("user_message = %q, want %q", response.UserMessage, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package llm
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/prompts"
+)
+
+// PromptVersion identifies the system prompt revision. Bump it whenever the
+// prompt text changes so evaluations can group responses by prompt.
+const PromptVersion = "v6"
+
+// promptVersion returns PromptVersion, tagged with the template's hash when
+// a custom system prompt template is loaded
+func promptVersion() string {
+	if id := prompts.SystemTemplateID(); id != "" {
+		return PromptVersion + "+custom-" + id
+	}
+	return PromptVersion
+}
+
+// buildTimingHint tells the model how long ago the previous message was
+// sent, or returns nothing when that is unknown
+func buildTimingHint(idle time.Duration) string {
+	if idle <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("\n\nTiming: the previous message in this conversation was sent %s.", humanizeDuration(idle))
+}
+
+// humanizeDuration renders a duration as a rough "N units ago" phrase
+func humanizeDuration(d time.Duration) string {
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("1 %s ago", unit)
+		}
+		return fmt.Sprintf("%d %ss ago", n, unit)
+	}
+
+	switch {
+	case d < time.Minute:
+		return "moments ago"
+	case d < time.Hour:
+		return plural(int(d/time.Minute), "minute")
+	case d < 24*time.Hour:
+		return plural(int(d/time.Hour), "hour")
+	default:
+		return plural(int(d/(24*time.Hour)), "day")
+	}
+}
+
+// deadlineHistoryTokens is the history budget when the deadline is tight,
+// about 2000 bytes
+const deadlineHistoryTokens = 500
@@ -0,0 +1,155 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/memory"
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+	"github.com/avvvet/cdnbuddy-intent/internal/prompts"
+)
+
+// newPromptServer answers the Anthropic, OpenAI and Ollama APIs with
+// readyReply and records the prompt text each request carried: the system
+// field for Anthropic, the single user message for the others
+func newPromptServer(t *testing.T) (*httptest.Server, func() []string) {
+	t.Helper()
+	var mu sync.Mutex
+	var sent []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			System   string `json:"system"`
+			Messages []struct {
+				Content string `json:"content"`
+			} `json:"messages"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		prompt := body.System
+		var reply any
+		switch r.URL.Path {
+		case "/v1/messages":
+			reply = map[string]any{
+				"content": []map[string]string{{"type": "text", "text": readyReply}},
+				"usage":   map[string]int{"input_tokens": 10, "output_tokens": 5},
+			}
+		case "/v1/chat/completions":
+			prompt = body.Messages[0].Content
+			reply = map[string]any{"choices": []map[string]any{{"message": OpenAIMessage{Role: "assistant", Content: readyReply}}}}
+		case "/api/chat":
+			prompt = body.Messages[0].Content
+			reply = OllamaResponse{Message: OllamaMessage{Role: "assistant", Content: readyReply}, Done: true}
+		default:
+			http.NotFound(w, r)
+			return
+		}
+
+		mu.Lock()
+		sent = append(sent, prompt)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reply)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, sent...)
+	}
+}
+
+func TestPromptParity(t *testing.T) {
+	facts := map[string]string{"domain": "example.com"}
+	request := &models.IntentRequest{
+		SessionID:   "s1",
+		UserMessage: "the blog",
+		Persona:     "terse",
+		Locale:      "es",
+		AvailableActions: []models.ActionSchema{
+			{Action: "purge_cache", Parameters: []models.ParameterSpec{{Name: "service_id", Required: true}, {Name: "path"}}},
+		},
+	}
+	system := prompts.BuildSystemPrompt(request, facts, "friendly")
+	history := "User: purge the cache\nAssistant: Which service?\nUser: the blog\n"
+
+	tests := []struct {
+		name        string
+		newProvider func(url string, manager *memory.Manager, opts ...Option) LLMProvider
+		want        string // Prompt text the API receives
+	}{
+		{
+			name: "anthropic",
+			newProvider: func(url string, manager *memory.Manager, opts ...Option) LLMProvider {
+				return NewAnthropicProvider("test-key", "claude-test", 5*time.Second, manager, append(opts, WithBaseURL(url))...)
+			},
+			// The conversation goes in messages, so only the reminder follows
+			want: system + "\n" + anthropicTurnReminder,
+		},
+		{
+			name: "openai",
+			newProvider: func(url string, manager *memory.Manager, opts ...Option) LLMProvider {
+				return NewOpenAIProvider("test-key", "gpt-test", 5*time.Second, manager, append(opts, WithBaseURL(url))...)
+			},
+			want: prompts.BuildIntentPrompt(request, history, facts, "friendly"),
+		},
+		{
+			name: "ollama",
+			newProvider: func(url string, manager *memory.Manager, opts ...Option) LLMProvider {
+				return NewOllamaProvider(url, "llama3", 5*time.Second, manager, opts...)
+			},
+			want: prompts.BuildIntentPrompt(request, history, facts, "friendly"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, sent := newPromptServer(t)
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			manager := memory.NewManager(memory.NewInMemoryStore(time.Hour), memory.WithLogger(logger))
+			t.Cleanup(func() { manager.Close() })
+			provider := tt.newProvider(server.URL, manager, WithLogger(logger), WithMaxRetries(0), WithPersona("friendly"))
+			if closer, ok := provider.(io.Closer); ok {
+				t.Cleanup(func() { closer.Close() })
+			}
+
+			ctx := context.Background()
+			if err := manager.SaveUserMessage(ctx, "s1", "user1", "purge the cache"); err != nil {
+				t.Fatal(err)
+			}
+			if err := manager.SaveAssistantMessage(ctx, "s1", "user1", "Which service?"); err != nil {
+				t.Fatal(err)
+			}
+			if err := manager.SetSlots(ctx, "s1", facts); err != nil {
+				t.Fatal(err)
+			}
+
+			turn := *request
+			if _, err := provider.AnalyzeIntent(ctx, &turn); err != nil {
+				t.Fatalf("AnalyzeIntent() error = %v", err)
+			}
+			got := sent()
+			if len(got) != 1 {
+				t.Fatalf("API called %d times, want 1", len(got))
+			}
+			if got[0] != tt.want {
+				t.Errorf("prompt differs from the prompts package:\ngot:\n%s\nwant:\n%s", got[0], tt.want)
+			}
+			// Every provider opens with the same instructions
+			if !strings.HasPrefix(got[0], system) {
+				t.Errorf("prompt doesn't start with the shared system prompt:\n%s", got[0])
+			}
+		})
+	}
+}
@@ -11,21 +11,33 @@ type LLMProvider interface {
 	AnalyzeIntent(ctx context.Context, request *models.IntentRequest) (*models.IntentResponse, error)
 }
 
-// LLMRequest represents the structured request to LLM
-type LLMRequest struct {
-	Prompt              string
-	ConversationHistory []models.ConversationMessage
-	MaxTokens           int
-	Temperature         float64
+type Usage struct {
+	InputTokens  int
+	OutputTokens int
+	Model        string // Model that answered as reported by the provider, empty when unknown
 }
 
-// LLMResponse represents the raw response from LLM
-type LLMResponse struct {
-	Content string
-	Usage   *Usage
+// ChunkFunc receives user_message text as the model generates it
+type ChunkFunc func(delta string)
+
+// StreamingProvider is implemented by providers that can stream the
+// user_message while the reply is being generated. The returned response is
+// parsed from the complete reply, as with AnalyzeIntent.
+type StreamingProvider interface {
+	AnalyzeIntentStream(ctx context.Context, request *models.IntentRequest, onChunk ChunkFunc) (*models.IntentResponse, error)
 }
 
-type Usage struct {
-	InputTokens  int
-	OutputTokens int
+// AnalyzeIntentStream streams from provider when it supports streaming.
+// Otherwise it falls back to AnalyzeIntent and sends the whole user_message
+// as a single chunk.
+func AnalyzeIntentStream(ctx context.Context, provider LLMProvider, request *models.IntentRequest, onChunk ChunkFunc) (*models.IntentResponse, error) {
+	if streamer, ok := provider.(StreamingProvider); ok {
+		return streamer.AnalyzeIntentStream(ctx, request, onChunk)
+	}
+
+	response, err := provider.AnalyzeIntent(ctx, request)
+	if err == nil && response.UserMessage != "" {
+		onChunk(response.UserMessage)
+	}
+	return response, err
 }
@@ -0,0 +1,72 @@
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/memory"
+	"github.com/avvvet/cdnbuddy-intent/internal/metrics"
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+)
+
+// ResponseCache stores raw model replies by prompt hash
+type ResponseCache interface {
+	Get(ctx context.Context, key string) (string, bool, error)
+	Save(ctx context.Context, key, reply string) error
+}
+
+// responseCacheKey hashes everything that shapes a first-turn reply. The
+// system prompt already lists the actions; they are hashed again in full so
+// parameter types and enums that the prompt summarizes still count.
+func responseCacheKey(model, system string, actions []models.ActionSchema, message string) string {
+	data, _ := json.Marshal(struct {
+		Model   string                `json:"model"`
+		System  string                `json:"system"`
+		Actions []models.ActionSchema `json:"actions"`
+		Message string                `json:"message"`
+	}{model, system, actions, message})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// isFirstTurn reports whether history holds nothing but the current user
+// message, so the reply can't depend on earlier turns
+func isFirstTurn(history []memory.Message) bool {
+	turns := 0
+	for _, msg := range history {
+		switch msg.Role {
+		case "assistant":
+			return false
+		case "user":
+			turns++
+		}
+	}
+	return turns <= 1
+}
+
+// cachedReply returns the cached reply for key, treating cache errors as
+// a miss
+func (c *conversation) cachedReply(ctx context.Context, sessionID, key string) (string, bool) {
+	reply, ok, err := c.responseCache.Get(ctx, key)
+	if err != nil {
+		c.logger.WarnContext(ctx, "failed to read response cache", "session_id", sessionID, "error", err)
+		return "", false
+	}
+	if ok {
+		metrics.ResponseCacheHits.Add(1)
+		c.logger.InfoContext(ctx, "answered from response cache", "session_id", sessionID)
+	}
+	return reply, ok
+}
+
+// cacheReply stores a reply for reuse if it parses
+func (c *conversation) cacheReply(ctx context.Context, sessionID, key, reply string) {
+	if _, _, err := decodeIntentJSON(reply, c.lenientJSON); err != nil {
+		return
+	}
+	if err := c.responseCache.Save(ctx, key, reply); err != nil {
+		c.logger.WarnContext(ctx, "failed to write response cache", "session_id", sessionID, "error", err)
+	}
+}
@@ -0,0 +1,73 @@
+package llm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+	"github.com/avvvet/cdnbuddy-intent/internal/respcache"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestResponseCache(t *testing.T) {
+	type turn struct {
+		sessionID string
+		message   string
+	}
+	tests := []struct {
+		name      string
+		turns     []turn
+		wantCalls int
+		wantKeys  int // Replies left in the cache
+	}{
+		{name: "identical first turns", turns: []turn{{"s1", "help me set up a CDN"}, {"s2", "help me set up a CDN"}}, wantCalls: 1, wantKeys: 1},
+		{name: "different first turns", turns: []turn{{"s1", "help me set up a CDN"}, {"s2", "purge the cache"}}, wantCalls: 2, wantKeys: 2},
+		{name: "second turn", turns: []turn{{"s1", "help me set up a CDN"}, {"s1", "help me set up a CDN"}}, wantCalls: 2, wantKeys: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr := miniredis.RunT(t)
+			client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+			t.Cleanup(func() { client.Close() })
+
+			server := newFakeAnthropic(t, readyReply)
+			provider, manager := newTestAnthropic(t, server, WithResponseCache(respcache.NewCache(client, time.Hour)))
+
+			ctx := context.Background()
+			for _, turn := range tt.turns {
+				response, err := provider.AnalyzeIntent(ctx, &models.IntentRequest{SessionID: turn.sessionID, UserMessage: turn.message})
+				if err != nil {
+					t.Fatalf("AnalyzeIntent() error = %v", err)
+				}
+				if response.Status != models.StatusReady {
+					t.Errorf("status = %s, want %s", response.Status, models.StatusReady)
+				}
+			}
+			if got := len(server.Requests()); got != tt.wantCalls {
+				t.Errorf("API called %d times, want %d", got, tt.wantCalls)
+			}
+
+			// Cached answers are still recorded in the session that got them
+			for _, turn := range tt.turns {
+				messages, err := manager.GetMessages(ctx, turn.sessionID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(messages) == 0 || messages[len(messages)-1].Role != "assistant" {
+					t.Errorf("session %s does not end with the assistant reply: %+v", turn.sessionID, messages)
+				}
+			}
+			if got := len(mr.Keys()); got != tt.wantKeys {
+				t.Errorf("cached %d replies, want %d", got, tt.wantKeys)
+			}
+			for _, key := range mr.Keys() {
+				if ttl := mr.TTL(key); ttl != time.Hour {
+					t.Errorf("TTL of %s = %v, want %v", key, ttl, time.Hour)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package llm
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Backoff bounds for retried API calls
+const (
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 10 * time.Second
+)
+
+// StatusError is returned when an LLM API answers with a non-200 status
+type StatusError struct {
+	StatusCode int
+	Message    string
+	Header     http.Header
+}
+
+func (e *StatusError) Error() string {
+	return e.Message
+}
+
+// isRetryableStatus reports whether a status is worth retrying: rate
+// limits, transient server errors and Anthropic's 529 overloaded_error
+func isRetryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		529:
+		return true
+	}
+	return false
+}
+
+// backoffDelay returns the exponential backoff for a retry attempt
+// (0-based) with jitter, so replicas don't retry in lockstep
+func backoffDelay(attempt int) time.Duration {
+	delay := retryBaseDelay << attempt
+	if delay <= 0 || delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	// Pick uniformly from [delay/2, delay]
+	half := delay / 2
+	return half + rand.N(half+1)
+}
+
+// retryAfter parses a Retry-After header in either delay-seconds or
+// HTTP-date form. It reports false when the header is missing or invalid.
+func retryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	value := strings.TrimSpace(header.Get("Retry-After"))
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if at, err := http.ParseTime(value); err == nil {
+		if delay := at.Sub(now); delay > 0 {
+			return delay, true
+		}
+		return 0, true // Date already passed, retry right away
+	}
+
+	return 0, false
+}
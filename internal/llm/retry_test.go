@@ -0,0 +1,106 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+)
+
+func TestAnthropicRetry(t *testing.T) {
+	tests := []struct {
+		name       string
+		failures   []fakeFailure
+		maxRetries int
+		wantStatus int // StatusError code returned, 0 for success
+		wantCalls  int
+		minElapsed time.Duration
+	}{
+		{name: "overloaded twice", failures: []fakeFailure{{status: 529}, {status: 529}}, maxRetries: 3, wantCalls: 3, minElapsed: retryBaseDelay/2 + retryBaseDelay},
+		{name: "retries used up", failures: []fakeFailure{{status: 529}, {status: 529}}, maxRetries: 1, wantStatus: 529, wantCalls: 2},
+		{name: "rate limit waits for Retry-After", failures: []fakeFailure{{status: http.StatusTooManyRequests, retryAfter: "1"}}, maxRetries: 3, wantCalls: 2, minElapsed: time.Second},
+		{name: "not retryable", failures: []fakeFailure{{status: http.StatusBadRequest}}, maxRetries: 3, wantStatus: http.StatusBadRequest, wantCalls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeAnthropic(t, readyReply)
+			server.failures = tt.failures
+			provider, _ := newTestAnthropic(t, server, WithMaxRetries(tt.maxRetries))
+
+			start := time.Now()
+			response, err := provider.AnalyzeIntent(context.Background(), &models.IntentRequest{SessionID: "s1", UserMessage: "purge the cache"})
+			elapsed := time.Since(start)
+
+			var statusErr *StatusError
+			switch {
+			case tt.wantStatus == 0 && err != nil:
+				t.Fatalf("AnalyzeIntent() error = %v", err)
+			case tt.wantStatus == 0 && response.Status != models.StatusReady:
+				t.Errorf("status = %s, want %s", response.Status, models.StatusReady)
+			case tt.wantStatus != 0 && (!errors.As(err, &statusErr) || statusErr.StatusCode != tt.wantStatus):
+				t.Errorf("AnalyzeIntent() error = %v, want status %d", err, tt.wantStatus)
+			}
+			if got := len(server.Requests()); got != tt.wantCalls {
+				t.Errorf("API called %d times, want %d", got, tt.wantCalls)
+			}
+			if elapsed < tt.minElapsed {
+				t.Errorf("retried after %v, want at least %v", elapsed, tt.minElapsed)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{name: "missing"},
+		{name: "seconds", value: "3", want: 3 * time.Second, wantOK: true},
+		{name: "negative seconds", value: "-1"},
+		{name: "HTTP date", value: now.Add(5 * time.Second).Format(http.TimeFormat), want: 5 * time.Second, wantOK: true},
+		{name: "past HTTP date", value: now.Add(-time.Minute).Format(http.TimeFormat), wantOK: true},
+		{name: "garbage", value: "soon"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.value != "" {
+				header.Set("Retry-After", tt.value)
+			}
+			got, ok := retryAfter(header, now)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("retryAfter(%q) = %v, %v, want %v, %v", tt.value, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{attempt: 0, max: retryBaseDelay},
+		{attempt: 1, max: 2 * retryBaseDelay},
+		{attempt: 3, max: 8 * retryBaseDelay},
+		{attempt: 10, max: retryMaxDelay},
+		{attempt: 70, max: retryMaxDelay}, // Shift overflow
+	}
+
+	for _, tt := range tests {
+		for range 20 {
+			if got := backoffDelay(tt.attempt); got < tt.max/2 || got > tt.max {
+				t.Errorf("backoffDelay(%d) = %v, want between %v and %v", tt.attempt, got, tt.max/2, tt.max)
+			}
+		}
+	}
+}
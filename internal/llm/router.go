@@ -0,0 +1,48 @@
+package llm
+
+import "github.com/avvvet/cdnbuddy-intent/internal/models"
+
+// ModelRouter picks a model for a request based on how complex the
+// available actions are, so simple actions can use a cheaper, faster model
+type ModelRouter struct {
+	models          map[string]string // complexity -> model
+	simpleMaxParams int               // Actions with at most this many parameters are simple
+}
+
+// NewModelRouter creates a router from a complexity -> model mapping.
+// Actions without an explicit Complexity are classed by parameter count.
+func NewModelRouter(models map[string]string, simpleMaxParams int) *ModelRouter {
+	return &ModelRouter{
+		models:          models,
+		simpleMaxParams: simpleMaxParams,
+	}
+}
+
+// Route returns the model for the given actions, or "" when the provider's
+// default model should be used. A request is only simple if every action in
+// it is simple.
+func (r *ModelRouter) Route(actions []models.ActionSchema) string {
+	if len(actions) == 0 {
+		return ""
+	}
+
+	complexity := models.ComplexitySimple
+	for _, action := range actions {
+		if r.complexityOf(action) == models.ComplexityComplex {
+			complexity = models.ComplexityComplex
+			break
+		}
+	}
+	return r.models[complexity]
+}
+
+func (r *ModelRouter) complexityOf(action models.ActionSchema) string {
+	switch action.Complexity {
+	case models.ComplexitySimple, models.ComplexityComplex:
+		return action.Complexity
+	}
+	if len(action.Parameters) <= r.simpleMaxParams {
+		return models.ComplexitySimple
+	}
+	return models.ComplexityComplex
+}
@@ -0,0 +1,54 @@
+package llm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+)
+
+func TestModelRouting(t *testing.T) {
+	routes := map[string]string{
+		models.ComplexitySimple:  "claude-haiku-test",
+		models.ComplexityComplex: "claude-opus-test",
+	}
+	purge := models.ActionSchema{Action: "PURGE_CACHE", Parameters: []models.ParameterSpec{{Name: "service_id"}}}
+	setup := models.ActionSchema{Action: "SETUP_CDN", Parameters: []models.ParameterSpec{{Name: "domain"}, {Name: "origin_hostname"}, {Name: "tls"}}}
+
+	tests := []struct {
+		name    string
+		routes  map[string]string
+		actions []models.ActionSchema
+		want    string
+	}{
+		{name: "simple by parameter count", routes: routes, actions: []models.ActionSchema{purge}, want: "claude-haiku-test"},
+		{name: "complex by parameter count", routes: routes, actions: []models.ActionSchema{setup}, want: "claude-opus-test"},
+		{name: "one complex action makes the request complex", routes: routes, actions: []models.ActionSchema{purge, setup}, want: "claude-opus-test"},
+		{name: "explicit complex flag", routes: routes, actions: []models.ActionSchema{{Action: "PURGE_CACHE", Complexity: models.ComplexityComplex}}, want: "claude-opus-test"},
+		{name: "explicit simple flag", routes: routes, actions: []models.ActionSchema{{Action: "SETUP_CDN", Parameters: setup.Parameters, Complexity: models.ComplexitySimple}}, want: "claude-haiku-test"},
+		{name: "no actions", routes: routes, want: "claude-test"},
+		{name: "complexity without a model", routes: map[string]string{models.ComplexitySimple: "claude-haiku-test"}, actions: []models.ActionSchema{setup}, want: "claude-test"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeAnthropic(t, readyReply)
+			provider, _ := newTestAnthropic(t, server, WithModelRouter(NewModelRouter(tt.routes, 2)))
+
+			response, err := provider.AnalyzeIntent(context.Background(), &models.IntentRequest{
+				SessionID:        "s1",
+				UserMessage:      "purge the cache",
+				AvailableActions: tt.actions,
+			})
+			if err != nil {
+				t.Fatalf("AnalyzeIntent() error = %v", err)
+			}
+			if got := server.Requests()[0].Model; got != tt.want {
+				t.Errorf("requested model = %q, want %q", got, tt.want)
+			}
+			if response.Model != tt.want {
+				t.Errorf("response model = %q, want %q", response.Model, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package llm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxStreamLine bounds a single server-sent event line
+const maxStreamLine = 1 << 20
+
+// anthropicStreamEvent covers the fields used from Messages API stream
+// events: message_start carries the model and input usage,
+// content_block_delta the text and message_delta the output usage
+type anthropicStreamEvent struct {
+	Type    string `json:"type"`
+	Message struct {
+		Model string `json:"model"`
+		Usage struct {
+			InputTokens  int `json:"input_tokens"`
+			OutputTokens int `json:"output_tokens"`
+		} `json:"usage"`
+	} `json:"message"`
+	Delta struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"delta"`
+	Usage struct {
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+	Error AnthropicError `json:"error"`
+}
+
+// completeStream is completeMessages over server-sent events. The reply is
+// accumulated and returned whole, and its user_message is passed to onChunk
+// as it is generated.
+func (a *AnthropicProvider) completeStream(ctx context.Context, sessionID, model, system string, messages []AnthropicMessage, onChunk ChunkFunc) (string, Usage, error) {
+	anthropicReq := AnthropicRequest{
+		Model:       model,
+		MaxTokens:   a.maxTokens,
+		Temperature: a.temperature,
+		System:      system,
+		Messages:    messages,
+		Stream:      true,
+	}
+	reqBody, err := json.Marshal(anthropicReq)
+	if err != nil {
+		return "", Usage{}, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	a.logger.InfoContext(ctx, "calling Claude API", "session_id", sessionID, "model", model, "stream", true)
+
+	// Only opening the stream is retried: once text has been passed on, a
+	// second attempt would repeat it
+	var body io.ReadCloser
+	err = a.retry(ctx, sessionID, func() error {
+		resp, err := a.post(ctx, reqBody)
+		if err != nil {
+			return err
+		}
+		body = resp.Body
+		return nil
+	})
+	if err != nil {
+		return "", Usage{}, err
+	}
+	defer body.Close()
+
+	streamer := newUserMessageStreamer(onChunk)
+	content, usage, err := readAnthropicStream(body, streamer.write)
+	if err != nil {
+		return "", Usage{}, err
+	}
+
+	a.logger.InfoContext(ctx, "Claude response received", "session_id", sessionID, "characters", len(content), "stream", true)
+	return content, usage, nil
+}
+
+// readAnthropicStream reads Messages API server-sent events until
+// message_stop, passing each text delta to onText
+func readAnthropicStream(r io.Reader, onText func(string)) (string, Usage, error) {
+	var content strings.Builder
+	var usage Usage
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxStreamLine)
+	for scanner.Scan() {
+		// Only data lines matter: every payload names its own type
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+
+		var event anthropicStreamEvent
+		if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &event); err != nil {
+			return "", Usage{}, fmt.Errorf("failed to parse stream event: %w", err)
+		}
+
+		switch event.Type {
+		case "message_start":
+			usage.InputTokens = event.Message.Usage.InputTokens
+			usage.OutputTokens = event.Message.Usage.OutputTokens
+			usage.Model = event.Message.Model
+		case "content_block_delta":
+			if event.Delta.Type == "text_delta" {
+				content.WriteString(event.Delta.Text)
+				onText(event.Delta.Text)
+			}
+		case "message_delta":
+			usage.OutputTokens = event.Usage.OutputTokens
+		case "message_stop":
+			return content.String(), usage, nil
+		case "error":
+			return "", Usage{}, fmt.Errorf("anthropic stream error: %s", event.Error.Message)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", Usage{}, fmt.Errorf("failed to read stream: %w", err)
+	}
+	return "", Usage{}, errors.New("stream ended before message_stop")
+}
+
+// userMessageKey matches the start of the user_message string value
+var userMessageKey = regexp.MustCompile(`"user_message"\s*:\s*"`)
+
+// userMessageStreamer picks the user_message value out of a JSON reply that
+// arrives in pieces, decoding escapes and passing on the text as soon as it
+// is complete
+type userMessageStreamer struct {
+	onChunk ChunkFunc
+	buf     strings.Builder
+	pos     int // Start of the undecoded part of the value, -1 until found
+	done    bool
+}
+
+func newUserMessageStreamer(onChunk ChunkFunc) *userMessageStreamer {
+	return &userMessageStreamer{onChunk: onChunk, pos: -1}
+}
+
+// write adds the next piece of the reply
+func (s *userMessageStreamer) write(text string) {
+	if s.done {
+		return
+	}
+	s.buf.WriteString(text)
+	reply := s.buf.String()
+
+	if s.pos < 0 {
+		loc := userMessageKey.FindStringIndex(reply)
+		if loc == nil {
+			return
+		}
+		s.pos = loc[1]
+	}
+
+	var out strings.Builder
+	i := s.pos
+decode:
+	for i < len(reply) {
+		switch c := reply[i]; c {
+		case '"':
+			s.done = true
+			break decode
+		case '\\':
+			decoded, n, ok := decodeEscape(reply[i:])
+			if !ok {
+				break decode // Wait for the rest of the escape
+			}
+			out.WriteString(decoded)
+			i += n
+		default:
+			out.WriteByte(c)
+			i++
+		}
+	}
+	s.pos = i
+
+	if out.Len() > 0 {
+		s.onChunk(out.String())
+	}
+}
+
+// decodeEscape decodes the JSON escape sequence at the start of s,
+// returning the text and its encoded length. ok is false while the
+// sequence is incomplete.
+func decodeEscape(s string) (string, int, bool) {
+	if len(s) < 2 {
+		return "", 0, false
+	}
+	if s[1] != 'u' {
+		switch s[1] {
+		case 'n':
+			return "\n", 2, true
+		case 't':
+			return "\t", 2, true
+		case 'r':
+			return "\r", 2, true
+		case 'b':
+			return "\b", 2, true
+		case 'f':
+			return "\f", 2, true
+		default:
+			return s[1:2], 2, true // \" \\ \/
+		}
+	}
+
+	if len(s) < 6 {
+		return "", 0, false
+	}
+	n := 6
+	// A high surrogate is decoded together with the low one following it
+	if v, err := strconv.ParseUint(s[2:6], 16, 16); err == nil && v >= 0xD800 && v < 0xDC00 {
+		if len(s) < 8 || (len(s) < 12 && s[6:8] == `\u`) {
+			return "", 0, false
+		}
+		if s[6:8] == `\u` {
+			n = 12
+		}
+	}
+
+	var decoded string
+	if err := json.Unmarshal([]byte(`"`+s[:n]+`"`), &decoded); err != nil {
+		return "", n, true // Invalid escape: skip it
+	}
+	return decoded, n, true
+}
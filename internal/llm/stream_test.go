@@ -0,0 +1,105 @@
+package llm
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+)
+
+func TestAnthropicStream(t *testing.T) {
+	const message = `Purging \"/img/*\" on svc-1\nDone \u2713 \ud83d\ude80`
+	const want = "Purging \"/img/*\" on svc-1\nDone ✓ 🚀"
+	reply := `{"status": "READY", "action": "purge_cache", "parameters": {}, "user_message": "` + message + `", "confidence": 0.9}`
+
+	tests := []struct {
+		name  string
+		chunk int
+	}{
+		{name: "byte at a time", chunk: 1},
+		{name: "escapes split across deltas", chunk: 5},
+		{name: "single delta", chunk: len(reply)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeAnthropic(t, reply)
+			server.chunk = tt.chunk
+			provider, _ := newTestAnthropic(t, server)
+
+			var chunks []string
+			response, err := provider.AnalyzeIntentStream(context.Background(), &models.IntentRequest{SessionID: "s1", UserMessage: "purge /img"},
+				func(delta string) { chunks = append(chunks, delta) })
+			if err != nil {
+				t.Fatalf("AnalyzeIntentStream() error = %v", err)
+			}
+			if got := strings.Join(chunks, ""); got != want {
+				t.Errorf("streamed %q, want %q", got, want)
+			}
+			if response.UserMessage != want || response.Status != models.StatusReady {
+				t.Errorf("got status %s and user_message %q, want READY and %q", response.Status, response.UserMessage, want)
+			}
+			if response.InputTokens != 10 || response.OutputTokens != 5 {
+				t.Errorf("usage = %d/%d, want 10/5", response.InputTokens, response.OutputTokens)
+			}
+			if requests := server.Requests(); len(requests) != 1 || !requests[0].Stream {
+				t.Errorf("requests = %+v, want one streamed request", requests)
+			}
+		})
+	}
+}
+
+func TestReadAnthropicStream(t *testing.T) {
+	tests := []struct {
+		name    string
+		events  string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "text deltas",
+			events: "event: message_start\ndata: {\"type\": \"message_start\", \"message\": {\"model\": \"claude-test\", \"usage\": {\"input_tokens\": 3}}}\n\n" +
+				": keep-alive comment\n\n" +
+				"data: {\"type\": \"content_block_delta\", \"delta\": {\"type\": \"text_delta\", \"text\": \"Hel\"}}\n\n" +
+				"data: {\"type\": \"content_block_delta\", \"delta\": {\"type\": \"input_json_delta\", \"partial_json\": \"{}\"}}\n\n" +
+				"data: {\"type\": \"content_block_delta\", \"delta\": {\"type\": \"text_delta\", \"text\": \"lo\"}}\n\n" +
+				"data: {\"type\": \"message_stop\"}\n\n",
+			want: "Hello",
+		},
+		{
+			name:    "error event",
+			events:  "data: {\"type\": \"error\", \"error\": {\"type\": \"overloaded_error\", \"message\": \"Overloaded\"}}\n\n",
+			wantErr: "anthropic stream error: Overloaded",
+		},
+		{
+			name:    "ends without message_stop",
+			events:  "data: {\"type\": \"content_block_delta\", \"delta\": {\"type\": \"text_delta\", \"text\": \"Hel\"}}\n\n",
+			wantErr: "stream ended before message_stop",
+		},
+		{
+			name:    "malformed event",
+			events:  "data: {\"type\": \n\n",
+			wantErr: "failed to parse stream event",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var streamed strings.Builder
+			got, _, err := readAnthropicStream(strings.NewReader(tt.events), func(text string) { streamed.WriteString(text) })
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("readAnthropicStream() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readAnthropicStream() error = %v", err)
+			}
+			if got != tt.want || streamed.String() != tt.want {
+				t.Errorf("content = %q, streamed %q, want %q", got, streamed.String(), tt.want)
+			}
+		})
+	}
+}
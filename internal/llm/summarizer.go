@@ -0,0 +1,96 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/config"
+	"github.com/avvvet/cdnbuddy-intent/internal/prompts"
+)
+
+// completer is implemented by providers that can run a raw prompt
+type completer interface {
+	complete(ctx context.Context, sessionID, model, prompt string) (string, Usage, error)
+}
+
+// Summarizer condenses old conversation turns with the configured model.
+// It implements memory.Summarizer.
+type Summarizer struct {
+	provider completer
+	model    string
+}
+
+// NewSummarizer builds a summarizer on the provider selected by
+// cfg.LLMProvider. For the weighted provider the heaviest backend is used.
+func NewSummarizer(cfg *config.Config) (*Summarizer, error) {
+	name := cfg.LLMProvider
+	if name == ProviderWeighted {
+		name = heaviestProvider(cfg.LLMWeights)
+	}
+
+	var model string
+	switch name {
+	case ProviderAnthropic:
+		model = cfg.AnthropicModel
+	case ProviderOpenAI:
+		model = cfg.OpenAIModel
+	case ProviderOllama:
+		model = cfg.OllamaModel
+	default:
+		return nil, fmt.Errorf("unknown LLM provider %q (supported: %s)", name, strings.Join(SupportedProviders, ", "))
+	}
+
+	// The summarizer never touches session memory, so no manager is needed
+	provider, err := newSingleProvider(name, cfg, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Summarizer{
+		provider: provider.(completer),
+		model:    model,
+	}, nil
+}
+
+// Summarize returns a short summary of a formatted transcript
+func (s *Summarizer) Summarize(ctx context.Context, transcript string) (string, error) {
+	content, _, err := s.provider.complete(ctx, "summary", s.model, prompts.BuildSummaryPrompt(transcript))
+	if err != nil {
+		return "", fmt.Errorf("failed to summarize conversation: %w", err)
+	}
+
+	summary := strings.TrimSpace(content)
+	if summary == "" {
+		return "", fmt.Errorf("model returned an empty summary")
+	}
+	return summary, nil
+}
+
+// Close releases the underlying provider's resources
+func (s *Summarizer) Close() error {
+	if closer, ok := s.provider.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// heaviestProvider returns the provider with the largest weight, breaking
+// ties by name so the choice is stable
+func heaviestProvider(weights map[string]int) string {
+	names := make([]string, 0, len(weights))
+	for name := range weights {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	best := ""
+	for _, name := range names {
+		if best == "" || weights[name] > weights[best] {
+			best = name
+		}
+	}
+	return best
+}
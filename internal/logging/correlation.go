@@ -0,0 +1,43 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+)
+
+// CorrelationKey is the log attribute holding a request's correlation ID
+const CorrelationKey = "correlation_id"
+
+type correlationIDKey struct{}
+
+// WithCorrelationID returns a context whose log lines carry id
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIDKey{}, id)
+}
+
+// CorrelationID returns the correlation ID on ctx, or "" when there is none
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationIDKey{}).(string)
+	return id
+}
+
+// contextHandler adds the correlation ID from the record's context to
+// every line logged with one of the *Context methods
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := CorrelationID(ctx); id != "" {
+		record.AddAttrs(slog.String(CorrelationKey, id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
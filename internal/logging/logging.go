@@ -0,0 +1,34 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Supported log formats
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// New builds a logger writing to w. level is debug, info, warn or error;
+// format is json or text. Lines logged with a context carry its
+// correlation ID.
+func New(w io.Writer, level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: %w", level, err)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch strings.ToLower(format) {
+	case FormatJSON:
+		return slog.New(contextHandler{slog.NewJSONHandler(w, opts)}), nil
+	case FormatText:
+		return slog.New(contextHandler{slog.NewTextHandler(w, opts)}), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q (supported: %s, %s)", format, FormatJSON, FormatText)
+	}
+}
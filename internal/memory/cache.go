@@ -0,0 +1,98 @@
+package memory
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/tmc/langchaingo/memory"
+)
+
+// DefaultSessionCacheSize bounds the session cache when no size is configured
+const DefaultSessionCacheSize = 1000
+
+// sessionCache is a least-recently-used cache of conversation buffers. The
+// store remains the source of truth: an evicted session is simply reloaded
+// on its next access.
+type sessionCache struct {
+	mu       sync.Mutex
+	capacity int                             // 0 means unbounded
+	order    *list.List                      // Most recently used at the front
+	entries  map[tenantSession]*list.Element // Values are *sessionCacheEntry
+}
+
+type sessionCacheEntry struct {
+	key    tenantSession
+	buffer *memory.ConversationBuffer
+}
+
+func newSessionCache(capacity int) *sessionCache {
+	return &sessionCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[tenantSession]*list.Element),
+	}
+}
+
+// get returns a cached buffer and marks it as recently used
+func (c *sessionCache) get(key tenantSession) (*memory.ConversationBuffer, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*sessionCacheEntry).buffer, true
+}
+
+// addIfAbsent caches buffer unless the session is already cached, in which
+// case the cached buffer wins and is returned. The least recently used
+// entries are evicted to stay within capacity.
+func (c *sessionCache) addIfAbsent(key tenantSession, buffer *memory.ConversationBuffer) *memory.ConversationBuffer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.order.MoveToFront(elem)
+		return elem.Value.(*sessionCacheEntry).buffer
+	}
+
+	c.entries[key] = c.order.PushFront(&sessionCacheEntry{key: key, buffer: buffer})
+	for c.capacity > 0 && c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*sessionCacheEntry).key)
+	}
+	return buffer
+}
+
+// remove drops a session from the cache
+func (c *sessionCache) remove(key tenantSession) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+	}
+}
+
+// keys returns the cached sessions, most recently used first
+func (c *sessionCache) keys() []tenantSession {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := make([]tenantSession, 0, c.order.Len())
+	for elem := c.order.Front(); elem != nil; elem = elem.Next() {
+		keys = append(keys, elem.Value.(*sessionCacheEntry).key)
+	}
+	return keys
+}
+
+// len returns the number of cached sessions
+func (c *sessionCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
@@ -0,0 +1,49 @@
+package memory
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/tmc/langchaingo/memory"
+)
+
+func TestSessionCache(t *testing.T) {
+	tests := []struct {
+		name     string
+		capacity int
+		add      []string
+		get      []string // Touched after every add
+		want     []string // Cached sessions, most recently used first
+	}{
+		{name: "within capacity", capacity: 3, add: []string{"s1", "s2"}, want: []string{"s2", "s1"}},
+		{name: "oldest evicted", capacity: 2, add: []string{"s1", "s2", "s3"}, want: []string{"s3", "s2"}},
+		{name: "recently used kept", capacity: 2, add: []string{"s1", "s2"}, get: []string{"s1"}, want: []string{"s1", "s2"}},
+		{name: "re-added moves to front", capacity: 2, add: []string{"s1", "s2", "s1"}, want: []string{"s1", "s2"}},
+		{name: "unbounded", add: []string{"s1", "s2", "s3"}, want: []string{"s3", "s2", "s1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newSessionCache(tt.capacity)
+			for _, id := range tt.add {
+				c.addIfAbsent(tenantSession{sessionID: id}, memory.NewConversationBuffer())
+			}
+			for _, id := range tt.get {
+				if _, ok := c.get(tenantSession{sessionID: id}); !ok {
+					t.Fatalf("get(%s) missed", id)
+				}
+			}
+
+			var got []string
+			for _, key := range c.keys() {
+				got = append(got, key.sessionID)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("cached = %v, want %v", got, tt.want)
+			}
+			if c.len() != len(tt.want) {
+				t.Errorf("len() = %d, want %d", c.len(), len(tt.want))
+			}
+		})
+	}
+}
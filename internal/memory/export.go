@@ -0,0 +1,190 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// SessionExportVersion is the schema_version written by ExportSession.
+// Bump it whenever the envelope or SessionData changes incompatibly.
+const SessionExportVersion = 1
+
+// SessionExport is the portable envelope for a whole session, including
+// its user ID and metadata. Unlike a RedactedTranscript it isn't scrubbed.
+type SessionExport struct {
+	SchemaVersion int         `json:"schema_version"`
+	ExportedAt    time.Time   `json:"exported_at"`
+	Session       SessionData `json:"session"`
+}
+
+// ExportSession serializes a session's messages and metadata as a
+// versioned SessionExport
+func (m *Manager) ExportSession(ctx context.Context, sessionID string) ([]byte, error) {
+	exists, err := m.store.SessionExists(ctx, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check session: %w", err)
+	}
+	if !exists {
+		return nil, ErrSessionNotFound
+	}
+
+	session, err := m.store.LoadSession(ctx, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load session: %w", err)
+	}
+
+	data, err := json.Marshal(SessionExport{
+		SchemaVersion: SessionExportVersion,
+		ExportedAt:    time.Now().UTC(),
+		Session:       *session,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal session: %w", err)
+	}
+
+	return data, nil
+}
+
+// ImportOption configures ImportSession
+type ImportOption func(*importSettings)
+
+type importSettings struct {
+	newID bool
+}
+
+// ImportAsNewSession stores the imported session under a freshly generated
+// ID instead of the one in the envelope
+func ImportAsNewSession() ImportOption {
+	return func(s *importSettings) {
+		s.newID = true
+	}
+}
+
+// ImportSession writes a session exported by ExportSession into the store
+// and warms the cache, returning the ID it was stored under. Malformed
+// envelopes and other schema versions are rejected, as is an import that
+// would overwrite an existing session unless ImportAsNewSession is given.
+func (m *Manager) ImportSession(ctx context.Context, data []byte, opts ...ImportOption) (string, error) {
+	var settings importSettings
+	for _, opt := range opts {
+		opt(&settings)
+	}
+
+	var export SessionExport
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&export); err != nil {
+		return "", fmt.Errorf("invalid session export: %w", err)
+	}
+	if export.SchemaVersion != SessionExportVersion {
+		return "", fmt.Errorf("unsupported session export schema_version %d, expected %d",
+			export.SchemaVersion, SessionExportVersion)
+	}
+
+	session := export.Session
+	if settings.newID {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return "", fmt.Errorf("failed to generate session ID: %w", err)
+		}
+		session.SessionID = hex.EncodeToString(b)
+	}
+	if session.SessionID == "" {
+		return "", fmt.Errorf("invalid session export: session.session_id is required")
+	}
+	for i, msg := range session.Messages {
+		if msg.Role != "user" && msg.Role != "assistant" && msg.Role != "system" {
+			return "", fmt.Errorf("invalid session export: messages[%d] has unknown role %q", i, msg.Role)
+		}
+	}
+	if session.Messages == nil {
+		session.Messages = []Message{}
+	}
+	session.Metadata.MessageCount = len(session.Messages)
+
+	exists, err := m.store.SessionExists(ctx, session.SessionID)
+	if err != nil {
+		return "", fmt.Errorf("failed to check session: %w", err)
+	}
+	if exists {
+		return "", fmt.Errorf("session %s already exists", session.SessionID)
+	}
+
+	if err := m.store.SaveSession(ctx, &session); err != nil {
+		return "", fmt.Errorf("failed to save session: %w", err)
+	}
+
+	// Load the imported history into the cache
+	m.sessions.remove(cacheKey(ctx, session.SessionID))
+	if _, err := m.GetOrCreateSession(ctx, session.SessionID); err != nil {
+		return "", err
+	}
+
+	m.logger.InfoContext(ctx, "imported session", "session_id", session.SessionID, "messages", len(session.Messages))
+	return session.SessionID, nil
+}
+
+// RedactedTranscript is a PII-scrubbed copy of a session that is safe to
+// attach to support tickets
+type RedactedTranscript struct {
+	SessionID    string            `json:"session_id"`
+	ExportedAt   string            `json:"exported_at"`
+	MessageCount int               `json:"message_count"`
+	Messages     []RedactedMessage `json:"messages"`
+}
+
+// RedactedMessage is a single scrubbed message
+type RedactedMessage struct {
+	Role      string `json:"role"`
+	Content   string `json:"content"`
+	Timestamp string `json:"timestamp"` // UTC, second precision
+}
+
+// ExportRedacted exports a session transcript with PII scrubbed and
+// timestamps normalized to UTC seconds. The user ID is left out entirely.
+// The configured PII classifier is used, or the default patterns when
+// classification is disabled.
+func (m *Manager) ExportRedacted(ctx context.Context, sessionID string) ([]byte, error) {
+	scrubber := m.piiClassifier
+	if scrubber == nil {
+		var err error
+		if scrubber, err = NewPIIClassifier(nil); err != nil {
+			return nil, err
+		}
+	}
+
+	messages, err := m.store.GetMessages(ctx, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load messages: %w", err)
+	}
+
+	transcript := RedactedTranscript{
+		SessionID:    sessionID,
+		ExportedAt:   normalizeTimestamp(time.Now()),
+		MessageCount: len(messages),
+		Messages:     make([]RedactedMessage, 0, len(messages)),
+	}
+	for _, msg := range messages {
+		transcript.Messages = append(transcript.Messages, RedactedMessage{
+			Role:      msg.Role,
+			Content:   scrubber.Redact(msg.Content),
+			Timestamp: normalizeTimestamp(msg.Timestamp),
+		})
+	}
+
+	data, err := json.MarshalIndent(transcript, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal transcript: %w", err)
+	}
+
+	return data, nil
+}
+
+func normalizeTimestamp(t time.Time) string {
+	return t.UTC().Truncate(time.Second).Format(time.RFC3339)
+}
@@ -0,0 +1,85 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportRedacted(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string // Custom PII classifier, nil to use the defaults
+		messages []string // Alternating user and assistant messages
+		want     []string // Exported content
+	}{
+		{
+			name:     "email and key",
+			messages: []string{"I'm ops@example.com, key sk_live_abcdefghijklmnop1234", "Thanks, which service?"},
+			want:     []string{"I'm [REDACTED], key [REDACTED]", "Thanks, which service?"},
+		},
+		{
+			name:     "phone number",
+			messages: []string{"call me on +1 415-555-0100", "Will do"},
+			want:     []string{"call me on [REDACTED]", "Will do"},
+		},
+		{
+			name:     "no PII",
+			messages: []string{"purge the cache", "Which service?", "example.com"},
+			want:     []string{"purge the cache", "Which service?", "example.com"},
+		},
+		{
+			name:     "configured classifier",
+			patterns: []string{`ACME-\d+`},
+			messages: []string{"account ACME-42, ops@example.com"},
+			want:     []string{"account [REDACTED], ops@example.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts []Option
+			if tt.patterns != nil {
+				classifier, err := NewPIIClassifier(tt.patterns)
+				if err != nil {
+					t.Fatal(err)
+				}
+				opts = append(opts, WithPIIClassifier(classifier))
+			}
+			m, _ := newTestManager(t, opts...)
+			saveTurns(t, m, "s1", tt.messages...)
+
+			data, err := m.ExportRedacted(context.Background(), "s1")
+			if err != nil {
+				t.Fatalf("ExportRedacted() error = %v", err)
+			}
+			if strings.Contains(string(data), "user1") {
+				t.Errorf("export contains the user ID:\n%s", data)
+			}
+
+			var transcript RedactedTranscript
+			if err := json.Unmarshal(data, &transcript); err != nil {
+				t.Fatal(err)
+			}
+			if transcript.SessionID != "s1" || transcript.MessageCount != len(tt.want) || len(transcript.Messages) != len(tt.want) {
+				t.Fatalf("transcript for %q has %d messages (count %d), want s1 with %d",
+					transcript.SessionID, len(transcript.Messages), transcript.MessageCount, len(tt.want))
+			}
+			for i, msg := range transcript.Messages {
+				wantRole := "user"
+				if i%2 == 1 {
+					wantRole = "assistant"
+				}
+				if msg.Role != wantRole || msg.Content != tt.want[i] {
+					t.Errorf("message %d = %s %q, want %s %q", i, msg.Role, msg.Content, wantRole, tt.want[i])
+				}
+				ts, err := time.Parse(time.RFC3339, msg.Timestamp)
+				if err != nil || !strings.HasSuffix(msg.Timestamp, "Z") || ts.Nanosecond() != 0 {
+					t.Errorf("message %d timestamp = %q, want UTC seconds", i, msg.Timestamp)
+				}
+			}
+		})
+	}
+}
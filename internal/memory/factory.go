@@ -0,0 +1,57 @@
+package memory
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/config"
+)
+
+// NewStore connects to the session store selected by cfg.StoreBackend. A
+// Redis store uses the topology in REDIS_MODE and the configured TLS
+// settings. Sessions expire after ttl of inactivity.
+func NewStore(cfg *config.Config, ttl time.Duration) (Store, error) {
+	switch cfg.StoreBackend {
+	case config.StoreBackendPostgres:
+		store, err := NewPostgresStore(cfg.PostgresDSN, ttl)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	case config.StoreBackendMemory:
+		return NewInMemoryStore(ttl), nil
+	default:
+		store, err := NewRedisStore(cfg.RedisURL, ttl, redisOptions(cfg)...)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	}
+}
+
+// NewStoreWithFallback is NewStore with REDIS_OPTIONAL applied: if Redis
+// can't be reached it logs a warning and returns an in-memory store, which
+// is kept until the service restarts. Other failures are returned as is.
+func NewStoreWithFallback(cfg *config.Config, ttl time.Duration, logger *slog.Logger) (Store, error) {
+	store, err := NewStore(cfg, ttl)
+	if err == nil || cfg.StoreBackend != config.StoreBackendRedis || !cfg.RedisOptional {
+		return store, err
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger.Warn("failed to connect to Redis, falling back to the in-memory store", "error", err)
+	return NewInMemoryStore(ttl), nil
+}
+
+// redisOptions returns the connection options cfg sets for Redis
+func redisOptions(cfg *config.Config) []RedisOption {
+	opts := []RedisOption{WithRedisTLS(cfg.RedisTLSCAFile, cfg.RedisTLSSkipVerify)}
+	switch cfg.RedisMode {
+	case config.RedisModeSentinel:
+		opts = append(opts, WithRedisSentinel(cfg.RedisSentinelMaster, cfg.RedisAddrs))
+	case config.RedisModeCluster:
+		opts = append(opts, WithRedisCluster(cfg.RedisAddrs))
+	}
+	return opts
+}
@@ -0,0 +1,256 @@
+package memory
+
+import (
+	"bytes"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/avvvet/cdnbuddy-intent/internal/config"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestNewStore(t *testing.T) {
+	mr := miniredis.RunT(t)
+	tests := []struct {
+		name    string
+		cfg     config.Config
+		want    string // Store type, empty when an error is expected
+		wantErr bool
+	}{
+		{name: "memory", cfg: config.Config{StoreBackend: config.StoreBackendMemory}, want: "*memory.InMemoryStore"},
+		{name: "redis", cfg: config.Config{StoreBackend: config.StoreBackendRedis, RedisURL: "redis://" + mr.Addr()}, want: "*memory.RedisStore"},
+		{name: "redis standalone mode", cfg: config.Config{StoreBackend: config.StoreBackendRedis, RedisMode: config.RedisModeStandalone, RedisURL: "redis://" + mr.Addr()}, want: "*memory.RedisStore"},
+		{name: "redis sentinel without addresses", cfg: config.Config{StoreBackend: config.StoreBackendRedis, RedisMode: config.RedisModeSentinel, RedisURL: "redis://" + mr.Addr()}, wantErr: true},
+		{name: "redis cluster without addresses", cfg: config.Config{StoreBackend: config.StoreBackendRedis, RedisMode: config.RedisModeCluster, RedisURL: "redis://" + mr.Addr()}, wantErr: true},
+		{name: "postgres unreachable", cfg: config.Config{StoreBackend: config.StoreBackendPostgres, PostgresDSN: "postgres://intent@127.0.0.1:1/intent"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := NewStore(&tt.cfg, time.Hour)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewStore() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if store != nil {
+					t.Errorf("NewStore() returned %T with an error, want nil", store)
+				}
+				return
+			}
+			if got := fmt.Sprintf("%T", store); got != tt.want {
+				t.Errorf("NewStore() = %s, want %s", got, tt.want)
+			}
+			if closer, ok := store.(io.Closer); ok {
+				closer.Close()
+			}
+		})
+	}
+}
+
+func TestNewStoreWithFallback(t *testing.T) {
+	mr := miniredis.RunT(t)
+	down := miniredis.RunT(t)
+	downURL := "redis://" + down.Addr()
+	down.Close()
+
+	tests := []struct {
+		name         string
+		cfg          config.Config
+		want         string // Store type, empty when an error is expected
+		wantFallback bool   // Expect the fallback warning
+	}{
+		{name: "redis up", cfg: config.Config{StoreBackend: config.StoreBackendRedis, RedisURL: "redis://" + mr.Addr(), RedisOptional: true}, want: "*memory.RedisStore"},
+		{name: "redis down and optional", cfg: config.Config{StoreBackend: config.StoreBackendRedis, RedisURL: downURL, RedisOptional: true}, want: "*memory.InMemoryStore", wantFallback: true},
+		{name: "redis down and required", cfg: config.Config{StoreBackend: config.StoreBackendRedis, RedisURL: downURL}},
+		{name: "postgres down", cfg: config.Config{StoreBackend: config.StoreBackendPostgres, PostgresDSN: "postgres://intent@127.0.0.1:1/intent", RedisOptional: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			store, err := NewStoreWithFallback(&tt.cfg, time.Hour, slog.New(slog.NewTextHandler(&logs, nil)))
+			if (err != nil) != (tt.want == "") {
+				t.Fatalf("NewStoreWithFallback() error = %v, want store %q", err, tt.want)
+			}
+			if err == nil {
+				if got := fmt.Sprintf("%T", store); got != tt.want {
+					t.Errorf("NewStoreWithFallback() = %s, want %s", got, tt.want)
+				}
+				if closer, ok := store.(io.Closer); ok {
+					closer.Close()
+				}
+			}
+			if warned := strings.Contains(logs.String(), "falling back"); warned != tt.wantFallback {
+				t.Errorf("fallback warning logged = %v, want %v", warned, tt.wantFallback)
+			}
+		})
+	}
+}
+
+// redisSettingsFor applies the options NewStore would use for cfg
+func redisSettingsFor(t *testing.T, cfg *config.Config) (*redisSettings, error) {
+	t.Helper()
+	opt, err := redis.ParseURL(cfg.RedisURL)
+	if err != nil {
+		t.Fatalf("ParseURL() error = %v", err)
+	}
+	settings := &redisSettings{options: opt}
+	for _, apply := range redisOptions(cfg) {
+		if err := apply(settings); err != nil {
+			return nil, err
+		}
+	}
+	return settings, nil
+}
+
+// writeTestCA writes a CA certificate in PEM form and returns its path
+func writeTestCA(t *testing.T) string {
+	t.Helper()
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	server.Close()
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRedisOptionsTLS(t *testing.T) {
+	tests := []struct {
+		name         string
+		cfg          config.Config
+		ca           bool // Set RedisTLSCAFile to a valid bundle
+		wantTLS      bool
+		wantCAs      bool
+		wantInsecure bool
+		wantUser     string
+		wantErr      bool
+	}{
+		{name: "plain", cfg: config.Config{RedisURL: "redis://cache:6379/0"}},
+		{name: "rediss scheme", cfg: config.Config{RedisURL: "rediss://cache:6380/0"}, wantTLS: true},
+		{name: "CA file on a redis URL", cfg: config.Config{RedisURL: "redis://cache:6379/0"}, ca: true, wantTLS: true, wantCAs: true},
+		{name: "skip verify", cfg: config.Config{RedisURL: "rediss://cache:6380/0", RedisTLSSkipVerify: true}, wantTLS: true, wantInsecure: true},
+		{name: "skip verify alone", cfg: config.Config{RedisURL: "redis://cache:6379/0", RedisTLSSkipVerify: true}},
+		{name: "ACL credentials", cfg: config.Config{RedisURL: "rediss://intent:pw@cache:6380/0"}, wantTLS: true, wantUser: "intent"},
+		{name: "missing CA file", cfg: config.Config{RedisURL: "rediss://cache:6380/0", RedisTLSCAFile: "/nonexistent/ca.pem"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.ca {
+				tt.cfg.RedisTLSCAFile = writeTestCA(t)
+			}
+			settings, err := redisSettingsFor(t, &tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("redisOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			tlsConfig := settings.options.TLSConfig
+			if (tlsConfig != nil) != tt.wantTLS {
+				t.Fatalf("TLS config = %+v, want TLS %v", tlsConfig, tt.wantTLS)
+			}
+			if tlsConfig != nil {
+				if tlsConfig.ServerName != "cache" {
+					t.Errorf("server name = %q, want cache", tlsConfig.ServerName)
+				}
+				if (tlsConfig.RootCAs != nil) != tt.wantCAs {
+					t.Errorf("root CAs set = %v, want %v", tlsConfig.RootCAs != nil, tt.wantCAs)
+				}
+				if tlsConfig.InsecureSkipVerify != tt.wantInsecure {
+					t.Errorf("skip verify = %v, want %v", tlsConfig.InsecureSkipVerify, tt.wantInsecure)
+				}
+			}
+			if settings.options.Username != tt.wantUser {
+				t.Errorf("username = %q, want %q", settings.options.Username, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestRedisOptionsTopology(t *testing.T) {
+	tests := []struct {
+		name           string
+		cfg            config.Config
+		wantClient     string
+		wantAddrs      []string
+		wantMaster     string
+		wantServerName string // TLS server name, for rediss URLs
+	}{
+		{
+			name:       "standalone",
+			cfg:        config.Config{RedisURL: "redis://cache:6379/2", RedisMode: config.RedisModeStandalone},
+			wantClient: "*redis.Client",
+			wantAddrs:  []string{"cache:6379"},
+		},
+		{
+			name:       "sentinel",
+			cfg:        config.Config{RedisURL: "redis://ignored:6379/2", RedisMode: config.RedisModeSentinel, RedisSentinelMaster: "intent", RedisAddrs: []string{"s1:26379", "s2:26379"}},
+			wantClient: "*redis.Client",
+			wantAddrs:  []string{"s1:26379", "s2:26379"},
+			wantMaster: "intent",
+		},
+		{
+			name:       "cluster",
+			cfg:        config.Config{RedisURL: "redis://ignored:6379/0", RedisMode: config.RedisModeCluster, RedisAddrs: []string{"n1:6379", "n2:6379"}},
+			wantClient: "*redis.ClusterClient",
+			wantAddrs:  []string{"n1:6379", "n2:6379"},
+		},
+		{
+			name:           "standalone over TLS",
+			cfg:            config.Config{RedisURL: "rediss://cache:6380/0"},
+			wantClient:     "*redis.Client",
+			wantAddrs:      []string{"cache:6380"},
+			wantServerName: "cache",
+		},
+		{
+			name:       "cluster over TLS verifies each node",
+			cfg:        config.Config{RedisURL: "rediss://ignored:6380/0", RedisMode: config.RedisModeCluster, RedisAddrs: []string{"n1:6380"}},
+			wantClient: "*redis.ClusterClient",
+			wantAddrs:  []string{"n1:6380"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings, err := redisSettingsFor(t, &tt.cfg)
+			if err != nil {
+				t.Fatalf("redisOptions() error = %v", err)
+			}
+			universal := settings.universalOptions()
+
+			if !slices.Equal(universal.Addrs, tt.wantAddrs) {
+				t.Errorf("addrs = %v, want %v", universal.Addrs, tt.wantAddrs)
+			}
+			if universal.MasterName != tt.wantMaster {
+				t.Errorf("master name = %q, want %q", universal.MasterName, tt.wantMaster)
+			}
+			if universal.DB != settings.options.DB {
+				t.Errorf("db = %d, want %d from the URL", universal.DB, settings.options.DB)
+			}
+			if universal.TLSConfig != nil && universal.TLSConfig.ServerName != tt.wantServerName {
+				t.Errorf("TLS server name = %q, want %q", universal.TLSConfig.ServerName, tt.wantServerName)
+			}
+
+			client := settings.newClient()
+			defer client.Close()
+			if got := fmt.Sprintf("%T", client); got != tt.wantClient {
+				t.Errorf("client = %s, want %s", got, tt.wantClient)
+			}
+		})
+	}
+}
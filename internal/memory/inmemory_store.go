@@ -0,0 +1,278 @@
+package memory
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// InMemoryStore implements Store interface in process memory. It needs no
+// external services, which makes it suitable for tests and local
+// development; data is lost on restart. Maps are keyed by the scoped
+// session ID, so tenants' sessions don't collide.
+type InMemoryStore struct {
+	mu          sync.Mutex
+	sessions    map[string]*inMemorySession
+	checkpoints map[string][]Checkpoint // Newest first
+	ttl         time.Duration           // Session TTL (time to live)
+}
+
+// inMemorySession is a stored session and when it expires
+type inMemorySession struct {
+	data      SessionData
+	tenant    string
+	expiresAt time.Time
+}
+
+// NewInMemoryStore creates a new in-memory store
+func NewInMemoryStore(ttl time.Duration) *InMemoryStore {
+	return &InMemoryStore{
+		sessions:    make(map[string]*inMemorySession),
+		checkpoints: make(map[string][]Checkpoint),
+		ttl:         ttl,
+	}
+}
+
+// get returns a live session by its scoped ID, dropping it if it has
+// expired. Callers must hold the lock.
+func (s *InMemoryStore) get(key string) (*inMemorySession, bool) {
+	session, ok := s.sessions[key]
+	if !ok {
+		return nil, false
+	}
+	if !time.Now().Before(session.expiresAt) {
+		delete(s.sessions, key)
+		delete(s.checkpoints, key)
+		return nil, false
+	}
+	return session, true
+}
+
+// load returns a copy of a session, or an empty session on a miss like
+// RedisStore. Callers must hold the lock.
+func (s *InMemoryStore) load(ctx context.Context, sessionID string) *SessionData {
+	session, ok := s.get(scopedSessionID(ctx, sessionID))
+	if !ok {
+		return &SessionData{
+			SessionID: sessionID,
+			Messages:  []Message{},
+			Metadata: Metadata{
+				StartedAt:    time.Now(),
+				LastActivity: time.Now(),
+				MessageCount: 0,
+			},
+		}
+	}
+	return copySession(&session.data)
+}
+
+// put stores a copy of a session and refreshes its TTL. Callers must hold
+// the lock.
+func (s *InMemoryStore) put(ctx context.Context, session *SessionData) {
+	data := copySession(session)
+	ttl := applySessionTTL(ctx, &data.Metadata, s.ttl)
+	s.sessions[scopedSessionID(ctx, session.SessionID)] = &inMemorySession{
+		data:      *data,
+		tenant:    TenantFromContext(ctx),
+		expiresAt: time.Now().Add(ttl),
+	}
+}
+
+// copySession deep-copies the parts of a session callers may mutate
+func copySession(session *SessionData) *SessionData {
+	c := *session
+	c.Messages = append([]Message{}, session.Messages...)
+	c.Metadata.Slots = maps.Clone(session.Metadata.Slots)
+	c.Metadata.PendingParameters = maps.Clone(session.Metadata.PendingParameters)
+	if session.Metadata.RoutedParameters != nil {
+		c.Metadata.RoutedParameters = make(map[string]map[string]string, len(session.Metadata.RoutedParameters))
+		for action, params := range session.Metadata.RoutedParameters {
+			c.Metadata.RoutedParameters[action] = maps.Clone(params)
+		}
+	}
+	c.Metadata.ReadyTurns = slices.Clone(session.Metadata.ReadyTurns)
+	return &c
+}
+
+// LoadSession loads a session, returning an empty one if it doesn't exist
+func (s *InMemoryStore) LoadSession(ctx context.Context, sessionID string) (*SessionData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.load(ctx, sessionID), nil
+}
+
+// SaveMessage appends a message to a session
+func (s *InMemoryStore) SaveMessage(ctx context.Context, sessionID, userID string, msg Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session := s.load(ctx, sessionID)
+
+	// Set user ID if this is a new session
+	if session.UserID == "" {
+		session.UserID = userID
+	}
+
+	session.Messages = append(session.Messages, msg)
+	session.Metadata.LastActivity = time.Now()
+	session.Metadata.MessageCount = len(session.Messages)
+
+	// If this is the first message, set started_at
+	if session.Metadata.MessageCount == 1 {
+		session.Metadata.StartedAt = msg.Timestamp
+	}
+
+	s.put(ctx, session)
+	return nil
+}
+
+// GetMessages retrieves all messages for a session
+func (s *InMemoryStore) GetMessages(ctx context.Context, sessionID string) ([]Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.load(ctx, sessionID).Messages, nil
+}
+
+// GetMessagesPage retrieves one page of a session's messages
+func (s *InMemoryStore) GetMessagesPage(ctx context.Context, sessionID string, offset, limit int) ([]Message, int, error) {
+	if offset < 0 || limit <= 0 {
+		return nil, 0, ErrInvalidPage
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	messages := s.load(ctx, sessionID).Messages
+	total := len(messages)
+	if offset >= total {
+		return []Message{}, total, nil
+	}
+	end := min(offset+limit, total)
+	return messages[offset:end], total, nil
+}
+
+// ClearSession removes a session and its checkpoints
+func (s *InMemoryStore) ClearSession(ctx context.Context, sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := scopedSessionID(ctx, sessionID)
+	delete(s.sessions, key)
+	delete(s.checkpoints, key)
+	return nil
+}
+
+// SessionExists checks if an unexpired session exists
+func (s *InMemoryStore) SessionExists(ctx context.Context, sessionID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.get(scopedSessionID(ctx, sessionID))
+	return ok, nil
+}
+
+// UpdateActivity updates the last activity timestamp and refreshes TTL
+func (s *InMemoryStore) UpdateActivity(ctx context.Context, sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.get(scopedSessionID(ctx, sessionID))
+	if !ok {
+		return nil
+	}
+	session.data.Metadata.LastActivity = time.Now()
+	session.expiresAt = time.Now().Add(applySessionTTL(ctx, &session.data.Metadata, s.ttl))
+	return nil
+}
+
+// SaveSession overwrites a session with the given data
+func (s *InMemoryStore) SaveSession(ctx context.Context, session *SessionData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.put(ctx, session)
+	return nil
+}
+
+// SaveCheckpoint stores a snapshot, keeping only the newest maxCount
+func (s *InMemoryStore) SaveCheckpoint(ctx context.Context, sessionID string, checkpoint Checkpoint, maxCount int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := scopedSessionID(ctx, sessionID)
+	checkpoints := append([]Checkpoint{checkpoint}, s.checkpoints[key]...)
+	if maxCount > 0 && len(checkpoints) > maxCount {
+		checkpoints = checkpoints[:maxCount]
+	}
+	s.checkpoints[key] = checkpoints
+	return nil
+}
+
+// LoadCheckpoint retrieves a snapshot by ID
+func (s *InMemoryStore) LoadCheckpoint(ctx context.Context, sessionID, checkpointID string) (*Checkpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, checkpoint := range s.checkpoints[scopedSessionID(ctx, sessionID)] {
+		if checkpoint.ID == checkpointID {
+			return &checkpoint, nil
+		}
+	}
+	return nil, ErrCheckpointNotFound
+}
+
+// CountActiveUserSessions counts a user's sessions that haven't expired
+func (s *InMemoryStore) CountActiveUserSessions(ctx context.Context, userID string) (int, error) {
+	sessionIDs, err := s.ListSessionsByUser(ctx, userID)
+	return len(sessionIDs), err
+}
+
+// ListSessionsByUser returns the IDs of a user's unexpired sessions within
+// the tenant in ctx
+func (s *InMemoryStore) ListSessionsByUser(ctx context.Context, userID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tenant := TenantFromContext(ctx)
+	sessionIDs := []string{}
+	for key, session := range s.sessions {
+		if session.data.UserID != userID || session.tenant != tenant {
+			continue
+		}
+		if _, ok := s.get(key); ok {
+			sessionIDs = append(sessionIDs, session.data.SessionID)
+		}
+	}
+
+	sort.Strings(sessionIDs)
+	return sessionIDs, nil
+}
+
+// AddUsage adds token counts to the session's running totals
+func (s *InMemoryStore) AddUsage(ctx context.Context, sessionID string, inputTokens, outputTokens int) error {
+	return s.Transaction(ctx, sessionID, func(session *SessionData) error {
+		session.Metadata.TotalInputTokens += inputTokens
+		session.Metadata.TotalOutputTokens += outputTokens
+		return nil
+	})
+}
+
+// Transaction applies fn to a copy of the session under the store lock and
+// saves it only if fn succeeds
+func (s *InMemoryStore) Transaction(ctx context.Context, sessionID string, fn func(session *SessionData) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session := s.load(ctx, sessionID)
+	if err := fn(session); err != nil {
+		return err
+	}
+
+	s.put(ctx, session)
+	return nil
+}
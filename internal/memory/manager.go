@@ -2,8 +2,14 @@ package memory
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"maps"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/avvvet/cdnbuddy-intent/internal/models"
@@ -13,25 +19,144 @@ import (
 
 // Manager orchestrates conversation memory using Redis + LangChainGo
 type Manager struct {
-	store         Store
-	sessions      map[string]*memory.ConversationBuffer // In-memory cache
-	defaultUserID string
+	store           Store
+	sessions        *sessionCache // In-memory LRU cache of conversation buffers
+	cacheBuffers    bool          // Only set when this process owns the store
+	cacheSize       int
+	janitorInterval time.Duration // How often expired sessions are dropped from the cache, 0 disables it
+	stop            chan struct{} // Closed by Close to stop the janitor
+	closeOnce       sync.Once
+	defaultUserID   string
+	maxHistoryBytes int            // 0 means unlimited
+	piiClassifier   *PIIClassifier // nil disables PII classification
+	maxCheckpoints  int            // Checkpoints kept per session
+	maxUserSessions int            // 0 means unlimited
+
+	// Summarization of old turns, disabled when summarizer is nil
+	summarizer        Summarizer
+	summarizeAfter    int // Message count that triggers summarization
+	summaryKeepRecent int // Messages left verbatim after summarizing
+
+	historyTimestamps bool // Prefix formatted history lines with when they were sent
+
+	logger *slog.Logger
 }
 
-// NewManager creates a new memory manager
-func NewManager(store Store) *Manager {
-	return &Manager{
-		store:         store,
-		sessions:      make(map[string]*memory.ConversationBuffer),
-		defaultUserID: "default_user",
+// Summarizer condenses a formatted transcript into a short summary
+type Summarizer interface {
+	Summarize(ctx context.Context, transcript string) (string, error)
+}
+
+// Option configures optional Manager behaviour
+type Option func(*Manager)
+
+// WithMaxHistoryBytes caps the size of the history returned by
+// GetFormattedHistory. Oldest messages are dropped first; system messages
+// and the most recent turn are always kept. Zero disables the cap.
+func WithMaxHistoryBytes(n int) Option {
+	return func(m *Manager) {
+		m.maxHistoryBytes = n
+	}
+}
+
+// WithPIIClassifier flags stored messages that contain PII so they can be
+// handled by retention policies
+func WithPIIClassifier(c *PIIClassifier) Option {
+	return func(m *Manager) {
+		m.piiClassifier = c
+	}
+}
+
+// WithMaxCheckpoints bounds how many checkpoints are kept per session
+func WithMaxCheckpoints(n int) Option {
+	return func(m *Manager) {
+		m.maxCheckpoints = n
+	}
+}
+
+// WithMaxSessionsPerUser caps how many active sessions a single user may
+// hold. Existing sessions keep working; only new ones are rejected.
+func WithMaxSessionsPerUser(n int) Option {
+	return func(m *Manager) {
+		m.maxUserSessions = n
+	}
+}
+
+// WithSummarizer replaces all but the newest keepRecent messages with a
+// single system summary once a session holds more than after messages
+func WithSummarizer(s Summarizer, after, keepRecent int) Option {
+	return func(m *Manager) {
+		m.summarizer = s
+		m.summarizeAfter = after
+		m.summaryKeepRecent = keepRecent
+	}
+}
+
+// WithSessionCacheSize bounds how many conversation buffers are cached in
+// memory. Least recently used sessions are evicted and reloaded from the
+// store on their next access. Zero leaves the cache unbounded. Buffers are
+// only cached in front of an in-memory store; see NewManager.
+func WithSessionCacheSize(n int) Option {
+	return func(m *Manager) {
+		m.cacheSize = n
+	}
+}
+
+// WithCacheJanitor periodically drops cached sessions that no longer exist
+// in the store, for example because their TTL expired. Zero disables it.
+func WithCacheJanitor(interval time.Duration) Option {
+	return func(m *Manager) {
+		m.janitorInterval = interval
+	}
+}
+
+// WithHistoryTimestamps prefixes each line of the formatted history with
+// the time the message was sent, so the model can reason about gaps in the
+// conversation. The timestamps come from the store rather than the cache.
+func WithHistoryTimestamps(enabled bool) Option {
+	return func(m *Manager) {
+		m.historyTimestamps = enabled
 	}
 }
 
+// WithLogger sets the structured logger, slog.Default() otherwise
+func WithLogger(l *slog.Logger) Option {
+	return func(m *Manager) {
+		m.logger = l
+	}
+}
+
+// NewManager creates a new memory manager. Conversation buffers are only
+// cached when the store is an InMemoryStore: a Redis or Postgres store is
+// shared with other replicas, whose writes and undos a cached buffer would
+// never see, so every access reloads from the store instead.
+func NewManager(store Store, opts ...Option) *Manager {
+	m := &Manager{
+		store:          store,
+		defaultUserID:  "default_user",
+		maxCheckpoints: 10,
+		cacheSize:      DefaultSessionCacheSize,
+		logger:         slog.Default(),
+		stop:           make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	m.sessions = newSessionCache(m.cacheSize)
+	_, m.cacheBuffers = store.(*InMemoryStore)
+	if m.cacheBuffers && m.janitorInterval > 0 {
+		go m.runJanitor()
+	}
+	return m
+}
+
 // GetOrCreateSession gets or creates a LangChainGo memory buffer for a session
 func (m *Manager) GetOrCreateSession(ctx context.Context, sessionID string) (*memory.ConversationBuffer, error) {
 	// Check if we already have it in cache
-	if mem, exists := m.sessions[sessionID]; exists {
-		return mem, nil
+	if m.cacheBuffers {
+		if mem, exists := m.sessions.get(cacheKey(ctx, sessionID)); exists {
+			return mem, nil
+		}
 	}
 
 	// Create new LangChainGo conversation buffer
@@ -55,7 +180,7 @@ func (m *Manager) GetOrCreateSession(ctx context.Context, sessionID string) (*me
 		case "system":
 			chatMsg = llms.SystemChatMessage{Content: msg.Content}
 		default:
-			log.Printf("⚠️ Unknown message role: %s, skipping", msg.Role)
+			m.logger.WarnContext(ctx, "skipping message with unknown role", "session_id", sessionID, "role", msg.Role)
 			continue
 		}
 
@@ -65,16 +190,24 @@ func (m *Manager) GetOrCreateSession(ctx context.Context, sessionID string) (*me
 		}
 	}
 
-	// Cache it
-	m.sessions[sessionID] = mem
+	// Cache it, unless a concurrent request for the session beat us to it
+	if m.cacheBuffers {
+		mem = m.sessions.addIfAbsent(cacheKey(ctx, sessionID), mem)
+	}
 
-	log.Printf("📚 Loaded session %s with %d messages", sessionID, len(sessionData.Messages))
+	m.logger.DebugContext(ctx, "loaded session", "session_id", sessionID, "messages", len(sessionData.Messages))
 
 	return mem, nil
 }
 
-// SaveUserMessage saves a user message to both Redis and LangChainGo memory
+// SaveUserMessage saves a user message to both Redis and LangChainGo memory.
+// It returns ErrSessionLimitExceeded if this would open a new session for a
+// user already at the per-user cap.
 func (m *Manager) SaveUserMessage(ctx context.Context, sessionID, userID, message string) error {
+	if err := m.checkSessionLimit(ctx, sessionID, userID); err != nil {
+		return err
+	}
+
 	// Get or create session
 	mem, err := m.GetOrCreateSession(ctx, sessionID)
 	if err != nil {
@@ -87,17 +220,13 @@ func (m *Manager) SaveUserMessage(ctx context.Context, sessionID, userID, messag
 	}
 
 	// Save to Redis
-	msg := Message{
-		Role:      "user",
-		Content:   message,
-		Timestamp: time.Now(),
-	}
+	msg := m.newMessage("user", message)
 
 	if err := m.store.SaveMessage(ctx, sessionID, userID, msg); err != nil {
 		return fmt.Errorf("failed to save message to Redis: %w", err)
 	}
 
-	log.Printf("💾 Saved user message to session %s", sessionID)
+	m.logger.DebugContext(ctx, "saved user message", "session_id", sessionID)
 
 	return nil
 }
@@ -116,21 +245,105 @@ func (m *Manager) SaveAssistantMessage(ctx context.Context, sessionID, userID, m
 	}
 
 	// Save to Redis
-	msg := Message{
-		Role:      "assistant",
-		Content:   message,
-		Timestamp: time.Now(),
-	}
+	msg := m.newMessage("assistant", message)
 
 	if err := m.store.SaveMessage(ctx, sessionID, userID, msg); err != nil {
 		return fmt.Errorf("failed to save message to Redis: %w", err)
 	}
 
-	log.Printf("💾 Saved assistant message to session %s", sessionID)
+	m.logger.DebugContext(ctx, "saved assistant message", "session_id", sessionID)
+
+	// Summarize in the background once the turn is complete, so the reply
+	// isn't held up by a second model call
+	if m.summarizer != nil && m.summarizeAfter > 0 {
+		go m.summarizeInBackground(context.WithoutCancel(ctx), sessionID)
+	}
+
+	return nil
+}
+
+// SaveSystemMessages stores system messages, such as a tenant policy
+// preamble sent with the request history, that the session doesn't already
+// hold. Resending the same preamble every turn therefore stores it once.
+func (m *Manager) SaveSystemMessages(ctx context.Context, sessionID, userID string, messages []string) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	if err := m.checkSessionLimit(ctx, sessionID, userID); err != nil {
+		return err
+	}
+
+	stored, err := m.store.GetMessages(ctx, sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to load messages: %w", err)
+	}
+	seen := make(map[string]bool)
+	for _, msg := range stored {
+		if msg.Role == "system" {
+			seen[msg.Content] = true
+		}
+	}
+
+	mem, err := m.GetOrCreateSession(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	for _, message := range messages {
+		if seen[message] || strings.TrimSpace(message) == "" {
+			continue
+		}
+		seen[message] = true
+
+		if err := mem.ChatHistory.AddMessage(ctx, llms.SystemChatMessage{Content: message}); err != nil {
+			return fmt.Errorf("failed to add system message to memory: %w", err)
+		}
+		if err := m.store.SaveMessage(ctx, sessionID, userID, m.newMessage("system", message)); err != nil {
+			return fmt.Errorf("failed to save message to Redis: %w", err)
+		}
+		m.logger.DebugContext(ctx, "saved system message", "session_id", sessionID)
+	}
+	return nil
+}
+
+// checkSessionLimit rejects new sessions for users at the per-user cap
+func (m *Manager) checkSessionLimit(ctx context.Context, sessionID, userID string) error {
+	if m.maxUserSessions <= 0 {
+		return nil
+	}
+
+	exists, err := m.store.SessionExists(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	active, err := m.store.CountActiveUserSessions(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if active >= m.maxUserSessions {
+		m.logger.WarnContext(ctx, "rejected new session over per-user limit", "session_id", sessionID, "user_id", userID, "active", active)
+		return ErrSessionLimitExceeded
+	}
 
 	return nil
 }
 
+// newMessage builds a message for storage, classifying it for PII when enabled
+func (m *Manager) newMessage(role, content string) Message {
+	msg := Message{
+		Role:      role,
+		Content:   content,
+		Timestamp: time.Now(),
+	}
+	if m.piiClassifier != nil {
+		msg.ContainsPII = m.piiClassifier.ContainsPII(content)
+	}
+	return msg
+}
+
 // LoadHistoryFromRequest loads conversation history from IntentRequest
 // This is useful when API Server sends existing history
 func (m *Manager) LoadHistoryFromRequest(ctx context.Context, sessionID string, history []models.ConversationMessage) error {
@@ -152,6 +365,8 @@ func (m *Manager) LoadHistoryFromRequest(ctx context.Context, sessionID string,
 			chatMsg = llms.HumanChatMessage{Content: msg.Message}
 		case "assistant":
 			chatMsg = llms.AIChatMessage{Content: msg.Message}
+		case "system":
+			chatMsg = llms.SystemChatMessage{Content: msg.Message}
 		default:
 			continue
 		}
@@ -161,7 +376,7 @@ func (m *Manager) LoadHistoryFromRequest(ctx context.Context, sessionID string,
 		}
 	}
 
-	log.Printf("📥 Loaded %d messages from request into session %s", len(history), sessionID)
+	m.logger.DebugContext(ctx, "loaded history from request", "session_id", sessionID, "messages", len(history))
 
 	return nil
 }
@@ -169,34 +384,274 @@ func (m *Manager) LoadHistoryFromRequest(ctx context.Context, sessionID string,
 // GetFormattedHistory returns conversation history as a formatted string
 // This is used for building prompts
 func (m *Manager) GetFormattedHistory(ctx context.Context, sessionID string) (string, error) {
-	mem, err := m.GetOrCreateSession(ctx, sessionID)
+	lines, err := m.historyLines(ctx, sessionID)
 	if err != nil {
 		return "", err
 	}
 
-	messages, err := mem.ChatHistory.Messages(ctx)
+	if len(lines) == 0 {
+		return "No previous conversation.", nil
+	}
+
+	if m.maxHistoryBytes > 0 {
+		before := len(lines)
+		lines = fitHistory(lines, m.maxHistoryBytes)
+		if dropped := before - len(lines); dropped > 0 {
+			m.logger.InfoContext(ctx, "dropped oldest messages to fit history cap", "session_id", sessionID, "dropped", dropped, "max_bytes", m.maxHistoryBytes)
+		}
+	}
+
+	return joinHistory(lines), nil
+}
+
+// GetTruncatedHistory returns the formatted history limited to roughly
+// maxTokens, estimated at four characters per token. The most recent
+// messages are kept, plus the first system message if there is one.
+// A maxTokens of zero or less skips the token budget. The byte cap from
+// WithMaxHistoryBytes still applies.
+func (m *Manager) GetTruncatedHistory(ctx context.Context, sessionID string, maxTokens int) (string, error) {
+	messages, err := m.GetTruncatedMessages(ctx, sessionID, maxTokens)
 	if err != nil {
-		return "", fmt.Errorf("failed to get messages: %w", err)
+		return "", err
 	}
+	return m.FormatHistory(messages), nil
+}
 
-	if len(messages) == 0 {
-		return "No previous conversation.", nil
+// GetTruncatedMessages returns the session history as role-tagged messages,
+// trimmed by the same rules as GetTruncatedHistory. Providers that take a
+// message list use it instead of the formatted transcript, and can format
+// the same messages with FormatHistory.
+func (m *Manager) GetTruncatedMessages(ctx context.Context, sessionID string, maxTokens int) ([]Message, error) {
+	lines, err := m.historyLines(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.maxHistoryBytes > 0 {
+		lines = fitHistory(lines, m.maxHistoryBytes)
+	}
+	if maxTokens > 0 {
+		before := len(lines)
+		lines = truncateHistory(lines, maxTokens)
+		if dropped := before - len(lines); dropped > 0 {
+			m.logger.InfoContext(ctx, "dropped oldest messages to fit token budget", "session_id", sessionID, "dropped", dropped, "max_tokens", maxTokens)
+		}
+	}
+
+	messages := make([]Message, 0, len(lines))
+	for _, line := range lines {
+		messages = append(messages, Message{Role: line.role, Content: line.content, Timestamp: line.timestamp})
+	}
+	return messages, nil
+}
+
+// FormatHistory formats messages as a prompt transcript, the same way as
+// GetFormattedHistory but without loading or trimming anything
+func (m *Manager) FormatHistory(messages []Message) string {
+	lines := make([]historyLine, 0, len(messages))
+	for _, msg := range messages {
+		if line, ok := m.formatLine(msg); ok {
+			lines = append(lines, line)
+		}
+	}
+
+	if len(lines) == 0 {
+		return "No previous conversation."
+	}
+	return joinHistory(lines)
+}
+
+// historyTimestampFormat is the layout of history line timestamps
+const historyTimestampFormat = "2006-01-02 15:04 UTC"
+
+// historyLines formats the session's cached messages, or its stored
+// messages with their timestamps when history timestamps are enabled
+func (m *Manager) historyLines(ctx context.Context, sessionID string) ([]historyLine, error) {
+	if m.historyTimestamps {
+		return m.timestampedHistoryLines(ctx, sessionID)
+	}
+
+	mem, err := m.GetOrCreateSession(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := mem.ChatHistory.Messages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get messages: %w", err)
 	}
 
 	// Format messages
-	var formatted string
+	lines := make([]historyLine, 0, len(messages))
 	for _, msg := range messages {
-		switch m := msg.(type) {
+		var line historyLine
+		switch msg := msg.(type) {
 		case llms.HumanChatMessage:
-			formatted += fmt.Sprintf("User: %s\n", m.Content)
+			line, _ = m.formatLine(Message{Role: "user", Content: msg.Content})
 		case llms.AIChatMessage:
-			formatted += fmt.Sprintf("Assistant: %s\n", m.Content)
+			line, _ = m.formatLine(Message{Role: "assistant", Content: msg.Content})
 		case llms.SystemChatMessage:
-			formatted += fmt.Sprintf("System: %s\n", m.Content)
+			line, _ = m.formatLine(Message{Role: "system", Content: msg.Content})
+		default:
+			continue
 		}
+		lines = append(lines, line)
 	}
+	return lines, nil
+}
+
+// timestampedHistoryLines formats the stored messages with the time each
+// was sent
+func (m *Manager) timestampedHistoryLines(ctx context.Context, sessionID string) ([]historyLine, error) {
+	messages, err := m.store.GetMessages(ctx, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get messages: %w", err)
+	}
+
+	lines := make([]historyLine, 0, len(messages))
+	for _, msg := range messages {
+		if line, ok := m.formatLine(msg); ok {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
 
-	return formatted, nil
+// formatLine formats a single message for the prompt, prefixed with the
+// time it was sent when history timestamps are enabled. Messages with an
+// unknown role are skipped.
+func (m *Manager) formatLine(msg Message) (historyLine, bool) {
+	var speaker string
+	switch msg.Role {
+	case "user":
+		speaker = "User"
+	case "assistant":
+		speaker = "Assistant"
+	case "system":
+		speaker = "System"
+	default:
+		return historyLine{}, false
+	}
+
+	text := fmt.Sprintf("%s: %s\n", speaker, msg.Content)
+	if m.historyTimestamps {
+		text = fmt.Sprintf("[%s] %s", msg.Timestamp.UTC().Format(historyTimestampFormat), text)
+	}
+	return historyLine{role: msg.Role, content: msg.Content, timestamp: msg.Timestamp, text: text}, true
+}
+
+// HistoryTimestamps reports whether history timestamps are enabled
+func (m *Manager) HistoryTimestamps() bool {
+	return m.historyTimestamps
+}
+
+// LastMessageTime returns when the session's latest message was sent, or
+// the zero time for a new session
+func (m *Manager) LastMessageTime(ctx context.Context, sessionID string) (time.Time, error) {
+	messages, err := m.store.GetMessages(ctx, sessionID)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get messages: %w", err)
+	}
+	if len(messages) == 0 {
+		return time.Time{}, nil
+	}
+	return messages[len(messages)-1].Timestamp, nil
+}
+
+// joinHistory concatenates formatted history lines
+func joinHistory(lines []historyLine) string {
+	var formatted strings.Builder
+	for _, line := range lines {
+		formatted.WriteString(line.text)
+	}
+	return formatted.String()
+}
+
+// approxTokens estimates token count at four characters per token
+func approxTokens(text string) int {
+	return (len(text) + 3) / 4
+}
+
+// truncateHistory keeps the newest lines that fit in maxTokens, always
+// keeping the first system message
+func truncateHistory(lines []historyLine, maxTokens int) []historyLine {
+	system := -1
+	budget := maxTokens
+	for i, line := range lines {
+		if line.role == "system" {
+			system = i
+			budget -= approxTokens(line.text)
+			break
+		}
+	}
+
+	// Walk back from the newest message until the budget runs out
+	start := len(lines)
+	for i := len(lines) - 1; i >= 0; i-- {
+		if i == system {
+			continue
+		}
+		cost := approxTokens(lines[i].text)
+		if cost > budget {
+			break
+		}
+		budget -= cost
+		start = i
+	}
+
+	kept := make([]historyLine, 0, len(lines)-start+1)
+	if system >= 0 && system < start {
+		kept = append(kept, lines[system])
+	}
+	return append(kept, lines[start:]...)
+}
+
+// historyLine is a single formatted history entry
+type historyLine struct {
+	role      string
+	content   string    // Raw message text
+	timestamp time.Time // Zero when the message came from the session cache
+	text      string    // Formatted for the prompt
+}
+
+// fitHistory drops the oldest messages until the formatted history fits in
+// maxBytes. System messages and the most recent turn (the last user message
+// and everything after it) are never dropped, so the result may still exceed
+// maxBytes if those alone are larger than the cap.
+func fitHistory(lines []historyLine, maxBytes int) []historyLine {
+	total := 0
+	for _, line := range lines {
+		total += len(line.text)
+	}
+	if total <= maxBytes {
+		return lines
+	}
+
+	// Find where the most recent turn starts
+	lastTurn := len(lines)
+	for i := len(lines) - 1; i >= 0; i-- {
+		if lines[i].role == "user" {
+			lastTurn = i
+			break
+		}
+	}
+
+	drop := make([]bool, len(lines))
+	for i := 0; i < lastTurn && total > maxBytes; i++ {
+		if lines[i].role == "system" {
+			continue
+		}
+		drop[i] = true
+		total -= len(lines[i].text)
+	}
+
+	kept := make([]historyLine, 0, len(lines))
+	for i, line := range lines {
+		if !drop[i] {
+			kept = append(kept, line)
+		}
+	}
+	return kept
 }
 
 // GetMessages returns raw messages from Redis
@@ -204,21 +659,139 @@ func (m *Manager) GetMessages(ctx context.Context, sessionID string) ([]Message,
 	return m.store.GetMessages(ctx, sessionID)
 }
 
+// GetMessagesPage returns up to limit raw messages starting at offset, plus
+// the session's total message count
+func (m *Manager) GetMessagesPage(ctx context.Context, sessionID string, offset, limit int) ([]Message, int, error) {
+	return m.store.GetMessagesPage(ctx, sessionID, offset, limit)
+}
+
 // ClearSession clears a session from both cache and Redis
 func (m *Manager) ClearSession(ctx context.Context, sessionID string) error {
 	// Remove from cache
-	delete(m.sessions, sessionID)
+	m.sessions.remove(cacheKey(ctx, sessionID))
 
 	// Remove from Redis
 	if err := m.store.ClearSession(ctx, sessionID); err != nil {
 		return fmt.Errorf("failed to clear session from Redis: %w", err)
 	}
 
-	log.Printf("🗑️ Cleared session %s", sessionID)
+	m.logger.InfoContext(ctx, "cleared session", "session_id", sessionID)
+
+	return nil
+}
+
+// Checkpoint snapshots the current session state and returns an ID that
+// can later be passed to Rollback
+func (m *Manager) Checkpoint(ctx context.Context, sessionID string) (string, error) {
+	session, err := m.store.LoadSession(ctx, sessionID)
+	if err != nil {
+		return "", fmt.Errorf("failed to load session: %w", err)
+	}
+
+	id, err := newCheckpointID()
+	if err != nil {
+		return "", err
+	}
+
+	checkpoint := Checkpoint{
+		ID:        id,
+		CreatedAt: time.Now(),
+		Messages:  session.Messages,
+		Metadata:  session.Metadata,
+	}
+
+	if err := m.store.SaveCheckpoint(ctx, sessionID, checkpoint, m.maxCheckpoints); err != nil {
+		return "", fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+
+	m.logger.InfoContext(ctx, "created checkpoint", "session_id", sessionID, "checkpoint_id", id, "messages", len(session.Messages))
+
+	return id, nil
+}
+
+// Rollback restores a session to the state captured by a checkpoint
+func (m *Manager) Rollback(ctx context.Context, sessionID, checkpointID string) error {
+	checkpoint, err := m.store.LoadCheckpoint(ctx, sessionID, checkpointID)
+	if err != nil {
+		return fmt.Errorf("failed to load checkpoint: %w", err)
+	}
+
+	err = m.store.Transaction(ctx, sessionID, func(session *SessionData) error {
+		session.Messages = checkpoint.Messages
+		session.Metadata = checkpoint.Metadata
+		session.Metadata.LastActivity = time.Now()
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to restore session: %w", err)
+	}
+
+	// Drop the cached buffer so the next access reloads the restored state
+	m.sessions.remove(cacheKey(ctx, sessionID))
+
+	m.logger.InfoContext(ctx, "rolled back session", "session_id", sessionID, "checkpoint_id", checkpointID)
+
+	return nil
+}
+
+// UndoLastExchange removes the last assistant reply and the user message it
+// answered, so the conversation continues as if they were never sent. A
+// session ending on an unanswered user message loses just that message.
+// ErrNothingToUndo is returned for an empty session or one that doesn't end
+// on a user or assistant message.
+func (m *Manager) UndoLastExchange(ctx context.Context, sessionID string) error {
+	removed := 0
+	err := m.store.Transaction(ctx, sessionID, func(session *SessionData) error {
+		cut := len(session.Messages)
+		if cut > 0 && session.Messages[cut-1].Role == "assistant" {
+			cut--
+		}
+		if cut > 0 && session.Messages[cut-1].Role == "user" {
+			cut--
+		}
+		removed = len(session.Messages) - cut
+		if removed == 0 {
+			return ErrNothingToUndo
+		}
+
+		session.Messages = session.Messages[:cut]
+		session.Metadata.MessageCount = cut
+		session.Metadata.LastActivity = time.Now()
+		return nil
+	})
+	if errors.Is(err, ErrNothingToUndo) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to undo last exchange: %w", err)
+	}
+
+	// Drop the cached buffer so the next access reloads the shortened history
+	m.sessions.remove(cacheKey(ctx, sessionID))
+
+	m.logger.InfoContext(ctx, "undid last exchange", "session_id", sessionID, "removed", removed)
 
 	return nil
 }
 
+func newCheckpointID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate checkpoint ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// ListUserSessions returns the IDs of a user's active sessions
+func (m *Manager) ListUserSessions(ctx context.Context, userID string) ([]string, error) {
+	sessionIDs, err := m.store.ListSessionsByUser(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list user sessions: %w", err)
+	}
+
+	return sessionIDs, nil
+}
+
 // SessionExists checks if a session exists in Redis
 func (m *Manager) SessionExists(ctx context.Context, sessionID string) (bool, error) {
 	return m.store.SessionExists(ctx, sessionID)
@@ -229,15 +802,206 @@ func (m *Manager) UpdateActivity(ctx context.Context, sessionID string) error {
 	return m.store.UpdateActivity(ctx, sessionID)
 }
 
-// GetActiveSessionCount returns the number of cached sessions
+// AddUsage adds one LLM call's token usage to the session totals
+func (m *Manager) AddUsage(ctx context.Context, sessionID string, inputTokens, outputTokens int) error {
+	if inputTokens == 0 && outputTokens == 0 {
+		return nil
+	}
+
+	if err := m.store.AddUsage(ctx, sessionID, inputTokens, outputTokens); err != nil {
+		return fmt.Errorf("failed to add usage: %w", err)
+	}
+
+	return nil
+}
+
+// GetUsage returns the session's token totals
+func (m *Manager) GetUsage(ctx context.Context, sessionID string) (inputTokens, outputTokens int, err error) {
+	session, err := m.store.LoadSession(ctx, sessionID)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to load session: %w", err)
+	}
+	return session.Metadata.TotalInputTokens, session.Metadata.TotalOutputTokens, nil
+}
+
+// RecordReady notes that the session reached a READY action and returns
+// how many user turns it took since the session started or since its
+// previous READY action
+func (m *Manager) RecordReady(ctx context.Context, sessionID string) (int, error) {
+	var turns int
+	err := m.store.Transaction(ctx, sessionID, func(session *SessionData) error {
+		userTurns := 0
+		for _, msg := range session.Messages {
+			if msg.Role == "user" {
+				userTurns++
+			}
+		}
+
+		// An undo can leave fewer turns than the last READY was reached on
+		turns = max(userTurns-session.Metadata.LastReadyTurn, 1)
+		session.Metadata.ReadyTurns = append(session.Metadata.ReadyTurns, turns)
+		session.Metadata.LastReadyTurn = userTurns
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to record READY turn: %w", err)
+	}
+	return turns, nil
+}
+
+// GetActiveSessionCount returns the number of cached sessions, always zero
+// in front of a shared store
 func (m *Manager) GetActiveSessionCount() int {
-	return len(m.sessions)
+	return m.sessions.len()
+}
+
+// runJanitor evicts expired sessions from the cache until Close is called
+func (m *Manager) runJanitor() {
+	ticker := time.NewTicker(m.janitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			m.evictExpiredSessions(context.Background())
+		}
+	}
 }
 
-// Close closes the underlying store
+// evictExpiredSessions drops cached sessions the store no longer has.
+// Sessions whose existence can't be checked are kept.
+func (m *Manager) evictExpiredSessions(ctx context.Context) {
+	evicted := 0
+	for _, key := range m.sessions.keys() {
+		exists, err := m.store.SessionExists(WithTenant(ctx, key.tenant), key.sessionID)
+		if err != nil {
+			m.logger.WarnContext(ctx, "janitor failed to check session", "session_id", key.sessionID, "tenant", key.tenant, "error", err)
+			continue
+		}
+		if !exists {
+			m.sessions.remove(key)
+			evicted++
+		}
+	}
+	if evicted > 0 {
+		m.logger.DebugContext(ctx, "evicted expired sessions from cache", "evicted", evicted, "cached", m.sessions.len())
+	}
+}
+
+// Close stops the cache janitor and closes the underlying store
 func (m *Manager) Close() error {
+	m.closeOnce.Do(func() { close(m.stop) })
 	if closer, ok := m.store.(interface{ Close() error }); ok {
 		return closer.Close()
 	}
 	return nil
 }
+
+// SetSlot remembers a named fact for the session
+func (m *Manager) SetSlot(ctx context.Context, sessionID, name, value string) error {
+	return m.SetSlots(ctx, sessionID, map[string]string{name: value})
+}
+
+// SetSlots remembers several named facts for the session in one write
+func (m *Manager) SetSlots(ctx context.Context, sessionID string, slots map[string]string) error {
+	if len(slots) == 0 {
+		return nil
+	}
+
+	err := m.store.Transaction(ctx, sessionID, func(session *SessionData) error {
+		if session.Metadata.Slots == nil {
+			session.Metadata.Slots = make(map[string]string, len(slots))
+		}
+		for name, value := range slots {
+			session.Metadata.Slots[name] = value
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save slots: %w", err)
+	}
+
+	return nil
+}
+
+// MergePendingParameters folds newly extracted parameters into those
+// collected earlier for the same action, including any routed to it with
+// RouteParameters, and returns the combined set. Switching to a different
+// action starts over, and once done is set the action is complete and
+// nothing stays pending.
+func (m *Manager) MergePendingParameters(ctx context.Context, sessionID, action string, params map[string]string, done bool) (map[string]string, error) {
+	var merged map[string]string
+	err := m.store.Transaction(ctx, sessionID, func(session *SessionData) error {
+		merged = make(map[string]string, len(params))
+		maps.Copy(merged, session.Metadata.RoutedParameters[action])
+		delete(session.Metadata.RoutedParameters, action)
+		if session.Metadata.PendingAction == action {
+			maps.Copy(merged, session.Metadata.PendingParameters)
+		}
+		for name, value := range params {
+			if value != "" {
+				merged[name] = value
+			}
+		}
+
+		if done {
+			session.Metadata.PendingAction = ""
+			session.Metadata.PendingParameters = nil
+		} else {
+			session.Metadata.PendingAction = action
+			session.Metadata.PendingParameters = maps.Clone(merged)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save pending parameters: %w", err)
+	}
+	return merged, nil
+}
+
+// RouteParameters keeps parameters extracted for actions other than the
+// one being worked on, by action, until MergePendingParameters is called
+// for that action
+func (m *Manager) RouteParameters(ctx context.Context, sessionID string, byAction map[string]map[string]string) error {
+	if len(byAction) == 0 {
+		return nil
+	}
+
+	err := m.store.Transaction(ctx, sessionID, func(session *SessionData) error {
+		if session.Metadata.RoutedParameters == nil {
+			session.Metadata.RoutedParameters = make(map[string]map[string]string, len(byAction))
+		}
+		for action, params := range byAction {
+			if session.Metadata.RoutedParameters[action] == nil {
+				session.Metadata.RoutedParameters[action] = make(map[string]string, len(params))
+			}
+			maps.Copy(session.Metadata.RoutedParameters[action], params)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save routed parameters: %w", err)
+	}
+	return nil
+}
+
+// GetSlot returns a remembered fact and whether it was set
+func (m *Manager) GetSlot(ctx context.Context, sessionID, name string) (string, bool, error) {
+	slots, err := m.GetSlots(ctx, sessionID)
+	if err != nil {
+		return "", false, err
+	}
+	value, ok := slots[name]
+	return value, ok, nil
+}
+
+// GetSlots returns all remembered facts for the session
+func (m *Manager) GetSlots(ctx context.Context, sessionID string) (map[string]string, error) {
+	session, err := m.store.LoadSession(ctx, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load session: %w", err)
+	}
+	return session.Metadata.Slots, nil
+}
@@ -0,0 +1,981 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+)
+
+// newTestManager returns a manager over a fresh in-memory store
+func newTestManager(t *testing.T, opts ...Option) (*Manager, *InMemoryStore) {
+	t.Helper()
+	store := NewInMemoryStore(time.Hour)
+	opts = append([]Option{WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))}, opts...)
+	m := NewManager(store, opts...)
+	t.Cleanup(func() { m.Close() })
+	return m, store
+}
+
+// saveTurns stores alternating user and assistant messages
+func saveTurns(t *testing.T, m *Manager, sessionID string, messages ...string) {
+	t.Helper()
+	ctx := context.Background()
+	for i, message := range messages {
+		var err error
+		if i%2 == 0 {
+			err = m.SaveUserMessage(ctx, sessionID, "user1", message)
+		} else {
+			err = m.SaveAssistantMessage(ctx, sessionID, "user1", message)
+		}
+		if err != nil {
+			t.Fatalf("saving %q: %v", message, err)
+		}
+	}
+}
+
+func TestGetFormattedHistoryMaxBytes(t *testing.T) {
+	long := strings.Repeat("x", 200)
+	tests := []struct {
+		name     string
+		maxBytes int
+		system   string
+		messages []string
+		wantMax  int // Upper bound on the history length, 0 to skip
+		want     []string
+		dropped  []string
+	}{
+		{
+			name:     "fits without dropping",
+			maxBytes: 1000,
+			messages: []string{"hello", "hi there"},
+			wantMax:  1000,
+			want:     []string{"User: hello\n", "Assistant: hi there\n"},
+		},
+		{
+			name:     "drops oldest turns first",
+			maxBytes: 100,
+			messages: []string{"first " + long, "reply " + long, "second", "answer"},
+			wantMax:  100,
+			want:     []string{"User: second\n", "Assistant: answer\n"},
+			dropped:  []string{"first", "reply"},
+		},
+		{
+			name:     "keeps system messages",
+			maxBytes: 100,
+			system:   "tenant policy",
+			messages: []string{"first " + long, "reply " + long, "second", "answer"},
+			wantMax:  100,
+			want:     []string{"System: tenant policy\n", "User: second\n"},
+			dropped:  []string{"first", "reply"},
+		},
+		{
+			name:     "keeps the newest turn even over the cap",
+			maxBytes: 50,
+			messages: []string{"old", "older reply", "latest " + long},
+			want:     []string{"User: latest " + long + "\n"},
+			dropped:  []string{"older reply"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestManager(t, WithMaxHistoryBytes(tt.maxBytes))
+			ctx := context.Background()
+			if tt.system != "" {
+				if err := m.SaveSystemMessages(ctx, "s1", "user1", []string{tt.system}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			saveTurns(t, m, "s1", tt.messages...)
+
+			history, err := m.GetFormattedHistory(ctx, "s1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantMax > 0 && len(history) > tt.wantMax {
+				t.Errorf("history is %d bytes, cap is %d", len(history), tt.wantMax)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(history, want) {
+					t.Errorf("history missing %q:\n%s", want, history)
+				}
+			}
+			for _, dropped := range tt.dropped {
+				if strings.Contains(history, dropped) {
+					t.Errorf("history still contains %q:\n%s", dropped, history)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckpointRollback(t *testing.T) {
+	tests := []struct {
+		name     string
+		before   []string
+		after    []string
+		slots    map[string]string
+		want     []string
+		wantSlot string
+	}{
+		{
+			name:   "drops turns added after the checkpoint",
+			before: []string{"create a distribution", "which origin?"},
+			after:  []string{"example.com", "done"},
+			want:   []string{"create a distribution", "which origin?"},
+		},
+		{
+			name:     "restores action metadata",
+			before:   []string{"purge the cache"},
+			after:    []string{"all of it", "purged"},
+			slots:    map[string]string{"service_id": "svc-1"},
+			want:     []string{"purge the cache"},
+			wantSlot: "svc-1",
+		},
+		{
+			name:  "empty session",
+			after: []string{"hello", "hi"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestManager(t)
+			ctx := context.Background()
+			saveTurns(t, m, "s1", tt.before...)
+			if err := m.SetSlots(ctx, "s1", tt.slots); err != nil {
+				t.Fatal(err)
+			}
+
+			id, err := m.Checkpoint(ctx, "s1")
+			if err != nil {
+				t.Fatalf("Checkpoint() error = %v", err)
+			}
+
+			saveTurns(t, m, "s1", tt.after...)
+			if err := m.SetSlot(ctx, "s1", "service_id", "svc-2"); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := m.Rollback(ctx, "s1", id); err != nil {
+				t.Fatalf("Rollback() error = %v", err)
+			}
+
+			messages, err := m.GetMessages(ctx, "s1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, message := range messages {
+				got = append(got, message.Content)
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("messages = %q, want %q", got, tt.want)
+			}
+
+			slot, _, err := m.GetSlot(ctx, "s1", "service_id")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if slot != tt.wantSlot {
+				t.Errorf("service_id slot = %q, want %q", slot, tt.wantSlot)
+			}
+		})
+	}
+}
+
+func TestCheckpointBoundedCount(t *testing.T) {
+	m, _ := newTestManager(t, WithMaxCheckpoints(2))
+	ctx := context.Background()
+
+	var ids []string
+	for _, message := range []string{"one", "two", "three"} {
+		saveTurns(t, m, "s1", message)
+		id, err := m.Checkpoint(ctx, "s1")
+		if err != nil {
+			t.Fatalf("Checkpoint() error = %v", err)
+		}
+		ids = append(ids, id)
+	}
+
+	tests := []struct {
+		name    string
+		id      string
+		wantErr error
+	}{
+		{name: "oldest is evicted", id: ids[0], wantErr: ErrCheckpointNotFound},
+		{name: "unknown id", id: "missing", wantErr: ErrCheckpointNotFound},
+		{name: "newer kept", id: ids[1]},
+		{name: "newest kept", id: ids[2]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := m.Rollback(ctx, "s1", tt.id)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Rollback() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFormatHistory(t *testing.T) {
+	sent := time.Date(2025, 3, 1, 9, 30, 0, 0, time.UTC)
+	messages := []Message{
+		{Role: "system", Content: "tenant policy", Timestamp: sent},
+		{Role: "user", Content: "purge the cache", Timestamp: sent},
+		{Role: "tool", Content: "ignored", Timestamp: sent},
+		{Role: "assistant", Content: "which service?", Timestamp: sent},
+	}
+
+	tests := []struct {
+		name       string
+		timestamps bool
+		messages   []Message
+		want       string
+	}{
+		{
+			name:     "no messages",
+			messages: nil,
+			want:     "No previous conversation.",
+		},
+		{
+			name:     "plain",
+			messages: messages,
+			want:     "System: tenant policy\nUser: purge the cache\nAssistant: which service?\n",
+		},
+		{
+			name:       "timestamped",
+			timestamps: true,
+			messages:   messages,
+			want: "[2025-03-01 09:30 UTC] System: tenant policy\n" +
+				"[2025-03-01 09:30 UTC] User: purge the cache\n" +
+				"[2025-03-01 09:30 UTC] Assistant: which service?\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestManager(t, WithHistoryTimestamps(tt.timestamps))
+			if got := m.FormatHistory(tt.messages); got != tt.want {
+				t.Errorf("FormatHistory() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestManagerConcurrentAccess(t *testing.T) {
+	tests := []struct {
+		name      string
+		sessions  int
+		workers   int
+		cacheSize int
+	}{
+		{name: "distinct sessions", sessions: 50, workers: 50},
+		{name: "shared sessions", sessions: 3, workers: 50},
+		{name: "evicting cache", sessions: 50, workers: 50, cacheSize: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestManager(t, WithSessionCacheSize(tt.cacheSize))
+			ctx := context.Background()
+
+			var wg sync.WaitGroup
+			for i := range tt.workers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					sessionID := fmt.Sprintf("s%d", i%tt.sessions)
+					if err := m.SaveUserMessage(ctx, sessionID, "user1", fmt.Sprintf("message %d", i)); err != nil {
+						t.Errorf("SaveUserMessage(%s): %v", sessionID, err)
+					}
+					if _, err := m.GetFormattedHistory(ctx, sessionID); err != nil {
+						t.Errorf("GetFormattedHistory(%s): %v", sessionID, err)
+					}
+					m.GetActiveSessionCount()
+				}()
+			}
+			wg.Wait()
+
+			total := 0
+			for i := range tt.sessions {
+				messages, err := m.GetMessages(ctx, fmt.Sprintf("s%d", i))
+				if err != nil {
+					t.Fatal(err)
+				}
+				total += len(messages)
+			}
+			if total != tt.workers {
+				t.Errorf("stored %d messages, want %d", total, tt.workers)
+			}
+			if tt.cacheSize > 0 && m.GetActiveSessionCount() > tt.cacheSize {
+				t.Errorf("cache holds %d sessions, limit is %d", m.GetActiveSessionCount(), tt.cacheSize)
+			}
+		})
+	}
+}
+
+func TestManagerSharedStoreReplicas(t *testing.T) {
+	tests := []struct {
+		name  string
+		other func(t *testing.T, m *Manager) // Runs on the second replica
+		want  string
+	}{
+		{
+			name: "turn on another replica",
+			other: func(t *testing.T, m *Manager) {
+				saveTurns(t, m, "s1", "use example.com", "Done")
+			},
+			want: "User: purge\nAssistant: Which service?\nUser: use example.com\nAssistant: Done\n",
+		},
+		{
+			name: "undo on another replica",
+			other: func(t *testing.T, m *Manager) {
+				if err := m.UndoLastExchange(context.Background(), "s1"); err != nil {
+					t.Fatalf("UndoLastExchange() error = %v", err)
+				}
+			},
+			want: "No previous conversation.",
+		},
+		{
+			name: "cleared on another replica",
+			other: func(t *testing.T, m *Manager) {
+				if err := m.ClearSession(context.Background(), "s1"); err != nil {
+					t.Fatalf("ClearSession() error = %v", err)
+				}
+			},
+			want: "No previous conversation.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestRedisStore(t)
+			logger := WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+			first, second := NewManager(store, logger), NewManager(store, logger)
+			ctx := context.Background()
+
+			saveTurns(t, first, "s1", "purge", "Which service?")
+			// Both replicas have read the session before the change
+			for _, m := range []*Manager{first, second} {
+				if _, err := m.GetFormattedHistory(ctx, "s1"); err != nil {
+					t.Fatalf("GetFormattedHistory() error = %v", err)
+				}
+			}
+
+			tt.other(t, second)
+
+			got, err := first.GetFormattedHistory(ctx, "s1")
+			if err != nil {
+				t.Fatalf("GetFormattedHistory() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("first replica's history = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUndoLastExchange(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages []string // Alternating user and assistant messages
+		system   bool     // End the session on a system message
+		undos    int
+		wantErr  error // From the last undo
+		want     string
+	}{
+		{name: "empty session", undos: 1, wantErr: ErrNothingToUndo, want: "No previous conversation."},
+		{name: "only a user message", messages: []string{"purge"}, undos: 1, want: "No previous conversation."},
+		{name: "full exchange", messages: []string{"purge", "Which service?", "example.com", "Purge it?"}, undos: 1, want: "User: purge\nAssistant: Which service?\n"},
+		{name: "repeated undo", messages: []string{"purge", "Which service?", "example.com", "Purge it?"}, undos: 2, want: "No previous conversation."},
+		{name: "undo past the start", messages: []string{"purge", "Which service?"}, undos: 2, wantErr: ErrNothingToUndo, want: "No previous conversation."},
+		{name: "ends on a system message", messages: []string{"purge", "Which service?"}, system: true, undos: 1, wantErr: ErrNothingToUndo, want: "User: purge\nAssistant: Which service?\nSystem: policy\n"},
+	}
+
+	stores := map[string]func(t *testing.T) Store{
+		"memory": func(t *testing.T) Store { return NewInMemoryStore(time.Hour) },
+		"redis":  func(t *testing.T) Store { return newTestRedisStore(t) },
+	}
+
+	for _, tt := range tests {
+		for backend, newStore := range stores {
+			t.Run(tt.name+"/"+backend, func(t *testing.T) {
+				m := NewManager(newStore(t), WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+				ctx := context.Background()
+				saveTurns(t, m, "s1", tt.messages...)
+				if tt.system {
+					if err := m.SaveSystemMessages(ctx, "s1", "user1", []string{"policy"}); err != nil {
+						t.Fatalf("SaveSystemMessages() error = %v", err)
+					}
+				}
+				// Load the session so a stale cached copy would show up
+				if _, err := m.GetFormattedHistory(ctx, "s1"); err != nil {
+					t.Fatalf("GetFormattedHistory() error = %v", err)
+				}
+
+				var err error
+				for range tt.undos {
+					err = m.UndoLastExchange(ctx, "s1")
+				}
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("UndoLastExchange() error = %v, want %v", err, tt.wantErr)
+				}
+
+				got, err := m.GetFormattedHistory(ctx, "s1")
+				if err != nil {
+					t.Fatalf("GetFormattedHistory() error = %v", err)
+				}
+				if got != tt.want {
+					t.Errorf("history = %q, want %q", got, tt.want)
+				}
+				messages, err := m.GetMessages(ctx, "s1")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if lines := strings.Count(tt.want, "\n"); len(messages) != lines {
+					t.Errorf("stored %d messages, want %d", len(messages), lines)
+				}
+			})
+		}
+	}
+}
+
+func TestMaxSessionsPerUser(t *testing.T) {
+	type save struct {
+		sessionID, userID string
+		clear             bool // Clear the session instead of saving to it
+		wantErr           error
+	}
+	tests := []struct {
+		name  string
+		saves []save
+	}{
+		{
+			name: "new session over the cap",
+			saves: []save{
+				{sessionID: "s1", userID: "user1"},
+				{sessionID: "s2", userID: "user1"},
+				{sessionID: "s3", userID: "user1", wantErr: ErrSessionLimitExceeded},
+			},
+		},
+		{
+			name: "existing sessions keep working",
+			saves: []save{
+				{sessionID: "s1", userID: "user1"},
+				{sessionID: "s2", userID: "user1"},
+				{sessionID: "s1", userID: "user1"},
+				{sessionID: "s2", userID: "user1"},
+			},
+		},
+		{
+			name: "other users are unaffected",
+			saves: []save{
+				{sessionID: "s1", userID: "user1"},
+				{sessionID: "s2", userID: "user1"},
+				{sessionID: "s3", userID: "user2"},
+			},
+		},
+		{
+			name: "cleared session frees a place",
+			saves: []save{
+				{sessionID: "s1", userID: "user1"},
+				{sessionID: "s2", userID: "user1"},
+				{sessionID: "s1", clear: true},
+				{sessionID: "s3", userID: "user1"},
+			},
+		},
+	}
+
+	stores := map[string]func(t *testing.T) Store{
+		"memory": func(t *testing.T) Store { return NewInMemoryStore(time.Hour) },
+		"redis":  func(t *testing.T) Store { return newTestRedisStore(t) },
+	}
+
+	for _, tt := range tests {
+		for backend, newStore := range stores {
+			t.Run(tt.name+"/"+backend, func(t *testing.T) {
+				m := NewManager(newStore(t), WithMaxSessionsPerUser(2), WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+				ctx := context.Background()
+
+				for i, s := range tt.saves {
+					if s.clear {
+						if err := m.ClearSession(ctx, s.sessionID); err != nil {
+							t.Fatalf("save %d: ClearSession() error = %v", i, err)
+						}
+						continue
+					}
+					err := m.SaveUserMessage(ctx, s.sessionID, s.userID, "purge the cache")
+					if !errors.Is(err, s.wantErr) {
+						t.Errorf("save %d: SaveUserMessage(%s) error = %v, want %v", i, s.sessionID, err, s.wantErr)
+					}
+					if s.wantErr == nil {
+						continue
+					}
+					// A rejected session isn't created
+					if exists, err := m.SessionExists(ctx, s.sessionID); err != nil || exists {
+						t.Errorf("save %d: %s exists = %v (%v), want false", i, s.sessionID, exists, err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestManagerCacheEviction(t *testing.T) {
+	m, _ := newTestManager(t, WithSessionCacheSize(2))
+	for _, id := range []string{"s1", "s2", "s3"} {
+		saveTurns(t, m, id, "purge the cache", "Which service?")
+	}
+
+	if got := m.GetActiveSessionCount(); got != 2 {
+		t.Errorf("GetActiveSessionCount() = %d, want 2", got)
+	}
+	if _, ok := m.sessions.get(tenantSession{sessionID: "s1"}); ok {
+		t.Error("s1 is still cached, want it evicted")
+	}
+
+	// Eviction only drops the cache entry, the store still has the session
+	messages, err := m.GetMessages(context.Background(), "s1")
+	if err != nil {
+		t.Fatalf("GetMessages() error = %v", err)
+	}
+	if len(messages) != 2 {
+		t.Errorf("GetMessages() returned %d messages, want 2", len(messages))
+	}
+	if _, err := m.GetOrCreateSession(context.Background(), "s1"); err != nil {
+		t.Fatalf("GetOrCreateSession() error = %v", err)
+	}
+	if _, ok := m.sessions.get(tenantSession{sessionID: "s2"}); ok {
+		t.Error("s2 is still cached after s1 was reloaded, want it evicted")
+	}
+}
+
+func TestCacheJanitor(t *testing.T) {
+	tests := []struct {
+		name       string
+		ttl        time.Duration
+		wantCached int
+	}{
+		{name: "expired session dropped", ttl: 50 * time.Millisecond},
+		{name: "live session kept", ttl: time.Hour, wantCached: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewManager(NewInMemoryStore(tt.ttl), WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))), WithCacheJanitor(10*time.Millisecond))
+			saveTurns(t, m, "s1", "purge the cache")
+			if got := m.GetActiveSessionCount(); got != 1 {
+				t.Fatalf("GetActiveSessionCount() = %d before the janitor ran, want 1", got)
+			}
+
+			deadline := time.Now().Add(2 * time.Second)
+			for m.GetActiveSessionCount() != tt.wantCached && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			// Give the janitor a few more rounds to drop a session it shouldn't
+			time.Sleep(100 * time.Millisecond)
+			if got := m.GetActiveSessionCount(); got != tt.wantCached {
+				t.Errorf("GetActiveSessionCount() = %d, want %d", got, tt.wantCached)
+			}
+
+			if err := m.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+			// Close stops the janitor and can be called again
+			if err := m.Close(); err != nil {
+				t.Fatalf("second Close() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestGetMessagesPage(t *testing.T) {
+	messages := []string{"m0", "m1", "m2", "m3", "m4"}
+	tests := []struct {
+		name      string
+		sessionID string
+		offset    int
+		limit     int
+		want      []string
+		wantTotal int
+		wantErr   error
+	}{
+		{name: "first page", sessionID: "s1", offset: 0, limit: 2, want: []string{"m0", "m1"}, wantTotal: 5},
+		{name: "middle page", sessionID: "s1", offset: 2, limit: 2, want: []string{"m2", "m3"}, wantTotal: 5},
+		{name: "last partial page", sessionID: "s1", offset: 4, limit: 2, want: []string{"m4"}, wantTotal: 5},
+		{name: "whole session", sessionID: "s1", offset: 0, limit: 10, want: messages, wantTotal: 5},
+		{name: "offset past the end", sessionID: "s1", offset: 10, limit: 2, wantTotal: 5},
+		{name: "missing session", sessionID: "other", offset: 0, limit: 2},
+		{name: "negative offset", sessionID: "s1", offset: -1, limit: 2, wantErr: ErrInvalidPage},
+		{name: "zero limit", sessionID: "s1", offset: 0, limit: 0, wantErr: ErrInvalidPage},
+	}
+
+	stores := map[string]func(t *testing.T) Store{
+		"memory": func(t *testing.T) Store { return NewInMemoryStore(time.Hour) },
+		"redis":  func(t *testing.T) Store { return newTestRedisStore(t) },
+	}
+
+	for _, tt := range tests {
+		for backend, newStore := range stores {
+			t.Run(tt.name+"/"+backend, func(t *testing.T) {
+				m := NewManager(newStore(t), WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+				saveTurns(t, m, "s1", messages...)
+
+				page, total, err := m.GetMessagesPage(context.Background(), tt.sessionID, tt.offset, tt.limit)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("GetMessagesPage() error = %v, want %v", err, tt.wantErr)
+				}
+				if err != nil {
+					return
+				}
+				var got []string
+				for _, msg := range page {
+					got = append(got, msg.Content)
+				}
+				if !slices.Equal(got, tt.want) || total != tt.wantTotal {
+					t.Errorf("GetMessagesPage() = %v, %d, want %v, %d", got, total, tt.want, tt.wantTotal)
+				}
+			})
+		}
+	}
+}
+
+func TestListUserSessions(t *testing.T) {
+	type save struct{ sessionID, userID string }
+	tests := []struct {
+		name    string
+		saves   []save
+		cleared []string
+		userID  string
+		want    []string
+	}{
+		{name: "only the user's sessions", saves: []save{{"s1", "user1"}, {"s2", "user1"}, {"s3", "user2"}}, userID: "user1", want: []string{"s1", "s2"}},
+		{name: "other user", saves: []save{{"s1", "user1"}, {"s2", "user1"}, {"s3", "user2"}}, userID: "user2", want: []string{"s3"}},
+		{name: "repeated saves listed once", saves: []save{{"s1", "user1"}, {"s1", "user1"}}, userID: "user1", want: []string{"s1"}},
+		{name: "cleared session dropped", saves: []save{{"s1", "user1"}, {"s2", "user1"}}, cleared: []string{"s1"}, userID: "user1", want: []string{"s2"}},
+		{name: "unknown user", saves: []save{{"s1", "user1"}}, userID: "user3"},
+	}
+
+	stores := map[string]func(t *testing.T) Store{
+		"memory": func(t *testing.T) Store { return NewInMemoryStore(time.Hour) },
+		"redis":  func(t *testing.T) Store { return newTestRedisStore(t) },
+	}
+
+	for _, tt := range tests {
+		for backend, newStore := range stores {
+			t.Run(tt.name+"/"+backend, func(t *testing.T) {
+				m := NewManager(newStore(t), WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+				ctx := context.Background()
+				for _, s := range tt.saves {
+					if err := m.SaveUserMessage(ctx, s.sessionID, s.userID, "purge the cache"); err != nil {
+						t.Fatal(err)
+					}
+				}
+				for _, sessionID := range tt.cleared {
+					if err := m.ClearSession(ctx, sessionID); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				got, err := m.ListUserSessions(ctx, tt.userID)
+				if err != nil {
+					t.Fatalf("ListUserSessions() error = %v", err)
+				}
+				slices.Sort(got)
+				if !slices.Equal(got, tt.want) {
+					t.Errorf("ListUserSessions() = %v, want %v", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestGetTruncatedMessages(t *testing.T) {
+	// Each user line costs 4 tokens and each assistant line 6, so an
+	// exchange costs 10
+	messages := make([]string, 100)
+	for i := range messages {
+		messages[i] = fmt.Sprintf("msg-%05d", i)
+	}
+
+	tests := []struct {
+		name      string
+		system    bool // Start the session with a 4-token system message
+		maxTokens int
+		wantFirst string // Oldest message kept after the system message
+		wantCount int    // Conversation messages kept, excluding the system message
+	}{
+		{name: "tail of ten exchanges", maxTokens: 100, wantFirst: "msg-00080", wantCount: 20},
+		{name: "leftover budget too small for the next message", maxTokens: 105, wantFirst: "msg-00080", wantCount: 20},
+		{name: "leftover budget fits an assistant message", maxTokens: 106, wantFirst: "msg-00079", wantCount: 21},
+		{name: "system message kept", system: true, maxTokens: 104, wantFirst: "msg-00080", wantCount: 20},
+		{name: "budget larger than the session", maxTokens: 5000, wantFirst: "msg-00000", wantCount: 100},
+		{name: "no budget", wantFirst: "msg-00000", wantCount: 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestManager(t)
+			ctx := context.Background()
+			if tt.system {
+				if err := m.SaveSystemMessages(ctx, "s1", "user1", []string{"policy"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			saveTurns(t, m, "s1", messages...)
+
+			got, err := m.GetTruncatedMessages(ctx, "s1", tt.maxTokens)
+			if err != nil {
+				t.Fatalf("GetTruncatedMessages() error = %v", err)
+			}
+			if tt.system {
+				if len(got) == 0 || got[0].Role != "system" {
+					t.Fatalf("GetTruncatedMessages() dropped the system message: %+v", got)
+				}
+				got = got[1:]
+			}
+			if len(got) != tt.wantCount || got[0].Content != tt.wantFirst {
+				t.Fatalf("GetTruncatedMessages() kept %d messages from %q, want %d from %q", len(got), got[0].Content, tt.wantCount, tt.wantFirst)
+			}
+			if last := got[len(got)-1].Content; last != "msg-00099" {
+				t.Errorf("newest message kept = %q, want msg-00099", last)
+			}
+
+			// The formatted history is trimmed the same way
+			history, err := m.GetTruncatedHistory(ctx, "s1", tt.maxTokens)
+			if err != nil {
+				t.Fatalf("GetTruncatedHistory() error = %v", err)
+			}
+			if got := strings.Count(history, "msg-"); got != tt.wantCount {
+				t.Errorf("GetTruncatedHistory() has %d messages, want %d", got, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestStoreTransaction(t *testing.T) {
+	errAbort := errors.New("abort")
+	tests := []struct {
+		name        string
+		sessionID   string
+		err         error // Returned by the callback after its changes
+		wantPending string
+		wantCount   int
+		wantExists  bool
+	}{
+		{name: "committed", sessionID: "s1", wantCount: 3, wantExists: true},
+		{name: "failed callback rolls back", sessionID: "s1", err: errAbort, wantPending: "purge_cache", wantCount: 2, wantExists: true},
+		{name: "failed callback creates nothing", sessionID: "s2", err: errAbort},
+	}
+
+	stores := map[string]func(t *testing.T) Store{
+		"memory": func(t *testing.T) Store { return NewInMemoryStore(time.Hour) },
+		"redis":  func(t *testing.T) Store { return newTestRedisStore(t) },
+	}
+
+	for _, tt := range tests {
+		for backend, newStore := range stores {
+			t.Run(tt.name+"/"+backend, func(t *testing.T) {
+				store := newStore(t)
+				m := NewManager(store, WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+				ctx := context.Background()
+				saveTurns(t, m, "s1", "purge the cache", "Which service?")
+				if err := store.Transaction(ctx, "s1", func(session *SessionData) error {
+					session.Metadata.PendingAction = "purge_cache"
+					return nil
+				}); err != nil {
+					t.Fatal(err)
+				}
+
+				// Clear the pending action and note it, then maybe fail
+				err := store.Transaction(ctx, tt.sessionID, func(session *SessionData) error {
+					session.Metadata.PendingAction = ""
+					session.Messages = append(session.Messages, Message{Role: "system", Content: "action cancelled", Timestamp: time.Now()})
+					return tt.err
+				})
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("Transaction() error = %v, want %v", err, tt.err)
+				}
+
+				exists, err := store.SessionExists(ctx, tt.sessionID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if exists != tt.wantExists {
+					t.Fatalf("SessionExists() = %v, want %v", exists, tt.wantExists)
+				}
+				if !exists {
+					return
+				}
+				session, err := store.LoadSession(ctx, tt.sessionID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if session.Metadata.PendingAction != tt.wantPending || len(session.Messages) != tt.wantCount {
+					t.Errorf("session has pending action %q and %d messages, want %q and %d",
+						session.Metadata.PendingAction, len(session.Messages), tt.wantPending, tt.wantCount)
+				}
+			})
+		}
+	}
+}
+
+func TestSlots(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []map[string]string // Passed to SetSlots in turn
+		want   map[string]string
+	}{
+		{name: "no slots"},
+		{name: "one write", writes: []map[string]string{{"domain": "example.com"}}, want: map[string]string{"domain": "example.com"}},
+		{
+			name:   "later writes merge and overwrite",
+			writes: []map[string]string{{"domain": "example.com", "origin": "old.example.com"}, {"origin": "new.example.com"}, {}},
+			want:   map[string]string{"domain": "example.com", "origin": "new.example.com"},
+		},
+	}
+
+	stores := map[string]func(t *testing.T) Store{
+		"memory": func(t *testing.T) Store { return NewInMemoryStore(time.Hour) },
+		"redis":  func(t *testing.T) Store { return newTestRedisStore(t) },
+	}
+
+	for _, tt := range tests {
+		for backend, newStore := range stores {
+			t.Run(tt.name+"/"+backend, func(t *testing.T) {
+				store := newStore(t)
+				m := NewManager(store, WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+				ctx := context.Background()
+				saveTurns(t, m, "s1", "set up example.com")
+				for _, slots := range tt.writes {
+					if err := m.SetSlots(ctx, "s1", slots); err != nil {
+						t.Fatalf("SetSlots() error = %v", err)
+					}
+				}
+
+				// Slots live in the store, so another replica sees them
+				other := NewManager(store, WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+				got, err := other.GetSlots(ctx, "s1")
+				if err != nil {
+					t.Fatalf("GetSlots() error = %v", err)
+				}
+				if !maps.Equal(got, tt.want) {
+					t.Errorf("GetSlots() = %v, want %v", got, tt.want)
+				}
+				for name, want := range tt.want {
+					if value, ok, err := other.GetSlot(ctx, "s1", name); err != nil || !ok || value != want {
+						t.Errorf("GetSlot(%s) = %q, %v, %v, want %q", name, value, ok, err, want)
+					}
+				}
+				if _, ok, err := other.GetSlot(ctx, "s1", "missing"); err != nil || ok {
+					t.Errorf("GetSlot(missing) found = %v, error = %v, want not found", ok, err)
+				}
+			})
+		}
+	}
+}
+
+func TestGetFormattedHistoryTimestamps(t *testing.T) {
+	asked := time.Date(2025, 3, 1, 9, 30, 0, 0, time.UTC)
+	answered := asked.Add(2 * time.Minute)
+
+	tests := []struct {
+		name       string
+		timestamps bool
+		want       string
+	}{
+		{name: "disabled", want: "User: purge the cache\nAssistant: which service?\n"},
+		{
+			name:       "enabled",
+			timestamps: true,
+			want:       "[2025-03-01 09:30 UTC] User: purge the cache\n[2025-03-01 09:32 UTC] Assistant: which service?\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, store := newTestManager(t, WithHistoryTimestamps(tt.timestamps))
+			ctx := context.Background()
+			for _, msg := range []Message{
+				{Role: "user", Content: "purge the cache", Timestamp: asked},
+				{Role: "assistant", Content: "which service?", Timestamp: answered},
+			} {
+				if err := store.SaveMessage(ctx, "s1", "user1", msg); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := m.GetFormattedHistory(ctx, "s1")
+			if err != nil {
+				t.Fatalf("GetFormattedHistory() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("GetFormattedHistory() = %q, want %q", got, tt.want)
+			}
+
+			last, err := m.LastMessageTime(ctx, "s1")
+			if err != nil {
+				t.Fatalf("LastMessageTime() error = %v", err)
+			}
+			if !last.Equal(answered) {
+				t.Errorf("LastMessageTime() = %v, want %v", last, answered)
+			}
+		})
+	}
+}
+
+func TestLoadHistoryFromRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		history []models.ConversationMessage
+		want    string
+	}{
+		{
+			name: "system preamble",
+			history: []models.ConversationMessage{
+				{Role: "system", Message: "Tenant policy: only purge paths under /static"},
+				{Role: "user", Message: "purge /static/app.js"},
+				{Role: "assistant", Message: "Which service?"},
+			},
+			want: "System: Tenant policy: only purge paths under /static\nUser: purge /static/app.js\nAssistant: Which service?\n",
+		},
+		{
+			name: "unknown role dropped",
+			history: []models.ConversationMessage{
+				{Role: "tool", Message: "lookup result"},
+				{Role: "user", Message: "purge the cache"},
+			},
+			want: "User: purge the cache\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestManager(t)
+			ctx := context.Background()
+			if err := m.LoadHistoryFromRequest(ctx, "s1", tt.history); err != nil {
+				t.Fatalf("LoadHistoryFromRequest() error = %v", err)
+			}
+
+			got, err := m.GetFormattedHistory(ctx, "s1")
+			if err != nil {
+				t.Fatalf("GetFormattedHistory() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("GetFormattedHistory() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package memory
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// RedactedPlaceholder replaces PII in redacted text
+const RedactedPlaceholder = "[REDACTED]"
+
+// DefaultPIIPatterns matches the PII we most commonly see in CDN setup
+// conversations: email addresses, phone numbers, card numbers and API keys
+var DefaultPIIPatterns = []string{
+	`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`,
+	`\+?\d[\d\s\-().]{7,}\d`,
+	`\b(?:\d[ \-]?){13,16}\b`,
+	`\b(?:sk|pk|api|key|token)[_\-][A-Za-z0-9_\-]{16,}\b`,
+}
+
+// PIIClassifier flags text matching any of its patterns
+type PIIClassifier struct {
+	patterns []*regexp.Regexp
+}
+
+// NewPIIClassifier compiles the given patterns, falling back to
+// DefaultPIIPatterns when none are supplied
+func NewPIIClassifier(patterns []string) (*PIIClassifier, error) {
+	if len(patterns) == 0 {
+		patterns = DefaultPIIPatterns
+	}
+
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PII pattern %q: %w", p, err)
+		}
+		compiled = append(compiled, re)
+	}
+
+	return &PIIClassifier{patterns: compiled}, nil
+}
+
+// ContainsPII reports whether text matches any PII pattern
+func (c *PIIClassifier) ContainsPII(text string) bool {
+	for _, re := range c.patterns {
+		if re.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact replaces every PII match in text with RedactedPlaceholder
+func (c *PIIClassifier) Redact(text string) string {
+	for _, re := range c.patterns {
+		text = re.ReplaceAllString(text, RedactedPlaceholder)
+	}
+	return text
+}
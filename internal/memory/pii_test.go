@@ -0,0 +1,66 @@
+package memory
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestPIIFlag(t *testing.T) {
+	tests := []struct {
+		name     string
+		enabled  bool
+		patterns []string // nil for DefaultPIIPatterns
+		message  string
+		want     bool
+	}{
+		{name: "disabled", message: "I'm ops@example.com"},
+		{name: "email", enabled: true, message: "I'm ops@example.com", want: true},
+		{name: "phone number", enabled: true, message: "call +1 415-555-0100", want: true},
+		{name: "card number", enabled: true, message: "card 4111 1111 1111 1111", want: true},
+		{name: "API key", enabled: true, message: "use token_abcdefghijklmnopqrst", want: true},
+		{name: "no PII", enabled: true, message: "purge the cache for example.com"},
+		{name: "custom pattern", enabled: true, patterns: []string{`ACME-\d+`}, message: "account ACME-42", want: true},
+		{name: "custom pattern replaces defaults", enabled: true, patterns: []string{`ACME-\d+`}, message: "I'm ops@example.com"},
+	}
+
+	stores := map[string]func(t *testing.T) Store{
+		"memory": func(t *testing.T) Store { return NewInMemoryStore(time.Hour) },
+		"redis":  func(t *testing.T) Store { return newTestRedisStore(t) },
+	}
+
+	for _, tt := range tests {
+		for backend, newStore := range stores {
+			t.Run(tt.name+"/"+backend, func(t *testing.T) {
+				opts := []Option{WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))}
+				if tt.enabled {
+					classifier, err := NewPIIClassifier(tt.patterns)
+					if err != nil {
+						t.Fatal(err)
+					}
+					opts = append(opts, WithPIIClassifier(classifier))
+				}
+				m := NewManager(newStore(t), opts...)
+				saveTurns(t, m, "s1", tt.message, tt.message)
+
+				messages, err := m.GetMessages(context.Background(), "s1")
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, msg := range messages {
+					if msg.ContainsPII != tt.want {
+						t.Errorf("%s message ContainsPII = %v, want %v", msg.Role, msg.ContainsPII, tt.want)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestNewPIIClassifierInvalidPattern(t *testing.T) {
+	if _, err := NewPIIClassifier([]string{`(unclosed`}); err == nil {
+		t.Error("NewPIIClassifier() error = nil, want an error for an invalid pattern")
+	}
+}
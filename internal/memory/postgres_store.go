@@ -0,0 +1,516 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// postgresSchema is applied on startup. Statements are idempotent so every
+// replica can run them.
+const postgresSchema = `
+CREATE TABLE IF NOT EXISTS sessions (
+	session_id TEXT PRIMARY KEY,
+	user_id    TEXT NOT NULL DEFAULT '',
+	metadata   JSONB NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id);
+
+-- Tenants' sessions are stored under tenant:<tenant>:session:<id>
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS tenant TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS messages (
+	id         BIGSERIAL PRIMARY KEY,
+	session_id TEXT NOT NULL REFERENCES sessions (session_id) ON DELETE CASCADE,
+	data       JSONB NOT NULL
+);
+CREATE INDEX IF NOT EXISTS messages_session_id_idx ON messages (session_id, id);
+
+CREATE TABLE IF NOT EXISTS checkpoints (
+	session_id    TEXT NOT NULL,
+	checkpoint_id TEXT NOT NULL,
+	data          JSONB NOT NULL,
+	created_at    TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (session_id, checkpoint_id)
+);
+`
+
+// querier is satisfied by both the pool and a transaction
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// PostgresStore implements Store interface using PostgreSQL
+type PostgresStore struct {
+	pool *pgxpool.Pool
+	ttl  time.Duration // Session TTL (time to live)
+}
+
+// NewPostgresStore creates a new Postgres-backed store and migrates the
+// schema. Expired sessions are removed lazily when they are next read.
+func NewPostgresStore(dsn string, ttl time.Duration) (*PostgresStore, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Postgres DSN: %w", err)
+	}
+
+	// Test connection
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to connect to Postgres: %w", err)
+	}
+
+	if _, err := pool.Exec(ctx, postgresSchema); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to migrate Postgres schema: %w", err)
+	}
+
+	return &PostgresStore{
+		pool: pool,
+		ttl:  ttl,
+	}, nil
+}
+
+// LoadSession loads a session from Postgres
+func (p *PostgresStore) LoadSession(ctx context.Context, sessionID string) (*SessionData, error) {
+	return p.loadSession(ctx, p.pool, sessionID, false)
+}
+
+// loadSession reads a session and its messages. With forUpdate the session
+// row is locked until the surrounding transaction ends.
+func (p *PostgresStore) loadSession(ctx context.Context, q querier, sessionID string, forUpdate bool) (*SessionData, error) {
+	key := scopedSessionID(ctx, sessionID)
+	query := `SELECT user_id, metadata, expires_at FROM sessions WHERE session_id = $1`
+	if forUpdate {
+		query += ` FOR UPDATE`
+	}
+
+	var (
+		userID    string
+		metadata  []byte
+		expiresAt time.Time
+	)
+	err := q.QueryRow(ctx, query, key).Scan(&userID, &metadata, &expiresAt)
+	if err == nil && !expiresAt.After(time.Now()) {
+		// Expired - clean it up lazily and treat it as missing
+		if _, err := q.Exec(ctx, `DELETE FROM sessions WHERE session_id = $1`, key); err != nil {
+			return nil, fmt.Errorf("failed to delete expired session: %w", err)
+		}
+		err = pgx.ErrNoRows
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Session doesn't exist - return empty session
+		return &SessionData{
+			SessionID: sessionID,
+			Messages:  []Message{},
+			Metadata: Metadata{
+				StartedAt:    time.Now(),
+				LastActivity: time.Now(),
+				MessageCount: 0,
+			},
+		}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load session from Postgres: %w", err)
+	}
+
+	session := SessionData{
+		SessionID: sessionID,
+		UserID:    userID,
+	}
+	if err := json.Unmarshal(metadata, &session.Metadata); err != nil {
+		return nil, fmt.Errorf("failed to parse session data: %w", err)
+	}
+
+	session.Messages, err = p.loadMessages(ctx, q, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	session.Metadata.MessageCount = len(session.Messages)
+
+	return &session, nil
+}
+
+// loadMessages reads a session's messages in insertion order
+func (p *PostgresStore) loadMessages(ctx context.Context, q querier, sessionID string) ([]Message, error) {
+	key := scopedSessionID(ctx, sessionID)
+	rows, err := q.Query(ctx, `SELECT data FROM messages WHERE session_id = $1 ORDER BY id`, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load messages: %w", err)
+	}
+	defer rows.Close()
+
+	messages := []Message{}
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to read message: %w", err)
+		}
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, fmt.Errorf("failed to parse session message: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load messages: %w", err)
+	}
+
+	return messages, nil
+}
+
+// SaveMessage appends a message to a session. The session row is locked
+// for the duration, so concurrent appends are serialized.
+func (p *PostgresStore) SaveMessage(ctx context.Context, sessionID, userID string, msg Message) error {
+	key := scopedSessionID(ctx, sessionID)
+	return p.inTx(ctx, func(tx pgx.Tx) error {
+		// Make sure the row exists so it can be locked
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO sessions (session_id, tenant, metadata, expires_at) VALUES ($1, $2, '{}', $3)
+			 ON CONFLICT (session_id) DO NOTHING`,
+			key, TenantFromContext(ctx), time.Now().Add(p.ttl)); err != nil {
+			return fmt.Errorf("failed to create session: %w", err)
+		}
+
+		session, err := p.loadSession(ctx, tx, sessionID, true)
+		if err != nil {
+			return err
+		}
+
+		// Set user ID if this is a new session
+		if session.UserID == "" {
+			session.UserID = userID
+		}
+
+		// Update metadata
+		session.Metadata.LastActivity = time.Now()
+		session.Metadata.MessageCount = len(session.Messages) + 1
+
+		// If this is the first message, set started_at
+		if session.Metadata.MessageCount == 1 {
+			session.Metadata.StartedAt = msg.Timestamp
+		}
+
+		if err := p.writeSessionRow(ctx, tx, session); err != nil {
+			return err
+		}
+		return p.insertMessages(ctx, tx, sessionID, []Message{msg})
+	})
+}
+
+// writeSessionRow upserts the session row and refreshes its expiry
+func (p *PostgresStore) writeSessionRow(ctx context.Context, q querier, session *SessionData) error {
+	ttl := applySessionTTL(ctx, &session.Metadata, p.ttl)
+	metadata, err := json.Marshal(session.Metadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal session: %w", err)
+	}
+
+	_, err = q.Exec(ctx,
+		`INSERT INTO sessions (session_id, tenant, user_id, metadata, expires_at) VALUES ($1, $2, $3, $4, $5)
+		 ON CONFLICT (session_id) DO UPDATE
+		 SET user_id = EXCLUDED.user_id, metadata = EXCLUDED.metadata, expires_at = EXCLUDED.expires_at`,
+		scopedSessionID(ctx, session.SessionID), TenantFromContext(ctx), session.UserID, metadata, time.Now().Add(ttl))
+	if err != nil {
+		return fmt.Errorf("failed to save session to Postgres: %w", err)
+	}
+
+	return nil
+}
+
+// insertMessages appends messages to a session
+func (p *PostgresStore) insertMessages(ctx context.Context, q querier, sessionID string, messages []Message) error {
+	key := scopedSessionID(ctx, sessionID)
+	for _, msg := range messages {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return fmt.Errorf("failed to marshal message: %w", err)
+		}
+		if _, err := q.Exec(ctx, `INSERT INTO messages (session_id, data) VALUES ($1, $2)`, key, data); err != nil {
+			return fmt.Errorf("failed to save message to Postgres: %w", err)
+		}
+	}
+	return nil
+}
+
+// inTx runs fn in a transaction, committing only if it succeeds
+func (p *PostgresStore) inTx(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // No-op after a successful commit
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// CountActiveUserSessions counts a user's sessions within the tenant in ctx
+// that haven't expired
+func (p *PostgresStore) CountActiveUserSessions(ctx context.Context, userID string) (int, error) {
+	var count int
+	err := p.pool.QueryRow(ctx,
+		`SELECT count(*) FROM sessions WHERE user_id = $1 AND tenant = $2 AND expires_at > now()`,
+		userID, TenantFromContext(ctx)).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count user sessions: %w", err)
+	}
+
+	return count, nil
+}
+
+// ListSessionsByUser returns the IDs of a user's unexpired sessions within
+// the tenant in ctx
+func (p *PostgresStore) ListSessionsByUser(ctx context.Context, userID string) ([]string, error) {
+	rows, err := p.pool.Query(ctx,
+		`SELECT session_id FROM sessions WHERE user_id = $1 AND tenant = $2 AND expires_at > now() ORDER BY session_id`,
+		userID, TenantFromContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user sessions: %w", err)
+	}
+
+	sessionIDs, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user sessions: %w", err)
+	}
+
+	// Return the IDs the caller knows the sessions by
+	prefix := scopedSessionID(ctx, "")
+	for i, sessionID := range sessionIDs {
+		sessionIDs[i] = strings.TrimPrefix(sessionID, prefix)
+	}
+
+	return sessionIDs, nil
+}
+
+// SaveSession saves session data to Postgres, replacing its messages
+func (p *PostgresStore) SaveSession(ctx context.Context, session *SessionData) error {
+	return p.inTx(ctx, func(tx pgx.Tx) error {
+		return p.replaceSession(ctx, tx, session)
+	})
+}
+
+// replaceSession overwrites the session row and all of its messages
+func (p *PostgresStore) replaceSession(ctx context.Context, q querier, session *SessionData) error {
+	if err := p.writeSessionRow(ctx, q, session); err != nil {
+		return err
+	}
+	if _, err := q.Exec(ctx, `DELETE FROM messages WHERE session_id = $1`, scopedSessionID(ctx, session.SessionID)); err != nil {
+		return fmt.Errorf("failed to replace messages: %w", err)
+	}
+	return p.insertMessages(ctx, q, session.SessionID, session.Messages)
+}
+
+// Transaction loads a session, applies fn and saves the result atomically.
+// The session row is locked with SELECT ... FOR UPDATE; if fn returns an
+// error nothing is written.
+func (p *PostgresStore) Transaction(ctx context.Context, sessionID string, fn func(session *SessionData) error) error {
+	return p.inTx(ctx, func(tx pgx.Tx) error {
+		session, err := p.loadSession(ctx, tx, sessionID, true)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(session); err != nil {
+			return err
+		}
+
+		return p.replaceSession(ctx, tx, session)
+	})
+}
+
+// AddUsage increments the session's token totals in place, so usage from
+// concurrent requests is never lost and the session isn't rewritten
+func (p *PostgresStore) AddUsage(ctx context.Context, sessionID string, inputTokens, outputTokens int) error {
+	_, err := p.pool.Exec(ctx,
+		`UPDATE sessions
+		 SET metadata = jsonb_set(jsonb_set(metadata,
+		         '{total_input_tokens}', to_jsonb(COALESCE((metadata->>'total_input_tokens')::int, 0) + $2)),
+		         '{total_output_tokens}', to_jsonb(COALESCE((metadata->>'total_output_tokens')::int, 0) + $3))
+		 WHERE session_id = $1 AND expires_at > now()`,
+		scopedSessionID(ctx, sessionID), inputTokens, outputTokens)
+	if err != nil {
+		return fmt.Errorf("failed to add usage: %w", err)
+	}
+
+	return nil
+}
+
+// GetMessages retrieves all messages for a session
+func (p *PostgresStore) GetMessages(ctx context.Context, sessionID string) ([]Message, error) {
+	session, err := p.LoadSession(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return session.Messages, nil
+}
+
+// GetMessagesPage reads one page of an unexpired session's messages
+func (p *PostgresStore) GetMessagesPage(ctx context.Context, sessionID string, offset, limit int) ([]Message, int, error) {
+	if offset < 0 || limit <= 0 {
+		return nil, 0, ErrInvalidPage
+	}
+
+	key := scopedSessionID(ctx, sessionID)
+	var total int
+	err := p.pool.QueryRow(ctx, `
+		SELECT count(*) FROM messages m JOIN sessions s USING (session_id)
+		WHERE m.session_id = $1 AND s.expires_at > now()`, key).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count messages: %w", err)
+	}
+
+	rows, err := p.pool.Query(ctx, `
+		SELECT m.data FROM messages m JOIN sessions s USING (session_id)
+		WHERE m.session_id = $1 AND s.expires_at > now()
+		ORDER BY m.id OFFSET $2 LIMIT $3`, key, offset, limit)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to load messages from Postgres: %w", err)
+	}
+	defer rows.Close()
+
+	messages := []Message{}
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, 0, fmt.Errorf("failed to read message: %w", err)
+		}
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, 0, fmt.Errorf("failed to parse session message: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to load messages from Postgres: %w", err)
+	}
+	return messages, total, nil
+}
+
+// ClearSession removes a session, its messages and its checkpoints
+func (p *PostgresStore) ClearSession(ctx context.Context, sessionID string) error {
+	key := scopedSessionID(ctx, sessionID)
+	return p.inTx(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `DELETE FROM sessions WHERE session_id = $1`, key); err != nil {
+			return fmt.Errorf("failed to clear session: %w", err)
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM checkpoints WHERE session_id = $1`, key); err != nil {
+			return fmt.Errorf("failed to clear checkpoints: %w", err)
+		}
+		return nil
+	})
+}
+
+// SessionExists checks if an unexpired session exists
+func (p *PostgresStore) SessionExists(ctx context.Context, sessionID string) (bool, error) {
+	key := scopedSessionID(ctx, sessionID)
+	var exists bool
+	err := p.pool.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM sessions WHERE session_id = $1 AND expires_at > now())`, key).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check session existence: %w", err)
+	}
+
+	return exists, nil
+}
+
+// UpdateActivity updates the last activity timestamp and refreshes TTL,
+// using the session's own TTL when one was saved with it
+func (p *PostgresStore) UpdateActivity(ctx context.Context, sessionID string) error {
+	key := scopedSessionID(ctx, sessionID)
+	now := time.Now()
+	ttl := SessionTTLFromContext(ctx)
+	_, err := p.pool.Exec(ctx,
+		`UPDATE sessions
+		 SET metadata = jsonb_set(metadata, '{last_activity}', to_jsonb($2::text)),
+		     expires_at = $3::timestamptz + make_interval(secs =>
+		         COALESCE(NULLIF($4::float8, 0), (metadata->>'ttl_seconds')::float8, $5::float8))
+		 WHERE session_id = $1 AND expires_at > now()`,
+		key, now.Format(time.RFC3339Nano), now, ttl.Seconds(), p.ttl.Seconds())
+	if err != nil {
+		return fmt.Errorf("failed to update activity: %w", err)
+	}
+
+	return nil
+}
+
+// SaveCheckpoint stores a snapshot, keeping only the newest maxCount
+func (p *PostgresStore) SaveCheckpoint(ctx context.Context, sessionID string, checkpoint Checkpoint, maxCount int) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return fmt.Errorf("failed to marshal checkpoint: %w", err)
+	}
+
+	key := scopedSessionID(ctx, sessionID)
+	return p.inTx(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO checkpoints (session_id, checkpoint_id, data, created_at) VALUES ($1, $2, $3, $4)`,
+			key, checkpoint.ID, data, checkpoint.CreatedAt); err != nil {
+			return fmt.Errorf("failed to save checkpoint: %w", err)
+		}
+
+		if maxCount > 0 {
+			if _, err := tx.Exec(ctx,
+				`DELETE FROM checkpoints WHERE session_id = $1 AND checkpoint_id NOT IN (
+					SELECT checkpoint_id FROM checkpoints WHERE session_id = $1
+					ORDER BY created_at DESC LIMIT $2)`,
+				key, maxCount); err != nil {
+				return fmt.Errorf("failed to trim checkpoints: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// LoadCheckpoint retrieves a snapshot by ID
+func (p *PostgresStore) LoadCheckpoint(ctx context.Context, sessionID, checkpointID string) (*Checkpoint, error) {
+	key := scopedSessionID(ctx, sessionID)
+	var data []byte
+	err := p.pool.QueryRow(ctx,
+		`SELECT data FROM checkpoints WHERE session_id = $1 AND checkpoint_id = $2`,
+		key, checkpointID).Scan(&data)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrCheckpointNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load checkpoint: %w", err)
+	}
+
+	var checkpoint Checkpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint: %w", err)
+	}
+
+	return &checkpoint, nil
+}
+
+// Close closes the connection pool
+func (p *PostgresStore) Close() error {
+	p.pool.Close()
+	return nil
+}
+
+// Ping verifies the database connection is alive
+func (p *PostgresStore) Ping(ctx context.Context) error {
+	return p.pool.Ping(ctx)
+}
@@ -0,0 +1,207 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// newTestRedisStore returns a store backed by an in-process Redis server
+func newTestRedisStore(t *testing.T) *RedisStore {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	store, err := NewRedisStore("redis://"+mr.Addr(), time.Hour)
+	if err != nil {
+		t.Fatalf("NewRedisStore() error = %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestRedisStoreConcurrentAppends(t *testing.T) {
+	tests := []struct {
+		name     string
+		appends  int
+		sessions int
+		userID   string
+	}{
+		{name: "one session", appends: 50, sessions: 1, userID: "user1"},
+		{name: "several sessions", appends: 50, sessions: 5, userID: "user1"},
+		{name: "no user id", appends: 50, sessions: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestRedisStore(t)
+			ctx := context.Background()
+
+			var wg sync.WaitGroup
+			for i := range tt.appends {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					msg := Message{Role: "user", Content: fmt.Sprintf("message %d", i), Timestamp: time.Now()}
+					if err := store.SaveMessage(ctx, fmt.Sprintf("s%d", i%tt.sessions), tt.userID, msg); err != nil {
+						t.Errorf("SaveMessage(%d): %v", i, err)
+					}
+				}()
+			}
+			wg.Wait()
+
+			seen := make(map[string]bool, tt.appends)
+			for s := range tt.sessions {
+				sessionID := fmt.Sprintf("s%d", s)
+				messages, err := store.GetMessages(ctx, sessionID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				session, err := store.LoadSession(ctx, sessionID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if session.Metadata.MessageCount != len(messages) {
+					t.Errorf("%s: message_count = %d, stored %d", sessionID, session.Metadata.MessageCount, len(messages))
+				}
+				if session.UserID != tt.userID {
+					t.Errorf("%s: user_id = %q, want %q", sessionID, session.UserID, tt.userID)
+				}
+				for _, msg := range messages {
+					seen[msg.Content] = true
+				}
+			}
+
+			for i := range tt.appends {
+				if !seen[fmt.Sprintf("message %d", i)] {
+					t.Errorf("message %d was lost", i)
+				}
+			}
+		})
+	}
+}
+
+func TestRedisStoreAddUsage(t *testing.T) {
+	type usage struct{ input, output int }
+	tests := []struct {
+		name       string
+		usage      []usage // Added after each message
+		rewrite    bool    // Rewrite the session through Transaction afterwards
+		concurrent bool
+		wantInput  int
+		wantOutput int
+	}{
+		{name: "two messages", usage: []usage{{120, 30}, {200, 45}}, wantInput: 320, wantOutput: 75},
+		{name: "kept by a rewrite", usage: []usage{{120, 30}, {200, 45}}, rewrite: true, wantInput: 320, wantOutput: 75},
+		{name: "concurrent", usage: []usage{{10, 1}, {10, 1}, {10, 1}, {10, 1}, {10, 1}, {10, 1}, {10, 1}, {10, 1}}, concurrent: true, wantInput: 80, wantOutput: 8},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestRedisStore(t)
+			ctx := context.Background()
+
+			var wg sync.WaitGroup
+			for i, u := range tt.usage {
+				record := func() {
+					msg := Message{Role: "user", Content: fmt.Sprintf("message %d", i), Timestamp: time.Now()}
+					if err := store.SaveMessage(ctx, "s1", "user1", msg); err != nil {
+						t.Errorf("SaveMessage(%d) error = %v", i, err)
+						return
+					}
+					if err := store.AddUsage(ctx, "s1", u.input, u.output); err != nil {
+						t.Errorf("AddUsage(%d) error = %v", i, err)
+					}
+				}
+				if !tt.concurrent {
+					record()
+					continue
+				}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					record()
+				}()
+			}
+			wg.Wait()
+
+			if tt.rewrite {
+				err := store.Transaction(ctx, "s1", func(session *SessionData) error {
+					session.Metadata.PendingAction = "purge_cache"
+					return nil
+				})
+				if err != nil {
+					t.Fatalf("Transaction() error = %v", err)
+				}
+			}
+
+			session, err := store.LoadSession(ctx, "s1")
+			if err != nil {
+				t.Fatalf("LoadSession() error = %v", err)
+			}
+			if session.Metadata.TotalInputTokens != tt.wantInput || session.Metadata.TotalOutputTokens != tt.wantOutput {
+				t.Errorf("totals = %d/%d, want %d/%d", session.Metadata.TotalInputTokens, session.Metadata.TotalOutputTokens, tt.wantInput, tt.wantOutput)
+			}
+			if session.Metadata.MessageCount != len(tt.usage) {
+				t.Errorf("message_count = %d, want %d", session.Metadata.MessageCount, len(tt.usage))
+			}
+		})
+	}
+}
+
+func TestRedisStoreSessionTTL(t *testing.T) {
+	tests := []struct {
+		name       string
+		firstTTL   time.Duration // Override on the first write, 0 for none
+		laterWrite bool          // Save another message without an override
+		touch      bool          // Shorten the expiry, then call UpdateActivity without an override
+		want       time.Duration
+	}{
+		{name: "store default", want: time.Hour},
+		{name: "request TTL", firstTTL: 2 * time.Hour, want: 2 * time.Hour},
+		{name: "request TTL kept by a later write", firstTTL: 2 * time.Hour, laterWrite: true, want: 2 * time.Hour},
+		{name: "request TTL reapplied on activity", firstTTL: 30 * time.Second, touch: true, want: 30 * time.Second},
+		{name: "store default reapplied on activity", touch: true, want: time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestRedisStore(t)
+			m := NewManager(store, WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+			ctx := context.Background()
+
+			if err := m.SaveUserMessage(WithSessionTTL(ctx, tt.firstTTL), "s1", "user1", "purge the cache"); err != nil {
+				t.Fatal(err)
+			}
+			if tt.laterWrite {
+				if err := m.SaveAssistantMessage(ctx, "s1", "user1", "Which service?"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.touch {
+				for _, key := range []string{store.metaKey(ctx, "s1"), store.messagesKey(ctx, "s1")} {
+					if err := store.Client().Expire(ctx, key, time.Second).Err(); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := m.UpdateActivity(ctx, "s1"); err != nil {
+					t.Fatalf("UpdateActivity() error = %v", err)
+				}
+			}
+
+			for _, key := range []string{store.metaKey(ctx, "s1"), store.messagesKey(ctx, "s1")} {
+				ttl, err := store.Client().TTL(ctx, key).Result()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ttl != tt.want {
+					t.Errorf("TTL of %s = %v, want %v", key, ttl, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// summaryTimeout bounds a background summarization
+const summaryTimeout = 30 * time.Second
+
+// summarizeInBackground runs SummarizeOldMessages with its own timeout and
+// logs failures; the conversation carries on unsummarized if it fails
+func (m *Manager) summarizeInBackground(ctx context.Context, sessionID string) {
+	ctx, cancel := context.WithTimeout(ctx, summaryTimeout)
+	defer cancel()
+
+	if err := m.SummarizeOldMessages(ctx, sessionID, m.summaryKeepRecent); err != nil {
+		m.logger.WarnContext(ctx, "failed to summarize session", "session_id", sessionID, "error", err)
+	}
+}
+
+// SummarizeOldMessages replaces all but the last keepRecent messages with a
+// single system message summarizing them, once the session holds more than
+// the configured threshold. The summary is stored as a real message so it
+// survives cache reloads.
+func (m *Manager) SummarizeOldMessages(ctx context.Context, sessionID string, keepRecent int) error {
+	if m.summarizer == nil {
+		return fmt.Errorf("no summarizer configured")
+	}
+	if keepRecent < 0 {
+		keepRecent = 0
+	}
+
+	messages, err := m.store.GetMessages(ctx, sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to load messages: %w", err)
+	}
+	if len(messages) <= m.summarizeAfter || len(messages) <= keepRecent {
+		return nil
+	}
+
+	old := messages[:len(messages)-keepRecent]
+	summary, err := m.summarizer.Summarize(ctx, formatTranscript(old))
+	if err != nil {
+		return err
+	}
+
+	summaryMsg := m.newMessage("system", "Summary of earlier conversation: "+summary)
+	err = m.store.Transaction(ctx, sessionID, func(session *SessionData) error {
+		// Messages may have been appended while the model was summarizing;
+		// only the summarized prefix is replaced
+		if len(session.Messages) < len(old) {
+			return fmt.Errorf("session changed while summarizing")
+		}
+		rest := session.Messages[len(old):]
+		session.Messages = append([]Message{summaryMsg}, rest...)
+		session.Metadata.MessageCount = len(session.Messages)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save summary: %w", err)
+	}
+
+	// Drop the cached buffer so the next access loads the summary
+	m.sessions.remove(cacheKey(ctx, sessionID))
+
+	m.logger.InfoContext(ctx, "summarized old messages", "session_id", sessionID, "messages", len(old))
+
+	return nil
+}
+
+// formatTranscript renders messages the same way as prompt history
+func formatTranscript(messages []Message) string {
+	var builder strings.Builder
+	for _, msg := range messages {
+		switch msg.Role {
+		case "user":
+			builder.WriteString("User: ")
+		case "assistant":
+			builder.WriteString("Assistant: ")
+		case "system":
+			builder.WriteString("System: ")
+		default:
+			continue
+		}
+		builder.WriteString(msg.Content)
+		builder.WriteString("\n")
+	}
+	return builder.String()
+}
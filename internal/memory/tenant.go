@@ -0,0 +1,50 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+)
+
+// tenantKey is the context key for the tenant whose sessions are accessed
+type tenantKey struct{}
+
+// WithTenant returns a context whose sessions are stored apart from those of
+// other tenants, so session IDs only need to be unique within a tenant. An
+// empty tenant leaves sessions unscoped.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant set by WithTenant, or ""
+func TenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// sessionKey is the key prefix a session is stored under:
+// session:<id>, or tenant:<tenant>:session:<id> for a tenant's session
+func sessionKey(ctx context.Context, sessionID string) string {
+	if tenant := TenantFromContext(ctx); tenant != "" {
+		return fmt.Sprintf("tenant:%s:session:%s", tenant, sessionID)
+	}
+	return "session:" + sessionID
+}
+
+// scopedSessionID is the ID a tenant's session is stored under in stores
+// keyed by session ID alone. Unscoped sessions keep their own ID.
+func scopedSessionID(ctx context.Context, sessionID string) string {
+	if TenantFromContext(ctx) == "" {
+		return sessionID
+	}
+	return sessionKey(ctx, sessionID)
+}
+
+// tenantSession identifies a cached session within its tenant
+type tenantSession struct {
+	tenant    string
+	sessionID string
+}
+
+func cacheKey(ctx context.Context, sessionID string) tenantSession {
+	return tenantSession{tenant: TenantFromContext(ctx), sessionID: sessionID}
+}
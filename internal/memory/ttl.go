@@ -0,0 +1,44 @@
+package memory
+
+import (
+	"context"
+	"time"
+)
+
+// sessionTTLKey is the context key for a per-session TTL override
+type sessionTTLKey struct{}
+
+// WithSessionTTL returns a context whose session writes keep the session
+// for ttl after its last activity instead of the store default. The choice
+// is saved in the session metadata, so later writes without it keep using
+// it. A ttl of 0 leaves the session's TTL unchanged.
+func WithSessionTTL(ctx context.Context, ttl time.Duration) context.Context {
+	return context.WithValue(ctx, sessionTTLKey{}, ttl)
+}
+
+// SessionTTLFromContext returns the TTL set by WithSessionTTL, or 0
+func SessionTTLFromContext(ctx context.Context) time.Duration {
+	ttl, _ := ctx.Value(sessionTTLKey{}).(time.Duration)
+	return ttl
+}
+
+// sessionTTL picks the TTL for a session write: the override in ctx, then
+// the TTL saved with the session, then the store default
+func sessionTTL(ctx context.Context, storedSeconds int, fallback time.Duration) time.Duration {
+	if ttl := SessionTTLFromContext(ctx); ttl > 0 {
+		return ttl
+	}
+	if storedSeconds > 0 {
+		return time.Duration(storedSeconds) * time.Second
+	}
+	return fallback
+}
+
+// applySessionTTL records the override in ctx, if any, in the session
+// metadata and returns the TTL the session should be saved with
+func applySessionTTL(ctx context.Context, metadata *Metadata, fallback time.Duration) time.Duration {
+	if ttl := SessionTTLFromContext(ctx); ttl > 0 {
+		metadata.TTLSeconds = int(ttl / time.Second)
+	}
+	return sessionTTL(ctx, metadata.TTLSeconds, fallback)
+}
@@ -2,14 +2,40 @@ package memory
 
 import (
 	"context"
+	"errors"
 	"time"
 )
 
+var (
+	// ErrCheckpointNotFound is returned when a checkpoint ID doesn't exist for a session
+	ErrCheckpointNotFound = errors.New("checkpoint not found")
+
+	// ErrSessionLimitExceeded is returned when a user tries to open more
+	// sessions than the configured per-user cap
+	ErrSessionLimitExceeded = errors.New("too many active sessions for user")
+
+	// ErrTransactionConflict is returned when a transaction keeps losing
+	// races with concurrent writers
+	ErrTransactionConflict = errors.New("session transaction conflict")
+
+	// ErrInvalidPage is returned for a negative offset or a non-positive limit
+	ErrInvalidPage = errors.New("offset must be non-negative and limit positive")
+
+	// ErrSessionNotFound is returned when a session doesn't exist or has
+	// expired
+	ErrSessionNotFound = errors.New("session not found")
+
+	// ErrNothingToUndo is returned by UndoLastExchange when the session has
+	// no exchange to remove
+	ErrNothingToUndo = errors.New("no exchange to undo")
+)
+
 // Message represents a single message in a conversation
 type Message struct {
-	Role      string    `json:"role"`      // "user" or "assistant"
-	Content   string    `json:"content"`   // The actual message text
-	Timestamp time.Time `json:"timestamp"` // When the message was sent
+	Role        string    `json:"role"`                   // "user" or "assistant"
+	Content     string    `json:"content"`                // The actual message text
+	Timestamp   time.Time `json:"timestamp"`              // When the message was sent
+	ContainsPII bool      `json:"contains_pii,omitempty"` // Set by the PII classifier when enabled
 }
 
 // SessionData represents all data for a conversation session
@@ -25,6 +51,38 @@ type Metadata struct {
 	StartedAt    time.Time `json:"started_at"`
 	LastActivity time.Time `json:"last_activity"`
 	MessageCount int       `json:"message_count"`
+
+	// Running token totals across every LLM call in the session
+	TotalInputTokens  int `json:"total_input_tokens"`
+	TotalOutputTokens int `json:"total_output_tokens"`
+
+	// Slots are named facts remembered across turns, e.g. the domain discussed
+	Slots map[string]string `json:"slots,omitempty"`
+
+	// The action being worked on and the parameters collected for it so far
+	PendingAction     string            `json:"pending_action,omitempty"`
+	PendingParameters map[string]string `json:"pending_parameters,omitempty"`
+
+	// Parameters the model extracted for other actions, by action. They are
+	// picked up once the conversation moves on to that action.
+	RoutedParameters map[string]map[string]string `json:"routed_parameters,omitempty"`
+
+	// User turns each READY action took, counted from the previous READY,
+	// and the user turn the last one was reached on
+	ReadyTurns    []int `json:"ready_turns,omitempty"`
+	LastReadyTurn int   `json:"last_ready_turn,omitempty"`
+
+	// TTLSeconds keeps the session this long after its last activity
+	// instead of the store default, when set
+	TTLSeconds int `json:"ttl_seconds,omitempty"`
+}
+
+// Checkpoint is a snapshot of a session's state that can be restored later
+type Checkpoint struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Messages  []Message `json:"messages"`
+	Metadata  Metadata  `json:"metadata"`
 }
 
 // Store defines the interface for conversation storage
@@ -39,6 +97,11 @@ type Store interface {
 	// GetMessages retrieves all messages for a session
 	GetMessages(ctx context.Context, sessionID string) ([]Message, error)
 
+	// GetMessagesPage retrieves up to limit messages starting at offset,
+	// oldest first, plus the session's total message count. An offset past
+	// the end returns no messages.
+	GetMessagesPage(ctx context.Context, sessionID string, offset, limit int) ([]Message, int, error)
+
 	// ClearSession removes a session from storage
 	ClearSession(ctx context.Context, sessionID string) error
 
@@ -47,4 +110,26 @@ type Store interface {
 
 	// UpdateActivity updates the last activity timestamp
 	UpdateActivity(ctx context.Context, sessionID string) error
+
+	// SaveSession overwrites a session with the given data
+	SaveSession(ctx context.Context, session *SessionData) error
+
+	// SaveCheckpoint stores a snapshot, keeping only the newest maxCount
+	SaveCheckpoint(ctx context.Context, sessionID string, checkpoint Checkpoint, maxCount int) error
+
+	// LoadCheckpoint retrieves a snapshot by ID
+	LoadCheckpoint(ctx context.Context, sessionID, checkpointID string) (*Checkpoint, error)
+
+	// CountActiveUserSessions counts a user's sessions that haven't expired
+	CountActiveUserSessions(ctx context.Context, userID string) (int, error)
+
+	// ListSessionsByUser returns the IDs of a user's unexpired sessions
+	ListSessionsByUser(ctx context.Context, userID string) ([]string, error)
+
+	// AddUsage adds token counts to the session's running totals
+	AddUsage(ctx context.Context, sessionID string, inputTokens, outputTokens int) error
+
+	// Transaction applies fn to a session and persists the result
+	// atomically. If fn returns an error none of its mutations are saved.
+	Transaction(ctx context.Context, sessionID string, fn func(session *SessionData) error) error
 }
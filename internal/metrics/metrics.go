@@ -0,0 +1,43 @@
+// Package metrics holds the service counters. They are published through
+// expvar so they can be read from the standard /debug/vars handler.
+package metrics
+
+import "expvar"
+
+var (
+	// ResponsesDroppedOnShutdown counts replies that couldn't be sent because
+	// the NATS connection was already closing or draining
+	ResponsesDroppedOnShutdown = expvar.NewInt("responses_dropped_on_shutdown")
+
+	// LenientJSONRecoveries counts model responses that only parsed after
+	// lenient normalization
+	LenientJSONRecoveries = expvar.NewInt("lenient_json_recoveries")
+
+	// JSONCorrectionAttempts counts follow-up requests asking the model to
+	// fix output that wasn't valid JSON, and JSONCorrectionSuccesses those
+	// that came back parseable
+	JSONCorrectionAttempts  = expvar.NewInt("json_correction_attempts")
+	JSONCorrectionSuccesses = expvar.NewInt("json_correction_successes")
+
+	// StreamsAbandoned counts streaming requests cancelled because the
+	// client stopped listening on its reply inbox
+	StreamsAbandoned = expvar.NewInt("streams_abandoned")
+
+	// ResponseCacheHits counts first-turn requests answered from the
+	// response cache without calling the model
+	ResponseCacheHits = expvar.NewInt("response_cache_hits")
+
+	// DeadLetters counts failed requests published to the dead-letter
+	// subject
+	DeadLetters = expvar.NewInt("dead_letters")
+
+	// IntentActions counts model responses by resolved action, "null" when
+	// none was resolved, and IntentStatuses by final status
+	IntentActions  = expvar.NewMap("intent_actions")
+	IntentStatuses = expvar.NewMap("intent_statuses")
+
+	// TurnsToReady is a histogram of the user turns it took to reach each
+	// READY action, keyed by turn count with longer conversations under
+	// "10+"
+	TurnsToReady = expvar.NewMap("turns_to_ready")
+)
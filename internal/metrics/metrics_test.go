@@ -0,0 +1,87 @@
+package metrics_test
+
+import (
+	"context"
+	"expvar"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/handlers"
+	"github.com/avvvet/cdnbuddy-intent/internal/llm"
+	"github.com/avvvet/cdnbuddy-intent/internal/metrics"
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+)
+
+// count reads one key of a counter map, 0 if it was never incremented
+func count(m *expvar.Map, key string) int64 {
+	if v, ok := m.Get(key).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func TestIntentCounters(t *testing.T) {
+	createService := "CREATE_SERVICE"
+	tests := []struct {
+		name       string
+		response   *models.IntentResponse // Parsed model reply
+		wantAction string
+		wantStatus string
+	}{
+		{
+			name:       "CREATE_SERVICE ready",
+			response:   &models.IntentResponse{Action: &createService, Status: models.StatusReady, UserMessage: "Creating it now"},
+			wantAction: "CREATE_SERVICE",
+			wantStatus: models.StatusReady,
+		},
+		{
+			name:       "no action yet",
+			response:   &models.IntentResponse{Status: models.StatusNeedsInfo, UserMessage: "What would you like to do?"},
+			wantAction: "null",
+			wantStatus: models.StatusNeedsInfo,
+		},
+		{
+			name:       "off-topic",
+			response:   &models.IntentResponse{Status: models.StatusError, UserMessage: "I can only help with your CDN."},
+			wantAction: "null",
+			wantStatus: models.StatusError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := llm.NewMockProvider()
+			provider.Enqueue(tt.response, nil)
+			h := handlers.NewIntentHandler(provider, handlers.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+
+			actionsBefore := count(metrics.IntentActions, tt.wantAction)
+			statusesBefore := count(metrics.IntentStatuses, tt.wantStatus)
+			response, err := h.ProcessIntent(context.Background(), &models.IntentRequest{
+				SessionID:        "s1",
+				UserMessage:      "create a service for example.com",
+				AvailableActions: []models.ActionSchema{{Action: "CREATE_SERVICE", Parameters: []models.ParameterSpec{{Name: "domain"}}}},
+			})
+			if err != nil {
+				t.Fatalf("ProcessIntent() error = %v", err)
+			}
+			if response.Status != tt.wantStatus {
+				t.Fatalf("status = %s, want %s", response.Status, tt.wantStatus)
+			}
+
+			if got := count(metrics.IntentActions, tt.wantAction) - actionsBefore; got != 1 {
+				t.Errorf("intent_actions[%s] went up by %d, want 1", tt.wantAction, got)
+			}
+			if got := count(metrics.IntentStatuses, tt.wantStatus) - statusesBefore; got != 1 {
+				t.Errorf("intent_statuses[%s] went up by %d, want 1", tt.wantStatus, got)
+			}
+		})
+	}
+
+	// Both maps are published for the /debug/vars handler
+	for _, name := range []string{"intent_actions", "intent_statuses"} {
+		if expvar.Get(name) == nil {
+			t.Errorf("%s is not published", name)
+		}
+	}
+}
@@ -1,21 +1,113 @@
 package models
 
+import (
+	"encoding/json"
+	"strings"
+)
+
 // NATS Request from backend
 type IntentRequest struct {
 	SessionID           string                `json:"session_id"`
+	UserID              string                `json:"user_id,omitempty"`    // Derived from the session ID when empty
+	RequestID           string                `json:"request_id,omitempty"` // Retries with the same ID get the original response
+	Reset               bool                  `json:"reset,omitempty"`      // Clear the conversation instead of analyzing the message
+	Stream              bool                  `json:"stream,omitempty"`     // Publish user_message chunks to the reply inbox before the response
+	CatalogID           string                `json:"catalog_id,omitempty"` // Registered catalog merged with available_actions
 	UserMessage         string                `json:"user_message"`
 	ConversationHistory []ConversationMessage `json:"conversation_history"`
 	AvailableActions    []ActionSchema        `json:"available_actions"`
+	Locale              string                `json:"locale,omitempty"`   // e.g. "en", "es-MX"
+	Language            string                `json:"language,omitempty"` // Language for user_message, e.g. "es"; taken from the locale when empty
+	Tenant              string                `json:"tenant,omitempty"`   // Scopes sessions and usage; a tenant request subject overrides it
+	Persona             string                `json:"persona,omitempty"`  // Overrides the deployment tone
+	Examples            []IntentExample       `json:"examples,omitempty"` // Few-shot examples for the prompt, never stored
+
+	// SessionTTLSeconds keeps the session this long after its last
+	// activity instead of the service default. It is remembered, so later
+	// requests may leave it out.
+	SessionTTLSeconds int `json:"session_ttl_seconds,omitempty"`
+}
+
+// IntentExample is a worked example shown to the model: a user message and
+// the JSON response expected for it
+type IntentExample struct {
+	UserMessage string          `json:"user_message"`
+	Response    json.RawMessage `json:"response"`
+}
+
+// MessageLocale returns the language or locale user-facing text should be
+// written in: Language when set, otherwise Locale
+func (r *IntentRequest) MessageLocale() string {
+	if r.Language != "" {
+		return r.Language
+	}
+	return r.Locale
+}
+
+// StreamChunk carries part of the user_message to a streaming client. Chunks
+// are published to the request's reply inbox ahead of the final
+// IntentResponse, which remains authoritative.
+type StreamChunk struct {
+	SessionID string `json:"session_id"`
+	Type      string `json:"type"` // StreamChunkDelta or StreamChunkHeartbeat
+	Delta     string `json:"delta,omitempty"`
 }
 
+// Stream chunk types. Heartbeats check that the client is still listening
+// and carry no text.
+const (
+	StreamChunkDelta     = "chunk"
+	StreamChunkHeartbeat = "heartbeat"
+)
+
 type ConversationMessage struct {
-	Role    string `json:"role"` // "user" or "assistant"
+	Role    string `json:"role"` // "user", "assistant" or "system"
 	Message string `json:"message"`
 }
 
 type ActionSchema struct {
-	Action     string   `json:"action"`
-	Parameters []string `json:"parameters"`
+	Action     string          `json:"action"`
+	Parameters []ParameterSpec `json:"parameters"`
+	Complexity string          `json:"complexity,omitempty"` // "simple" or "complex", inferred when empty
+}
+
+// ParameterNames returns the names of the action's parameters in order
+func (a ActionSchema) ParameterNames() []string {
+	names := make([]string, len(a.Parameters))
+	for i, param := range a.Parameters {
+		names[i] = param.Name
+	}
+	return names
+}
+
+// ParameterSpec describes one parameter of an action
+type ParameterSpec struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type,omitempty"` // "string", "int", "bool" or "enum"; string when empty
+	Required bool     `json:"required"`
+	Enum     []string `json:"enum,omitempty"` // Allowed values for enum parameters
+}
+
+// UnmarshalJSON accepts either a full spec object or, for backward
+// compatibility, a bare parameter name which is treated as a required string
+func (p *ParameterSpec) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*p = ParameterSpec{Name: name, Type: ParamTypeString, Required: true}
+		return nil
+	}
+
+	type spec ParameterSpec // Avoids recursing into this method
+	var decoded spec
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*p = ParameterSpec(decoded)
+	if p.Type == "" {
+		p.Type = ParamTypeString
+	}
+	p.Type = strings.ToLower(p.Type)
+	return nil
 }
 
 // NATS Response to backend
@@ -25,8 +117,34 @@ type IntentResponse struct {
 	Status       string             `json:"status"` // "NEEDS_INFO", "READY", "ERROR"
 	Parameters   map[string]*string `json:"parameters"`
 	UserMessage  string             `json:"user_message"`
+	Confidence   float64            `json:"confidence,omitempty"` // 0-1, how sure the model is; omitted on responses the model didn't produce
+	Model        string             `json:"model,omitempty"`      // Model that produced the response
+	InputTokens  int                `json:"input_tokens,omitempty"`
+	OutputTokens int                `json:"output_tokens,omitempty"`
 	ErrorCode    *string            `json:"error_code,omitempty"`
 	ErrorMessage *string            `json:"error_message,omitempty"`
+
+	// SuggestedNextActions lists follow-up actions offered once an action is READY
+	SuggestedNextActions []string `json:"suggested_next_actions,omitempty"`
+
+	// Meta records what produced the response, for reproducibility
+	Meta *ResponseMeta `json:"meta,omitempty"`
+
+	// CorrelationID echoes the request's X-Request-ID header, or the ID
+	// generated for it when the header was missing
+	CorrelationID string `json:"correlation_id,omitempty"`
+
+	// Replayed marks a stored response returned for a retried request_id.
+	// It isn't sent to clients.
+	Replayed bool `json:"-"`
+}
+
+// ResponseMeta identifies the prompt and model settings behind a response
+type ResponseMeta struct {
+	PromptVersion string  `json:"prompt_version,omitempty"`
+	Provider      string  `json:"provider,omitempty"`
+	Model         string  `json:"model,omitempty"`
+	Temperature   float64 `json:"temperature,omitempty"`
 }
 
 // Status constants
@@ -36,10 +154,34 @@ const (
 	StatusError     = "ERROR"
 )
 
+// Parameter types
+const (
+	ParamTypeString = "string"
+	ParamTypeInt    = "int"
+	ParamTypeBool   = "bool"
+	ParamTypeEnum   = "enum"
+)
+
+// DefaultConfidence is assumed when the model doesn't report a confidence
+const DefaultConfidence = 0.5
+
+// ActionReset is returned when the conversation was cleared, either on
+// request or because the user asked to start over
+const ActionReset = "RESET"
+
+// Action complexity levels used for model routing
+const (
+	ComplexitySimple  = "simple"
+	ComplexityComplex = "complex"
+)
+
 // Error codes
 const (
-	ErrorLLMTimeout    = "LLM_API_TIMEOUT"
-	ErrorLLMFailed     = "LLM_API_FAILED"
-	ErrorParseError    = "PARSE_ERROR"
-	ErrorUnknownIntent = "UNKNOWN_INTENT"
+	ErrorLLMTimeout     = "LLM_API_TIMEOUT"
+	ErrorLLMFailed      = "LLM_API_FAILED"
+	ErrorParseError     = "PARSE_ERROR"
+	ErrorUnknownIntent  = "UNKNOWN_INTENT"
+	ErrorSessionLimit   = "SESSION_LIMIT_EXCEEDED"
+	ErrorRateLimited    = "RATE_LIMITED"
+	ErrorBudgetExceeded = "BUDGET_EXCEEDED"
 )
@@ -0,0 +1,62 @@
+package prompts
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+)
+
+// RankActions returns at most limit actions, ordered by how many keywords
+// they share with the user message. Action names and parameter names are
+// split on underscores, so SETUP_CDN matches "set up my cdn". Ties keep their
+// original order.
+func RankActions(actions []models.ActionSchema, userMessage string, limit int) []models.ActionSchema {
+	words := make(map[string]bool)
+	for _, word := range tokenize(userMessage) {
+		words[word] = true
+	}
+
+	type scored struct {
+		action models.ActionSchema
+		score  int
+	}
+	ranked := make([]scored, len(actions))
+	for i, action := range actions {
+		score := 0
+		for _, token := range actionKeywords(action) {
+			if words[token] {
+				score++
+			}
+		}
+		ranked[i] = scored{action: action, score: score}
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].score > ranked[j].score
+	})
+
+	if limit > len(ranked) {
+		limit = len(ranked)
+	}
+	result := make([]models.ActionSchema, limit)
+	for i := 0; i < limit; i++ {
+		result[i] = ranked[i].action
+	}
+	return result
+}
+
+func actionKeywords(action models.ActionSchema) []string {
+	keywords := tokenize(strings.ReplaceAll(action.Action, "_", " "))
+	for _, param := range action.Parameters {
+		keywords = append(keywords, tokenize(strings.ReplaceAll(param.Name, "_", " "))...)
+	}
+	return keywords
+}
+
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
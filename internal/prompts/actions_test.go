@@ -0,0 +1,43 @@
+package prompts
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+)
+
+func TestRankActions(t *testing.T) {
+	actions := []models.ActionSchema{
+		{Action: "CREATE_SERVICE", Parameters: []models.ParameterSpec{{Name: "origin_url"}}},
+		{Action: "PURGE_CACHE", Parameters: []models.ParameterSpec{{Name: "service_id"}, {Name: "path"}}},
+		{Action: "DELETE_SERVICE", Parameters: []models.ParameterSpec{{Name: "service_id"}}},
+		{Action: "ENABLE_WAF"},
+	}
+
+	tests := []struct {
+		name    string
+		message string
+		limit   int
+		want    []string
+	}{
+		{name: "best match first", message: "purge the cache", limit: 2, want: []string{"PURGE_CACHE", "CREATE_SERVICE"}},
+		{name: "parameter names count", message: "set the origin url", limit: 1, want: []string{"CREATE_SERVICE"}},
+		{name: "more keywords rank higher", message: "delete the service with this service id", limit: 3, want: []string{"DELETE_SERVICE", "PURGE_CACHE", "CREATE_SERVICE"}},
+		{name: "case and punctuation ignored", message: "Enable WAF!", limit: 1, want: []string{"ENABLE_WAF"}},
+		{name: "ties keep their order", message: "hello", limit: 2, want: []string{"CREATE_SERVICE", "PURGE_CACHE"}},
+		{name: "limit above the list", message: "purge", limit: 10, want: []string{"PURGE_CACHE", "CREATE_SERVICE", "DELETE_SERVICE", "ENABLE_WAF"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, action := range RankActions(actions, tt.message, tt.limit) {
+				got = append(got, action.Action)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("RankActions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
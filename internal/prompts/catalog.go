@@ -0,0 +1,117 @@
+package prompts
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Message catalog keys
+const (
+	MsgFallback       = "fallback"
+	MsgTransportError = "transport_error"
+	MsgUnavailable    = "unavailable"
+	MsgRateLimited    = "rate_limited"
+	MsgReset          = "reset"
+	MsgConfirm        = "confirm"
+	MsgGreeting       = "greeting"
+	MsgBudget         = "budget_exceeded"
+)
+
+// DefaultLocale is used when a request has no locale or the catalog has no
+// entry for it
+const DefaultLocale = "en"
+
+// Catalog maps locale -> message key -> text
+type Catalog map[string]map[string]string
+
+var (
+	catalogMu sync.RWMutex
+	catalog   = Catalog{
+		DefaultLocale: {
+			MsgFallback:       FallbackMessage,
+			MsgTransportError: "I'm sorry, I encountered an error processing your request. Please try again.",
+			MsgUnavailable:    "I'm having trouble reaching my language service right now. Please try again in a minute.",
+			MsgRateLimited:    "You're sending messages faster than I can keep up with. Please wait a moment and try again.",
+			MsgReset:          "No problem, let's start over. What would you like to do with your CDN?",
+			MsgConfirm:        "Before I go ahead, can you confirm that's what you'd like me to do?",
+			MsgGreeting:       "How can I help you with your CDN setup?",
+			MsgBudget:         "This conversation has reached its usage limit, so I can't take further requests in it. Please contact support if you need more help.",
+		},
+		"es": {
+			MsgFallback:       "No he entendido bien tu solicitud. ¿Podrías reformular lo que necesitas respecto a la configuración o gestión de tu CDN?",
+			MsgTransportError: "Lo siento, se produjo un error al procesar tu solicitud. Inténtalo de nuevo.",
+			MsgUnavailable:    "Ahora mismo tengo problemas para conectar con mi servicio de lenguaje. Inténtalo de nuevo en un minuto.",
+			MsgRateLimited:    "Estás enviando mensajes más rápido de lo que puedo procesarlos. Espera un momento e inténtalo de nuevo.",
+			MsgReset:          "Sin problema, empecemos de nuevo. ¿Qué te gustaría hacer con tu CDN?",
+			MsgConfirm:        "Antes de continuar, ¿puedes confirmar que es eso lo que quieres que haga?",
+			MsgGreeting:       "¿Cómo puedo ayudarte con la configuración de tu CDN?",
+			MsgBudget:         "Esta conversación ha alcanzado su límite de uso, así que no puedo atender más solicitudes en ella. Contacta con soporte si necesitas más ayuda.",
+		},
+		"fr": {
+			MsgFallback:       "Je n'ai pas bien compris votre demande. Pourriez-vous reformuler ce dont vous avez besoin pour la configuration ou la gestion de votre CDN ?",
+			MsgTransportError: "Désolé, une erreur s'est produite lors du traitement de votre demande. Veuillez réessayer.",
+			MsgUnavailable:    "J'ai du mal à joindre mon service de langage pour le moment. Veuillez réessayer dans une minute.",
+			MsgRateLimited:    "Vous envoyez des messages plus vite que je ne peux les traiter. Patientez un instant puis réessayez.",
+			MsgReset:          "Pas de problème, recommençons. Que souhaitez-vous faire avec votre CDN ?",
+			MsgConfirm:        "Avant de continuer, pouvez-vous confirmer que c'est bien ce que vous souhaitez ?",
+			MsgGreeting:       "Comment puis-je vous aider à configurer votre CDN ?",
+			MsgBudget:         "Cette conversation a atteint sa limite d'utilisation, je ne peux donc plus traiter de demandes ici. Contactez le support si vous avez besoin d'aide.",
+		},
+	}
+)
+
+// LoadCatalogFile merges a JSON catalog file of the form
+// {"es": {"fallback": "..."}} over the built-in messages
+func LoadCatalogFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read message catalog: %w", err)
+	}
+
+	var loaded Catalog
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return fmt.Errorf("failed to parse message catalog: %w", err)
+	}
+
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+	for locale, messages := range loaded {
+		locale = normalizeLocale(locale)
+		if catalog[locale] == nil {
+			catalog[locale] = make(map[string]string)
+		}
+		for key, text := range messages {
+			catalog[locale][key] = text
+		}
+	}
+	return nil
+}
+
+// Localize returns the message for key in the given locale. A regional
+// locale such as "es-MX" falls back to "es", and anything missing falls
+// back to English.
+func Localize(locale, key string) string {
+	catalogMu.RLock()
+	defer catalogMu.RUnlock()
+
+	locale = normalizeLocale(locale)
+	candidates := []string{locale}
+	if base, _, found := strings.Cut(locale, "-"); found {
+		candidates = append(candidates, base)
+	}
+	candidates = append(candidates, DefaultLocale)
+
+	for _, candidate := range candidates {
+		if text, ok := catalog[candidate][key]; ok {
+			return text
+		}
+	}
+	return ""
+}
+
+func normalizeLocale(locale string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(locale)), "_", "-")
+}
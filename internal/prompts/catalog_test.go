@@ -0,0 +1,98 @@
+package prompts
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// restoreCatalog puts back the built-in catalog after a test loads a file
+func restoreCatalog(t *testing.T) {
+	t.Helper()
+	catalogMu.Lock()
+	saved := make(Catalog, len(catalog))
+	for locale, messages := range catalog {
+		saved[locale] = make(map[string]string, len(messages))
+		for key, text := range messages {
+			saved[locale][key] = text
+		}
+	}
+	catalogMu.Unlock()
+
+	t.Cleanup(func() {
+		catalogMu.Lock()
+		defer catalogMu.Unlock()
+		catalog = saved
+	})
+}
+
+func TestLocalize(t *testing.T) {
+	tests := []struct {
+		name   string
+		locale string
+		key    string
+		want   string
+	}{
+		{name: "default", key: MsgFallback, want: FallbackMessage},
+		{name: "english", locale: "en", key: MsgFallback, want: FallbackMessage},
+		{name: "spanish", locale: "es", key: MsgFallback, want: catalog["es"][MsgFallback]},
+		{name: "regional locale", locale: "es-MX", key: MsgTransportError, want: catalog["es"][MsgTransportError]},
+		{name: "underscore and case", locale: " FR_ca ", key: MsgFallback, want: catalog["fr"][MsgFallback]},
+		{name: "unknown locale", locale: "ja", key: MsgFallback, want: FallbackMessage},
+		{name: "unknown key", locale: "es", key: "missing"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Localize(tt.locale, tt.key); got != tt.want {
+				t.Errorf("Localize(%q, %q) = %q, want %q", tt.locale, tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadCatalogFile(t *testing.T) {
+	restoreCatalog(t)
+	spanishTransport := Localize("es", MsgTransportError)
+
+	path := filepath.Join(t.TempDir(), "catalog.json")
+	catalogJSON := `{"de": {"fallback": "Das habe ich nicht verstanden."}, "ES": {"fallback": "¿Cómo dices?"}}`
+	if err := os.WriteFile(path, []byte(catalogJSON), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadCatalogFile(path); err != nil {
+		t.Fatalf("LoadCatalogFile() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		locale string
+		key    string
+		want   string
+	}{
+		{name: "new locale", locale: "de-AT", key: MsgFallback, want: "Das habe ich nicht verstanden."},
+		{name: "new locale missing key", locale: "de", key: MsgTransportError, want: Localize("en", MsgTransportError)},
+		{name: "overridden message", locale: "es", key: MsgFallback, want: "¿Cómo dices?"},
+		{name: "other messages kept", locale: "es", key: MsgTransportError, want: spanishTransport},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Localize(tt.locale, tt.key); got != tt.want {
+				t.Errorf("Localize(%q, %q) = %q, want %q", tt.locale, tt.key, got, tt.want)
+			}
+		})
+	}
+
+	for _, bad := range []string{"missing.json", "invalid.json"} {
+		path := filepath.Join(t.TempDir(), bad)
+		if bad == "invalid.json" {
+			if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := LoadCatalogFile(path); err == nil {
+			t.Errorf("LoadCatalogFile(%s) error = nil, want an error", bad)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package prompts
+
+import (
+	"fmt"
+	"strings"
+)
+
+// languageNames maps language codes to the name used in the prompt. Codes
+// missing here are passed to the model as they are.
+var languageNames = map[string]string{
+	"de": "German",
+	"en": "English",
+	"es": "Spanish",
+	"fr": "French",
+	"it": "Italian",
+	"nl": "Dutch",
+	"pt": "Portuguese",
+}
+
+// LanguageInstruction tells the model which language to write user_message
+// in, given a language code or locale such as "es" or "fr-CA". English
+// needs no instruction, so it returns nothing for English or an empty code.
+func LanguageInstruction(locale string) string {
+	code, _, _ := strings.Cut(normalizeLocale(locale), "-")
+	if code == "" || code == DefaultLocale {
+		return ""
+	}
+
+	name, ok := languageNames[code]
+	if !ok {
+		name = fmt.Sprintf("the language with code %q", code)
+	}
+	return fmt.Sprintf("Write user_message in %s. Keep the JSON keys, action names, status values and parameter names in English exactly as listed.", name)
+}
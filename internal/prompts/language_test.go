@@ -0,0 +1,49 @@
+package prompts
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+)
+
+func TestLanguageInstruction(t *testing.T) {
+	tests := []struct {
+		name    string
+		request models.IntentRequest
+		want    string // Language named in the prompt, empty for no instruction
+	}{
+		{name: "no language", request: models.IntentRequest{}},
+		{name: "english", request: models.IntentRequest{Language: "en"}},
+		{name: "english locale", request: models.IntentRequest{Locale: "en-GB"}},
+		{name: "spanish", request: models.IntentRequest{Language: "es"}, want: "Spanish"},
+		{name: "french from the locale", request: models.IntentRequest{Locale: "fr-CA"}, want: "French"},
+		{name: "language beats locale", request: models.IntentRequest{Language: "es", Locale: "fr-FR"}, want: "Spanish"},
+		{name: "english language beats locale", request: models.IntentRequest{Language: "en", Locale: "fr-FR"}},
+		{name: "unlisted language", request: models.IntentRequest{Language: "sw"}, want: `the language with code "sw"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := LanguageInstruction(tt.request.MessageLocale())
+			if tt.want == "" {
+				if got != "" {
+					t.Errorf("LanguageInstruction() = %q, want none", got)
+				}
+				return
+			}
+			if !strings.Contains(got, "Write user_message in "+tt.want+".") {
+				t.Errorf("LanguageInstruction() = %q, want it to ask for %s", got, tt.want)
+			}
+
+			// The instruction reaches the system prompt, with JSON kept in English
+			system := BuildSystemPrompt(&tt.request, nil, "")
+			if !strings.Contains(system, got) {
+				t.Errorf("system prompt is missing %q", got)
+			}
+			if !strings.Contains(got, "in English exactly as listed") {
+				t.Errorf("LanguageInstruction() = %q, want it to keep keys and actions in English", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package prompts
+
+// DefaultPersona is used when neither the deployment nor the request picks one
+const DefaultPersona = "friendly"
+
+// personas maps the allowed persona names to their tone instruction. The
+// instructions only shape user_message; the JSON rules are unaffected.
+var personas = map[string]string{
+	"friendly": "Write user_message in a warm, friendly and encouraging tone. Keep it conversational and avoid jargon.",
+	"formal":   "Write user_message in a formal, professional tone. Use complete sentences and avoid slang or emoji.",
+	"terse":    "Write user_message as briefly as possible. Ask only for what is missing, in one short sentence.",
+}
+
+// IsKnownPersona reports whether name is on the persona allowlist
+func IsKnownPersona(name string) bool {
+	_, ok := personas[name]
+	return ok
+}
+
+// ResolvePersona returns requested if it is allowed, otherwise fallback,
+// otherwise DefaultPersona
+func ResolvePersona(requested, fallback string) string {
+	if IsKnownPersona(requested) {
+		return requested
+	}
+	if IsKnownPersona(fallback) {
+		return fallback
+	}
+	return DefaultPersona
+}
+
+// PersonaInstruction returns the tone instruction for a persona
+func PersonaInstruction(name string) string {
+	return personas[ResolvePersona(name, DefaultPersona)]
+}
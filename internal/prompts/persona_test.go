@@ -0,0 +1,39 @@
+package prompts
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+)
+
+func TestPersonaInPrompt(t *testing.T) {
+	tests := []struct {
+		name       string
+		deployment string // Deployment default, as set by ASSISTANT_PERSONA
+		requested  string // Per-request override
+		want       string
+	}{
+		{name: "built-in default", want: "friendly"},
+		{name: "deployment default", deployment: "formal", want: "formal"},
+		{name: "request override", deployment: "formal", requested: "terse", want: "terse"},
+		{name: "unknown request persona", deployment: "formal", requested: "pirate", want: "formal"},
+		{name: "unknown deployment persona", deployment: "pirate", want: DefaultPersona},
+		{name: "persona names are case sensitive", requested: "Terse", want: DefaultPersona},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			system := BuildSystemPrompt(&models.IntentRequest{UserMessage: "purge the cache", Persona: tt.requested}, nil, tt.deployment)
+			for name, instruction := range personas {
+				if got := strings.Contains(system, instruction); got != (name == tt.want) {
+					t.Errorf("prompt has the %s instruction = %v, want %v", name, got, name == tt.want)
+				}
+			}
+			// The tone never replaces the output rules
+			if !strings.Contains(system, "You must respond with a valid JSON object") {
+				t.Error("prompt is missing the JSON response format")
+			}
+		})
+	}
+}
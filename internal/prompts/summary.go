@@ -0,0 +1,19 @@
+package prompts
+
+import "fmt"
+
+// summaryPrompt asks the model to condense older turns into facts the
+// intent prompt can keep relying on
+const summaryPrompt = `Summarize the following conversation between a user and the CDNbuddy assistant.
+
+Keep every fact the assistant may still need: requested actions, domain names, origin hostnames, settings, decisions and any open questions. Drop greetings and small talk. Write plain sentences, no more than 150 words, and do not invent details.
+
+Conversation:
+%s
+
+Summary:`
+
+// BuildSummaryPrompt returns the prompt used to summarize old messages
+func BuildSummaryPrompt(transcript string) string {
+	return fmt.Sprintf(summaryPrompt, transcript)
+}
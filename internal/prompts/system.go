@@ -0,0 +1,133 @@
+package prompts
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync"
+	"text/template"
+)
+
+// SystemPromptData is passed to the system prompt template
+type SystemPromptData struct {
+	Persona string // Tone instruction for user_message
+	Actions string // Available actions, one per line
+	Facts   string // Known facts, one per line, or "None"
+
+	// Language instruction for user_message, empty for English
+	Language string
+}
+
+// DefaultSystemTemplate is the built-in system prompt. Deployments can
+// replace it with LoadSystemTemplateFile.
+const DefaultSystemTemplate = `You are an AI assistant for CDNbuddy, a CDN management platform. Your job is to analyze user conversations and determine what CDN-related actions they want to perform.
+
+IMPORTANT RULES:
+1. Work on ONE action at a time, even if multiple actions are mentioned
+2. If multiple actions are mentioned, pick the first one mentioned
+3. Extract parameters from the conversation for the selected action
+4. If you need more information, ask specific questions
+5. When an action is complete, you can ask "Do you have any other requirements?"
+6. IMPORTANT: Review the ENTIRE conversation history before responding - don't ask for information already provided
+7. Parameter values must match the listed type; for "one of" parameters use exactly one of the allowed values
+8. If the user wants to abandon what they are doing and start over (e.g. "never mind, scratch that"), respond with action "RESET"
+9. If the request can't be mapped to any available action (e.g. it is off-topic), respond with action null and status "ERROR", and say what you can help with in user_message
+10. Set confidence between 0 and 1 to how sure you are that the action and parameters are what the user wants
+
+CDN SETUP REQUIREMENTS:
+When user wants to setup CDN (SETUP_CDN action), you MUST collect these TWO pieces of information:
+1. Domain name - The website domain (e.g., "example.com")
+2. Origin hostname - Where content is currently hosted (e.g., "yellowgreen.com", "backend.example.com")
+
+For the origin hostname:
+- Ask: "Where is your website currently hosted? This can be a domain name or subdomain."
+- If user doesn't provide it explicitly, ask: "What's the hostname where your content is currently served from?"
+- Examples of valid origins: "origin.example.com", "example.com", "server.company.com", "backend.example.com"
+
+ONLY return status="READY" and action="SETUP_CDN" when you have BOTH:
+- parameter "domain" with the website domain
+- parameter "origin_hostname" with the origin server hostname
+
+If you only have the domain but not the origin, ask for the origin hostname specifically.
+
+TONE:
+{{.Persona}}{{if .Language}}
+{{.Language}}{{end}}
+The tone only applies to user_message. Always follow the response format below exactly.
+
+RESPONSE FORMAT:
+You must respond with a valid JSON object in this exact format:
+{
+ "action": "ACTION_NAME or null",
+ "status": "NEEDS_INFO, READY or ERROR",
+ "parameters": {
+ "param_name": "extracted_value or null"
+ },
+ "user_message": "Your response to the user",
+ "confidence": 0.0 to 1.0
+}
+
+Available Actions:
+{{.Actions}}
+
+Known Facts (already confirmed in this session - use them and don't ask for them again):
+{{.Facts}}`
+
+var builtinSystemTemplate = template.Must(template.New("system").Parse(DefaultSystemTemplate))
+
+var (
+	systemTemplateMu sync.RWMutex
+	systemTemplate   = builtinSystemTemplate
+	systemTemplateID string // Empty for the built-in template
+)
+
+// LoadSystemTemplateFile replaces the built-in system prompt with a
+// text/template file. The template is rendered once with placeholder data
+// so mistakes such as unknown fields are reported here rather than on
+// the first request.
+func LoadSystemTemplateFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read prompt template: %w", err)
+	}
+
+	tmpl, err := template.New("system").Parse(string(data))
+	if err != nil {
+		return fmt.Errorf("failed to parse prompt template: %w", err)
+	}
+	if err := tmpl.Execute(&bytes.Buffer{}, SystemPromptData{}); err != nil {
+		return fmt.Errorf("invalid prompt template: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	systemTemplateMu.Lock()
+	defer systemTemplateMu.Unlock()
+	systemTemplate = tmpl
+	systemTemplateID = hex.EncodeToString(sum[:4])
+	return nil
+}
+
+// SystemTemplateID identifies a loaded template by a hash of its content,
+// or is empty while the built-in template is in use
+func SystemTemplateID() string {
+	systemTemplateMu.RLock()
+	defer systemTemplateMu.RUnlock()
+	return systemTemplateID
+}
+
+// RenderSystemPrompt renders the system prompt template
+func RenderSystemPrompt(data SystemPromptData) string {
+	systemTemplateMu.RLock()
+	tmpl := systemTemplate
+	systemTemplateMu.RUnlock()
+
+	var builder bytes.Buffer
+	if err := tmpl.Execute(&builder, data); err != nil {
+		// Templates are checked when loaded, so this can't normally happen
+		builder.Reset()
+		_ = builtinSystemTemplate.Execute(&builder, data)
+	}
+	return builder.String()
+}
@@ -0,0 +1,77 @@
+package prompts
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+)
+
+// restoreSystemTemplate puts back the built-in system prompt after a test
+// loads a template file
+func restoreSystemTemplate(t *testing.T) {
+	t.Helper()
+	systemTemplateMu.RLock()
+	saved, savedID := systemTemplate, systemTemplateID
+	systemTemplateMu.RUnlock()
+
+	t.Cleanup(func() {
+		systemTemplateMu.Lock()
+		defer systemTemplateMu.Unlock()
+		systemTemplate, systemTemplateID = saved, savedID
+	})
+}
+
+func TestLoadSystemTemplateFile(t *testing.T) {
+	request := &models.IntentRequest{
+		UserMessage:      "purge the cache",
+		AvailableActions: []models.ActionSchema{{Action: "PURGE_CACHE", Parameters: []models.ParameterSpec{{Name: "service_id", Required: true}}}},
+	}
+	facts := map[string]string{"domain": "example.com"}
+
+	tests := []struct {
+		name     string
+		template string // Contents of the file, empty to leave it missing
+		wantErr  bool
+		want     []string // Present in the system prompt
+	}{
+		{
+			name:     "custom template",
+			template: "You are ACME's CDN assistant. Be brief.\n\nActions:\n{{.Actions}}\nFacts:\n{{.Facts}}",
+			want:     []string{"You are ACME's CDN assistant. Be brief.", "- PURGE_CACHE: requires [service_id (string)]", "- domain: example.com"},
+		},
+		{name: "missing file", wantErr: true, want: []string{"You are an AI assistant for CDNbuddy"}},
+		{name: "parse error", template: "{{.Actions", wantErr: true, want: []string{"You are an AI assistant for CDNbuddy"}},
+		{name: "unknown field", template: "{{.Tenant}}", wantErr: true, want: []string{"You are an AI assistant for CDNbuddy"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restoreSystemTemplate(t)
+			path := filepath.Join(t.TempDir(), "system.tmpl")
+			if tt.template != "" {
+				if err := os.WriteFile(path, []byte(tt.template), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := LoadSystemTemplateFile(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadSystemTemplateFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			// A template that fails to load leaves the built-in one in place
+			if id := SystemTemplateID(); (id != "") == tt.wantErr {
+				t.Errorf("SystemTemplateID() = %q after error = %v", id, err)
+			}
+
+			system := BuildSystemPrompt(request, facts, "")
+			for _, want := range tt.want {
+				if !strings.Contains(system, want) {
+					t.Errorf("system prompt is missing %q:\n%s", want, system)
+				}
+			}
+		})
+	}
+}
@@ -1,115 +1,114 @@
 package prompts
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/avvvet/cdnbuddy-intent/internal/models"
 )
 
-const SystemPrompt = `You are an AI assistant for CDNbuddy, a CDN management platform. Your job is to analyze user conversations and determine what CDN-related actions they want to perform.
-
-IMPORTANT RULES:
-1. Work on ONE action at a time, even if multiple actions are mentioned
-2. If multiple actions are mentioned, pick the first one mentioned
-3. Extract parameters from the conversation for the selected action
-4. If you need more information, ask specific questions
-5. When an action is complete, you can ask "Do you have any other requirements?"
-
-RESPONSE FORMAT:
-You must respond with a valid JSON object in this exact format:
-{
-  "action": "ACTION_NAME or null",
-  "status": "NEEDS_INFO or READY", 
-  "parameters": {
-    "param_name": "extracted_value or null"
-  },
-  "user_message": "Your response to the user"
-}
-
-Available Actions:
-%s
+const FallbackMessage = "I didn't understand your request clearly. Could you please rephrase what you'd like me to help you with regarding CDN setup or management?"
 
-Current Conversation:
+// userTurnTemplate carries the pre-formatted history and the current message
+const userTurnTemplate = `Conversation History:
 %s
 
-Analyze the conversation and respond with the JSON format above.`
-
-const FallbackMessage = "I didn't understand your request clearly. Could you please rephrase what you'd like me to help you with regarding CDN setup or management?"
-
-func BuildIntentPrompt(request *models.IntentRequest) string {
-	// Build available actions section
-	actionsSection := buildActionsSection(request.AvailableActions)
+Current User Message: %s
 
-	// Build conversation section
-	conversationSection := buildConversationSection(request.ConversationHistory, request.UserMessage)
+Analyze the FULL conversation history above and respond with the JSON format. Remember to check what information was already provided in previous messages.`
 
-	return fmt.Sprintf(SystemPrompt, actionsSection, conversationSection)
+// BuildIntentPrompt renders the whole prompt as a single message, for
+// providers without a separate system field: the system prompt followed by
+// the pre-formatted history and the current message
+func BuildIntentPrompt(request *models.IntentRequest, formattedHistory string, knownFacts map[string]string, defaultPersona string) string {
+	return BuildSystemPrompt(request, knownFacts, defaultPersona) + "\n\n" + fmt.Sprintf(userTurnTemplate, formattedHistory, request.UserMessage)
 }
 
-func buildActionsSection(actions []models.ActionSchema) string {
-	var builder strings.Builder
-
-	for _, action := range actions {
-		builder.WriteString(fmt.Sprintf("- %s: requires [%s]\n",
-			action.Action,
-			strings.Join(action.Parameters, ", ")))
+// BuildSystemPrompt renders the instructions, available actions and known
+// facts. Providers with a native system field send it there and the
+// conversation as separate messages.
+func BuildSystemPrompt(request *models.IntentRequest, knownFacts map[string]string, defaultPersona string) string {
+	// Pick the tone, falling back to the deployment default for unknown personas
+	persona := ResolvePersona(request.Persona, defaultPersona)
+
+	system := RenderSystemPrompt(SystemPromptData{
+		Persona:  PersonaInstruction(persona),
+		Actions:  describeActions(request.AvailableActions),
+		Facts:    describeFacts(knownFacts),
+		Language: LanguageInstruction(request.MessageLocale()),
+	})
+
+	// Examples follow the instructions, whatever template is in use, so they
+	// sit just before the conversation
+	if len(request.Examples) > 0 {
+		system = strings.TrimRight(system, "\n") + "\n\n" + describeExamples(request.Examples)
 	}
+	return system
+}
 
-	return builder.String()
+// describeExamples renders few-shot examples with compacted responses
+func describeExamples(examples []models.IntentExample) string {
+	described := make([]string, len(examples))
+	for i, example := range examples {
+		response := string(example.Response)
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, example.Response); err == nil {
+			response = compact.String()
+		}
+		described[i] = fmt.Sprintf("User: %s\nResponse: %s", example.UserMessage, response)
+	}
+	return "Examples:\n\n" + strings.Join(described, "\n\n")
 }
 
-func buildConversationSection(history []models.ConversationMessage, currentMessage string) string {
+// describeActions lists the available actions, one per line
+func describeActions(actions []models.ActionSchema) string {
 	var builder strings.Builder
-
-	// Add conversation history
-	for _, msg := range history {
-		builder.WriteString(fmt.Sprintf("%s: %s\n", strings.Title(msg.Role), msg.Message))
+	for _, action := range actions {
+		builder.WriteString(fmt.Sprintf("- %s: requires [%s]\n", action.Action, DescribeParameters(action.Parameters)))
 	}
-
-	// Add current user message
-	builder.WriteString(fmt.Sprintf("User: %s\n", currentMessage))
-
 	return builder.String()
 }
 
-func ParseLLMResponse(content string) (*models.IntentResponse, error) {
-	// Try to extract JSON from the response
-	jsonContent := extractJSON(content)
-	if jsonContent == "" {
-		return nil, fmt.Errorf("no valid JSON found in response")
-	}
-
-	var response models.IntentResponse
-	if err := json.Unmarshal([]byte(jsonContent), &response); err != nil {
-		return nil, fmt.Errorf("failed to parse JSON: %w", err)
+// describeFacts renders memory slots in a stable order
+func describeFacts(facts map[string]string) string {
+	if len(facts) == 0 {
+		return "None\n"
 	}
 
-	// Validate required fields
-	if response.Status == "" {
-		response.Status = models.StatusError
-		response.UserMessage = FallbackMessage
+	names := make([]string, 0, len(facts))
+	for name := range facts {
+		names = append(names, name)
 	}
+	sort.Strings(names)
 
-	if response.Parameters == nil {
-		response.Parameters = make(map[string]*string)
+	var builder strings.Builder
+	for _, name := range names {
+		builder.WriteString(fmt.Sprintf("- %s: %s\n", name, facts[name]))
 	}
-
-	return &response, nil
+	return builder.String()
 }
 
-func extractJSON(content string) string {
-	// Look for JSON object in the content
-	start := strings.Index(content, "{")
-	if start == -1 {
-		return ""
+// DescribeParameters renders parameter specs for a prompt, including each
+// parameter's type and, for enums, the allowed values
+func DescribeParameters(params []models.ParameterSpec) string {
+	described := make([]string, len(params))
+	for i, param := range params {
+		var kind string
+		switch {
+		case param.Type == models.ParamTypeEnum && len(param.Enum) > 0:
+			kind = "one of: " + strings.Join(param.Enum, ", ")
+		case param.Type == "":
+			kind = models.ParamTypeString
+		default:
+			kind = param.Type
+		}
+		if !param.Required {
+			kind += "; optional"
+		}
+		described[i] = fmt.Sprintf("%s (%s)", param.Name, kind)
 	}
-
-	end := strings.LastIndex(content, "}")
-	if end == -1 || end <= start {
-		return ""
-	}
-
-	return content[start : end+1]
+	return strings.Join(described, ", ")
 }
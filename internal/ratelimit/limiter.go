@@ -0,0 +1,142 @@
+// Package ratelimit provides token-bucket rate limiters keyed by an
+// arbitrary string such as a session or user ID.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// bucketScript refills and takes from a token bucket atomically. The
+// bucket expires once it would be full again, so idle keys don't pile up.
+var bucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tokens, 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate))
+return allowed
+`)
+
+// RedisLimiter is a token bucket shared by every replica through Redis
+type RedisLimiter struct {
+	client    redis.Scripter
+	perMinute int
+	burst     int
+}
+
+// NewRedisLimiter allows perMinute requests per key on average, with bursts
+// of up to burst requests. A burst below 1 defaults to perMinute.
+func NewRedisLimiter(client redis.Scripter, perMinute, burst int) *RedisLimiter {
+	if burst < 1 {
+		burst = perMinute
+	}
+	return &RedisLimiter{
+		client:    client,
+		perMinute: perMinute,
+		burst:     burst,
+	}
+}
+
+// bucketKey generates the Redis key for a key's bucket
+func (l *RedisLimiter) bucketKey(key string) string {
+	return fmt.Sprintf("ratelimit:%s", key)
+}
+
+// Allow takes a token from the key's bucket, reporting false if it is empty
+func (l *RedisLimiter) Allow(ctx context.Context, key string) (bool, error) {
+	ratePerMs := float64(l.perMinute) / float64(time.Minute.Milliseconds())
+	allowed, err := bucketScript.Run(ctx, l.client, []string{l.bucketKey(key)},
+		ratePerMs, l.burst, time.Now().UnixMilli()).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to check rate limit: %w", err)
+	}
+	return allowed == 1, nil
+}
+
+// LocalLimiter is an in-process token bucket for deployments without Redis.
+// Each replica enforces the limit on its own.
+type LocalLimiter struct {
+	mu        sync.Mutex
+	perMinute int
+	burst     int
+	buckets   map[string]*bucket
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewLocalLimiter allows perMinute requests per key on average, with bursts
+// of up to burst requests. A burst below 1 defaults to perMinute.
+func NewLocalLimiter(perMinute, burst int) *LocalLimiter {
+	if burst < 1 {
+		burst = perMinute
+	}
+	return &LocalLimiter{
+		perMinute: perMinute,
+		burst:     burst,
+		buckets:   make(map[string]*bucket),
+		now:       time.Now,
+	}
+}
+
+// Allow takes a token from the key's bucket, reporting false if it is empty
+func (l *LocalLimiter) Allow(ctx context.Context, key string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	rate := float64(l.perMinute) / time.Minute.Seconds()
+	l.prune(now, rate)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(l.burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, nil
+	}
+	b.tokens--
+	return true, nil
+}
+
+// prune drops buckets that have refilled completely, which behave exactly
+// like new ones. It scans at most once a minute. Callers must hold the lock.
+func (l *LocalLimiter) prune(now time.Time, rate float64) {
+	if now.Sub(l.lastPrune) < time.Minute {
+		return
+	}
+	l.lastPrune = now
+
+	full := time.Duration(float64(l.burst) / rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, key)
+		}
+	}
+}
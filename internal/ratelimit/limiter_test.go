@@ -0,0 +1,139 @@
+package ratelimit
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+type step struct {
+	after time.Duration // Time passed since the previous request
+	key   string
+	want  bool
+}
+
+var limiterTests = []struct {
+	name      string
+	perMinute int
+	burst     int
+	steps     []step
+}{
+	{
+		name:      "burst then deny",
+		perMinute: 60, burst: 3,
+		steps: []step{{key: "a", want: true}, {key: "a", want: true}, {key: "a", want: true}, {key: "a", want: false}},
+	},
+	{
+		name:      "burst defaults to the rate",
+		perMinute: 2,
+		steps:     []step{{key: "a", want: true}, {key: "a", want: true}, {key: "a", want: false}},
+	},
+	{
+		name:      "keys are independent",
+		perMinute: 60, burst: 1,
+		steps: []step{{key: "a", want: true}, {key: "a", want: false}, {key: "b", want: true}, {key: "b", want: false}},
+	},
+	{
+		name:      "refills over time",
+		perMinute: 60, burst: 1,
+		steps: []step{{key: "a", want: true}, {key: "a", want: false}, {after: 500 * time.Millisecond, key: "a", want: false}, {after: 600 * time.Millisecond, key: "a", want: true}},
+	},
+	{
+		name:      "refill is capped at the burst",
+		perMinute: 60, burst: 2,
+		steps: []step{{key: "a", want: true}, {after: time.Hour, key: "a", want: true}, {key: "a", want: true}, {key: "a", want: false}},
+	},
+}
+
+func TestLocalLimiter(t *testing.T) {
+	for _, tt := range limiterTests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+			l := NewLocalLimiter(tt.perMinute, tt.burst)
+			l.now = func() time.Time { return now }
+
+			for i, s := range tt.steps {
+				now = now.Add(s.after)
+				got, err := l.Allow(context.Background(), s.key)
+				if err != nil {
+					t.Fatalf("step %d: Allow() error = %v", i, err)
+				}
+				if got != s.want {
+					t.Errorf("step %d: Allow(%q) = %v, want %v", i, s.key, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestLocalLimiterPrune(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := NewLocalLimiter(60, 1)
+	l.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for _, key := range []string{"a", "b", "c"} {
+		if _, err := l.Allow(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now = now.Add(2 * time.Minute)
+	if _, err := l.Allow(ctx, "d"); err != nil {
+		t.Fatal(err)
+	}
+	if len(l.buckets) != 1 {
+		t.Errorf("%d buckets kept, want only the new one", len(l.buckets))
+	}
+}
+
+func TestRedisLimiter(t *testing.T) {
+	for _, tt := range limiterTests {
+		// The script reads the real clock, so cases that wait for a refill
+		// are left to TestRedisLimiterRefill
+		if slices.ContainsFunc(tt.steps, func(s step) bool { return s.after > 0 }) {
+			continue
+		}
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewRedisLimiter(newTestRedis(t), tt.perMinute, tt.burst)
+
+			for i, s := range tt.steps {
+				got, err := l.Allow(context.Background(), s.key)
+				if err != nil {
+					t.Fatalf("step %d: Allow() error = %v", i, err)
+				}
+				if got != s.want {
+					t.Errorf("step %d: Allow(%q) = %v, want %v", i, s.key, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRedisLimiterRefill(t *testing.T) {
+	// One token every 10ms
+	l := NewRedisLimiter(newTestRedis(t), 6000, 1)
+	ctx := context.Background()
+
+	for i, want := range []bool{true, false} {
+		if got, err := l.Allow(ctx, "a"); err != nil || got != want {
+			t.Fatalf("request %d: Allow() = %v, %v, want %v", i, got, err, want)
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	if got, err := l.Allow(ctx, "a"); err != nil || !got {
+		t.Errorf("after refill: Allow() = %v, %v, want true", got, err)
+	}
+}
+
+// newTestRedis returns a client for an in-process Redis server
+func newTestRedis(t *testing.T) *redis.Client {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return client
+}
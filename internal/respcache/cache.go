@@ -0,0 +1,51 @@
+// Package respcache stores model replies to first-turn prompts so identical
+// opening messages can be answered without calling the model again.
+package respcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Cache stores replies in Redis keyed by a hash of the prompt
+type Cache struct {
+	client redis.Cmdable
+	ttl    time.Duration // How long a reply is reused
+}
+
+// NewCache creates a Redis-backed reply cache
+func NewCache(client redis.Cmdable, ttl time.Duration) *Cache {
+	return &Cache{
+		client: client,
+		ttl:    ttl,
+	}
+}
+
+// replyKey generates the Redis key for a prompt hash
+func (c *Cache) replyKey(key string) string {
+	return fmt.Sprintf("response_cache:%s", key)
+}
+
+// Get returns the cached reply for key and whether there was one
+func (c *Cache) Get(ctx context.Context, key string) (string, bool, error) {
+	reply, err := c.client.Get(ctx, c.replyKey(key)).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get cached reply: %w", err)
+	}
+	return reply, true, nil
+}
+
+// Save caches the reply for key
+func (c *Cache) Save(ctx context.Context, key, reply string) error {
+	if err := c.client.Set(ctx, c.replyKey(key), reply, c.ttl).Err(); err != nil {
+		return fmt.Errorf("failed to cache reply: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package respcache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestCache(t *testing.T) {
+	tests := []struct {
+		name    string
+		save    string        // Reply saved under "k1", empty to save nothing
+		elapsed time.Duration // Time passed before the lookup
+		wantHit bool
+	}{
+		{name: "miss"},
+		{name: "hit", save: `{"status": "READY"}`, wantHit: true},
+		{name: "expired", save: `{"status": "READY"}`, elapsed: 2 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr := miniredis.RunT(t)
+			client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+			t.Cleanup(func() { client.Close() })
+			cache := NewCache(client, time.Hour)
+			ctx := context.Background()
+
+			if tt.save != "" {
+				if err := cache.Save(ctx, "k1", tt.save); err != nil {
+					t.Fatalf("Save() error = %v", err)
+				}
+			}
+			mr.FastForward(tt.elapsed)
+
+			reply, hit, err := cache.Get(ctx, "k1")
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			if hit != tt.wantHit {
+				t.Fatalf("Get() hit = %v, want %v", hit, tt.wantHit)
+			}
+			if hit && reply != tt.save {
+				t.Errorf("Get() = %q, want %q", reply, tt.save)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package transport
+
+import (
+	"crypto/tls"
+	"strings"
+	"time"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/config"
+	"github.com/nats-io/nats.go"
+)
+
+// ConnectOptions builds the NATS connection options for cfg, including its
+// authentication and TLS settings
+func ConnectOptions(cfg *config.Config) []nats.Option {
+	opts := []nats.Option{
+		nats.Name(cfg.ServiceName),
+		nats.Timeout(cfg.NatsTimeout),
+		nats.ReconnectWait(2 * time.Second),
+		nats.MaxReconnects(-1), // Infinite reconnects
+	}
+	if auth := authOption(cfg); auth != nil {
+		opts = append(opts, auth)
+	}
+	return append(opts, tlsOptions(cfg)...)
+}
+
+// tlsOptions returns the TLS options for cfg. Certificates are only read
+// when connecting.
+func tlsOptions(cfg *config.Config) []nats.Option {
+	var opts []nats.Option
+	if cfg.NatsTLSCA != "" {
+		opts = append(opts, nats.RootCAs(cfg.NatsTLSCA))
+	}
+	if cfg.NatsTLSCert != "" {
+		opts = append(opts, nats.ClientCert(cfg.NatsTLSCert, cfg.NatsTLSKey))
+	}
+	if len(opts) == 0 && usesTLS(cfg.NatsURL) {
+		// No certificates: verify the server against the system roots
+		opts = append(opts, nats.Secure(&tls.Config{MinVersion: tls.VersionTLS12}))
+	}
+	return opts
+}
+
+// usesTLS reports whether any server in a NATS URL list has a tls:// scheme
+func usesTLS(urls string) bool {
+	for _, server := range strings.Split(urls, ",") {
+		if strings.HasPrefix(strings.TrimSpace(server), "tls://") {
+			return true
+		}
+	}
+	return false
+}
+
+// authOption returns the option for the configured authentication method,
+// or nil when none is set. Config validation rejects more than one.
+func authOption(cfg *config.Config) nats.Option {
+	switch {
+	case cfg.NatsCredsFile != "":
+		return nats.UserCredentials(cfg.NatsCredsFile)
+	case cfg.NatsToken != "":
+		return nats.Token(cfg.NatsToken)
+	case cfg.NatsUser != "":
+		return nats.UserInfo(cfg.NatsUser, cfg.NatsPassword)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package transport
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/config"
+	"github.com/nats-io/nats.go"
+)
+
+// connectSettings applies ConnectOptions for cfg to the client defaults
+func connectSettings(t *testing.T, cfg *config.Config) nats.Options {
+	t.Helper()
+	settings := nats.GetDefaultOptions()
+	for _, opt := range ConnectOptions(cfg) {
+		if err := opt(&settings); err != nil {
+			t.Fatalf("applying connect option: %v", err)
+		}
+	}
+	return settings
+}
+
+// credsPlaceholder stands for a credentials file created by the test
+const credsPlaceholder = "service.creds"
+
+func TestConnectOptionsAuth(t *testing.T) {
+	tests := []struct {
+		name         string
+		cfg          config.Config
+		wantCreds    bool
+		wantToken    string
+		wantUser     string
+		wantPassword string
+	}{
+		{name: "none"},
+		{name: "credentials file", cfg: config.Config{NatsCredsFile: credsPlaceholder}, wantCreds: true},
+		{name: "token", cfg: config.Config{NatsToken: "s3cret"}, wantToken: "s3cret"},
+		{name: "user and password", cfg: config.Config{NatsUser: "intent", NatsPassword: "pw"}, wantUser: "intent", wantPassword: "pw"},
+		{name: "credentials file wins", cfg: config.Config{NatsCredsFile: credsPlaceholder, NatsToken: "s3cret"}, wantCreds: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.NatsURL = "nats://localhost:4222"
+			if tt.cfg.NatsCredsFile == credsPlaceholder {
+				// The file must exist, though it is only read when connecting
+				tt.cfg.NatsCredsFile = filepath.Join(t.TempDir(), credsPlaceholder)
+				if err := os.WriteFile(tt.cfg.NatsCredsFile, nil, 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			settings := connectSettings(t, &tt.cfg)
+
+			if creds := settings.UserJWT != nil; creds != tt.wantCreds {
+				t.Errorf("credentials set = %v, want %v", creds, tt.wantCreds)
+			}
+			if settings.Token != tt.wantToken {
+				t.Errorf("token = %q, want %q", settings.Token, tt.wantToken)
+			}
+			if settings.User != tt.wantUser || settings.Password != tt.wantPassword {
+				t.Errorf("user info = %q/%q, want %q/%q", settings.User, settings.Password, tt.wantUser, tt.wantPassword)
+			}
+		})
+	}
+}
+
+// writeTestCert writes a self-signed certificate and its key as PEM files
+func writeTestCert(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "nats"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestConnectOptionsTLS(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        config.Config
+		ca         bool // Set NatsTLSCA
+		cert       bool // Set NatsTLSCert and NatsTLSKey
+		wantSecure bool
+		wantCAs    bool // Root CAs read from NatsTLSCA
+		wantCert   bool // Client certificate read from NatsTLSCert
+	}{
+		{name: "plain", cfg: config.Config{NatsURL: "nats://localhost:4222"}},
+		{name: "tls scheme", cfg: config.Config{NatsURL: "tls://nats:4222"}, wantSecure: true},
+		{name: "tls scheme in a server list", cfg: config.Config{NatsURL: "nats://a:4222, tls://b:4222"}, wantSecure: true},
+		{name: "custom CA", cfg: config.Config{NatsURL: "nats://nats:4222"}, ca: true, wantSecure: true, wantCAs: true},
+		{name: "client certificate", cfg: config.Config{NatsURL: "tls://nats:4222"}, cert: true, wantSecure: true, wantCert: true},
+		{name: "mutual TLS", cfg: config.Config{NatsURL: "tls://nats:4222"}, ca: true, cert: true, wantSecure: true, wantCAs: true, wantCert: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certFile, keyFile := writeTestCert(t)
+			if tt.ca {
+				tt.cfg.NatsTLSCA = certFile
+			}
+			if tt.cert {
+				tt.cfg.NatsTLSCert, tt.cfg.NatsTLSKey = certFile, keyFile
+			}
+			settings := connectSettings(t, &tt.cfg)
+
+			if settings.Secure != tt.wantSecure {
+				t.Errorf("secure = %v, want %v", settings.Secure, tt.wantSecure)
+			}
+			if tt.wantSecure && (settings.TLSConfig == nil || settings.TLSConfig.MinVersion < tls.VersionTLS12) {
+				t.Errorf("TLS config = %+v, want at least TLS 1.2", settings.TLSConfig)
+			}
+			if cas := settings.RootCAsCB != nil; cas != tt.wantCAs {
+				t.Errorf("root CAs set = %v, want %v", cas, tt.wantCAs)
+			}
+			if cert := settings.TLSCertCB != nil; cert != tt.wantCert {
+				t.Errorf("client certificate set = %v, want %v", cert, tt.wantCert)
+			}
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package transport
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/nats-io/nats.go"
+)
+
+// correlationHeader carries the caller's request ID
+const correlationHeader = "X-Request-ID"
+
+// correlationID returns the request ID from the message headers, or a new
+// random one when the caller didn't send any
+func correlationID(msg *nats.Msg) string {
+	if msg.Header != nil {
+		if id := msg.Header.Get(correlationHeader); id != "" {
+			return id
+		}
+	}
+	b := make([]byte, 16)
+	rand.Read(b) // Never returns an error
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,95 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/metrics"
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+	"github.com/nats-io/nats.go"
+)
+
+// errorUndelivered is the dead-letter error code for a response that
+// couldn't be sent back to the client
+const errorUndelivered = "RESPONSE_UNDELIVERED"
+
+// retryCountHeader carries how many times a dead-lettered request has been
+// replayed. Operators replaying an entry set it to retry_count+1.
+const retryCountHeader = "Intent-Retry-Count"
+
+// deadLetter is published to the dead-letter subject for a request that
+// failed or whose response couldn't be delivered
+type deadLetter struct {
+	Subject      string          `json:"subject"`
+	Request      json.RawMessage `json:"request"` // The payload as received
+	ErrorCode    string          `json:"error_code"`
+	ErrorMessage string          `json:"error_message"`
+	RetryCount   int             `json:"retry_count"`
+	FailedAt     time.Time       `json:"failed_at"`
+}
+
+// shouldDeadLetter reports whether an error response should be dead
+// lettered: the model call failed after its retries. Errors the client can
+// fix, such as invalid requests and rate limits, are not.
+func shouldDeadLetter(response *models.IntentResponse) bool {
+	if response.Status != models.StatusError || response.ErrorCode == nil {
+		return false
+	}
+	code := *response.ErrorCode
+	return code == models.ErrorLLMFailed || code == models.ErrorLLMTimeout
+}
+
+// publishDeadLetter sends a failed request to the dead-letter subject.
+// Failures are only logged.
+func (nt *NATSTransport) publishDeadLetter(ctx context.Context, msg *nats.Msg, sessionID, errorCode, errorMessage string) {
+	if nt.config.NatsDLQSubject == "" {
+		return
+	}
+
+	data, err := json.Marshal(deadLetter{
+		Subject:      msg.Subject,
+		Request:      json.RawMessage(msg.Data),
+		ErrorCode:    errorCode,
+		ErrorMessage: errorMessage,
+		RetryCount:   retryCount(msg),
+		FailedAt:     time.Now(),
+	})
+	if err != nil {
+		// The payload wasn't valid JSON; keep it as a string instead
+		data, err = json.Marshal(map[string]any{
+			"subject":       msg.Subject,
+			"request_raw":   string(msg.Data),
+			"error_code":    errorCode,
+			"error_message": errorMessage,
+			"retry_count":   retryCount(msg),
+			"failed_at":     time.Now(),
+		})
+		if err != nil {
+			nt.logger.ErrorContext(ctx, "failed to marshal dead letter", "session_id", sessionID, "error", err)
+			return
+		}
+	}
+
+	if err := nt.conn.Publish(nt.config.NatsDLQSubject, data); err != nil {
+		nt.logger.ErrorContext(ctx, "failed to publish dead letter", "session_id", sessionID,
+			"subject", nt.config.NatsDLQSubject, "error", err)
+		return
+	}
+	metrics.DeadLetters.Add(1)
+	nt.logger.WarnContext(ctx, "request dead lettered", "session_id", sessionID, "error_code", errorCode,
+		"subject", nt.config.NatsDLQSubject)
+}
+
+// retryCount reads the replay count header, 0 for a first attempt
+func retryCount(msg *nats.Msg) int {
+	if msg.Header == nil {
+		return 0
+	}
+	n, err := strconv.Atoi(msg.Header.Get(retryCountHeader))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,93 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+)
+
+// maxSessionTTLSeconds caps session_ttl_seconds at a week
+const maxSessionTTLSeconds = 7 * 24 * 60 * 60
+
+// decodeIntentRequest strictly decodes an incoming request. Unknown fields,
+// wrong types and missing required fields are rejected with a precise error.
+// The returned request is never nil so callers can still echo whatever
+// session_id was decoded.
+func decodeIntentRequest(data []byte) (*models.IntentRequest, error) {
+	var request models.IntentRequest
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&request); err != nil {
+		return &request, describeDecodeError(err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return &request, fmt.Errorf("unexpected data after request object")
+	}
+
+	if err := validateRequestFields(&request); err != nil {
+		return &request, err
+	}
+
+	return &request, nil
+}
+
+// validateRequestFields checks fields that JSON decoding can't enforce.
+// Reset requests carry no message.
+func validateRequestFields(request *models.IntentRequest) error {
+	if request.SessionID == "" {
+		return fmt.Errorf("session_id is required")
+	}
+	if request.UserMessage == "" && !request.Reset {
+		return fmt.Errorf("user_message is required")
+	}
+	for i, msg := range request.ConversationHistory {
+		if msg.Role == "" {
+			return fmt.Errorf("conversation_history[%d].role is required", i)
+		}
+	}
+	for i, action := range request.AvailableActions {
+		if action.Action == "" {
+			return fmt.Errorf("available_actions[%d].action is required", i)
+		}
+	}
+	if request.SessionTTLSeconds < 0 || request.SessionTTLSeconds > maxSessionTTLSeconds {
+		return fmt.Errorf("session_ttl_seconds must be between 0 and %d", maxSessionTTLSeconds)
+	}
+	for i, example := range request.Examples {
+		if example.UserMessage == "" {
+			return fmt.Errorf("examples[%d].user_message is required", i)
+		}
+		if len(example.Response) == 0 {
+			return fmt.Errorf("examples[%d].response is required", i)
+		}
+	}
+	return nil
+}
+
+// describeDecodeError turns encoding/json errors into messages that name the
+// offending field
+func describeDecodeError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+
+	switch {
+	case errors.As(err, &typeErr):
+		return fmt.Errorf("field %q must be of type %s, got %s", typeErr.Field, typeErr.Type, typeErr.Value)
+	case errors.As(err, &syntaxErr):
+		return fmt.Errorf("malformed JSON at offset %d: %v", syntaxErr.Offset, syntaxErr)
+	case errors.Is(err, io.EOF):
+		return fmt.Errorf("request body is empty")
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return fmt.Errorf("request body is truncated")
+	case strings.HasPrefix(err.Error(), "json: unknown field"):
+		return fmt.Errorf("%s", strings.TrimPrefix(err.Error(), "json: "))
+	default:
+		return fmt.Errorf("invalid request: %w", err)
+	}
+}
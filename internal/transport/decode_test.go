@@ -0,0 +1,112 @@
+package transport
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDecodeIntentRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantErr     string // Substring of the error, empty for success
+		wantSession string
+	}{
+		{
+			name:        "valid request",
+			body:        `{"session_id": "s1", "user_message": "purge the cache", "available_actions": [{"action": "purge_cache"}]}`,
+			wantSession: "s1",
+		},
+		{
+			name:        "unknown field",
+			body:        `{"session_id": "s1", "user_message": "hi", "sesion_ttl": 60}`,
+			wantErr:     `unknown field "sesion_ttl"`,
+			wantSession: "s1",
+		},
+		{
+			name:    "wrong type",
+			body:    `{"session_id": 42, "user_message": "hi"}`,
+			wantErr: `field "session_id" must be of type string, got number`,
+		},
+		{
+			name:    "wrong nested type",
+			body:    `{"session_id": "s1", "user_message": "hi", "available_actions": {"action": "purge_cache"}}`,
+			wantErr: `field "available_actions" must be of type`,
+		},
+		{
+			name:    "missing session_id",
+			body:    `{"user_message": "hi"}`,
+			wantErr: "session_id is required",
+		},
+		{
+			name:        "missing user_message",
+			body:        `{"session_id": "s1"}`,
+			wantErr:     "user_message is required",
+			wantSession: "s1",
+		},
+		{
+			name:        "reset without user_message",
+			body:        `{"session_id": "s1", "reset": true}`,
+			wantSession: "s1",
+		},
+		{
+			name:    "reset still needs session_id",
+			body:    `{"reset": true}`,
+			wantErr: "session_id is required",
+		},
+		{
+			name:    "missing action name",
+			body:    `{"session_id": "s1", "user_message": "hi", "available_actions": [{"complexity": "simple"}]}`,
+			wantErr: "available_actions[0].action is required",
+		},
+		{
+			name:    "missing history role",
+			body:    `{"session_id": "s1", "user_message": "hi", "conversation_history": [{"message": "hello"}]}`,
+			wantErr: "conversation_history[0].role is required",
+		},
+		{
+			name:    "ttl out of range",
+			body:    `{"session_id": "s1", "user_message": "hi", "session_ttl_seconds": -1}`,
+			wantErr: "session_ttl_seconds must be between",
+		},
+		{
+			name:    "empty body",
+			body:    ``,
+			wantErr: "request body is empty",
+		},
+		{
+			name:    "malformed JSON",
+			body:    `{"session_id": "s1",,}`,
+			wantErr: "malformed JSON",
+		},
+		{
+			name:    "truncated JSON",
+			body:    `{"session_id": "s1",`,
+			wantErr: "request body is truncated",
+		},
+		{
+			name:    "trailing data",
+			body:    `{"session_id": "s1", "user_message": "hi"} {}`,
+			wantErr: "unexpected data after request object",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, err := decodeIntentRequest([]byte(tt.body))
+			if request == nil {
+				t.Fatal("decodeIntentRequest returned a nil request")
+			}
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+			if tt.wantSession != "" && request.SessionID != tt.wantSession {
+				t.Errorf("session_id = %q, want %q", request.SessionID, tt.wantSession)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+)
+
+// replyToHeader names the inbox a JetStream request's response is sent to.
+// Stream messages don't keep the publisher's reply subject, so clients set
+// this header on the request and subscribe to the inbox themselves.
+const replyToHeader = "Intent-Reply-To"
+
+// subscribeJetStream consumes intent requests through a durable consumer on
+// the request stream, creating the stream if it doesn't exist. Messages are
+// acked once a response has been produced, so requests in flight when the
+// service stops are redelivered on restart.
+func (nt *NATSTransport) subscribeJetStream() error {
+	js, err := nt.conn.JetStream()
+	if err != nil {
+		return fmt.Errorf("failed to open JetStream context: %w", err)
+	}
+	if err := nt.ensureStream(js); err != nil {
+		return err
+	}
+
+	// Processing is bounded by the model timeout; leave room for the reply
+	ackWait := nt.config.AnthropicTimeout + nt.config.NatsTimeout
+	_, err = js.QueueSubscribe("", nt.config.NatsQueueGroup, nt.dispatchJetStreamRequest,
+		nats.BindStream(nt.config.NatsStreamName),
+		nats.Durable(nt.config.NatsQueueGroup),
+		nats.ManualAck(),
+		nats.AckWait(ackWait),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to consume stream %s: %w", nt.config.NatsStreamName, err)
+	}
+
+	nt.logger.Info("consuming stream", "stream", nt.config.NatsStreamName,
+		"durable", nt.config.NatsQueueGroup, "ack_wait", ackWait)
+	return nil
+}
+
+// ensureStream creates the request stream unless it already exists. Acks
+// to publishers are disabled so they don't answer request/reply callers.
+func (nt *NATSTransport) ensureStream(js nats.JetStreamContext) error {
+	_, err := js.StreamInfo(nt.config.NatsStreamName)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, nats.ErrStreamNotFound) {
+		return fmt.Errorf("failed to look up stream %s: %w", nt.config.NatsStreamName, err)
+	}
+
+	_, err = js.AddStream(&nats.StreamConfig{
+		Name:      nt.config.NatsStreamName,
+		Subjects:  nt.subjects,
+		Retention: nats.WorkQueuePolicy,
+		NoAck:     true,
+	})
+	if err != nil && !errors.Is(err, nats.ErrStreamNameAlreadyInUse) {
+		return fmt.Errorf("failed to create stream %s: %w", nt.config.NatsStreamName, err)
+	}
+	nt.logger.Info("created stream", "stream", nt.config.NatsStreamName, "subjects", nt.subjects)
+	return nil
+}
+
+// dispatchJetStreamRequest is dispatchIntentRequest for a stream message.
+// The response goes to the inbox in the reply-to header. The message is
+// acked once the response is sent, terminated when the request failed for
+// good and nak'd for redelivery when the reply couldn't be published.
+func (nt *NATSTransport) dispatchJetStreamRequest(msg *nats.Msg) {
+	request := &nats.Msg{
+		Subject: msg.Subject,
+		Reply:   jetStreamReplyTo(msg),
+		Header:  msg.Header,
+		Data:    msg.Data,
+		Sub:     msg.Sub,
+	}
+	if request.Reply == "" {
+		nt.logger.Warn("stream request has no reply inbox", "subject", msg.Subject, "header", replyToHeader)
+	}
+
+	nt.slots <- struct{}{}
+	go func() {
+		defer func() { <-nt.slots }()
+		nt.settle(msg, nt.handleIntentRequest(request))
+	}()
+}
+
+// jetStreamReplyTo returns the inbox to answer msg on. Without the header it
+// falls back to the message's reply subject, unless that is the consumer's
+// ack subject: responding there would ack the message instead.
+func jetStreamReplyTo(msg *nats.Msg) string {
+	if reply := msg.Header.Get(replyToHeader); reply != "" {
+		return reply
+	}
+	if _, err := msg.Metadata(); err == nil {
+		return ""
+	}
+	return msg.Reply
+}
+
+// settle acks, terminates or naks msg according to how handling it went
+func (nt *NATSTransport) settle(msg *nats.Msg, err error) {
+	var settleErr error
+	switch {
+	case err == nil:
+		settleErr = msg.Ack()
+	case errors.Is(err, errRequestAbandoned), errors.Is(err, nats.ErrMsgNoReply):
+		settleErr = msg.Term()
+	default:
+		nt.logger.Warn("redelivering request", "subject", msg.Subject, "error", err)
+		settleErr = msg.Nak()
+	}
+	if settleErr != nil {
+		nt.logger.Warn("failed to settle request", "subject", msg.Subject, "error", settleErr)
+	}
+}
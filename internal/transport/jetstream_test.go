@@ -0,0 +1,214 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/config"
+	"github.com/avvvet/cdnbuddy-intent/internal/llm"
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+// jetStreamConfig returns testConfig with requests consumed from a stream
+func jetStreamConfig(url string) *config.Config {
+	cfg := testConfig(url)
+	cfg.NatsJetStream = true
+	cfg.AnthropicTimeout = time.Second
+	cfg.NatsTimeout = 500 * time.Millisecond
+	return cfg
+}
+
+// waitSettled waits until the service's consumer has no requests waiting
+// for delivery or an ack
+func waitSettled(t *testing.T, js nats.JetStreamContext, cfg *config.Config) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		info, err := js.ConsumerInfo(cfg.NatsStreamName, cfg.NatsQueueGroup)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.NumPending == 0 && info.NumAckPending == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("requests were not settled")
+}
+
+// decodeReply unmarshals a response from the service
+func decodeReply(t *testing.T, msg *nats.Msg) models.IntentResponse {
+	t.Helper()
+	var response models.IntentResponse
+	if err := json.Unmarshal(msg.Data, &response); err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func TestJetStreamRequest(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		header    bool   // Set the reply-to header
+		wantReply bool   // Expect a response on the inbox
+		wantCode  string // Error code in the response
+		wantCalls int
+	}{
+		{name: "reply-to header", body: `{"session_id": "s1", "user_message": "purge"}`, header: true, wantReply: true, wantCalls: 1},
+		{name: "malformed request", body: `{"session_id": "s1"}`, header: true, wantReply: true, wantCode: models.ErrorParseError},
+		{name: "no reply inbox", body: `{"session_id": "s1", "user_message": "purge"}`, wantCalls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ns := runNATSServer(t, &server.Options{JetStream: true, StoreDir: t.TempDir()})
+			cfg := jetStreamConfig(ns.ClientURL())
+			provider := llm.NewMockProvider()
+			provider.Enqueue(&models.IntentResponse{SessionID: "s1", Status: models.StatusNeedsInfo, UserMessage: "Which service?"}, nil)
+			startTransport(t, cfg, provider)
+
+			client := connectClient(t, ns.ClientURL())
+			js, err := client.JetStream()
+			if err != nil {
+				t.Fatal(err)
+			}
+			inbox, err := client.SubscribeSync(client.NewRespInbox())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			msg := nats.NewMsg(cfg.NatsRequestSubject)
+			msg.Data = []byte(tt.body)
+			if tt.header {
+				msg.Header.Set(replyToHeader, inbox.Subject)
+			}
+			if err := client.PublishMsg(msg); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.wantReply {
+				reply, err := inbox.NextMsg(5 * time.Second)
+				if err != nil {
+					t.Fatalf("no reply: %v", err)
+				}
+				response := decodeReply(t, reply)
+				var code string
+				if response.ErrorCode != nil {
+					code = *response.ErrorCode
+				}
+				if code != tt.wantCode {
+					t.Errorf("error code = %q, want %q", code, tt.wantCode)
+				}
+			}
+
+			waitSettled(t, js, cfg)
+			// Give a redelivery a moment to show up
+			time.Sleep(100 * time.Millisecond)
+			if got := len(provider.Requests()); got != tt.wantCalls {
+				t.Errorf("provider called %d times, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestJetStreamSettle(t *testing.T) {
+	tests := []struct {
+		name          string
+		err           error
+		wantRedeliver bool
+	}{
+		{name: "handled", err: nil},
+		{name: "abandoned", err: errRequestAbandoned},
+		{name: "no reply inbox", err: nats.ErrMsgNoReply},
+		{name: "reply not published", err: errors.New("failed to send response: nats: connection reconnecting"), wantRedeliver: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ns := runNATSServer(t, &server.Options{JetStream: true, StoreDir: t.TempDir()})
+			nt := &NATSTransport{config: jetStreamConfig(ns.ClientURL()), logger: discardLogger, subjects: []string{"intent.analyze"}}
+			nt.conn = connectClient(t, ns.ClientURL())
+			js, err := nt.conn.JetStream()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := nt.ensureStream(js); err != nil {
+				t.Fatal(err)
+			}
+			sub, err := js.SubscribeSync("intent.analyze", nats.Durable("settle"), nats.ManualAck(), nats.AckWait(time.Minute))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := nt.conn.Publish("intent.analyze", []byte("{}")); err != nil {
+				t.Fatal(err)
+			}
+
+			msg, err := sub.NextMsg(5 * time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			nt.settle(msg, tt.err)
+
+			_, err = sub.NextMsg(500 * time.Millisecond)
+			if redelivered := err == nil; redelivered != tt.wantRedeliver {
+				t.Errorf("redelivered = %v, want %v", redelivered, tt.wantRedeliver)
+			}
+		})
+	}
+}
+
+func TestJetStreamRedeliveryAfterCrash(t *testing.T) {
+	ns := runNATSServer(t, &server.Options{JetStream: true, StoreDir: t.TempDir()})
+	cfg := jetStreamConfig(ns.ClientURL())
+
+	// The first replica takes the request and goes down while it's working
+	received := make(chan struct{})
+	crashing := llm.NewMockProvider()
+	crashing.AnalyzeFunc = func(ctx context.Context, request *models.IntentRequest) (*models.IntentResponse, error) {
+		close(received)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	first := startTransport(t, cfg, crashing)
+
+	client := connectClient(t, ns.ClientURL())
+	inbox, err := client.SubscribeSync(client.NewRespInbox())
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := nats.NewMsg(cfg.NatsRequestSubject)
+	msg.Data = []byte(`{"session_id": "s1", "user_message": "purge the cache"}`)
+	msg.Header.Set(replyToHeader, inbox.Subject)
+	if err := client.PublishMsg(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request never reached the first replica")
+	}
+	first.Close()
+
+	// Its replacement picks the request up once the ack wait runs out
+	provider := llm.NewMockProvider()
+	provider.Enqueue(&models.IntentResponse{SessionID: "s1", Status: models.StatusNeedsInfo, UserMessage: "Which service?"}, nil)
+	startTransport(t, cfg, provider)
+
+	reply, err := inbox.NextMsg(10 * time.Second)
+	if err != nil {
+		t.Fatalf("request was not redelivered: %v", err)
+	}
+	if response := decodeReply(t, reply); response.UserMessage != "Which service?" {
+		t.Errorf("user_message = %q, want the replacement's reply", response.UserMessage)
+	}
+	if got := len(provider.Requests()); got != 1 {
+		t.Errorf("replacement called the provider %d times, want 1", got)
+	}
+}
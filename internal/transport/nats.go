@@ -3,116 +3,551 @@ package transport
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"time"
 
 	"github.com/avvvet/cdnbuddy-intent/internal/config"
 	"github.com/avvvet/cdnbuddy-intent/internal/handlers"
+	"github.com/avvvet/cdnbuddy-intent/internal/logging"
+	"github.com/avvvet/cdnbuddy-intent/internal/memory"
+	"github.com/avvvet/cdnbuddy-intent/internal/metrics"
 	"github.com/avvvet/cdnbuddy-intent/internal/models"
+	"github.com/avvvet/cdnbuddy-intent/internal/prompts"
+	"github.com/avvvet/cdnbuddy-intent/internal/tracing"
+	"github.com/avvvet/cdnbuddy-intent/internal/usage"
 	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type NATSTransport struct {
-	conn    *nats.Conn
-	config  *config.Config
-	handler *handlers.IntentHandler
+	conn          *nats.Conn
+	config        *config.Config
+	handler       *handlers.IntentHandler
+	usageReporter UsageReporter
+	sessions      SessionController // Answers the control subject, nil disables it
+	catalogs      ActionRegistry    // Answers the actions subject, nil disables it
+	logger        *slog.Logger
+	slots         chan struct{} // Bounds in-flight intent requests
+	redis         Pinger        // Checked by the health subject, nil when Redis isn't used
+	startedAt     time.Time
+
+	// Request subjects and the tokens they share; the token after these
+	// names the tenant
+	subjects   []string
+	tenantBase []string
+}
+
+// Pinger verifies a dependency's connection
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// UsageReporter answers usage queries on the admin subject
+type UsageReporter interface {
+	GetUsage(ctx context.Context, tenant, day string) (*usage.Totals, error)
+}
+
+// SessionController carries out session commands from the control subject
+type SessionController interface {
+	UndoLastExchange(ctx context.Context, sessionID string) error
+	ExportSession(ctx context.Context, sessionID string) ([]byte, error)
+	ImportSession(ctx context.Context, data []byte, opts ...memory.ImportOption) (string, error)
+}
+
+// ActionRegistry stores action catalogs registered on the actions subject
+type ActionRegistry interface {
+	Save(ctx context.Context, id string, actions []models.ActionSchema) error
+}
+
+// Option configures optional NATSTransport behaviour
+type Option func(*NATSTransport)
+
+// WithLogger sets the structured logger, slog.Default() otherwise
+func WithLogger(l *slog.Logger) Option {
+	return func(nt *NATSTransport) {
+		nt.logger = l
+	}
+}
+
+// WithRedisHealth includes Redis connectivity in health responses
+func WithRedisHealth(p Pinger) Option {
+	return func(nt *NATSTransport) {
+		nt.redis = p
+	}
+}
+
+// WithUsageReporter enables the usage query subject
+func WithUsageReporter(r UsageReporter) Option {
+	return func(nt *NATSTransport) {
+		nt.usageReporter = r
+	}
+}
+
+// WithSessionControl enables the session control subject
+func WithSessionControl(c SessionController) Option {
+	return func(nt *NATSTransport) {
+		nt.sessions = c
+	}
 }
 
-func NewNATSTransport(cfg *config.Config, handler *handlers.IntentHandler) (*NATSTransport, error) {
+// WithActionRegistry enables the action catalog registration subject
+func WithActionRegistry(r ActionRegistry) Option {
+	return func(nt *NATSTransport) {
+		nt.catalogs = r
+	}
+}
+
+func NewNATSTransport(cfg *config.Config, handler *handlers.IntentHandler, opts ...Option) (*NATSTransport, error) {
+	concurrency := cfg.MaxConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	nt := &NATSTransport{
+		config:    cfg,
+		handler:   handler,
+		logger:    slog.Default(),
+		slots:     make(chan struct{}, concurrency),
+		startedAt: time.Now(),
+	}
+	nt.subjects = requestSubjects(cfg.NatsRequestSubject)
+	nt.tenantBase = tenantBase(nt.subjects)
+	for _, opt := range opts {
+		opt(nt)
+	}
+
 	// Connect to NATS
-	conn, err := nats.Connect(cfg.NatsURL,
-		nats.Name(cfg.ServiceName),
-		nats.Timeout(cfg.NatsTimeout),
-		nats.ReconnectWait(2*time.Second),
-		nats.MaxReconnects(-1), // Infinite reconnects
-	)
+	conn, err := nats.Connect(cfg.NatsURL, ConnectOptions(cfg)...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
 	}
 
-	log.Printf("Connected to NATS server: %s", cfg.NatsURL)
+	nt.logger.Info("connected to NATS server", "url", cfg.NatsURL)
 
-	return &NATSTransport{
-		conn:    conn,
-		config:  cfg,
-		handler: handler,
-	}, nil
+	nt.conn = conn
+	return nt, nil
 }
 
 func (nt *NATSTransport) Start() error {
-	// Subscribe to intent analysis requests
-	_, err := nt.conn.Subscribe(nt.config.NatsRequestSubject, nt.handleIntentRequest)
-	if err != nil {
-		return fmt.Errorf("failed to subscribe to %s: %w", nt.config.NatsRequestSubject, err)
+	// Subscribe to intent analysis requests. Replicas share the queue group,
+	// so each request is handled by exactly one of them.
+	if nt.config.NatsJetStream {
+		if err := nt.subscribeJetStream(); err != nil {
+			return err
+		}
+	} else {
+		for _, subject := range nt.subjects {
+			if _, err := nt.conn.QueueSubscribe(subject, nt.config.NatsQueueGroup, nt.dispatchIntentRequest); err != nil {
+				return fmt.Errorf("failed to subscribe to %s: %w", subject, err)
+			}
+			nt.logger.Info("subscribed", "subject", subject, "queue_group", nt.config.NatsQueueGroup)
+		}
+	}
+
+	// Subscribe to usage queries
+	if nt.usageReporter != nil {
+		if _, err := nt.conn.QueueSubscribe(nt.config.NatsUsageSubject, nt.config.NatsQueueGroup, nt.handleUsageRequest); err != nil {
+			return fmt.Errorf("failed to subscribe to %s: %w", nt.config.NatsUsageSubject, err)
+		}
+		nt.logger.Info("subscribed", "subject", nt.config.NatsUsageSubject)
 	}
 
-	log.Printf("Subscribed to subject: %s", nt.config.NatsRequestSubject)
+	// Subscribe to session commands
+	if nt.sessions != nil {
+		if _, err := nt.conn.QueueSubscribe(nt.config.NatsControlSubject, nt.config.NatsQueueGroup, nt.handleControlRequest); err != nil {
+			return fmt.Errorf("failed to subscribe to %s: %w", nt.config.NatsControlSubject, err)
+		}
+		nt.logger.Info("subscribed", "subject", nt.config.NatsControlSubject)
+	}
+
+	// Subscribe to action catalog registrations
+	if nt.catalogs != nil {
+		if _, err := nt.conn.QueueSubscribe(nt.config.NatsActionsSubject, nt.config.NatsQueueGroup, nt.handleRegisterActions); err != nil {
+			return fmt.Errorf("failed to subscribe to %s: %w", nt.config.NatsActionsSubject, err)
+		}
+		nt.logger.Info("subscribed", "subject", nt.config.NatsActionsSubject)
+	}
+
+	// Subscribe to health probes. Every replica answers, so this is a plain
+	// subscription rather than a queue group.
+	if _, err := nt.conn.Subscribe(nt.config.NatsHealthSubject, nt.handleHealthRequest); err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", nt.config.NatsHealthSubject, err)
+	}
+	nt.logger.Info("subscribed", "subject", nt.config.NatsHealthSubject)
+
 	return nil
 }
 
-func (nt *NATSTransport) handleIntentRequest(msg *nats.Msg) {
-	// Parse the request
-	var request models.IntentRequest
+// Health states reported by the health subject
+const (
+	healthOK       = "ok"
+	healthDegraded = "degraded"
+	healthDown     = "down"
+	healthUnused   = "unused"
+)
+
+// healthStatus is the response body for the health subject
+type healthStatus struct {
+	Status string `json:"status"` // ok or degraded
+	Redis  string `json:"redis"`  // ok, down or unused
+	NATS   string `json:"nats"`
+	Uptime string `json:"uptime"`
+}
+
+// handleHealthRequest reports dependency health. A failed dependency makes
+// the status degraded rather than failing the probe.
+func (nt *NATSTransport) handleHealthRequest(msg *nats.Msg) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	health := healthStatus{
+		Status: healthOK,
+		Redis:  healthUnused,
+		NATS:   healthOK,
+		Uptime: time.Since(nt.startedAt).Round(time.Second).String(),
+	}
+
+	if nt.redis != nil {
+		health.Redis = healthOK
+		if err := nt.redis.Ping(ctx); err != nil {
+			nt.logger.Warn("health check: Redis ping failed", "error", err)
+			health.Redis = healthDown
+			health.Status = healthDegraded
+		}
+	}
+	if !nt.conn.IsConnected() {
+		health.NATS = healthDown
+		health.Status = healthDegraded
+	}
+
+	nt.respondJSON(msg, health)
+}
+
+// usageQuery is the request body for the usage subject
+type usageQuery struct {
+	Tenant string `json:"tenant"`
+	Day    string `json:"day"` // YYYY-MM-DD, defaults to today (UTC)
+}
+
+func (nt *NATSTransport) handleUsageRequest(msg *nats.Msg) {
+	var query usageQuery
+	if len(msg.Data) > 0 {
+		if err := json.Unmarshal(msg.Data, &query); err != nil {
+			nt.respondJSON(msg, map[string]string{"error": "invalid usage query: " + err.Error()})
+			return
+		}
+	}
+	if query.Day == "" {
+		query.Day = time.Now().UTC().Format(usage.DayFormat)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), nt.config.NatsTimeout)
+	defer cancel()
+
+	totals, err := nt.usageReporter.GetUsage(ctx, query.Tenant, query.Day)
+	if err != nil {
+		nt.logger.Error("failed to load usage", "tenant", query.Tenant, "day", query.Day, "error", err)
+		nt.respondJSON(msg, map[string]string{"error": err.Error()})
+		return
+	}
+
+	nt.respondJSON(msg, totals)
+}
+
+// Commands accepted on the control subject
+const (
+	commandUndo   = "undo"
+	commandExport = "export"
+	commandImport = "import"
+)
+
+// controlRequest is the request body for the control subject
+type controlRequest struct {
+	SessionID string `json:"session_id"`
+	Tenant    string `json:"tenant,omitempty"`
+	Command   string `json:"command"` // undo, export or import
+
+	// For import: the envelope from export, and whether to store it under a
+	// new session ID. session_id isn't needed.
+	Export     json.RawMessage `json:"export,omitempty"`
+	NewSession bool            `json:"new_session,omitempty"`
+}
+
+// controlResponse reports the outcome of a control command
+type controlResponse struct {
+	SessionID string `json:"session_id"`
+	Command   string `json:"command"`
+	Status    string `json:"status"` // ok or error
+	Error     string `json:"error,omitempty"`
+
+	// The session envelope, for export; session_id is the stored ID after
+	// an import
+	Export json.RawMessage `json:"export,omitempty"`
+}
+
+func (nt *NATSTransport) handleControlRequest(msg *nats.Msg) {
+	var request controlRequest
 	if err := json.Unmarshal(msg.Data, &request); err != nil {
-		log.Printf("Error parsing request: %v", err)
-		nt.sendErrorResponse(msg, &request, models.ErrorParseError, "Invalid request format")
+		nt.respondJSON(msg, map[string]string{"error": "invalid control request: " + err.Error()})
+		return
+	}
+	response := controlResponse{SessionID: request.SessionID, Command: request.Command, Status: "ok"}
+	if request.SessionID == "" && request.Command != commandImport {
+		response.Status, response.Error = "error", "session_id is required"
+		nt.respondJSON(msg, response)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), nt.config.NatsTimeout)
+	defer cancel()
+	ctx = memory.WithTenant(ctx, request.Tenant)
+
+	var err error
+	switch request.Command {
+	case commandUndo:
+		err = nt.sessions.UndoLastExchange(ctx, request.SessionID)
+	case commandExport:
+		response.Export, err = nt.sessions.ExportSession(ctx, request.SessionID)
+	case commandImport:
+		var opts []memory.ImportOption
+		if request.NewSession {
+			opts = append(opts, memory.ImportAsNewSession())
+		}
+		response.SessionID, err = nt.sessions.ImportSession(ctx, request.Export, opts...)
+	default:
+		err = fmt.Errorf("unknown command %q", request.Command)
+	}
+	if err != nil {
+		nt.logger.Warn("control command failed", "session_id", request.SessionID, "command", request.Command, "error", err)
+		response.Status, response.Error = "error", err.Error()
+	}
+	nt.respondJSON(msg, response)
+}
+
+// registerActionsRequest is the request body for the actions subject
+type registerActionsRequest struct {
+	CatalogID string                `json:"catalog_id"`
+	Actions   []models.ActionSchema `json:"actions"`
+}
+
+// registerActionsResponse confirms a registration
+type registerActionsResponse struct {
+	CatalogID string `json:"catalog_id"`
+	Actions   int    `json:"actions"`
+	Status    string `json:"status"` // ok or error
+	Error     string `json:"error,omitempty"`
+}
+
+func (nt *NATSTransport) handleRegisterActions(msg *nats.Msg) {
+	var request registerActionsRequest
+	if err := json.Unmarshal(msg.Data, &request); err != nil {
+		nt.respondJSON(msg, map[string]string{"error": "invalid catalog registration: " + err.Error()})
+		return
+	}
+	response := registerActionsResponse{CatalogID: request.CatalogID, Actions: len(request.Actions), Status: "ok"}
+
+	err := validateCatalog(&request)
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), nt.config.NatsTimeout)
+		defer cancel()
+		err = nt.catalogs.Save(ctx, request.CatalogID, request.Actions)
+	}
+	if err != nil {
+		nt.logger.Warn("failed to register action catalog", "catalog_id", request.CatalogID, "error", err)
+		response.Status, response.Error = "error", err.Error()
+	} else {
+		nt.logger.Info("registered action catalog", "catalog_id", request.CatalogID, "actions", len(request.Actions))
+	}
+	nt.respondJSON(msg, response)
+}
+
+// validateCatalog checks a registration the way request actions are checked
+func validateCatalog(request *registerActionsRequest) error {
+	if request.CatalogID == "" {
+		return fmt.Errorf("catalog_id is required")
+	}
+	if len(request.Actions) == 0 {
+		return fmt.Errorf("actions is required")
+	}
+	for i, action := range request.Actions {
+		if action.Action == "" {
+			return fmt.Errorf("actions[%d].action is required", i)
+		}
+	}
+	return nil
+}
+
+// respondJSON replies to an admin request with a JSON body
+func (nt *NATSTransport) respondJSON(msg *nats.Msg, body interface{}) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		nt.logger.Error("failed to marshal response", "error", err)
 		return
 	}
+	if err := msg.Respond(data); err != nil {
+		if isConnectionClosing(err) {
+			metrics.ResponsesDroppedOnShutdown.Add(1)
+			return
+		}
+		nt.logger.Error("failed to send response", "error", err)
+	}
+}
+
+// isConnectionClosing reports whether a publish failed because the
+// connection is shutting down, which is expected for late replies
+func isConnectionClosing(err error) bool {
+	return errors.Is(err, nats.ErrConnectionClosed) || errors.Is(err, nats.ErrConnectionDraining)
+}
 
-	log.Printf("Processing intent request for session: %s", request.SessionID)
+// dispatchIntentRequest processes a request on its own goroutine once a
+// slot is free. While all slots are busy it blocks, leaving further
+// messages queued in the subscription's pending buffer.
+func (nt *NATSTransport) dispatchIntentRequest(msg *nats.Msg) {
+	nt.slots <- struct{}{}
+	go func() {
+		defer func() { <-nt.slots }()
+		nt.handleIntentRequest(msg)
+	}()
+}
+
+// errRequestAbandoned marks a request that was answered with an error or
+// dropped. Delivering it again won't produce a different outcome.
+var errRequestAbandoned = errors.New("request abandoned")
+
+// handleIntentRequest processes msg and replies to it. It returns nil once
+// a response has been sent, an errRequestAbandoned error when the request
+// failed for good, and any other error when the reply couldn't be sent.
+func (nt *NATSTransport) handleIntentRequest(msg *nats.Msg) error {
+	ctx, span := startRequestSpan(msg)
+	defer span.End()
+	ctx = logging.WithCorrelationID(ctx, correlationID(msg))
+
+	// Parse and validate the request
+	request, err := decodeIntentRequest(msg.Data)
+	if err != nil {
+		nt.logger.WarnContext(ctx, "failed to parse request", "session_id", request.SessionID, "error", err)
+		tracing.Fail(span, err)
+		nt.sendErrorResponse(ctx, msg, request, models.ErrorParseError, err.Error())
+		return fmt.Errorf("%w: %w", errRequestAbandoned, err)
+	}
+
+	// A tenant subject overrides any tenant named in the body
+	if tenant := tenantFromSubject(nt.tenantBase, msg.Subject); tenant != "" {
+		request.Tenant = tenant
+	}
+
+	nt.logger.InfoContext(ctx, "processing intent request", "session_id", request.SessionID, "tenant", request.Tenant)
+	span.SetAttributes(attribute.String("session_id", request.SessionID), attribute.String("tenant", request.Tenant))
 
 	// Create context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), nt.config.AnthropicTimeout)
+	ctx, cancel := context.WithTimeout(ctx, nt.config.AnthropicTimeout)
 	defer cancel()
 
-	// Call the handler
-	response, err := nt.handler.ProcessIntent(ctx, &request)
+	// Call the handler, streaming the reply when the client asked for it
+	var response *models.IntentResponse
+	if request.Stream && msg.Reply != "" {
+		response, err = nt.processStream(ctx, msg, request)
+	} else {
+		response, err = nt.handler.ProcessIntent(ctx, request)
+	}
+	if errors.Is(err, errClientGone) {
+		metrics.StreamsAbandoned.Add(1)
+		nt.logger.WarnContext(ctx, "streaming client went away, request cancelled", "session_id", request.SessionID)
+		return fmt.Errorf("%w: %w", errRequestAbandoned, err)
+	}
 	if err != nil {
-		log.Printf("Error processing intent: %v", err)
-		nt.sendErrorResponse(msg, &request, models.ErrorLLMFailed, err.Error())
-		return
+		// The handler reports LLM failures in the response; anything
+		// returned here failed outside of it
+		nt.logger.ErrorContext(ctx, "failed to process intent", "session_id", request.SessionID, "error", err)
+		tracing.Fail(span, err)
+		nt.sendErrorResponse(ctx, msg, request, models.ErrorLLMFailed, err.Error())
+		nt.publishDeadLetter(ctx, msg, request.SessionID, models.ErrorLLMFailed, err.Error())
+		return fmt.Errorf("%w: %w", errRequestAbandoned, err)
 	}
 
 	// Send response
-	if err := nt.sendResponse(msg, response); err != nil {
-		log.Printf("Error sending response: %v", err)
+	sendErr := nt.sendResponse(ctx, msg, response)
+	if sendErr != nil {
+		nt.logger.ErrorContext(ctx, "failed to send response", "session_id", request.SessionID, "error", sendErr)
+		nt.publishDeadLetter(ctx, msg, request.SessionID, errorUndelivered, sendErr.Error())
+	} else if shouldDeadLetter(response) && !response.Replayed {
+		var message string
+		if response.ErrorMessage != nil {
+			message = *response.ErrorMessage
+		}
+		nt.publishDeadLetter(ctx, msg, request.SessionID, *response.ErrorCode, message)
+	}
+
+	// Let workflow consumers know an action is ready to run. A replayed
+	// response was announced when it was first produced.
+	if response.Status == models.StatusReady && !response.Replayed {
+		nt.publishCompletion(ctx, response)
+	}
+	return sendErr
+}
+
+// publishCompletion publishes a READY response to the event subject. It
+// runs after the reply has been sent, and failures are only logged.
+func (nt *NATSTransport) publishCompletion(ctx context.Context, response *models.IntentResponse) {
+	if nt.config.NatsEventSubject == "" {
+		return
+	}
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		nt.logger.ErrorContext(ctx, "failed to marshal completion event", "session_id", response.SessionID, "error", err)
+		return
+	}
+	if err := nt.conn.Publish(nt.config.NatsEventSubject, data); err != nil {
+		nt.logger.WarnContext(ctx, "failed to publish completion event", "session_id", response.SessionID,
+			"subject", nt.config.NatsEventSubject, "error", err)
+		return
 	}
+	nt.logger.DebugContext(ctx, "completion event published", "session_id", response.SessionID, "subject", nt.config.NatsEventSubject)
 }
 
-func (nt *NATSTransport) sendResponse(msg *nats.Msg, response *models.IntentResponse) error {
+// sendResponse replies to msg, echoing the request's correlation ID
+func (nt *NATSTransport) sendResponse(ctx context.Context, msg *nats.Msg, response *models.IntentResponse) error {
+	response.CorrelationID = logging.CorrelationID(ctx)
 	responseData, err := json.Marshal(response)
 	if err != nil {
 		return fmt.Errorf("failed to marshal response: %w", err)
 	}
 
 	if err := msg.Respond(responseData); err != nil {
+		if isConnectionClosing(err) {
+			metrics.ResponsesDroppedOnShutdown.Add(1)
+			nt.logger.WarnContext(ctx, "response dropped: connection is closing", "session_id", response.SessionID)
+			return nil
+		}
 		return fmt.Errorf("failed to send response: %w", err)
 	}
 
-	log.Printf("Response sent for session: %s, status: %s", response.SessionID, response.Status)
+	nt.logger.InfoContext(ctx, "response sent", "session_id", response.SessionID, "status", response.Status)
 	return nil
 }
 
-func (nt *NATSTransport) sendErrorResponse(msg *nats.Msg, request *models.IntentRequest, errorCode, errorMessage string) {
+func (nt *NATSTransport) sendErrorResponse(ctx context.Context, msg *nats.Msg, request *models.IntentRequest, errorCode, errorMessage string) {
 	response := &models.IntentResponse{
 		SessionID:    request.SessionID,
 		Status:       models.StatusError,
 		Parameters:   make(map[string]*string),
-		UserMessage:  "I'm sorry, I encountered an error processing your request. Please try again.",
+		UserMessage:  prompts.Localize(request.MessageLocale(), prompts.MsgTransportError),
 		ErrorCode:    &errorCode,
 		ErrorMessage: &errorMessage,
 	}
 
-	if err := nt.sendResponse(msg, response); err != nil {
-		log.Printf("Failed to send error response: %v", err)
+	if err := nt.sendResponse(ctx, msg, response); err != nil {
+		nt.logger.ErrorContext(ctx, "failed to send error response", "session_id", request.SessionID, "error", err)
 	}
 }
 
 func (nt *NATSTransport) Close() error {
 	if nt.conn != nil {
 		nt.conn.Close()
-		log.Println("NATS connection closed")
+		nt.logger.Info("NATS connection closed")
 	}
 	return nil
 }
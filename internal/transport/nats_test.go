@@ -0,0 +1,949 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/avvvet/cdnbuddy-intent/internal/catalog"
+	"github.com/avvvet/cdnbuddy-intent/internal/config"
+	"github.com/avvvet/cdnbuddy-intent/internal/handlers"
+	"github.com/avvvet/cdnbuddy-intent/internal/idempotency"
+	"github.com/avvvet/cdnbuddy-intent/internal/llm"
+	"github.com/avvvet/cdnbuddy-intent/internal/logging"
+	"github.com/avvvet/cdnbuddy-intent/internal/memory"
+	"github.com/avvvet/cdnbuddy-intent/internal/metrics"
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+	"github.com/avvvet/cdnbuddy-intent/internal/ratelimit"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/redis/go-redis/v9"
+)
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// runNATSServer starts an embedded NATS server for the test
+func runNATSServer(t *testing.T, opts *server.Options) *server.Server {
+	t.Helper()
+	opts.Host = "127.0.0.1"
+	opts.Port = -1
+	opts.NoLog = true
+	opts.NoSigs = true
+
+	ns, err := server.NewServer(opts)
+	if err != nil {
+		t.Fatalf("failed to create NATS server: %v", err)
+	}
+	ns.Start()
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server not ready")
+	}
+	t.Cleanup(ns.Shutdown)
+	return ns
+}
+
+// testConfig returns the settings a transport needs to run against url
+func testConfig(url string) *config.Config {
+	return &config.Config{
+		ServiceName:        "cdnbuddy-intent-test",
+		NatsURL:            url,
+		NatsRequestSubject: "intent.analyze",
+		NatsTimeout:        5 * time.Second,
+		NatsHealthSubject:  "intent.health",
+		NatsQueueGroup:     "cdnbuddy-intent",
+		NatsStreamGrace:    2 * time.Second,
+		NatsStreamName:     "INTENT_REQUESTS",
+		MaxConcurrency:     4,
+		AnthropicTimeout:   10 * time.Second,
+	}
+}
+
+// startTransport runs a transport over provider and returns it once it is
+// subscribed
+func startTransport(t *testing.T, cfg *config.Config, provider llm.LLMProvider) *NATSTransport {
+	t.Helper()
+	return startTransportWith(t, cfg, handlers.NewIntentHandler(provider, handlers.WithLogger(discardLogger)))
+}
+
+// startTransportWith runs a transport over handler and returns it once it
+// is subscribed
+func startTransportWith(t *testing.T, cfg *config.Config, handler *handlers.IntentHandler, opts ...Option) *NATSTransport {
+	t.Helper()
+	nt, err := NewNATSTransport(cfg, handler, append([]Option{WithLogger(discardLogger)}, opts...)...)
+	if err != nil {
+		t.Fatalf("NewNATSTransport() error = %v", err)
+	}
+	t.Cleanup(func() { nt.Close() })
+
+	if err := nt.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if err := nt.conn.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	return nt
+}
+
+// newTestRedis returns a client for an in-process Redis server
+func newTestRedis(t *testing.T) *redis.Client {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return rdb
+}
+
+// connectClient opens a client connection to url
+func connectClient(t *testing.T, url string) *nats.Conn {
+	t.Helper()
+	nc, err := nats.Connect(url)
+	if err != nil {
+		t.Fatalf("client connect: %v", err)
+	}
+	t.Cleanup(nc.Close)
+	return nc
+}
+
+func TestRespondAfterClose(t *testing.T) {
+	tests := []struct {
+		name    string
+		respond func(nt *NATSTransport, msg *nats.Msg) error
+	}{
+		{
+			name: "response",
+			respond: func(nt *NATSTransport, msg *nats.Msg) error {
+				return nt.sendResponse(context.Background(), msg, &models.IntentResponse{SessionID: "s1", Status: models.StatusReady})
+			},
+		},
+		{
+			name: "error response",
+			respond: func(nt *NATSTransport, msg *nats.Msg) error {
+				nt.sendErrorResponse(context.Background(), msg, &models.IntentRequest{SessionID: "s1"}, models.ErrorLLMFailed, "late")
+				return nil
+			},
+		},
+		{
+			name: "admin response",
+			respond: func(nt *NATSTransport, msg *nats.Msg) error {
+				nt.respondJSON(msg, map[string]string{"status": "ok"})
+				return nil
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ns := runNATSServer(t, &server.Options{})
+			nt := startTransport(t, testConfig(ns.ClientURL()), llm.NewMockProvider())
+			client := connectClient(t, ns.ClientURL())
+
+			sub, err := nt.conn.SubscribeSync("late.reply")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := nt.conn.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if err := client.PublishRequest("late.reply", client.NewRespInbox(), []byte("{}")); err != nil {
+				t.Fatal(err)
+			}
+			msg, err := sub.NextMsg(5 * time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			nt.Close()
+			dropped := metrics.ResponsesDroppedOnShutdown.Value()
+			if err := tt.respond(nt, msg); err != nil {
+				t.Fatalf("responding after close returned %v, want nil", err)
+			}
+			if got := metrics.ResponsesDroppedOnShutdown.Value(); got != dropped+1 {
+				t.Errorf("responses_dropped_on_shutdown = %d, want %d", got, dropped+1)
+			}
+		})
+	}
+}
+
+func TestQueueGroupDeliversOnce(t *testing.T) {
+	tests := []struct {
+		name      string
+		groups    []string // Queue group of each replica
+		wantCalls int      // LLM calls per request across all replicas
+	}{
+		{name: "same group", groups: []string{"cdnbuddy-intent", "cdnbuddy-intent"}, wantCalls: 1},
+		{name: "separate groups", groups: []string{"blue", "green"}, wantCalls: 2},
+	}
+
+	const requests = 20
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ns := runNATSServer(t, &server.Options{})
+
+			var calls atomic.Int64
+			for _, group := range tt.groups {
+				provider := llm.NewMockProvider()
+				provider.AnalyzeFunc = func(ctx context.Context, request *models.IntentRequest) (*models.IntentResponse, error) {
+					calls.Add(1)
+					return &models.IntentResponse{SessionID: request.SessionID, Status: models.StatusNeedsInfo, UserMessage: "ok"}, nil
+				}
+				cfg := testConfig(ns.ClientURL())
+				cfg.NatsQueueGroup = group
+				startTransport(t, cfg, provider)
+			}
+
+			client := connectClient(t, ns.ClientURL())
+			for i := range requests {
+				body, _ := json.Marshal(models.IntentRequest{SessionID: fmt.Sprintf("s%d", i), UserMessage: "hello"})
+				if _, err := client.Request("intent.analyze", body, 5*time.Second); err != nil {
+					t.Fatalf("request %d: %v", i, err)
+				}
+			}
+
+			want := int64(requests * tt.wantCalls)
+			deadline := time.Now().Add(5 * time.Second)
+			for calls.Load() < want && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			// Give any duplicate delivery a moment to show up
+			time.Sleep(100 * time.Millisecond)
+			if got := calls.Load(); got != want {
+				t.Errorf("LLM called %d times for %d requests, want %d", got, requests, want)
+			}
+		})
+	}
+}
+
+func TestResetRequest(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantAction string
+		wantCode   string
+	}{
+		{name: "reset without a message", body: `{"session_id": "s1", "reset": true}`, wantAction: models.ActionReset},
+		{name: "reset with a message", body: `{"session_id": "s1", "reset": true, "user_message": "start over"}`, wantAction: models.ActionReset},
+		{name: "no message without reset", body: `{"session_id": "s1"}`, wantCode: models.ErrorParseError},
+	}
+
+	ns := runNATSServer(t, &server.Options{})
+	provider := llm.NewMockProvider()
+	startTransport(t, testConfig(ns.ClientURL()), provider)
+	client := connectClient(t, ns.ClientURL())
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply, err := client.Request("intent.analyze", []byte(tt.body), 5*time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var response models.IntentResponse
+			if err := json.Unmarshal(reply.Data, &response); err != nil {
+				t.Fatal(err)
+			}
+
+			var action, code string
+			if response.Action != nil {
+				action = *response.Action
+			}
+			if response.ErrorCode != nil {
+				code = *response.ErrorCode
+			}
+			if action != tt.wantAction || code != tt.wantCode {
+				t.Errorf("got action %q and error code %q, want %q and %q", action, code, tt.wantAction, tt.wantCode)
+			}
+		})
+	}
+
+	if n := len(provider.Requests()); n != 0 {
+		t.Errorf("provider called %d times, want 0", n)
+	}
+}
+
+func TestTenantIsolation(t *testing.T) {
+	ns := runNATSServer(t, &server.Options{})
+	rdb := newTestRedis(t)
+
+	manager := memory.NewManager(memory.NewInMemoryStore(time.Hour), memory.WithLogger(discardLogger))
+	t.Cleanup(func() { manager.Close() })
+
+	// Save the message as the real providers do and echo it back
+	provider := llm.NewMockProvider()
+	provider.AnalyzeFunc = func(ctx context.Context, request *models.IntentRequest) (*models.IntentResponse, error) {
+		if err := manager.SaveUserMessage(ctx, request.SessionID, request.UserID, request.UserMessage); err != nil {
+			return nil, err
+		}
+		return &models.IntentResponse{SessionID: request.SessionID, Status: models.StatusNeedsInfo, UserMessage: "re: " + request.UserMessage}, nil
+	}
+	handler := handlers.NewIntentHandler(provider,
+		handlers.WithLogger(discardLogger),
+		handlers.WithMemoryManager(manager),
+		handlers.WithIdempotency(idempotency.NewCache(rdb, time.Hour)),
+		handlers.WithRateLimiter(ratelimit.NewLocalLimiter(1, 1), false),
+	)
+	cfg := testConfig(ns.ClientURL())
+	cfg.NatsRequestSubject = "intent.analyze.*"
+	startTransportWith(t, cfg, handler)
+	client := connectClient(t, ns.ClientURL())
+
+	// Both tenants use the same session and request IDs
+	tests := []struct {
+		name        string
+		tenant      string
+		requestID   string
+		message     string
+		wantMessage string // Reply user_message, empty when rate limited
+		wantCalls   int    // Provider calls so far
+	}{
+		{name: "first tenant", tenant: "acme", requestID: "r1", message: "purge acme", wantMessage: "re: purge acme", wantCalls: 1},
+		{name: "second tenant is neither replayed nor limited", tenant: "globex", requestID: "r1", message: "purge globex", wantMessage: "re: purge globex", wantCalls: 2},
+		{name: "retry replays the tenant's own reply", tenant: "acme", requestID: "r1", message: "purge acme", wantMessage: "re: purge acme", wantCalls: 2},
+		{name: "new request hits the tenant's limit", tenant: "acme", requestID: "r2", message: "purge acme again", wantCalls: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(models.IntentRequest{SessionID: "s1", RequestID: tt.requestID, UserMessage: tt.message})
+			reply, err := client.Request("intent.analyze."+tt.tenant, body, 5*time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var response models.IntentResponse
+			if err := json.Unmarshal(reply.Data, &response); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.wantMessage == "" {
+				if response.ErrorCode == nil || *response.ErrorCode != models.ErrorRateLimited {
+					t.Errorf("error_code = %v, want %s", response.ErrorCode, models.ErrorRateLimited)
+				}
+			} else if response.UserMessage != tt.wantMessage {
+				t.Errorf("user_message = %q, want %q", response.UserMessage, tt.wantMessage)
+			}
+			if got := len(provider.Requests()); got != tt.wantCalls {
+				t.Errorf("provider called %d times, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+
+	for tenant, want := range map[string]string{"acme": "purge acme", "globex": "purge globex"} {
+		messages, err := manager.GetMessages(memory.WithTenant(context.Background(), tenant), "s1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(messages) != 1 || messages[0].Content != want {
+			t.Errorf("%s history = %+v, want only %q", tenant, messages, want)
+		}
+	}
+}
+
+func TestRetryPublishesOnce(t *testing.T) {
+	ns := runNATSServer(t, &server.Options{})
+	provider := llm.NewMockProvider()
+	provider.Enqueue(&models.IntentResponse{SessionID: "s1", Status: models.StatusReady, UserMessage: "Purging now"}, nil)
+	handler := handlers.NewIntentHandler(provider,
+		handlers.WithLogger(discardLogger),
+		handlers.WithIdempotency(idempotency.NewCache(newTestRedis(t), time.Hour)),
+	)
+	cfg := testConfig(ns.ClientURL())
+	cfg.NatsEventSubject = "intent.completed"
+	startTransportWith(t, cfg, handler)
+
+	client := connectClient(t, ns.ClientURL())
+	events, err := client.SubscribeSync(cfg.NatsEventSubject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	body := []byte(`{"session_id": "s1", "request_id": "r1", "user_message": "purge the cache"}`)
+	for attempt := range 3 {
+		reply, err := client.Request(cfg.NatsRequestSubject, body, 5*time.Second)
+		if err != nil {
+			t.Fatalf("attempt %d: %v", attempt, err)
+		}
+		var response models.IntentResponse
+		if err := json.Unmarshal(reply.Data, &response); err != nil {
+			t.Fatal(err)
+		}
+		if response.Status != models.StatusReady || response.UserMessage != "Purging now" {
+			t.Errorf("attempt %d: got %s %q, want the original READY reply", attempt, response.Status, response.UserMessage)
+		}
+	}
+
+	if got := len(provider.Requests()); got != 1 {
+		t.Errorf("provider called %d times, want 1", got)
+	}
+	if _, err := events.NextMsg(time.Second); err != nil {
+		t.Fatalf("no completion event: %v", err)
+	}
+	if msg, err := events.NextMsg(200 * time.Millisecond); err == nil {
+		t.Errorf("duplicate completion event: %s", msg.Data)
+	}
+}
+
+func TestModelReplyErrorCodes(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int    // HTTP status of the model API
+		reply      string // Text the model answers with
+		wantStatus string
+		wantCode   string
+	}{
+		{name: "valid reply", status: http.StatusOK, reply: `{"status": "NEEDS_INFO", "action": "purge_cache", "parameters": {}, "user_message": "Which service?", "confidence": 0.9}`, wantStatus: models.StatusNeedsInfo},
+		{name: "prose reply", status: http.StatusOK, reply: "Sure, I can purge that cache for you.", wantStatus: models.StatusError, wantCode: models.ErrorParseError},
+		{name: "truncated JSON", status: http.StatusOK, reply: `{"status": "READY", "action": "purge_`, wantStatus: models.StatusError, wantCode: models.ErrorParseError},
+		{name: "API failure", status: http.StatusBadRequest, wantStatus: models.StatusError, wantCode: models.ErrorLLMFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.status != http.StatusOK {
+					http.Error(w, `{"type": "error", "error": {"type": "invalid_request_error", "message": "bad request"}}`, tt.status)
+					return
+				}
+				json.NewEncoder(w).Encode(map[string]any{
+					"type":    "message",
+					"role":    "assistant",
+					"content": []map[string]string{{"type": "text", "text": tt.reply}},
+					"usage":   map[string]int{"input_tokens": 10, "output_tokens": 5},
+				})
+			}))
+			t.Cleanup(api.Close)
+
+			manager := memory.NewManager(memory.NewInMemoryStore(time.Hour), memory.WithLogger(discardLogger))
+			t.Cleanup(func() { manager.Close() })
+			provider := llm.NewAnthropicProvider("test-key", "claude-test", 5*time.Second, manager,
+				llm.WithBaseURL(api.URL), llm.WithLogger(discardLogger), llm.WithMaxRetries(0))
+			t.Cleanup(func() { provider.Close() })
+
+			ns := runNATSServer(t, &server.Options{})
+			cfg := testConfig(ns.ClientURL())
+			startTransport(t, cfg, provider)
+			client := connectClient(t, ns.ClientURL())
+
+			reply, err := client.Request(cfg.NatsRequestSubject, []byte(`{"session_id": "s1", "user_message": "purge the cache"}`), 5*time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			response := decodeReply(t, reply)
+			var code string
+			if response.ErrorCode != nil {
+				code = *response.ErrorCode
+			}
+			if response.Status != tt.wantStatus || code != tt.wantCode {
+				t.Errorf("got status %s and error code %q, want %s and %q", response.Status, code, tt.wantStatus, tt.wantCode)
+			}
+			if response.SessionID != "s1" {
+				t.Errorf("session_id = %q, want s1", response.SessionID)
+			}
+		})
+	}
+}
+
+func TestDispatchConcurrencyLimit(t *testing.T) {
+	tests := []struct {
+		name     string
+		limit    int
+		requests int
+	}{
+		{name: "one at a time", limit: 1, requests: 4},
+		{name: "several at a time", limit: 3, requests: 12},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var inFlight, highWater atomic.Int32
+			saturated := make(chan struct{}, tt.requests)
+			release := make(chan struct{})
+			provider := llm.NewMockProvider()
+			provider.AnalyzeFunc = func(ctx context.Context, request *models.IntentRequest) (*models.IntentResponse, error) {
+				n := inFlight.Add(1)
+				defer inFlight.Add(-1)
+				for {
+					high := highWater.Load()
+					if n <= high || highWater.CompareAndSwap(high, n) {
+						break
+					}
+				}
+				if n == int32(tt.limit) {
+					saturated <- struct{}{}
+				}
+				<-release
+				return &models.IntentResponse{SessionID: request.SessionID, Status: models.StatusNeedsInfo, UserMessage: "Which service?"}, nil
+			}
+
+			ns := runNATSServer(t, &server.Options{})
+			cfg := testConfig(ns.ClientURL())
+			cfg.MaxConcurrency = tt.limit
+			startTransport(t, cfg, provider)
+			client := connectClient(t, ns.ClientURL())
+
+			errs := make(chan error, tt.requests)
+			for i := range tt.requests {
+				go func() {
+					body := fmt.Sprintf(`{"session_id": "s%d", "user_message": "purge the cache"}`, i)
+					_, err := client.Request(cfg.NatsRequestSubject, []byte(body), 10*time.Second)
+					errs <- err
+				}()
+			}
+
+			select {
+			case <-saturated:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("never reached %d requests in flight", tt.limit)
+			}
+			// Give queued requests a chance to slip past the limit
+			time.Sleep(100 * time.Millisecond)
+			close(release)
+
+			for range tt.requests {
+				if err := <-errs; err != nil {
+					t.Errorf("request failed: %v", err)
+				}
+			}
+			if got := highWater.Load(); got != int32(tt.limit) {
+				t.Errorf("%d requests in flight at once, limit is %d", got, tt.limit)
+			}
+			if got := len(provider.Requests()); got != tt.requests {
+				t.Errorf("provider called %d times, want %d", got, tt.requests)
+			}
+		})
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		err       error  // Returned by the provider
+		retry     string // Intent-Retry-Count header, empty to omit
+		wantCode  string // Error code of the dead letter, empty for none
+		wantRetry int
+	}{
+		{name: "model call failed", body: `{"session_id": "s1", "user_message": "purge"}`, err: errors.New("upstream overloaded"), wantCode: models.ErrorLLMFailed},
+		{name: "model call timed out", body: `{"session_id": "s1", "user_message": "purge"}`, err: context.DeadlineExceeded, wantCode: models.ErrorLLMTimeout},
+		{name: "replayed entry", body: `{"session_id": "s1", "user_message": "purge"}`, err: errors.New("upstream overloaded"), retry: "2", wantCode: models.ErrorLLMFailed, wantRetry: 2},
+		{name: "succeeded", body: `{"session_id": "s1", "user_message": "purge"}`},
+		{name: "invalid request", body: `{"session_id": "s1"}`},
+		{name: "session limit", body: `{"session_id": "s1", "user_message": "purge"}`, err: memory.ErrSessionLimitExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ns := runNATSServer(t, &server.Options{})
+			cfg := testConfig(ns.ClientURL())
+			cfg.NatsDLQSubject = "intent.dlq"
+			provider := llm.NewMockProvider()
+			provider.Enqueue(&models.IntentResponse{SessionID: "s1", Status: models.StatusNeedsInfo, UserMessage: "Which service?"}, tt.err)
+			startTransport(t, cfg, provider)
+
+			client := connectClient(t, ns.ClientURL())
+			dlq, err := client.SubscribeSync(cfg.NatsDLQSubject)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := client.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			msg := nats.NewMsg(cfg.NatsRequestSubject)
+			msg.Data = []byte(tt.body)
+			if tt.retry != "" {
+				msg.Header.Set(retryCountHeader, tt.retry)
+			}
+			if _, err := client.RequestMsg(msg, 5*time.Second); err != nil {
+				t.Fatal(err)
+			}
+
+			entry, err := dlq.NextMsg(500 * time.Millisecond)
+			if tt.wantCode == "" {
+				if err == nil {
+					t.Errorf("unexpected dead letter: %s", entry.Data)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("no dead letter: %v", err)
+			}
+			var letter deadLetter
+			if err := json.Unmarshal(entry.Data, &letter); err != nil {
+				t.Fatal(err)
+			}
+			if letter.ErrorCode != tt.wantCode || letter.RetryCount != tt.wantRetry {
+				t.Errorf("got error code %q and retry count %d, want %q and %d", letter.ErrorCode, letter.RetryCount, tt.wantCode, tt.wantRetry)
+			}
+			var request bytes.Buffer
+			if err := json.Compact(&request, []byte(tt.body)); err != nil {
+				t.Fatal(err)
+			}
+			if letter.Subject != cfg.NatsRequestSubject || string(letter.Request) != request.String() {
+				t.Errorf("dead letter holds %s %s, want the original request", letter.Subject, letter.Request)
+			}
+		})
+	}
+}
+
+func TestControlExportImport(t *testing.T) {
+	ns := runNATSServer(t, &server.Options{})
+	cfg := testConfig(ns.ClientURL())
+	cfg.NatsControlSubject = "intent.admin.control"
+	manager := memory.NewManager(memory.NewInMemoryStore(time.Hour), memory.WithLogger(discardLogger))
+	t.Cleanup(func() { manager.Close() })
+	handler := handlers.NewIntentHandler(llm.NewMockProvider(), handlers.WithLogger(discardLogger), handlers.WithMemoryManager(manager))
+	startTransportWith(t, cfg, handler, WithSessionControl(manager))
+	client := connectClient(t, ns.ClientURL())
+
+	ctx := context.Background()
+	if err := manager.SaveUserMessage(ctx, "s1", "user1", "purge the cache"); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.SaveAssistantMessage(ctx, "s1", "user1", "Which service?"); err != nil {
+		t.Fatal(err)
+	}
+
+	control := func(t *testing.T, request controlRequest) controlResponse {
+		t.Helper()
+		body, err := json.Marshal(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reply, err := client.Request(cfg.NatsControlSubject, body, 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var response controlResponse
+		if err := json.Unmarshal(reply.Data, &response); err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+	exported := control(t, controlRequest{Command: commandExport, SessionID: "s1"})
+	if exported.Status != "ok" {
+		t.Fatalf("export failed: %s", exported.Error)
+	}
+
+	tests := []struct {
+		name       string
+		export     func(e memory.SessionExport) memory.SessionExport
+		raw        string // Sent instead of the export when set
+		newSession bool
+		wantErr    string
+	}{
+		{name: "round trip", newSession: true},
+		{name: "existing session", wantErr: "already exists"},
+		{name: "version mismatch", newSession: true, wantErr: "unsupported session export schema_version 2",
+			export: func(e memory.SessionExport) memory.SessionExport { e.SchemaVersion = 2; return e }},
+		{name: "malformed envelope", raw: `{"schema_version": 1, "session": []}`, newSession: true, wantErr: "invalid session export"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var envelope memory.SessionExport
+			if err := json.Unmarshal(exported.Export, &envelope); err != nil {
+				t.Fatal(err)
+			}
+			data := json.RawMessage(exported.Export)
+			if tt.export != nil {
+				var err error
+				if data, err = json.Marshal(tt.export(envelope)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.raw != "" {
+				data = json.RawMessage(tt.raw)
+			}
+
+			imported := control(t, controlRequest{Command: commandImport, Export: data, NewSession: tt.newSession})
+			if tt.wantErr != "" {
+				if imported.Status != "error" || !strings.Contains(imported.Error, tt.wantErr) {
+					t.Errorf("import = %s %q, want error %q", imported.Status, imported.Error, tt.wantErr)
+				}
+				return
+			}
+			if imported.Status != "ok" || imported.SessionID == "" || imported.SessionID == "s1" {
+				t.Fatalf("import = %+v, want a new session", imported)
+			}
+
+			// Exporting the copy gives back the original session
+			again := control(t, controlRequest{Command: commandExport, SessionID: imported.SessionID})
+			var copied memory.SessionExport
+			if err := json.Unmarshal(again.Export, &copied); err != nil {
+				t.Fatal(err)
+			}
+			copied.Session.SessionID = envelope.Session.SessionID
+			want, _ := json.Marshal(envelope.Session)
+			got, _ := json.Marshal(copied.Session)
+			if !bytes.Equal(got, want) {
+				t.Errorf("imported session = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestCompletionEvent(t *testing.T) {
+	tests := []struct {
+		name      string
+		subject   string // NATS_EVENT_SUBJECT, empty to disable events
+		status    string
+		wantEvent bool
+	}{
+		{name: "READY", subject: "intent.completed", status: models.StatusReady, wantEvent: true},
+		{name: "NEEDS_INFO", subject: "intent.completed", status: models.StatusNeedsInfo},
+		{name: "events disabled", status: models.StatusReady},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ns := runNATSServer(t, &server.Options{})
+			cfg := testConfig(ns.ClientURL())
+			cfg.NatsEventSubject = tt.subject
+
+			action := "purge_cache"
+			path := "/images"
+			provider := llm.NewMockProvider()
+			provider.Enqueue(&models.IntentResponse{SessionID: "s1", Action: &action, Status: tt.status,
+				Parameters: map[string]*string{"path": &path}, UserMessage: "Purging /images"}, nil)
+			startTransport(t, cfg, provider)
+
+			client := connectClient(t, ns.ClientURL())
+			events, err := client.SubscribeSync("intent.completed")
+			if err != nil {
+				t.Fatal(err)
+			}
+			reply, err := client.Request(cfg.NatsRequestSubject, []byte(`{"session_id": "s1", "user_message": "purge /images"}`), 5*time.Second)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			if response := decodeReply(t, reply); response.Status != tt.status {
+				t.Fatalf("reply status = %s, want %s", response.Status, tt.status)
+			}
+
+			msg, err := events.NextMsg(500 * time.Millisecond)
+			if gotEvent := err == nil; gotEvent != tt.wantEvent {
+				t.Fatalf("event received = %v, want %v", gotEvent, tt.wantEvent)
+			}
+			if !tt.wantEvent {
+				return
+			}
+			event := decodeReply(t, msg)
+			if event.SessionID != "s1" || event.Action == nil || *event.Action != action || event.Parameters["path"] == nil || *event.Parameters["path"] != path {
+				t.Errorf("event = %+v, want the READY purge_cache response for s1 with its parameters", event)
+			}
+		})
+	}
+}
+
+func TestActionCatalog(t *testing.T) {
+	purge := models.ActionSchema{Action: "PURGE_CACHE", Parameters: []models.ParameterSpec{{Name: "service_id", Type: "string", Required: true}}}
+	create := models.ActionSchema{Action: "CREATE_SERVICE", Parameters: []models.ParameterSpec{{Name: "domain", Type: "string", Required: true}}}
+	inlinePurge := models.ActionSchema{Action: "PURGE_CACHE", Parameters: []models.ParameterSpec{{Name: "path", Type: "string", Required: true}}}
+
+	tests := []struct {
+		name         string
+		register     registerActionsRequest
+		wantRegister string // Registration status
+		request      models.IntentRequest
+		wantCode     string                // Error code of the intent response
+		wantActions  []models.ActionSchema // Actions the provider is given
+	}{
+		{
+			name:         "catalog only",
+			register:     registerActionsRequest{CatalogID: "cdn", Actions: []models.ActionSchema{purge, create}},
+			wantRegister: "ok",
+			request:      models.IntentRequest{SessionID: "s1", UserMessage: "purge", CatalogID: "cdn"},
+			wantActions:  []models.ActionSchema{purge, create},
+		},
+		{
+			name:         "inline action wins",
+			register:     registerActionsRequest{CatalogID: "cdn", Actions: []models.ActionSchema{purge, create}},
+			wantRegister: "ok",
+			request:      models.IntentRequest{SessionID: "s1", UserMessage: "purge", CatalogID: "cdn", AvailableActions: []models.ActionSchema{inlinePurge}},
+			wantActions:  []models.ActionSchema{inlinePurge, create},
+		},
+		{
+			name:         "unknown catalog",
+			register:     registerActionsRequest{CatalogID: "cdn", Actions: []models.ActionSchema{purge}},
+			wantRegister: "ok",
+			request:      models.IntentRequest{SessionID: "s1", UserMessage: "purge", CatalogID: "dns"},
+			wantCode:     models.ErrorParseError,
+		},
+		{
+			name:         "empty registration",
+			register:     registerActionsRequest{CatalogID: "cdn"},
+			wantRegister: "error",
+			request:      models.IntentRequest{SessionID: "s1", UserMessage: "purge", CatalogID: "cdn"},
+			wantCode:     models.ErrorParseError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ns := runNATSServer(t, &server.Options{})
+			cfg := testConfig(ns.ClientURL())
+			cfg.NatsActionsSubject = "intent.actions.register"
+
+			catalogs := catalog.NewStore(newTestRedis(t))
+			provider := llm.NewMockProvider()
+			provider.Enqueue(&models.IntentResponse{Status: models.StatusNeedsInfo, UserMessage: "Which service?"}, nil)
+			handler := handlers.NewIntentHandler(provider, handlers.WithLogger(discardLogger), handlers.WithActionCatalog(catalogs))
+			startTransportWith(t, cfg, handler, WithActionRegistry(catalogs))
+			client := connectClient(t, ns.ClientURL())
+
+			body, err := json.Marshal(tt.register)
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg, err := client.Request(cfg.NatsActionsSubject, body, 5*time.Second)
+			if err != nil {
+				t.Fatalf("registration failed: %v", err)
+			}
+			var registered registerActionsResponse
+			if err := json.Unmarshal(msg.Data, &registered); err != nil {
+				t.Fatal(err)
+			}
+			if registered.Status != tt.wantRegister {
+				t.Fatalf("registration status = %q (%s), want %q", registered.Status, registered.Error, tt.wantRegister)
+			}
+
+			body, err = json.Marshal(tt.request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg, err = client.Request(cfg.NatsRequestSubject, body, 5*time.Second)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			response := decodeReply(t, msg)
+			var code string
+			if response.ErrorCode != nil {
+				code = *response.ErrorCode
+			}
+			if code != tt.wantCode {
+				t.Fatalf("error code = %q, want %q", code, tt.wantCode)
+			}
+
+			requests := provider.Requests()
+			if tt.wantCode != "" {
+				if len(requests) != 0 {
+					t.Errorf("provider called %d times, want 0", len(requests))
+				}
+				return
+			}
+			if len(requests) != 1 {
+				t.Fatalf("provider called %d times, want 1", len(requests))
+			}
+			if got := requests[0].AvailableActions; !reflect.DeepEqual(got, tt.wantActions) {
+				t.Errorf("provider got actions %+v, want %+v", got, tt.wantActions)
+			}
+		})
+	}
+}
+
+// logBuffer collects log output written from several goroutines
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// lines decodes every JSON log line written so far
+func (b *logBuffer) lines(t *testing.T) []map[string]any {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var lines []map[string]any
+	for _, line := range bytes.Split(bytes.TrimSpace(b.buf.Bytes()), []byte("\n")) {
+		var fields map[string]any
+		if err := json.Unmarshal(line, &fields); err != nil {
+			t.Fatalf("log line %q: %v", line, err)
+		}
+		lines = append(lines, fields)
+	}
+	return lines
+}
+
+func TestCorrelationID(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string // X-Request-ID sent, empty for none
+		body   string
+	}{
+		{name: "header supplied", header: "req-1234", body: `{"session_id": "s1", "user_message": "purge the cache"}`},
+		{name: "generated", body: `{"session_id": "s1", "user_message": "purge the cache"}`},
+		{name: "error response", header: "req-5678", body: `{"session_id": "s1"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := &logBuffer{}
+			logger, err := logging.New(logs, "debug", logging.FormatJSON)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ns := runNATSServer(t, &server.Options{})
+			cfg := testConfig(ns.ClientURL())
+			provider := llm.NewMockProvider()
+			provider.Enqueue(&models.IntentResponse{Status: models.StatusNeedsInfo, UserMessage: "Which service?"}, nil)
+			startTransportWith(t, cfg, handlers.NewIntentHandler(provider, handlers.WithLogger(logger)), WithLogger(logger))
+			client := connectClient(t, ns.ClientURL())
+
+			msg := nats.NewMsg(cfg.NatsRequestSubject)
+			msg.Data = []byte(tt.body)
+			if tt.header != "" {
+				msg.Header.Set(correlationHeader, tt.header)
+			}
+			reply, err := client.RequestMsg(msg, 5*time.Second)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+
+			response := decodeReply(t, reply)
+			want := tt.header
+			if want == "" {
+				if len(response.CorrelationID) != 32 {
+					t.Fatalf("correlation_id = %q, want a generated 32-character ID", response.CorrelationID)
+				}
+				want = response.CorrelationID
+			} else if response.CorrelationID != want {
+				t.Fatalf("correlation_id = %q, want %q", response.CorrelationID, want)
+			}
+
+			// Every line logged for the session carries the same ID
+			var logged int
+			for _, line := range logs.lines(t) {
+				if line["session_id"] != "s1" {
+					continue
+				}
+				logged++
+				if got := line[logging.CorrelationKey]; got != want {
+					t.Errorf("log line %q has %s %v, want %q", line["msg"], logging.CorrelationKey, got, want)
+				}
+			}
+			if logged == 0 {
+				t.Error("nothing logged for the request")
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+	"github.com/nats-io/nats.go"
+)
+
+// Streaming clients get heartbeats on their reply inbox at this interval.
+// A listening client doesn't answer them, so a heartbeat only returns
+// before the timeout when the server reports no responders.
+const (
+	streamProbeInterval = 500 * time.Millisecond
+	streamProbeTimeout  = 100 * time.Millisecond
+)
+
+// errClientGone cancels a streaming request whose client stopped listening
+var errClientGone = errors.New("streaming client stopped listening")
+
+// processStream handles a streaming request. Chunks of the user_message are
+// published to the reply inbox as they are generated and the caller sends
+// the final response there as usual. The client must subscribe to the
+// inbox before sending the request and keep the subscription until the
+// final response arrives: once the inbox has had no listener for the
+// configured grace period the request is cancelled with errClientGone.
+func (nt *NATSTransport) processStream(ctx context.Context, msg *nats.Msg, request *models.IntentRequest) (*models.IntentResponse, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	go nt.watchReplyInbox(ctx, cancel, msg.Reply, request.SessionID)
+
+	response, err := nt.handler.ProcessIntentStream(ctx, request, func(delta string) {
+		nt.publishChunk(ctx, msg.Reply, models.StreamChunk{
+			SessionID: request.SessionID,
+			Type:      models.StreamChunkDelta,
+			Delta:     delta,
+		})
+	})
+
+	// The handler reports a cancelled call as an error response
+	if errors.Is(context.Cause(ctx), errClientGone) {
+		return nil, errClientGone
+	}
+	return response, err
+}
+
+// publishChunk sends a chunk to a streaming client. Failures are only
+// logged: the final response is what counts.
+func (nt *NATSTransport) publishChunk(ctx context.Context, inbox string, chunk models.StreamChunk) {
+	data, err := json.Marshal(chunk)
+	if err != nil {
+		nt.logger.ErrorContext(ctx, "failed to marshal stream chunk", "session_id", chunk.SessionID, "error", err)
+		return
+	}
+	if err := nt.conn.Publish(inbox, data); err != nil {
+		nt.logger.DebugContext(ctx, "failed to publish stream chunk", "session_id", chunk.SessionID, "error", err)
+	}
+}
+
+// watchReplyInbox sends heartbeats to a streaming client's reply inbox
+// until ctx is done, cancelling it once the inbox has had no listener for
+// longer than the grace period
+func (nt *NATSTransport) watchReplyInbox(ctx context.Context, cancel context.CancelCauseFunc, inbox, sessionID string) {
+	heartbeat, err := json.Marshal(models.StreamChunk{SessionID: sessionID, Type: models.StreamChunkHeartbeat})
+	if err != nil {
+		nt.logger.ErrorContext(ctx, "failed to marshal heartbeat", "session_id", sessionID, "error", err)
+		return
+	}
+
+	ticker := time.NewTicker(streamProbeInterval)
+	defer ticker.Stop()
+
+	var missingSince time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// A timeout means someone is subscribed but didn't answer
+		_, err := nt.conn.Request(inbox, heartbeat, streamProbeTimeout)
+		if !errors.Is(err, nats.ErrNoResponders) {
+			missingSince = time.Time{}
+			continue
+		}
+
+		if missingSince.IsZero() {
+			missingSince = time.Now()
+			nt.logger.DebugContext(ctx, "streaming client inbox has no listener", "session_id", sessionID, "grace", nt.config.NatsStreamGrace)
+		}
+		if time.Since(missingSince) >= nt.config.NatsStreamGrace {
+			cancel(errClientGone)
+			return
+		}
+	}
+}
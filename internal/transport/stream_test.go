@@ -0,0 +1,131 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/llm"
+	"github.com/avvvet/cdnbuddy-intent/internal/metrics"
+	"github.com/avvvet/cdnbuddy-intent/internal/models"
+	"github.com/nats-io/nats-server/v2/server"
+)
+
+// slowStreamer sends one chunk and then takes duration to finish, unless
+// its context is cancelled first
+type slowStreamer struct {
+	duration time.Duration
+
+	cancelled chan struct{}
+	once      sync.Once
+}
+
+func (s *slowStreamer) AnalyzeIntent(ctx context.Context, request *models.IntentRequest) (*models.IntentResponse, error) {
+	return s.AnalyzeIntentStream(ctx, request, func(string) {})
+}
+
+func (s *slowStreamer) AnalyzeIntentStream(ctx context.Context, request *models.IntentRequest, onChunk llm.ChunkFunc) (*models.IntentResponse, error) {
+	onChunk("Working on it")
+	select {
+	case <-ctx.Done():
+		s.once.Do(func() { close(s.cancelled) })
+		return nil, ctx.Err()
+	case <-time.After(s.duration):
+		return &models.IntentResponse{
+			SessionID:   request.SessionID,
+			Status:      models.StatusNeedsInfo,
+			UserMessage: "Working on it",
+			Parameters:  map[string]*string{},
+		}, nil
+	}
+}
+
+func TestStreamClientDisappears(t *testing.T) {
+	tests := []struct {
+		name          string
+		leave         bool // Client unsubscribes after the first chunk
+		wantCancelled bool
+	}{
+		{name: "client leaves mid-stream", leave: true, wantCancelled: true},
+		{name: "client keeps listening", leave: false, wantCancelled: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ns := runNATSServer(t, &server.Options{})
+			cfg := testConfig(ns.ClientURL())
+			cfg.NatsStreamGrace = 200 * time.Millisecond
+
+			provider := &slowStreamer{duration: 2 * time.Second, cancelled: make(chan struct{})}
+			startTransport(t, cfg, provider)
+			client := connectClient(t, ns.ClientURL())
+			abandoned := metrics.StreamsAbandoned.Value()
+
+			inbox := client.NewRespInbox()
+			sub, err := client.SubscribeSync(inbox)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := json.Marshal(models.IntentRequest{SessionID: "s1", UserMessage: "purge the cache", Stream: true})
+			if err := client.PublishRequest(cfg.NatsRequestSubject, inbox, body); err != nil {
+				t.Fatal(err)
+			}
+
+			// Wait for the first chunk of text
+			for {
+				msg, err := sub.NextMsg(5 * time.Second)
+				if err != nil {
+					t.Fatalf("waiting for a chunk: %v", err)
+				}
+				var chunk models.StreamChunk
+				if json.Unmarshal(msg.Data, &chunk) == nil && chunk.Type == models.StreamChunkDelta {
+					break
+				}
+			}
+
+			if tt.leave {
+				if err := sub.Unsubscribe(); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				// Read until the final response arrives
+				for {
+					msg, err := sub.NextMsg(5 * time.Second)
+					if err != nil {
+						t.Fatalf("waiting for the final response: %v", err)
+					}
+					var response models.IntentResponse
+					if json.Unmarshal(msg.Data, &response) == nil && response.Status != "" {
+						break
+					}
+				}
+			}
+
+			if !tt.wantCancelled {
+				// The final response means the upstream call already finished
+				select {
+				case <-provider.cancelled:
+					t.Fatal("upstream call was cancelled while the client was listening")
+				default:
+				}
+				return
+			}
+
+			select {
+			case <-provider.cancelled:
+			case <-time.After(3 * time.Second):
+				t.Fatal("upstream call was not cancelled after the client left")
+			}
+
+			deadline := time.Now().Add(time.Second)
+			for metrics.StreamsAbandoned.Value() == abandoned && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if metrics.StreamsAbandoned.Value() == abandoned {
+				t.Error("abandoned stream was not counted")
+			}
+		})
+	}
+}
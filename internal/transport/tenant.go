@@ -0,0 +1,60 @@
+package transport
+
+import (
+	"strings"
+)
+
+// requestSubjects splits the configured request subjects, which may be a
+// comma-separated list and may contain wildcards
+func requestSubjects(subjects string) []string {
+	var out []string
+	for _, subject := range strings.Split(subjects, ",") {
+		if subject = strings.TrimSpace(subject); subject != "" {
+			out = append(out, subject)
+		}
+	}
+	return out
+}
+
+// tenantBase returns the tokens every request subject starts with, stopping
+// at the first wildcard. The token after the base names the tenant, so
+// "intent.analyze.*" and "intent.analyze.acme,intent.analyze.globex" both
+// route by tenant while the single subject "intent.analyze" has none.
+func tenantBase(subjects []string) []string {
+	var base []string
+	for i, subject := range subjects {
+		tokens := strings.Split(subject, ".")
+		if j := wildcardIndex(tokens); j >= 0 {
+			tokens = tokens[:j]
+		}
+		if i == 0 {
+			base = tokens
+			continue
+		}
+		n := 0
+		for n < len(base) && n < len(tokens) && base[n] == tokens[n] {
+			n++
+		}
+		base = base[:n]
+	}
+	return base
+}
+
+func wildcardIndex(tokens []string) int {
+	for i, token := range tokens {
+		if token == "*" || token == ">" {
+			return i
+		}
+	}
+	return -1
+}
+
+// tenantFromSubject returns the token following base in a message subject,
+// or "" when the subject has none
+func tenantFromSubject(base []string, subject string) string {
+	tokens := strings.Split(subject, ".")
+	if len(tokens) <= len(base) {
+		return ""
+	}
+	return tokens[len(base)]
+}
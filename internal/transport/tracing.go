@@ -0,0 +1,37 @@
+package transport
+
+import (
+	"context"
+
+	"github.com/avvvet/cdnbuddy-intent/internal/tracing"
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// headerCarrier reads and writes trace context in NATS message headers
+type headerCarrier nats.Header
+
+func (c headerCarrier) Get(key string) string {
+	return nats.Header(c).Get(key)
+}
+
+func (c headerCarrier) Set(key, value string) {
+	nats.Header(c).Set(key, value)
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// startRequestSpan starts the root span for an intent request, continuing
+// the caller's trace when the message carries a traceparent header
+func startRequestSpan(msg *nats.Msg) (context.Context, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), headerCarrier(msg.Header))
+	return tracing.Start(ctx, "intent.request", attribute.String("messaging.destination.name", msg.Subject))
+}
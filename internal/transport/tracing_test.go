@@ -0,0 +1,163 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/avvvet/cdnbuddy-intent/internal/llm"
+	"github.com/avvvet/cdnbuddy-intent/internal/memory"
+	"github.com/avvvet/cdnbuddy-intent/internal/tracing"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans installs a tracer provider that keeps every span in memory
+// until the test ends
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	if _, err := tracing.Setup(context.Background(), "", "cdnbuddy-intent-test"); err != nil {
+		t.Fatal(err)
+	}
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		provider.Shutdown(context.Background())
+	})
+	return recorder
+}
+
+func TestRequestSpans(t *testing.T) {
+	const (
+		traceID      = "4bf92f3577b34da6a3ce929d0e0e4736"
+		parentSpanID = "00f067aa0ba902b7"
+	)
+	tests := []struct {
+		name        string
+		traceparent string // Header sent with the request, empty for none
+	}{
+		{name: "new trace"},
+		{name: "continued trace", traceparent: "00-" + traceID + "-" + parentSpanID + "-01"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := recordSpans(t)
+
+			api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				json.NewEncoder(w).Encode(map[string]any{
+					"type":    "message",
+					"role":    "assistant",
+					"content": []map[string]string{{"type": "text", "text": `{"status": "NEEDS_INFO", "action": "purge_cache", "parameters": {}, "user_message": "Which service?", "confidence": 0.9}`}},
+					"usage":   map[string]int{"input_tokens": 10, "output_tokens": 5},
+				})
+			}))
+			t.Cleanup(api.Close)
+
+			mr := miniredis.RunT(t)
+			store, err := memory.NewRedisStore("redis://"+mr.Addr(), time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			manager := memory.NewManager(store, memory.WithLogger(discardLogger))
+			t.Cleanup(func() { manager.Close() })
+			provider := llm.NewAnthropicProvider("test-key", "claude-test", 5*time.Second, manager,
+				llm.WithBaseURL(api.URL), llm.WithLogger(discardLogger), llm.WithMaxRetries(0))
+			t.Cleanup(func() { provider.Close() })
+
+			ns := runNATSServer(t, &server.Options{})
+			cfg := testConfig(ns.ClientURL())
+			startTransport(t, cfg, provider)
+			client := connectClient(t, ns.ClientURL())
+
+			msg := nats.NewMsg(cfg.NatsRequestSubject)
+			msg.Data = []byte(`{"session_id": "s1", "user_message": "purge the cache"}`)
+			if tt.traceparent != "" {
+				msg.Header.Set("traceparent", tt.traceparent)
+			}
+			if _, err := client.RequestMsg(msg, 5*time.Second); err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+
+			// The root span ends just after the reply is sent
+			var spans []sdktrace.ReadOnlySpan
+			var root sdktrace.ReadOnlySpan
+			deadline := time.Now().Add(5 * time.Second)
+			for root == nil && time.Now().Before(deadline) {
+				spans = recorder.Ended()
+				root = findSpan(spans, "intent.request")
+				time.Sleep(10 * time.Millisecond)
+			}
+			if root == nil {
+				t.Fatal("no intent.request span")
+			}
+
+			if tt.traceparent != "" {
+				if got := root.SpanContext().TraceID().String(); got != traceID {
+					t.Errorf("trace ID = %s, want the caller's %s", got, traceID)
+				}
+				if got := root.Parent().SpanID().String(); got != parentSpanID {
+					t.Errorf("root parent = %s, want the caller's span %s", got, parentSpanID)
+				}
+			} else if root.Parent().IsValid() {
+				t.Errorf("root has parent %s, want none", root.Parent().SpanID())
+			}
+
+			byID := make(map[string]sdktrace.ReadOnlySpan, len(spans))
+			for _, span := range spans {
+				byID[span.SpanContext().SpanID().String()] = span
+			}
+			// underRoot reports whether the root span is an ancestor of span
+			underRoot := func(span sdktrace.ReadOnlySpan) bool {
+				for parent, ok := byID[span.Parent().SpanID().String()]; ok; parent, ok = byID[parent.Parent().SpanID().String()] {
+					if parent == root {
+						return true
+					}
+				}
+				return false
+			}
+
+			seen := make(map[string]bool)
+			for _, span := range spans {
+				if span == root {
+					continue
+				}
+				seen[span.Name()] = true
+				if span.SpanContext().TraceID() != root.SpanContext().TraceID() {
+					t.Errorf("%s is in trace %s, want %s", span.Name(), span.SpanContext().TraceID(), root.SpanContext().TraceID())
+				}
+				if !underRoot(span) {
+					t.Errorf("%s is not under intent.request", span.Name())
+				}
+			}
+			if api := findSpan(spans, "anthropic.messages"); api == nil || api.Parent().SpanID() != root.SpanContext().SpanID() {
+				t.Errorf("anthropic.messages is not a child of intent.request")
+			}
+			for _, name := range []string{"anthropic.messages", "redis.load_session", "redis.save_message"} {
+				if !seen[name] {
+					t.Errorf("no %s span, got %v", name, seen)
+				}
+			}
+		})
+	}
+}
+
+// findSpan returns the first span called name
+func findSpan(spans []sdktrace.ReadOnlySpan, name string) sdktrace.ReadOnlySpan {
+	for _, span := range spans {
+		if span.Name() == name {
+			return span
+		}
+	}
+	return nil
+}
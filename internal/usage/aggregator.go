@@ -0,0 +1,115 @@
+package usage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultTenant is used for requests that don't name a tenant
+const DefaultTenant = "default"
+
+// DayFormat is the layout of the day component of usage keys
+const DayFormat = "2006-01-02"
+
+// Totals holds accumulated usage for one tenant on one day
+type Totals struct {
+	Tenant       string `json:"tenant"`
+	Day          string `json:"day"`
+	InputTokens  int64  `json:"input_tokens"`
+	OutputTokens int64  `json:"output_tokens"`
+	Requests     int64  `json:"requests"`
+}
+
+// Aggregator accumulates token usage in Redis counters keyed by tenant and
+// UTC day
+type Aggregator struct {
+	client    redis.Cmdable
+	retention time.Duration // How long daily counters are kept
+}
+
+// NewAggregator creates a Redis-backed usage aggregator
+func NewAggregator(client redis.Cmdable, retention time.Duration) *Aggregator {
+	return &Aggregator{
+		client:    client,
+		retention: retention,
+	}
+}
+
+// usageKey generates the Redis key for one counter
+func (a *Aggregator) usageKey(tenant, day, counter string) string {
+	return fmt.Sprintf("usage:%s:%s:%s", tenant, day, counter)
+}
+
+// RecordUsage adds one request's tokens to today's totals for the tenant.
+// INCRBY keeps the counters correct across concurrent requests and replicas.
+func (a *Aggregator) RecordUsage(ctx context.Context, tenant string, inputTokens, outputTokens int) error {
+	if tenant == "" {
+		tenant = DefaultTenant
+	}
+	day := time.Now().UTC().Format(DayFormat)
+
+	inputKey := a.usageKey(tenant, day, "input_tokens")
+	outputKey := a.usageKey(tenant, day, "output_tokens")
+	requestsKey := a.usageKey(tenant, day, "requests")
+
+	_, err := a.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.IncrBy(ctx, inputKey, int64(inputTokens))
+		pipe.IncrBy(ctx, outputKey, int64(outputTokens))
+		pipe.IncrBy(ctx, requestsKey, 1)
+		if a.retention > 0 {
+			pipe.Expire(ctx, inputKey, a.retention)
+			pipe.Expire(ctx, outputKey, a.retention)
+			pipe.Expire(ctx, requestsKey, a.retention)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record usage: %w", err)
+	}
+
+	return nil
+}
+
+// GetUsage returns the totals for a tenant on a day (YYYY-MM-DD, UTC).
+// Days with no traffic return zero totals.
+func (a *Aggregator) GetUsage(ctx context.Context, tenant, day string) (*Totals, error) {
+	if tenant == "" {
+		tenant = DefaultTenant
+	}
+	if _, err := time.Parse(DayFormat, day); err != nil {
+		return nil, fmt.Errorf("invalid day %q, expected YYYY-MM-DD", day)
+	}
+
+	values, err := a.client.MGet(ctx,
+		a.usageKey(tenant, day, "input_tokens"),
+		a.usageKey(tenant, day, "output_tokens"),
+		a.usageKey(tenant, day, "requests"),
+	).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load usage: %w", err)
+	}
+
+	counts := make([]int64, len(values))
+	for i, v := range values {
+		if v == nil {
+			continue
+		}
+		n, err := strconv.ParseInt(fmt.Sprint(v), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse usage counter: %w", err)
+		}
+		counts[i] = n
+	}
+
+	return &Totals{
+		Tenant:       tenant,
+		Day:          day,
+		InputTokens:  counts[0],
+		OutputTokens: counts[1],
+		Requests:     counts[2],
+	}, nil
+}
@@ -0,0 +1,139 @@
+package usage
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestAggregator returns an aggregator over an in-process Redis server
+func newTestAggregator(t *testing.T, retention time.Duration) (*Aggregator, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewAggregator(client, retention), mr
+}
+
+func TestAggregatorTotals(t *testing.T) {
+	type record struct {
+		tenant        string
+		input, output int
+	}
+	tests := []struct {
+		name    string
+		records []record
+		tenant  string // Tenant whose totals are read
+		want    Totals
+	}{
+		{
+			name:    "one tenant",
+			records: []record{{"acme", 100, 20}, {"acme", 50, 10}},
+			tenant:  "acme",
+			want:    Totals{Tenant: "acme", InputTokens: 150, OutputTokens: 30, Requests: 2},
+		},
+		{
+			name:    "tenants are kept apart",
+			records: []record{{"acme", 100, 20}, {"globex", 7, 3}, {"acme", 1, 1}},
+			tenant:  "globex",
+			want:    Totals{Tenant: "globex", InputTokens: 7, OutputTokens: 3, Requests: 1},
+		},
+		{
+			name:    "no tenant counts as default",
+			records: []record{{"", 10, 5}, {DefaultTenant, 10, 5}},
+			tenant:  "",
+			want:    Totals{Tenant: DefaultTenant, InputTokens: 20, OutputTokens: 10, Requests: 2},
+		},
+		{
+			name:    "no traffic",
+			records: []record{{"acme", 100, 20}},
+			tenant:  "initech",
+			want:    Totals{Tenant: "initech"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, _ := newTestAggregator(t, 0)
+			ctx := context.Background()
+			for _, r := range tt.records {
+				if err := a.RecordUsage(ctx, r.tenant, r.input, r.output); err != nil {
+					t.Fatalf("RecordUsage() error = %v", err)
+				}
+			}
+
+			day := time.Now().UTC().Format(DayFormat)
+			got, err := a.GetUsage(ctx, tt.tenant, day)
+			if err != nil {
+				t.Fatalf("GetUsage() error = %v", err)
+			}
+			tt.want.Day = day
+			if *got != tt.want {
+				t.Errorf("GetUsage() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAggregatorConcurrentRecords(t *testing.T) {
+	a, _ := newTestAggregator(t, 0)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := a.RecordUsage(ctx, "acme", 3, 2); err != nil {
+				t.Errorf("RecordUsage() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := a.GetUsage(ctx, "acme", time.Now().UTC().Format(DayFormat))
+	if err != nil {
+		t.Fatalf("GetUsage() error = %v", err)
+	}
+	if got.InputTokens != 150 || got.OutputTokens != 100 || got.Requests != 50 {
+		t.Errorf("GetUsage() = %+v, want 150/100 over 50 requests", *got)
+	}
+}
+
+func TestAggregatorRetention(t *testing.T) {
+	tests := []struct {
+		name      string
+		retention time.Duration
+	}{
+		{name: "kept for the retention", retention: 48 * time.Hour},
+		{name: "kept forever"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, mr := newTestAggregator(t, tt.retention)
+			if err := a.RecordUsage(context.Background(), "acme", 1, 1); err != nil {
+				t.Fatalf("RecordUsage() error = %v", err)
+			}
+			day := time.Now().UTC().Format(DayFormat)
+			for _, counter := range []string{"input_tokens", "output_tokens", "requests"} {
+				if ttl := mr.TTL(a.usageKey("acme", day, counter)); ttl != tt.retention {
+					t.Errorf("%s TTL = %v, want %v", counter, ttl, tt.retention)
+				}
+			}
+		})
+	}
+}
+
+func TestAggregatorInvalidDay(t *testing.T) {
+	a, _ := newTestAggregator(t, 0)
+	for _, day := range []string{"", "yesterday", "2025-13-01", "01/02/2025"} {
+		if _, err := a.GetUsage(context.Background(), "acme", day); err == nil {
+			t.Errorf("GetUsage(%q) succeeded, want an error", day)
+		}
+	}
+}